@@ -0,0 +1,79 @@
+//
+// arithmetic.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+)
+
+func arithMode(file string, params *utils.Params, player int,
+	peers, key string) error {
+	fmt.Printf("semi-honest secure 2-party arithmetic secret sharing\n")
+	fmt.Printf("player: %d\n", player)
+
+	if !strings.HasSuffix(file, ".qcl") {
+		return fmt.Errorf("arithmetic mode takes single QCL file")
+	}
+	if player > 1 {
+		return fmt.Errorf("invalid party number %d for 2-party computation",
+			player)
+	}
+	inputSizes, err := circuit.InputSizes(inputFlag)
+	if err != nil {
+		return err
+	}
+
+	nw, err := newPlayerNetwork(2, player, peers, key)
+	if err != nil {
+		return err
+	}
+	defer nw.Close()
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	if err := nw.Connect(ctx); err != nil {
+		return err
+	}
+
+	log.Printf("Network created\n")
+
+	// Exchange the input sizes with the peer.
+	sizes := make([][]int, 2)
+	sizes[player] = inputSizes
+	for id, peer := range nw.Peers {
+		conn := peer.Conn()
+		release := conn.WithContext(ctx)
+		err := conn.SendInputSizes(inputSizes)
+		if err == nil {
+			err = conn.Flush()
+		}
+		if err == nil {
+			sizes[id], err = conn.ReceiveInputSizes()
+		}
+		release()
+		if err != nil {
+			return err
+		}
+	}
+
+	outputs, result, err := compiler.New(params).ArithmeticFile(ctx, nw,
+		file, inputFlag, sizes)
+	if err != nil {
+		return err
+	}
+	printResults(result, outputs)
+	return nil
+}
@@ -7,16 +7,40 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/big"
 
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
 )
 
-func bmrMode(file string, params *utils.Params, player int) error {
+func bmrMode(file string, params *utils.Params, player int,
+	peers, key string, malicious bool) error {
+	if malicious {
+		fmt.Printf("BMR protocol with authenticated shares\n")
+		return playerMode(file, params, player, peers, key,
+			circuit.MaliciousPlayer, circuit.MaliciousCorrelations)
+	}
 	fmt.Printf("semi-honest secure BMR protocol\n")
+	return playerMode(file, params, player, peers, key, circuit.Player,
+		circuit.PlayerCorrelations)
+}
+
+// playerFunc runs a multi-party protocol as a player of the network.
+type playerFunc func(ctx context.Context, nw *p2p.Network,
+	circ *circuit.Circuit, inputs *big.Int, verbose bool) ([]*big.Int, error)
+
+// playerMode runs the multi-party protocol as the player of the
+// network. If the dealer address is set, the player fetches the
+// correlations of the protocol from the dealer before running the
+// protocol. The deal is nil for protocols that don't use the
+// dealer.
+func playerMode(file string, params *utils.Params, player int,
+	peers, key string, run playerFunc,
+	deal func(circ *circuit.Circuit) p2p.Correlations) error {
 	fmt.Printf("player: %d\n", player)
 
 	circ, err := loadCircuit(file, params, nil)
@@ -45,29 +69,29 @@ func bmrMode(file string, params *utils.Params, player int) error {
 	fmt.Printf(" - Out: %s\n", circ.Outputs)
 	fmt.Printf(" - In:  %s\n", inputFlag)
 
-	// Create network.
-	addr := makeAddr(player)
-	nw, err := p2p.NewNetwork(addr, player)
+	nw, err := newPlayerNetwork(len(circ.Inputs), player, peers, key)
 	if err != nil {
 		return err
 	}
 	defer nw.Close()
 
-	numPlayers := len(circ.Inputs)
+	ctx, cancel := newContext()
+	defer cancel()
 
-	for i := 0; i < numPlayers; i++ {
-		if i == player {
-			continue
-		}
-		err := nw.AddPeer(makeAddr(i), i)
-		if err != nil {
-			return err
-		}
+	if err := nw.Connect(ctx); err != nil {
+		return err
 	}
 
 	log.Printf("Network created\n")
 
-	result, err := circuit.Player(nw, circ, input, verbose)
+	if len(dealerAddr) > 0 && deal != nil {
+		fmt.Printf("WARNING: trusted dealer preprocessing is INSECURE\n")
+		if err := nw.Preprocess(ctx, dealerAddr, deal(circ)); err != nil {
+			return err
+		}
+	}
+
+	result, err := run(ctx, nw, circ, input, verbose)
 	if err != nil {
 		return err
 	}
@@ -76,6 +100,39 @@ func bmrMode(file string, params *utils.Params, player int) error {
 	return nil
 }
 
-func makeAddr(player int) string {
-	return fmt.Sprintf("127.0.0.1:%d", 8080+player)
+// newPlayerNetwork creates the network for the player of the
+// numPlayers-party computation. The peers specifies the network
+// configuration file and key our party key file. Without the
+// configuration, the players run on the loopback ports 8080...
+func newPlayerNetwork(numPlayers, player int, peers, key string) (
+	*p2p.Network, error) {
+
+	var config *p2p.Config
+	var err error
+	if len(peers) > 0 {
+		config, err = p2p.LoadConfig(peers)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		config = p2p.NewLoopbackConfig(numPlayers, 8080)
+	}
+	if emulation != nil {
+		config.Emulation = emulation
+	}
+	if len(config.Peers) != numPlayers {
+		return nil, fmt.Errorf("network has %d peers, expected %d",
+			len(config.Peers), numPlayers)
+	}
+	var identity *p2p.Identity
+	if len(key) > 0 {
+		if len(peers) == 0 {
+			return nil, fmt.Errorf("party key requires network configuration")
+		}
+		identity, err = p2p.LoadIdentity(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p2p.NewConfigNetwork(config, player, identity)
 }
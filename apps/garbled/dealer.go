@@ -0,0 +1,34 @@
+//
+// dealer.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// dealerMode runs the trusted dealer for the multi-party protocols.
+func dealerMode(numParties int) error {
+	if len(dealerAddr) == 0 {
+		return fmt.Errorf("no dealer address")
+	}
+	dealer, err := p2p.NewDealer(dealerAddr, numParties)
+	if err != nil {
+		return err
+	}
+	defer dealer.Close()
+
+	fmt.Printf("WARNING: the trusted dealer is INSECURE, use only for " +
+		"testing and benchmarking\n")
+	log.Printf("dealer for %d parties at %s\n", numParties, dealer.Addr())
+
+	return dealer.Serve()
+}
@@ -0,0 +1,23 @@
+//
+// gmw.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+)
+
+func gmwMode(file string, params *utils.Params, player int,
+	peers, key string) error {
+	fmt.Printf("semi-honest secure GMW protocol\n")
+	return playerMode(file, params, player, peers, key, circuit.GMW,
+		circuit.GMWCorrelations)
+}
@@ -9,15 +9,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
 	"os"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"time"
 
 	"golang.org/x/exp/slices"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
@@ -28,8 +33,14 @@ import (
 )
 
 var (
-	port    = ":8080"
-	verbose = false
+	port         = ":8080"
+	verbose      = false
+	timeout      time.Duration
+	phaseTimeout time.Duration
+	emulation    *p2p.Emulation
+	dealerAddr   string
+	identity     *p2p.Identity
+	peerKey      p2p.PublicKey
 )
 
 type input []string
@@ -46,9 +57,12 @@ func (i *input) Set(value string) error {
 }
 
 var inputFlag input
+var sharesFlag input
 
 func init() {
 	flag.Var(&inputFlag, "i", "comma-separated list of circuit inputs")
+	flag.Var(&sharesFlag, "shares",
+		"comma-separated list of garbler's shares of evaluator's inputs")
 }
 
 func main() {
@@ -67,12 +81,61 @@ func main() {
 	memprofile := flag.String("memprofile", "",
 		"write memory profile to `file`")
 	bmr := flag.Int("bmr", -1, "semi-honest secure BMR protocol player number")
+	gmw := flag.Int("gmw", -1, "semi-honest secure GMW protocol player number")
+	rss := flag.Int("rss", -1,
+		"semi-honest secure 3-party replicated secret sharing player number")
+	arith := flag.Int("arith", -1,
+		"semi-honest secure 2-party arithmetic secret sharing player number")
+	malicious := flag.Bool("malicious", false,
+		"run BMR with authenticated shares, aborting on cheating players")
+	peers := flag.String("peers", "",
+		"network configuration `file` with the party addresses and keys "+
+			"(default loopback ports 8080...)")
+	key := flag.String("key", "",
+		"party key `file` for authenticated and encrypted connections")
+	keygen := flag.String("keygen", "", "create a new party key `file`")
+	pregarble := flag.String("pregarble", "",
+		"garble circuit offline into `prefix`.{garbler,evaluator}")
+	offlineFile := flag.String("offline", "",
+		"run protocol with offline garbled circuit `file`")
+	ledger := flag.String("ledger", "offline.ledger",
+		"offline garbled circuit consumption ledger `directory`")
+	flag.DurationVar(&timeout, "timeout", 0,
+		"abort protocol runs after `duration` (0 for no limit)")
+	flag.DurationVar(&phaseTimeout, "phase-timeout", 0,
+		"abort protocol phases (OT, garbled tables, result) after "+
+			"`duration` (0 for no limit)")
+	netem := flag.String("netem", "",
+		"emulate network conditions `spec` for benchmarks, for example "+
+			"latency=50ms,jitter=5ms,bandwidth=100M,packet=1460")
+	flag.StringVar(&dealerAddr, "dealer", "",
+		"fetch BMR and GMW preprocessing from the trusted dealer at "+
+			"`address` (INSECURE, for testing and benchmarking only)")
+	serve := flag.String("serve", "",
+		"run garbler daemon for the program registry `file`")
+	logDir := flag.String("log-dir", "",
+		"write garbler daemon session logs to `directory`")
+	program := flag.String("program", "",
+		"run the `name`d program of the garbler daemon")
+	fingerprint := flag.String("fingerprint", "",
+		"expected hex `fingerprint` of the garbler daemon program circuit")
+	serveDealer := flag.Int("serve-dealer", 0,
+		"run a trusted dealer for `parties` parties at the -dealer address "+
+			"(INSECURE)")
 	flag.Parse()
 
 	log.SetFlags(0)
 
 	verbose = *fVerbose
 
+	if len(*netem) > 0 {
+		var err error
+		emulation, err = p2p.ParseEmulation(*netem)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if len(*cpuprofile) > 0 {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -108,13 +171,51 @@ func main() {
 		return
 	}
 
+	if *serveDealer > 0 {
+		if err := dealerMode(*serveDealer); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(*keygen) > 0 {
+		id, err := p2p.NewIdentity()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := id.Save(*keygen); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("public key: %s\n", id.PublicKey)
+		return
+	}
+
 	var err error
 
 	oti := ot.NewCO()
 
+	if len(*serve) > 0 {
+		if err := serviceMode(*serve, params, *logDir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(*program) > 0 {
+		if err := clientMode(oti, *program, *fingerprint); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if *stream {
+		if len(*key) > 0 {
+			if err := loadPartyKeys(*peers, *key, *evaluator); err != nil {
+				log.Fatal(err)
+			}
+		}
 		if *evaluator {
-			err = streamEvaluatorMode(oti, inputFlag, len(*cpuprofile) > 0)
+			err = streamEvaluatorMode(params, oti, inputFlag, flag.Args(),
+				len(*cpuprofile) > 0)
 		} else {
 			err = streamGarblerMode(params, oti, inputFlag, flag.Args())
 		}
@@ -131,17 +232,59 @@ func main() {
 	file := flag.Args()[0]
 
 	if *bmr >= 0 {
-		err = bmrMode(file, params, *bmr)
+		err = bmrMode(file, params, *bmr, *peers, *key, *malicious)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *gmw >= 0 {
+		err = gmwMode(file, params, *gmw, *peers, *key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *rss >= 0 {
+		err = rssMode(file, params, *rss, *peers, *key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *arith >= 0 {
+		err = arithMode(file, params, *arith, *peers, *key)
 		if err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
+	if len(*pregarble) > 0 {
+		err = pregarbleMode(file, params, *pregarble)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var off *offline
+	if len(*offlineFile) > 0 {
+		off = &offline{
+			file:   *offlineFile,
+			ledger: *ledger,
+		}
+	}
+
+	if len(*key) > 0 {
+		if err := loadPartyKeys(*peers, *key, *evaluator); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if *evaluator {
-		err = evaluatorMode(oti, file, params, len(*cpuprofile) > 0)
+		err = evaluatorMode(oti, file, params, off, len(*cpuprofile) > 0)
 	} else {
-		err = garblerMode(oti, file, params)
+		err = garblerMode(oti, file, params, off)
 	}
 	if err != nil {
 		log.Fatal(err)
@@ -177,6 +320,100 @@ func loadCircuit(file string, params *utils.Params, inputSizes [][]int) (
 	return circ, err
 }
 
+// newContext creates a context for a protocol run. The context
+// implements the -timeout and -phase-timeout limits.
+func newContext() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if phaseTimeout > 0 {
+		ctx = circuit.WithTimeouts(ctx, circuit.Timeouts{
+			Tables: phaseTimeout,
+			OT:     phaseTimeout,
+			Result: phaseTimeout,
+		})
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// newConn creates a protocol connection for the network
+// connection. The connection emulates the -netem network conditions.
+func newConn(nc net.Conn) *p2p.Conn {
+	if emulation != nil {
+		return p2p.NewConn(p2p.EmulateNet(nc, emulation))
+	}
+	return p2p.NewConn(nc)
+}
+
+// loadPartyKeys loads our party key from the key file and the peer's
+// public key from the network configuration file for the two-party
+// connections. The garbler is the party 0 and the evaluator the party
+// 1 of the configuration.
+func loadPartyKeys(peers, key string, evaluator bool) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("party key requires network configuration")
+	}
+	config, err := p2p.LoadConfig(peers)
+	if err != nil {
+		return err
+	}
+	self, other := 0, 1
+	if evaluator {
+		self, other = 1, 0
+	}
+	id, err := p2p.LoadIdentity(key)
+	if err != nil {
+		return err
+	}
+	our, err := config.Peer(self)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(our.PublicKey, id.PublicKey) {
+		return fmt.Errorf("peer %d: identity does not match public key %s",
+			self, our.PublicKey)
+	}
+	peer, err := config.Peer(other)
+	if err != nil {
+		return err
+	}
+	if len(peer.PublicKey) == 0 {
+		return fmt.Errorf("peer %d: no public key", other)
+	}
+	identity = id
+	peerKey = peer.PublicKey
+	return nil
+}
+
+// newSecureConn creates a protocol connection for the two-party
+// network connection. If the party keys are loaded, the connection
+// is secured with our key and the peer is authenticated with its
+// public key. The evaluator runs the server side of the handshake.
+func newSecureConn(ctx context.Context, nc net.Conn, server bool) (
+	*p2p.Conn, error) {
+
+	if identity == nil {
+		return newConn(nc), nil
+	}
+	if emulation != nil {
+		nc = p2p.EmulateNet(nc, emulation)
+	}
+	trusted := []p2p.PublicKey{peerKey}
+	var tc *tls.Conn
+	var err error
+	if server {
+		tc, _, err = p2p.SecureServer(ctx, nc, identity, trusted)
+	} else {
+		tc, _, err = p2p.SecureClient(ctx, nc, identity, trusted)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return p2p.NewConn(tc), nil
+}
+
 func printInputs(evaluator bool, circ *circuit.Circuit) {
 	var i1t, i2t string
 	if evaluator {
@@ -212,7 +449,7 @@ func memProfile(file string) {
 }
 
 func evaluatorMode(oti ot.OT, file string, params *utils.Params,
-	once bool) error {
+	off *offline, once bool) error {
 
 	inputSizes := make([][]int, 2)
 	myInputSizes, err := circuit.InputSizes(inputFlag)
@@ -237,7 +474,13 @@ func evaluatorMode(oti ot.OT, file string, params *utils.Params,
 		}
 		fmt.Printf("New connection from %s\n", nc.RemoteAddr())
 
-		conn := p2p.NewConn(nc)
+		ctx, cancel := newContext()
+		conn, err := newSecureConn(ctx, nc, true)
+		if err != nil {
+			cancel()
+			return err
+		}
+		release := conn.WithContext(ctx)
 
 		err = conn.SendInputSizes(myInputSizes)
 		if err != nil {
@@ -272,10 +515,20 @@ func evaluatorMode(oti ot.OT, file string, params *utils.Params,
 
 		input, err := circ.Inputs[1].Parse(inputFlag)
 		if err != nil {
+			err = circuit.Abort(conn,
+				fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err))
 			conn.Close()
 			return err
 		}
-		result, err := circuit.Evaluator(conn, oti, circ, input, verbose)
+		var result []*big.Int
+		if off != nil {
+			result, err = off.evaluator(ctx, conn, oti, circ, input)
+		} else {
+			result, err = circuit.Evaluator(ctx, conn, oti, circ, input,
+				verbose)
+		}
+		release()
+		cancel()
 		conn.Close()
 		if err != nil && err != io.EOF {
 			return err
@@ -287,7 +540,8 @@ func evaluatorMode(oti ot.OT, file string, params *utils.Params,
 	}
 }
 
-func garblerMode(oti ot.OT, file string, params *utils.Params) error {
+func garblerMode(oti ot.OT, file string, params *utils.Params,
+	off *offline) error {
 	inputSizes := make([][]int, 2)
 	myInputSizes, err := circuit.InputSizes(inputFlag)
 	if err != nil {
@@ -295,13 +549,24 @@ func garblerMode(oti ot.OT, file string, params *utils.Params) error {
 	}
 	inputSizes[0] = myInputSizes
 
-	nc, err := net.Dial("tcp", port)
+	ctx, cancel := newContext()
+	defer cancel()
+
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", port)
+	if err != nil {
+		return err
+	}
+	conn, err := newSecureConn(ctx, nc, false)
 	if err != nil {
 		return err
 	}
-	conn := p2p.NewConn(nc)
 	defer conn.Close()
 
+	// Bind the input size exchange to the context.
+	release := conn.WithContext(ctx)
+	defer release()
+
 	peerInputSizes, err := conn.ReceiveInputSizes()
 	if err != nil {
 		conn.Close()
@@ -331,9 +596,27 @@ func garblerMode(oti ot.OT, file string, params *utils.Params) error {
 
 	input, err := circ.Inputs[0].Parse(inputFlag)
 	if err != nil {
-		return err
+		return circuit.Abort(conn,
+			fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err))
+	}
+	var shares *big.Int
+	if len(sharesFlag) > 0 {
+		shares, err = circ.Inputs[1].Parse(sharesFlag)
+		if err != nil {
+			return circuit.Abort(conn,
+				fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err))
+		}
+	}
+	var result []*big.Int
+	if off != nil {
+		if shares != nil {
+			return fmt.Errorf("offline mode does not support shares")
+		}
+		result, err = off.garbler(ctx, conn, oti, circ, input)
+	} else {
+		result, err = circuit.GarblerShared(ctx, conn, oti, circ, input,
+			shares, verbose)
 	}
-	result, err := circuit.Garbler(conn, oti, circ, input, verbose)
 	if err != nil {
 		return err
 	}
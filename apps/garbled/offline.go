@@ -0,0 +1,111 @@
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// offline specifies the offline garbled circuit and its consumption
+// ledger.
+type offline struct {
+	file   string
+	ledger string
+}
+
+func (o *offline) load(circ *circuit.Circuit, parts int, role string) (
+	*circuit.Offline, circuit.Tracker, error) {
+
+	garbled, err := circuit.LoadOffline(o.file, circ)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := garbled.Verify(circ, parts); err != nil {
+		return nil, nil, err
+	}
+	// Parties track their consumption separately so that they can
+	// share the ledger directory.
+	tracker, err := circuit.NewDirTracker(filepath.Join(o.ledger, role))
+	if err != nil {
+		return nil, nil, err
+	}
+	return garbled, tracker, nil
+}
+
+func (o *offline) garbler(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *circuit.Circuit, input *big.Int) ([]*big.Int, error) {
+
+	garbled, tracker, err := o.load(circ, circuit.OfflineGarbler, "garbler")
+	if err != nil {
+		return nil, err
+	}
+	return circuit.GarblerOffline(ctx, conn, oti, circ, garbled, tracker,
+		input, verbose)
+}
+
+func (o *offline) evaluator(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *circuit.Circuit, input *big.Int) ([]*big.Int, error) {
+
+	garbled, tracker, err := o.load(circ, circuit.OfflineEvaluator,
+		"evaluator")
+	if err != nil {
+		return nil, err
+	}
+	return circuit.EvaluatorOffline(ctx, conn, oti, circ, garbled, tracker,
+		input, verbose)
+}
+
+// pregarbleMode garbles the circuit offline and writes the garbler
+// and evaluator parts to the files prefix.garbler and
+// prefix.evaluator.
+func pregarbleMode(file string, params *utils.Params, prefix string) error {
+	circ, err := loadCircuit(file, params, nil)
+	if err != nil {
+		return err
+	}
+	garbled, err := circ.GarbleOffline()
+	if err != nil {
+		return err
+	}
+
+	parts := []struct {
+		suffix string
+		part   int
+		mode   os.FileMode
+	}{
+		{".garbler", circuit.OfflineGarbler, 0600},
+		{".evaluator", circuit.OfflineEvaluator, 0644},
+	}
+	for _, part := range parts {
+		name := prefix + part.suffix
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+			part.mode)
+		if err != nil {
+			return err
+		}
+		err = garbled.Marshal(f, part.part)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", name)
+	}
+	fmt.Printf("Offline garbled circuit %s\n", garbled.ID)
+
+	return nil
+}
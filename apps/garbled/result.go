@@ -19,7 +19,10 @@ import (
 
 func printResults(results []*big.Int, outputs circuit.IO) {
 	for idx, result := range results {
-		if outputs == nil {
+		if result == nil {
+			// The output is delivered only to the peer.
+			fmt.Printf("Result[%d]: -\n", idx)
+		} else if outputs == nil {
 			fmt.Printf("Result[%d]: %v\n", idx, result)
 			fmt.Printf("Result[%d]: 0b%s\n", idx, result.Text(2))
 			bytes := result.Bytes()
@@ -0,0 +1,22 @@
+//
+// rss.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+)
+
+func rssMode(file string, params *utils.Params, player int,
+	peers, key string) error {
+	fmt.Printf("semi-honest secure 3-party replicated secret sharing\n")
+	return playerMode(file, params, player, peers, key, circuit.RSS, nil)
+}
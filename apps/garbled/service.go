@@ -0,0 +1,119 @@
+//
+// service.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/service"
+)
+
+// serviceMode runs the garbler daemon for the programs of the
+// registry file. The first interrupt stops accepting new sessions and
+// waits for the running sessions to complete; the sessions that don't
+// complete within the server's shutdown grace period are canceled.
+// The second interrupt exits immediately.
+func serviceMode(file string, params *utils.Params, logDir string) error {
+	registry, err := service.LoadRegistry(file, params)
+	if err != nil {
+		return err
+	}
+	for _, name := range registry.Names() {
+		prog, err := registry.Program(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf(" - %s: %s\n", name, prog.File)
+		fps, err := prog.Fingerprints()
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for key := range fps {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fp := fps[key]
+			fmt.Printf("   %s: %x\n", key, fp[:])
+		}
+	}
+	if len(logDir) > 0 {
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+		<-sigs
+		log.Fatal("interrupted")
+	}()
+
+	ln, err := net.Listen("tcp", port)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Listening for sessions at %s\n", port)
+
+	server := service.NewServer(registry)
+	server.LogDir = logDir
+	server.NewConn = newConn
+	server.NewContext = newContext
+
+	err = server.Serve(ctx, ln)
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+// clientMode runs the program of the garbler daemon with our inputs.
+// The garbler daemon's circuit must have the hex fingerprint.
+func clientMode(oti ot.OT, program, fingerprint string) error {
+	fp, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		return fmt.Errorf("invalid fingerprint: %s", err)
+	}
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", port)
+	if err != nil {
+		return err
+	}
+	conn := newConn(nc)
+	defer conn.Close()
+
+	outputs, result, err := service.Run(ctx, conn, oti, program, fp,
+		inputFlag)
+	if err != nil {
+		return err
+	}
+	printResults(result, outputs)
+	return nil
+}
@@ -16,15 +16,23 @@ import (
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
-	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
 )
 
-func streamEvaluatorMode(oti ot.OT, input input, once bool) error {
+func streamEvaluatorMode(params *utils.Params, oti ot.OT, input input,
+	args []string, once bool) error {
+
 	inputSizes, err := circuit.InputSizes(input)
 	if err != nil {
 		return err
 	}
 
+	// The evaluator compiles the program and verifies that the
+	// garbler streams the same program.
+	if len(args) != 1 || !strings.HasSuffix(args[0], ".qcl") {
+		return fmt.Errorf("streaming mode takes single QCL file")
+	}
+	file := args[0]
+
 	ln, err := net.Listen("tcp", port)
 	if err != nil {
 		return err
@@ -38,7 +46,13 @@ func streamEvaluatorMode(oti ot.OT, input input, once bool) error {
 		}
 		fmt.Printf("New connection from %s\n", nc.RemoteAddr())
 
-		conn := p2p.NewConn(nc)
+		ctx, cancel := newContext()
+		conn, err := newSecureConn(ctx, nc, true)
+		if err != nil {
+			cancel()
+			return err
+		}
+		release := conn.WithContext(ctx)
 
 		err = conn.SendInputSizes(inputSizes)
 		if err != nil {
@@ -50,9 +64,22 @@ func streamEvaluatorMode(oti ot.OT, input input, once bool) error {
 			conn.Close()
 			return err
 		}
+		peerInputSizes, err := conn.ReceiveInputSizes()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		program, err := compiler.New(params).StreamProgramFile(file,
+			[][]int{peerInputSizes, inputSizes})
+		if err != nil {
+			conn.Close()
+			return err
+		}
 
-		outputs, result, err := circuit.StreamEvaluator(conn, oti, input,
-			verbose)
+		outputs, result, err := circuit.StreamEvaluator(ctx, conn, oti,
+			program, input, verbose)
+		release()
+		cancel()
 		conn.Close()
 
 		if err != nil && err != io.EOF {
@@ -80,21 +107,37 @@ func streamGarblerMode(params *utils.Params, oti ot.OT, input input,
 	if len(args) != 1 || !strings.HasSuffix(args[0], ".qcl") {
 		return fmt.Errorf("streaming mode takes single QCL file")
 	}
-	nc, err := net.Dial("tcp", port)
+	ctx, cancel := newContext()
+	defer cancel()
+
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", port)
+	if err != nil {
+		return err
+	}
+	conn, err := newSecureConn(ctx, nc, false)
 	if err != nil {
 		return err
 	}
-	conn := p2p.NewConn(nc)
 	defer conn.Close()
 
+	// Bind the input size exchange to the context.
+	release := conn.WithContext(ctx)
+	defer release()
+
 	sizes, err = conn.ReceiveInputSizes()
 	if err != nil {
 		return err
 	}
 	inputSizes[1] = sizes
 
-	outputs, result, err := compiler.New(params).StreamFile(
-		conn, oti, args[0], input, inputSizes)
+	// Send our input sizes for the evaluator's program fingerprint.
+	if err := conn.SendInputSizes(inputSizes[0]); err != nil {
+		return err
+	}
+
+	outputs, result, err := compiler.New(params).StreamFile(ctx,
+		conn, oti, args[0], input, sharesFlag, inputSizes)
 	if err != nil {
 		return err
 	}
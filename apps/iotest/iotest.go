@@ -12,12 +12,22 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
 )
 
+// newConn creates a protocol connection for the network
+// connection. The connection emulates the -netem network conditions.
+func newConn(nc net.Conn) *p2p.Conn {
+	if emulation != nil {
+		return p2p.NewConn(p2p.EmulateNet(nc, emulation))
+	}
+	return p2p.NewConn(nc)
+}
+
 func evaluatorTestIO(size int64, once bool) error {
 	ln, err := net.Listen("tcp", port)
 	if err != nil {
@@ -32,7 +42,8 @@ func evaluatorTestIO(size int64, once bool) error {
 		}
 		fmt.Printf("New connection from %s\n", nc.RemoteAddr())
 
-		conn := p2p.NewConn(nc)
+		conn := newConn(nc)
+		start := time.Now()
 		for {
 			var label ot.Label
 			var labelData ot.LabelData
@@ -44,8 +55,8 @@ func evaluatorTestIO(size int64, once bool) error {
 				return err
 			}
 		}
-		fmt.Printf("Received: %v\n",
-			circuit.FileSize(conn.Stats.Sum()).String())
+		fmt.Printf("Received: %v in %s\n",
+			circuit.FileSize(conn.Stats.Sum()).String(), time.Since(start))
 
 		if once {
 			return nil
@@ -58,7 +69,8 @@ func garblerTestIO(size int64) error {
 	if err != nil {
 		return err
 	}
-	conn := p2p.NewConn(nc)
+	conn := newConn(nc)
+	start := time.Now()
 
 	var sent int64
 	var label ot.Label
@@ -78,6 +90,7 @@ func garblerTestIO(size int64) error {
 		return err
 	}
 
-	fmt.Printf("Sent: %v\n", circuit.FileSize(conn.Stats.Sum()).String())
+	fmt.Printf("Sent: %v in %s\n", circuit.FileSize(conn.Stats.Sum()).String(),
+		time.Since(start))
 	return nil
 }
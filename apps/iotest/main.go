@@ -13,20 +13,34 @@ import (
 	"log"
 	"os"
 	"runtime/pprof"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
 )
 
 var (
-	port = ":8080"
+	port      = ":8080"
+	emulation *p2p.Emulation
 )
 
 func main() {
 	evaluator := flag.Bool("e", false, "evaluator / garbler mode")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
 	testIO := flag.Int64("test-io", 0, "test I/O performance")
+	netem := flag.String("netem", "",
+		"emulate network conditions `spec`, for example "+
+			"latency=50ms,bandwidth=100M,packet=1460")
 	flag.Parse()
 
 	log.SetFlags(0)
 
+	if len(*netem) > 0 {
+		var err error
+		emulation, err = p2p.ParseEmulation(*netem)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if len(*cpuprofile) > 0 {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
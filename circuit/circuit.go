@@ -138,6 +138,40 @@ func (io IO) Split(in *big.Int) []*big.Int {
 	return result
 }
 
+// Visible returns the output bits that are visible to the garbler or
+// to the evaluator.
+func (io IO) Visible(garbler bool) []bool {
+	var result []bool
+	for _, arg := range io {
+		var visible bool
+		if garbler {
+			visible = arg.Recipient.Garbler()
+		} else {
+			visible = arg.Recipient.Evaluator()
+		}
+		for i := 0; i < int(arg.Type.Bits); i++ {
+			result = append(result, visible)
+		}
+	}
+	return result
+}
+
+// SplitVisible splits the value into separate I/O arguments like
+// Split but returns nil for the arguments that are not delivered to
+// the garbler or to the evaluator. The shared arguments are delivered
+// to both parties.
+func (io IO) SplitVisible(in *big.Int, garbler bool) []*big.Int {
+	result := io.Split(in)
+	for idx, arg := range io {
+		if garbler && !arg.Recipient.Garbler() &&
+			arg.Recipient != RecipientShared ||
+			!garbler && !arg.Recipient.Evaluator() {
+			result[idx] = nil
+		}
+	}
+	return result
+}
+
 // Circuit specifies a boolean circuit.
 type Circuit struct {
 	NumGates int
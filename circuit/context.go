@@ -0,0 +1,186 @@
+//
+// context.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Phase specifies a protocol phase.
+type Phase int
+
+// Protocol phases.
+const (
+	PhaseTables Phase = iota
+	PhaseOT
+	PhaseResult
+)
+
+var phaseNames = map[Phase]string{
+	PhaseTables: "garbled tables",
+	PhaseOT:     "OT",
+	PhaseResult: "result",
+}
+
+func (p Phase) String() string {
+	name, ok := phaseNames[p]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Phase %d}", p)
+}
+
+// Timeouts define the per-phase time limits of a protocol run. The
+// zero value means no time limit.
+type Timeouts struct {
+	// Tables limits the garbled table transfer.
+	Tables time.Duration
+	// OT limits the input label transfer and the oblivious
+	// transfers.
+	OT time.Duration
+	// Result limits the result resolution.
+	Result time.Duration
+}
+
+func (t Timeouts) timeout(phase Phase) time.Duration {
+	switch phase {
+	case PhaseTables:
+		return t.Tables
+	case PhaseOT:
+		return t.OT
+	case PhaseResult:
+		return t.Result
+	default:
+		return 0
+	}
+}
+
+type timeoutsKey struct{}
+
+// WithTimeouts returns a copy of the context that carries the
+// per-phase timeouts for the protocol runs.
+func WithTimeouts(ctx context.Context, timeouts Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, timeouts)
+}
+
+// PhaseError is returned when a protocol phase is aborted because its
+// context was canceled or its deadline expired. The error unwraps to
+// the context error so errors.Is(err, context.DeadlineExceeded)
+// tells if the phase timed out.
+type PhaseError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("%s phase aborted: %s", e.Phase, e.Err)
+}
+
+// Unwrap returns the underlying context error.
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// Phases binds the protocol I/O to the current protocol phase. Each
+// phase gets its own context with the phase's timeout from the
+// context's Timeouts.
+type Phases struct {
+	ctx     context.Context
+	bind    func(ctx context.Context) func()
+	phase   Phase
+	pctx    context.Context
+	cancel  context.CancelFunc
+	release func()
+}
+
+// NewPhases creates protocol phases for the context. The bind
+// function binds the protocol I/O to the phase context, see
+// p2p.Conn.WithContext.
+func NewPhases(ctx context.Context,
+	bind func(ctx context.Context) func()) *Phases {
+
+	return &Phases{
+		ctx:  ctx,
+		bind: bind,
+	}
+}
+
+// Enter leaves the current phase and enters the argument phase.
+func (p *Phases) Enter(phase Phase) error {
+	p.Leave()
+	if err := p.ctx.Err(); err != nil {
+		return &PhaseError{
+			Phase: phase,
+			Err:   err,
+		}
+	}
+	p.phase = phase
+	p.pctx = p.ctx
+
+	timeouts, _ := p.ctx.Value(timeoutsKey{}).(Timeouts)
+	if timeout := timeouts.timeout(phase); timeout > 0 {
+		p.pctx, p.cancel = context.WithTimeout(p.ctx, timeout)
+	}
+	p.release = p.bind(p.pctx)
+	return nil
+}
+
+// Leave leaves the current phase.
+func (p *Phases) Leave() {
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+// Wrap maps the error err to a PhaseError if the current phase was
+// aborted. The function must be called before leaving the phase.
+func (p *Phases) Wrap(err error) error {
+	if err == nil || p.pctx == nil || p.pctx.Err() == nil {
+		return err
+	}
+	if _, ok := err.(*PhaseError); ok {
+		return err
+	}
+	return &PhaseError{
+		Phase: p.phase,
+		Err:   p.pctx.Err(),
+	}
+}
+
+// runPhase runs the protocol phase f with the I/O bound to the phase
+// context by bind.
+func runPhase(ctx context.Context, phase Phase,
+	bind func(ctx context.Context) func(), f func() error) error {
+
+	p := NewPhases(ctx, bind)
+	if err := p.Enter(phase); err != nil {
+		return err
+	}
+	err := p.Wrap(f())
+	p.Leave()
+
+	return err
+}
+
+// connPhase runs the protocol phase f with the connection I/O bound
+// to the phase context.
+func connPhase(ctx context.Context, conn *p2p.Conn, phase Phase,
+	f func() error) error {
+
+	return runPhase(ctx, phase, conn.WithContext, f)
+}
@@ -0,0 +1,119 @@
+//
+// context_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+func TestPhaseTimeout(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16}, 512, 8)
+
+	// The garbler never sends the garbled tables.
+	ec, gc := net.Pipe()
+	defer gc.Close()
+
+	ctx := WithTimeouts(context.Background(), Timeouts{
+		Tables: 50 * time.Millisecond,
+	})
+	_, err := Evaluator(ctx, p2p.NewConn(ec), ot.NewCO(), circ,
+		big.NewInt(0), false)
+
+	var phaseErr *PhaseError
+	if !errors.As(err, &phaseErr) {
+		t.Fatalf("expected PhaseError, got %v", err)
+	}
+	if phaseErr.Phase != PhaseTables {
+		t.Errorf("aborted phase %s, expected %s", phaseErr.Phase, PhaseTables)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16}, 512, 8)
+
+	// The evaluator receives the garbled tables but stalls in OT.
+	gc, ec := net.Pipe()
+	defer ec.Close()
+	go func() {
+		conn := p2p.NewConn(ec)
+		if _, err := conn.ReceiveData(); err != nil {
+			return
+		}
+		receiveGarbledTables(conn, circ)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err := Garbler(ctx, p2p.NewConn(gc), ot.NewCO(), circ, big.NewInt(0),
+		false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16}, 512, 8)
+
+	gc, ec := net.Pipe()
+
+	ctx := WithTimeouts(context.Background(), Timeouts{
+		Tables: time.Minute,
+		OT:     time.Minute,
+		Result: time.Minute,
+	})
+
+	done := make(chan protocolResult)
+	go func() {
+		conn := p2p.NewConn(ec)
+		result, err := Evaluator(ctx, conn, ot.NewCO(), circ, big.NewInt(3),
+			false)
+		conn.Close()
+		done <- protocolResult{
+			result: result,
+			err:    err,
+		}
+	}()
+
+	conn := p2p.NewConn(gc)
+	gResult, err := Garbler(ctx, conn, ot.NewCO(), circ, big.NewInt(5),
+		false)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Garbler failed: %s", err)
+	}
+	eResult := <-done
+	if eResult.err != nil {
+		t.Fatalf("Evaluator failed: %s", eResult.err)
+	}
+	expected, err := circ.Compute([]*big.Int{big.NewInt(5), big.NewInt(3)})
+	if err != nil {
+		t.Fatalf("Compute failed: %s", err)
+	}
+	for i := range expected {
+		if gResult[i].Cmp(expected[i]) != 0 ||
+			eResult.result[i].Cmp(expected[i]) != 0 {
+			t.Errorf("result %d: got %v/%v, expected %v", i, gResult[i],
+				eResult.result[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,204 @@
+//
+// cot.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Correlated oblivious transfers. The authenticated shares of the
+// BMR protocol are created with the IKNP OT extension: the MAC key
+// holder is the receiver of labelBits base OTs and its global key
+// Delta is its choice bits. The MAC holder extends the base OTs to
+// its bits so that each MAC is M = K XOR x*Delta. Since the key
+// holder chooses Delta only once in the base OTs, all MACs of the
+// peer are correlated with the same Delta.
+
+// labelBits is the number of bits in labels and in the elements of
+// GF(2^128).
+const labelBits = 128
+
+// authenticate authenticates the bits with the peer. The function
+// returns our MACs for the bits and our keys for the peer's bits. The
+// peer must authenticate the same number of bits.
+func authenticate(p *p2p.Peer, we, peer int, bits []byte,
+	delta ot.Label) (macs, keys []ot.Label, err error) {
+
+	conn := p.Conn()
+	count := len(bits)
+	colSize := (count + 7) / 8
+
+	// As the key holder, we receive the base OT seeds with the bits of
+	// our Delta, and derive our keys from the peer's columns.
+	keyHolder := func() error {
+		receiver := p.OT()
+		if err := receiver.InitReceiver(conn); err != nil {
+			return err
+		}
+		flags := make([]bool, labelBits)
+		for k := range flags {
+			flags[k] = labelBit(delta, k) != 0
+		}
+		seeds := make([]ot.Label, labelBits)
+		if err := receiver.Receive(flags, seeds); err != nil {
+			return err
+		}
+		data, err := receiveChunked(conn)
+		if err != nil {
+			return err
+		}
+		if len(data) != labelBits*colSize {
+			return fmt.Errorf("%w: %d bytes of OT extension columns",
+				ErrProtocolMismatch, len(data))
+		}
+		cols := make([][]byte, labelBits)
+		for k := range cols {
+			cols[k] = expand(seeds[k], colSize)
+			if flags[k] {
+				xorBytes(cols[k], data[k*colSize:(k+1)*colSize])
+			}
+		}
+		keys = transpose(cols, count)
+		return nil
+	}
+
+	// As the MAC holder, we send the base OT seeds and the columns
+	// that correct the key holder's columns to our bits.
+	macHolder := func() error {
+		sender := p.OT()
+		if err := sender.InitSender(conn); err != nil {
+			return err
+		}
+		wires := make([]ot.Wire, labelBits)
+		for k := range wires {
+			var err error
+			wires[k].L0, err = ot.NewLabel(rand.Reader)
+			if err != nil {
+				return err
+			}
+			wires[k].L1, err = ot.NewLabel(rand.Reader)
+			if err != nil {
+				return err
+			}
+		}
+		if err := sender.Send(wires); err != nil {
+			return err
+		}
+		x := packBits(bits)
+		cols := make([][]byte, labelBits)
+		msg := make([]byte, 0, labelBits*colSize)
+		for k := range cols {
+			cols[k] = expand(wires[k].L0, colSize)
+			u := expand(wires[k].L1, colSize)
+			xorBytes(u, cols[k])
+			xorBytes(u, x)
+			msg = append(msg, u...)
+		}
+		if err := sendChunked(conn, msg); err != nil {
+			return err
+		}
+		macs = transpose(cols, count)
+		return nil
+	}
+
+	if err := pairwise(we, peer, keyHolder, macHolder); err != nil {
+		return nil, nil, err
+	}
+	return macs, keys, nil
+}
+
+// expand expands the seed into size pseudorandom bytes.
+func expand(seed ot.Label, size int) []byte {
+	var key ot.LabelData
+	seed.GetData(&key)
+	alg, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	buf := make([]byte, size)
+	cipher.NewCTR(alg, make([]byte, alg.BlockSize())).XORKeyStream(buf, buf)
+	return buf
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// transpose transposes the labelBits packed columns into count
+// labels. The bit i of the column k is the bit k of the label i.
+func transpose(cols [][]byte, count int) []ot.Label {
+	result := make([]ot.Label, count)
+	for k, col := range cols {
+		for i := 0; i < count; i++ {
+			if col[i/8]&(1<<(i%8)) == 0 {
+				continue
+			}
+			if k < 64 {
+				result[i].D1 |= 1 << k
+			} else {
+				result[i].D0 |= 1 << (k - 64)
+			}
+		}
+	}
+	return result
+}
+
+// labelBit returns the bit k of the label. The label D0 holds the
+// bits 64-127 and D1 the bits 0-63.
+func labelBit(l ot.Label, k int) byte {
+	if k < 64 {
+		return byte(l.D1>>k) & 1
+	}
+	return byte(l.D0>>(k-64)) & 1
+}
+
+// gfBasis returns the element X^k of GF(2^128).
+func gfBasis(k int) ot.Label {
+	if k < 64 {
+		return ot.Label{D1: 1 << k}
+	}
+	return ot.Label{D0: 1 << (k - 64)}
+}
+
+// gfMul multiplies the elements a and b of GF(2^128) with the
+// reduction polynomial X^128 + X^7 + X^2 + X + 1. The bit k of the
+// labels is the coefficient of X^k.
+func gfMul(a, b ot.Label) ot.Label {
+	var r ot.Label
+	for _, d := range []uint64{b.D0, b.D1} {
+		for i := 63; i >= 0; i-- {
+			carry := r.D0 >> 63
+			r.D0 = r.D0<<1 | r.D1>>63
+			r.D1 = r.D1<<1 ^ carry*0x87
+
+			mask := -(d >> i & 1)
+			r.D0 ^= a.D0 & mask
+			r.D1 ^= a.D1 & mask
+		}
+	}
+	return r
+}
+
+// gfCombine returns the linear combination of the labels with the
+// coefficients.
+func gfCombine(coeffs, labels []ot.Label) ot.Label {
+	var r ot.Label
+	for i, l := range labels {
+		r.Xor(gfMul(coeffs[i], l))
+	}
+	return r
+}
@@ -0,0 +1,99 @@
+//
+// cot_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+func TestGFMul(t *testing.T) {
+	one := gfBasis(0)
+
+	// X^127 * X = X^128 = X^7 + X^2 + X + 1
+	r := gfMul(gfBasis(127), gfBasis(1))
+	if !r.Equal(ot.Label{D1: 0x87}) {
+		t.Errorf("X^128 = %v", r)
+	}
+
+	for i := 0; i < 100; i++ {
+		a, _ := ot.NewLabel(rand.Reader)
+		b, _ := ot.NewLabel(rand.Reader)
+		c, _ := ot.NewLabel(rand.Reader)
+
+		if r := gfMul(a, one); !r.Equal(a) {
+			t.Errorf("%v*1 = %v", a, r)
+		}
+		if !gfMul(a, b).Equal(gfMul(b, a)) {
+			t.Errorf("%v*%v is not commutative", a, b)
+		}
+		bc := b
+		bc.Xor(c)
+		expected := gfMul(a, b)
+		expected.Xor(gfMul(a, c))
+		if r := gfMul(a, bc); !r.Equal(expected) {
+			t.Errorf("%v*(%v+%v) is not distributive", a, b, c)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	networks := newTestNetworks(t, 2)
+
+	const count = 1000
+	type result struct {
+		id    int
+		bits  []byte
+		delta ot.Label
+		macs  []ot.Label
+		keys  []ot.Label
+		err   error
+	}
+	results := make(chan result)
+	for _, nw := range networks {
+		go func(nw *p2p.Network) {
+			r := result{
+				id: nw.ID,
+			}
+			r.bits, r.err = randomBits(count)
+			if r.err == nil {
+				r.delta, r.err = ot.NewLabel(rand.Reader)
+			}
+			if r.err == nil {
+				peer := 1 - nw.ID
+				r.macs, r.keys, r.err = authenticate(nw.Peers[peer], nw.ID,
+					peer, r.bits, r.delta)
+			}
+			results <- r
+		}(nw)
+	}
+	var parties [2]result
+	for range networks {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("party %d: %s", r.id, r.err)
+		}
+		parties[r.id] = r
+	}
+	for id, p := range parties {
+		peer := parties[1-id]
+		for h, bit := range p.bits {
+			expected := peer.keys[h]
+			if bit != 0 {
+				expected.Xor(peer.delta)
+			}
+			if !p.macs[h].Equal(expected) {
+				t.Fatalf("party %d: invalid MAC %d", id, h)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//
+// dealer.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"fmt"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// The multi-party protocols can run their preprocessing with the
+// correlations of a trusted dealer; see p2p.Dealer and
+// Network.Preprocess. The functions below return the correlations
+// that the protocols use. The dealt correlations are INSECURE and
+// meant only for testing and benchmarking.
+
+// PlayerCorrelations returns the correlations that Player uses: an
+// AND triple for the lambda product of each AND and OR gate, and
+// three correlated transfers for the R shares of each gate.
+func PlayerCorrelations(circ *Circuit) p2p.Correlations {
+	return p2p.Correlations{
+		Triples: numProducts(circ),
+		COTs:    3 * len(circ.Gates),
+	}
+}
+
+// GMWCorrelations returns the correlations that GMW uses: an AND
+// triple for each AND and OR gate.
+func GMWCorrelations(circ *Circuit) p2p.Correlations {
+	return p2p.Correlations{
+		Triples: numProducts(circ),
+	}
+}
+
+// MaliciousCorrelations returns the correlations that
+// MaliciousPlayer uses: authenticated random bits for the masks of
+// the input wires and the AND and OR gate outputs, and an
+// authenticated AND triple for the lambda product of each AND and OR
+// gate.
+func MaliciousCorrelations(circ *Circuit) p2p.Correlations {
+	return p2p.Correlations{
+		Bits:          circ.Inputs.Size() + numProducts(circ),
+		Triples:       numProducts(circ),
+		Authenticated: true,
+	}
+}
+
+// numProducts returns the number of AND and OR gates of the circuit.
+func numProducts(circ *Circuit) int {
+	var count int
+	for _, gate := range circ.Gates {
+		if gate.Op == AND || gate.Op == OR {
+			count++
+		}
+	}
+	return count
+}
+
+// dealtProducts computes our shares of x AND y with the dealt AND
+// triples (a, b, c). The parties open d = x XOR a and e = y XOR b, and
+// our share is c XOR d*b XOR e*a. The party 0 adds d*e to its share.
+func dealtProducts(nw *p2p.Network, dealt *p2p.Dealt, x, y []byte) (
+	[]byte, error) {
+
+	count := len(x)
+	a, b, c, err := dealt.Triples(count)
+	if err != nil {
+		return nil, err
+	}
+	de := make([]byte, 2*count)
+	for i := 0; i < count; i++ {
+		de[i] = x[i] ^ a.Bits[i]
+		de[count+i] = y[i] ^ b.Bits[i]
+	}
+	msg := packBits(de)
+	received, err := exchangeAll(nw, func(id int) []byte {
+		return msg
+	})
+	if err != nil {
+		return nil, err
+	}
+	for id, data := range received {
+		theirs := make([]byte, len(de))
+		if err := unpackBits(data, theirs); err != nil {
+			return nil, fmt.Errorf("peer %d: %w", id, err)
+		}
+		for i := range de {
+			de[i] ^= theirs[i]
+		}
+	}
+	result := make([]byte, count)
+	for i := 0; i < count; i++ {
+		d := de[i]
+		e := de[count+i]
+		result[i] = c.Bits[i] ^ d&b.Bits[i] ^ e&a.Bits[i]
+		if nw.ID == 0 {
+			result[i] ^= d & e
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+//
+// dealer_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var dealerTests = []struct {
+	name string
+	run  func(ctx context.Context, nw *p2p.Network, circ *Circuit,
+		inputs *big.Int, verbose bool) ([]*big.Int, error)
+	deal func(circ *Circuit) p2p.Correlations
+	// partial is set if the protocol does not compute the outputs.
+	partial bool
+}{
+	{
+		name:    "Player",
+		run:     Player,
+		deal:    PlayerCorrelations,
+		partial: true,
+	},
+	{
+		name: "GMW",
+		run:  GMW,
+		deal: GMWCorrelations,
+	},
+	{
+		name: "MaliciousPlayer",
+		run:  MaliciousPlayer,
+		deal: MaliciousCorrelations,
+	},
+}
+
+func TestDealer(t *testing.T) {
+	const numPlayers = 6
+
+	dealer, err := p2p.NewDealer("127.0.0.1:0", numPlayers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dealer.Close()
+	go dealer.Serve()
+
+	for _, test := range dealerTests {
+		t.Run(fmt.Sprintf("%s/%d-party", test.name, numPlayers),
+			func(t *testing.T) {
+				circ, inputs, expected, err := newMaliciousTest(numPlayers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				networks := newTestNetworks(t, numPlayers)
+
+				results := make(chan maliciousResult)
+				for i, nw := range networks {
+					go func(player int, nw *p2p.Network) {
+						ctx := context.Background()
+						err := nw.Preprocess(ctx, dealer.Addr(),
+							test.deal(circ))
+						if err != nil {
+							results <- maliciousResult{
+								player: player,
+								err:    err,
+							}
+							return
+						}
+						result, err := test.run(ctx, nw, circ,
+							inputs[player], false)
+						results <- maliciousResult{
+							player: player,
+							result: result,
+							err:    err,
+						}
+					}(i, nw)
+				}
+				for range networks {
+					r := <-results
+					if r.err != nil {
+						t.Fatalf("player %d: failed: %s", r.player, r.err)
+					}
+					if test.partial {
+						continue
+					}
+					for i := range expected {
+						if r.result[i].Cmp(expected[i]) != 0 {
+							t.Errorf("player %d: result %d: got %v, "+
+								"expected %v", r.player, i, r.result[i],
+								expected[i])
+						}
+					}
+				}
+			})
+	}
+}
@@ -0,0 +1,91 @@
+//
+// errors.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"errors"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Protocol errors. The protocol functions wrap these errors so
+// callers can tell the failure causes apart with errors.Is.
+var (
+	// ErrPeerAbort is returned when the peer aborted the protocol,
+	// see p2p.AbortError.
+	ErrPeerAbort = p2p.ErrPeerAbort
+
+	// ErrProtocolMismatch is returned when the peers run different
+	// circuits or protocol steps.
+	ErrProtocolMismatch = p2p.ErrProtocolMismatch
+
+	// ErrInvalidInput is returned when the input values can't be
+	// parsed for the circuit inputs.
+	ErrInvalidInput = errors.New("invalid input")
+
+	// ErrCorruptGarbledTable is returned when the evaluator receives
+	// garbled tables that don't match the circuit gates.
+	ErrCorruptGarbledTable = errors.New("corrupt garbled table")
+
+	// ErrInvalidOutputLabel is returned when the garbler receives an
+	// output label that is neither of the output wire's labels.
+	ErrInvalidOutputLabel = errors.New("invalid output label")
+
+	// ErrInvalidMAC is returned when a peer opens an authenticated
+	// share with an invalid MAC.
+	ErrInvalidMAC = errors.New("invalid MAC")
+
+	// ErrInvalidTriple is returned when the authenticated products
+	// of the preprocessing fail their consistency check.
+	ErrInvalidTriple = errors.New("invalid multiplication triple")
+
+	// ErrInvalidCorrelation is returned when the authenticated shares
+	// are not consistent with the parties' global MAC keys.
+	ErrInvalidCorrelation = errors.New("invalid MAC correlation")
+)
+
+// abortCode returns the abort code for the local protocol error
+// err. The function returns false if err should not be reported to
+// the peer.
+func abortCode(err error) (p2p.AbortCode, bool) {
+	switch {
+	case errors.Is(err, ErrPeerAbort):
+		return 0, false
+	case errors.Is(err, ErrProtocolMismatch), errors.Is(err, ErrConsumed):
+		return p2p.AbortProtocolMismatch, true
+	case errors.Is(err, ErrInvalidInput):
+		return p2p.AbortInvalidInput, true
+	case errors.Is(err, ErrCorruptGarbledTable):
+		return p2p.AbortCorruptGarbledTable, true
+	case errors.Is(err, ErrInvalidOutputLabel):
+		return p2p.AbortInvalidOutputLabel, true
+	case errors.Is(err, ErrInvalidMAC):
+		return p2p.AbortInvalidMAC, true
+	case errors.Is(err, ErrInvalidTriple):
+		return p2p.AbortInvalidTriple, true
+	case errors.Is(err, ErrInvalidCorrelation):
+		return p2p.AbortInvalidCorrelation, true
+	default:
+		return 0, false
+	}
+}
+
+// Abort reports the local protocol error err to the peer with an
+// abort message and returns err. I/O errors, context errors, and
+// peer aborts are not reported since the connection can't be used to
+// report them. The protocol entry points call Abort for their
+// errors so it is only needed when the caller itself detects a
+// protocol failure, for example when parsing its inputs.
+func Abort(conn *p2p.Conn, err error) error {
+	code, ok := abortCode(err)
+	if ok {
+		conn.Abort(code, err.Error())
+	}
+	return err
+}
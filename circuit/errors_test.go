@@ -0,0 +1,125 @@
+//
+// errors_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+func expectAbort(t *testing.T, err error, code p2p.AbortCode) {
+	t.Helper()
+	var abortErr *p2p.AbortError
+	if !errors.As(err, &abortErr) {
+		t.Fatalf("expected AbortError, got %v", err)
+	}
+	if !errors.Is(err, ErrPeerAbort) {
+		t.Errorf("%v is not %v", err, ErrPeerAbort)
+	}
+	if abortErr.Code != code {
+		t.Errorf("got abort code %s, expected %s", abortErr.Code, code)
+	}
+}
+
+func TestAbortMismatch(t *testing.T) {
+	gCirc := newRandomCircuit([]int{8, 8}, 32, 8)
+	eCirc := newRandomCircuit([]int{8, 8}, 16, 8)
+
+	gc, ec := net.Pipe()
+	defer gc.Close()
+	defer ec.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := Evaluator(context.Background(), p2p.NewConn(ec), ot.NewCO(),
+			eCirc, big.NewInt(3), false)
+		done <- err
+	}()
+
+	// The garbler claims the evaluator's circuit in the handshake but
+	// sends the tables of a different circuit.
+	conn := p2p.NewConn(gc)
+	fp, err := eCirc.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHandshake(ot.NewCO(), fp[:]).exchange(conn); err != nil {
+		t.Fatal(err)
+	}
+	garbled, err := gCirc.Garble(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendData(make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendGarbledTables(conn, garbled.Gates); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ReceiveUint32()
+	expectAbort(t, err, p2p.AbortProtocolMismatch)
+
+	err = <-done
+	if !errors.Is(err, ErrProtocolMismatch) {
+		t.Errorf("Evaluator: expected %v, got %v", ErrProtocolMismatch, err)
+	}
+}
+
+func TestAbortInvalidOutputLabel(t *testing.T) {
+	circ := newRandomCircuit([]int{8, 8}, 32, 8)
+
+	r, err := ot.NewLabel(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wires := make([]ot.Wire, circ.Outputs.Size())
+	for i := range wires {
+		wires[i], err = makeLabels(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gc, ec := net.Pipe()
+	defer gc.Close()
+	defer ec.Close()
+
+	// The evaluator returns a label that is not an output label.
+	done := make(chan error)
+	go func() {
+		conn := p2p.NewConn(ec)
+		label, err := ot.NewLabel(rand.Reader)
+		if err != nil {
+			done <- err
+			return
+		}
+		var labelData ot.LabelData
+		conn.SendLabel(label, &labelData)
+		conn.Flush()
+		_, err = conn.ReceiveData()
+		done <- err
+	}()
+
+	conn := p2p.NewConn(gc)
+	_, err = garblerResult(conn, circ.Outputs, wires, NewTiming())
+	if !errors.Is(Abort(conn, err), ErrInvalidOutputLabel) {
+		t.Errorf("expected %v, got %v", ErrInvalidOutputLabel, err)
+	}
+	expectAbort(t, <-done, p2p.AbortInvalidOutputLabel)
+}
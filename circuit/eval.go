@@ -8,6 +8,7 @@ package circuit
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"fmt"
 
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
@@ -26,90 +27,97 @@ func (c *Circuit) Eval(key []byte, wires []ot.Label,
 	var id uint32
 
 	for i := 0; i < len(c.Gates); i++ {
-		gate := &c.Gates[i]
+		err := c.Gates[i].eval(wires, alg, garbled[i], &id, &data)
+		if err != nil {
+			return err
+		}
+	}
 
-		var a, b, c ot.Label
+	return nil
+}
 
-		switch gate.Op {
-		case XOR, XNOR, AND, OR:
-			a = wires[gate.Input0]
-			b = wires[gate.Input1]
+// eval evaluates the gate and sets its output wire label.
+func (g *Gate) eval(wires []ot.Label, alg cipher.Block, row []ot.Label,
+	idp *uint32, data *ot.LabelData) error {
 
-		case INV:
-			a = wires[gate.Input0]
+	var a, b, c ot.Label
 
-		default:
-			return fmt.Errorf("invalid operation %s", gate.Op)
-		}
+	switch g.Op {
+	case XOR, XNOR, AND, OR:
+		a = wires[g.Input0]
+		b = wires[g.Input1]
 
-		var output ot.Label
+	case INV:
+		a = wires[g.Input0]
 
-		switch gate.Op {
-		case XOR, XNOR:
-			a.Xor(b)
-			output = a
+	default:
+		return fmt.Errorf("invalid operation %s", g.Op)
+	}
 
-		case AND:
-			row := garbled[i]
-			if len(row) != 2 {
-				return fmt.Errorf("corrupted ciruit: AND row length: %d",
-					len(row))
-			}
-			sa := a.S()
-			sb := b.S()
+	var output ot.Label
 
-			j0 := id
-			j1 := id + 1
-			id += 2
+	switch g.Op {
+	case XOR, XNOR:
+		a.Xor(b)
+		output = a
 
-			tg := row[0]
-			te := row[1]
+	case AND:
+		if len(row) != 2 {
+			return fmt.Errorf("%w: AND row length: %d",
+				ErrCorruptGarbledTable, len(row))
+		}
+		sa := a.S()
+		sb := b.S()
 
-			wg := encryptHalf(alg, a, j0, &data)
-			if sa {
-				wg.Xor(tg)
-			}
-			we := encryptHalf(alg, b, j1, &data)
-			if sb {
-				we.Xor(te)
-				we.Xor(a)
-			}
-			output = wg
-			output.Xor(we)
-
-		case OR:
-			row := garbled[i]
-			index := idx(a, b)
-			if index > 0 {
-				// First row is zero and not transmitted.
-				index--
-				if index >= len(row) {
-					return fmt.Errorf("corrupted circuit: index %d >= row %d",
-						index, len(row))
-				}
-				c = row[index]
+		j0 := *idp
+		j1 := *idp + 1
+		*idp = *idp + 2
+
+		tg := row[0]
+		te := row[1]
+
+		wg := encryptHalf(alg, a, j0, data)
+		if sa {
+			wg.Xor(tg)
+		}
+		we := encryptHalf(alg, b, j1, data)
+		if sb {
+			we.Xor(te)
+			we.Xor(a)
+		}
+		output = wg
+		output.Xor(we)
+
+	case OR:
+		index := idx(a, b)
+		if index > 0 {
+			// First row is zero and not transmitted.
+			index--
+			if index >= len(row) {
+				return fmt.Errorf("%w: index %d >= row %d",
+					ErrCorruptGarbledTable, index, len(row))
 			}
+			c = row[index]
+		}
 
-			output = decrypt(alg, a, b, id, c, &data)
-			id++
-
-		case INV:
-			row := garbled[i]
-			index := idxUnary(a)
-			if index > 0 {
-				// First row is zero and not transmitted.
-				index--
-				if index >= len(row) {
-					return fmt.Errorf("corrupted circuit: index %d >= row %d",
-						index, len(row))
-				}
-				c = row[index]
+		output = decrypt(alg, a, b, *idp, c, data)
+		*idp = *idp + 1
+
+	case INV:
+		index := idxUnary(a)
+		if index > 0 {
+			// First row is zero and not transmitted.
+			index--
+			if index >= len(row) {
+				return fmt.Errorf("%w: index %d >= row %d",
+					ErrCorruptGarbledTable, index, len(row))
 			}
-			output = decrypt(alg, a, ot.Label{}, id, c, &data)
-			id++
+			c = row[index]
 		}
-		wires[gate.Output] = output
+		output = decrypt(alg, a, ot.Label{}, *idp, c, data)
+		*idp = *idp + 1
 	}
+	wires[g.Output] = output
 
 	return nil
 }
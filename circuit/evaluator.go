@@ -9,6 +9,7 @@
 package circuit
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
@@ -20,36 +21,61 @@ var (
 	debug = false
 )
 
-// Evaluator runs the evaluator on the P2P network.
-func Evaluator(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
-	verbose bool) ([]*big.Int, error) {
+// Evaluator runs the evaluator on the P2P network. The protocol run
+// is aborted when the context is done; see WithTimeouts for
+// per-phase time limits.
+func Evaluator(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit, inputs *big.Int, verbose bool) ([]*big.Int, error) {
 
 	timing := NewTiming()
-
-	garbled := make([][]ot.Label, circ.NumGates)
+	if err := circuitHandshake(ctx, conn, oti, circ); err != nil {
+		return nil, err
+	}
 
 	// Receive program info.
 	if verbose {
 		fmt.Printf(" - Waiting for circuit info...\n")
 	}
-	key, err := conn.ReceiveData()
+	var key []byte
+	var garbled [][]ot.Label
+	err := connPhase(ctx, conn, PhaseTables, func() (err error) {
+		key, err = conn.ReceiveData()
+		if err != nil {
+			return
+		}
+
+		// Receive garbled tables.
+		timing.Sample("Wait", nil)
+		if verbose {
+			fmt.Printf(" - Receiving garbled circuit...\n")
+		}
+		garbled, err = receiveGarbledTables(conn, circ)
+		return
+	})
 	if err != nil {
-		return nil, err
+		return nil, Abort(conn, err)
 	}
 
-	// Receive garbled tables.
-	timing.Sample("Wait", nil)
-	if verbose {
-		fmt.Printf(" - Receiving garbled circuit...\n")
-	}
+	timing.Sample("Recv", []string{FileSize(conn.Stats.Sum()).String()})
+
+	return evaluatorOnline(ctx, conn, oti, circ, key, garbled, inputs,
+		timing, verbose)
+}
+
+// receiveGarbledTables receives the garbled tables of the circuit
+// gates.
+func receiveGarbledTables(conn *p2p.Conn, circ *Circuit) (
+	[][]ot.Label, error) {
+
 	count, err := conn.ReceiveUint32()
 	if err != nil {
 		return nil, err
 	}
 	if count != circ.NumGates {
-		return nil, fmt.Errorf("wrong number of gates: got %d, expected %d",
-			count, circ.NumGates)
+		return nil, fmt.Errorf("%w: wrong number of gates: got %d, "+
+			"expected %d", ErrProtocolMismatch, count, circ.NumGates)
 	}
+	garbled := make([][]ot.Label, circ.NumGates)
 	var label ot.Label
 	var labelData ot.LabelData
 	for i := 0; i < circ.NumGates; i++ {
@@ -68,74 +94,127 @@ func Evaluator(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
 		}
 		garbled[i] = values
 	}
+	return garbled, nil
+}
+
+// evaluatorOnline runs the online phase of the evaluator protocol: it
+// receives garbler's input labels, queries our input labels with OT,
+// evaluates the garbled circuit, and resolves the result values with
+// the garbler.
+func evaluatorOnline(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit, key []byte, garbled [][]ot.Label, inputs *big.Int,
+	timing *Timing, verbose bool) ([]*big.Int, error) {
 
 	wires := make([]ot.Label, circ.NumWires)
 
+	err := connPhase(ctx, conn, PhaseOT, func() error {
+		return evaluatorInputs(conn, oti, circ.Inputs, wires, inputs, timing,
+			verbose)
+	})
+	if err != nil {
+		return nil, Abort(conn, err)
+	}
+
+	// Evaluate gates.
+	if verbose {
+		fmt.Printf(" - Evaluating circuit...\n")
+	}
+	if circ.Parallel() {
+		err = circ.EvalParallel(key, wires, garbled, 0)
+	} else {
+		err = circ.Eval(key, wires, garbled)
+	}
+	if err != nil {
+		return nil, Abort(conn, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timing.Sample("Eval", nil)
+
+	var result []*big.Int
+	err = connPhase(ctx, conn, PhaseResult, func() (err error) {
+		result, err = evaluatorResult(conn, circ.Outputs,
+			wires[circ.NumWires-circ.Outputs.Size():], timing)
+		return
+	})
+	return result, Abort(conn, err)
+}
+
+// evaluatorInputs receives garbler's input labels and queries our
+// input labels with OT. The function stores the input labels to
+// wires.
+func evaluatorInputs(conn *p2p.Conn, oti ot.OT, args IO, wires []ot.Label,
+	inputs *big.Int, timing *Timing, verbose bool) error {
+
+	ioStats := conn.Stats.Sum()
+
 	// Receive peer inputs.
-	for i := 0; i < int(circ.Inputs[0].Type.Bits); i++ {
+	var label ot.Label
+	var labelData ot.LabelData
+	for i := 0; i < int(args[0].Type.Bits); i++ {
 		err := conn.ReceiveLabel(&label, &labelData)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		wires[Wire(i)] = label
 	}
 
 	// Init oblivious transfer.
-	err = oti.InitReceiver(conn)
+	err := oti.InitReceiver(conn)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	ioStats := conn.Stats.Sum()
-	timing.Sample("Recv", []string{FileSize(ioStats).String()})
+	xfer := conn.Stats.Sum() - ioStats
+	ioStats = conn.Stats.Sum()
+	timing.Sample("OT Init", []string{FileSize(xfer).String()})
 
 	// Query our inputs.
 	if verbose {
 		fmt.Printf(" - Querying our inputs...\n")
 	}
 	// Wire offset.
-	if err := conn.SendUint32(int(circ.Inputs[0].Type.Bits)); err != nil {
-		return nil, err
+	if err := conn.SendUint32(int(args[0].Type.Bits)); err != nil {
+		return err
 	}
 	// Wire count.
-	if err := conn.SendUint32(int(circ.Inputs[1].Type.Bits)); err != nil {
-		return nil, err
+	if err := conn.SendUint32(int(args[1].Type.Bits)); err != nil {
+		return err
 	}
 	if err := conn.Flush(); err != nil {
-		return nil, err
+		return err
 	}
-	flags := make([]bool, int(circ.Inputs[1].Type.Bits))
-	for i := 0; i < int(circ.Inputs[1].Type.Bits); i++ {
+	flags := make([]bool, int(args[1].Type.Bits))
+	for i := 0; i < int(args[1].Type.Bits); i++ {
 		if inputs.Bit(i) == 1 {
 			flags[i] = true
 		}
 	}
-	if err := oti.Receive(flags, wires[circ.Inputs[0].Type.Bits:]); err != nil {
-		return nil, err
+	offset := int(args[0].Type.Bits)
+	if err := oti.Receive(flags, wires[offset:offset+len(flags)]); err != nil {
+		return err
 	}
-	xfer := conn.Stats.Sum() - ioStats
-	ioStats = conn.Stats.Sum()
+	xfer = conn.Stats.Sum() - ioStats
 	timing.Sample("Inputs", []string{FileSize(xfer).String()})
 
-	// Evaluate gates.
-	if verbose {
-		fmt.Printf(" - Evaluating circuit...\n")
-	}
-	err = circ.Eval(key[:], wires, garbled)
-	if err != nil {
-		return nil, err
-	}
-	timing.Sample("Eval", nil)
+	return nil
+}
 
-	// Resolve result values.
+// evaluatorResult resolves the result values with the garbler from
+// the output labels.
+func evaluatorResult(conn *p2p.Conn, outputs IO, labels []ot.Label,
+	timing *Timing) ([]*big.Int, error) {
 
-	var labels []ot.Label
+	ioStats := conn.Stats.Sum()
 
-	for i := 0; i < circ.Outputs.Size(); i++ {
-		r := wires[Wire(circ.NumWires-circ.Outputs.Size()+i)]
-		labels = append(labels, r)
-	}
-	for _, l := range labels {
-		if err := conn.SendLabel(l, &labelData); err != nil {
+	// Resolve result values. We return labels only for the outputs
+	// the garbler is allowed to learn.
+	var labelData ot.LabelData
+	for i, visible := range outputs.Visible(true) {
+		if !visible {
+			continue
+		}
+		if err := conn.SendLabel(labels[i], &labelData); err != nil {
 			return nil, err
 		}
 	}
@@ -143,14 +222,32 @@ func Evaluator(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
 		return nil, err
 	}
 
-	result, err := conn.ReceiveData()
+	data, err := conn.ReceiveData()
 	if err != nil {
 		return nil, err
 	}
-	raw := big.NewInt(0).SetBytes(result)
+	raw := decodeResult(outputs, labels, data)
 
-	xfer = conn.Stats.Sum() - ioStats
+	xfer := conn.Stats.Sum() - ioStats
 	timing.Sample("Result", []string{FileSize(xfer).String()})
 
-	return circ.Outputs.Split(raw), nil
+	return outputs.SplitVisible(raw, false), nil
+}
+
+// decodeResult resolves the output values from the output labels and
+// decoding bits for the outputs that are visible to the evaluator.
+func decodeResult(outputs IO, labels []ot.Label, data []byte) *big.Int {
+	bits := new(big.Int).SetBytes(data)
+	result := new(big.Int)
+	for i, visible := range outputs.Visible(false) {
+		if !visible {
+			continue
+		}
+		var bit uint
+		if labels[i].S() {
+			bit = 1
+		}
+		result.SetBit(result, i, bit^bits.Bit(i))
+	}
+	return result
 }
@@ -187,19 +187,49 @@ func (g *Garbled) SetLambda(wire Wire, val uint) {
 
 // Garble garbles the circuit.
 func (c *Circuit) Garble(key []byte) (*Garbled, error) {
-	// Create R.
-	r, err := ot.NewLabel(rand.Reader)
+	garbled, err := c.newGarbled()
 	if err != nil {
 		return nil, err
 	}
-	r.SetS(true)
-
-	garbled := make([][]ot.Label, c.NumGates)
+	err = c.garble(key, garbled)
+	if err != nil {
+		return nil, err
+	}
+	return garbled, nil
+}
 
+// garble garbles the circuit gates sequentially. The garbled must
+// have its R and input wire labels set.
+func (c *Circuit) garble(key []byte, garbled *Garbled) error {
 	alg, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	// Garble gates.
+	var data ot.LabelData
+	var id uint32
+	for i := 0; i < len(c.Gates); i++ {
+		gate := &c.Gates[i]
+		data, err := gate.garble(garbled.Wires, alg, garbled.R, &id, &data)
+		if err != nil {
+			return err
+		}
+		garbled.Gates[i] = data
+	}
+
+	return nil
+}
+
+// newGarbled creates the R label and the input wire labels for the
+// circuit.
+func (c *Circuit) newGarbled() (*Garbled, error) {
+	// Create R.
+	r, err := ot.NewLabel(rand.Reader)
 	if err != nil {
 		return nil, err
 	}
+	r.SetS(true)
 
 	// Wire labels.
 	wires := make([]ot.Wire, c.NumWires)
@@ -213,22 +243,10 @@ func (c *Circuit) Garble(key []byte) (*Garbled, error) {
 		wires[i] = w
 	}
 
-	// Garble gates.
-	var data ot.LabelData
-	var id uint32
-	for i := 0; i < len(c.Gates); i++ {
-		gate := &c.Gates[i]
-		data, err := gate.garble(wires, alg, r, &id, &data)
-		if err != nil {
-			return nil, err
-		}
-		garbled[i] = data
-	}
-
 	return &Garbled{
 		R:     r,
 		Wires: wires,
-		Gates: garbled,
+		Gates: make([][]ot.Label, c.NumGates),
 	}, nil
 }
 
@@ -9,6 +9,7 @@
 package circuit
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"math/big"
@@ -34,11 +35,27 @@ func (s FileSize) String() string {
 	}
 }
 
-// Garbler runs the garbler on the P2P network.
-func Garbler(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
-	verbose bool) ([]*big.Int, error) {
+// Garbler runs the garbler on the P2P network. The protocol run is
+// aborted when the context is done; see WithTimeouts for per-phase
+// time limits.
+func Garbler(ctx context.Context, conn *p2p.Conn, oti ot.OT, circ *Circuit,
+	inputs *big.Int, verbose bool) ([]*big.Int, error) {
+	return GarblerShared(ctx, conn, oti, circ, inputs, nil, verbose)
+}
+
+// GarblerShared runs the garbler on the P2P network with
+// secret-shared evaluator input. The evaluator's input value is the
+// XOR of the evaluator's input and our shares. The shares typically
+// come from the RecipientShared outputs of an earlier computation. If
+// shares is nil, the evaluator's input is used as-is.
+func GarblerShared(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit, inputs, shares *big.Int, verbose bool) (
+	[]*big.Int, error) {
 
 	timing := NewTiming()
+	if err := circuitHandshake(ctx, conn, oti, circ); err != nil {
+		return nil, err
+	}
 	if verbose {
 		fmt.Printf(" - Garbling...\n")
 	}
@@ -49,10 +66,18 @@ func Garbler(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
 		return nil, err
 	}
 
-	garbled, err := circ.Garble(key[:])
+	var garbled *Garbled
+	if circ.Parallel() {
+		garbled, err = circ.GarbleParallel(key[:], 0)
+	} else {
+		garbled, err = circ.Garble(key[:])
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	timing.Sample("Garble", nil)
 
@@ -60,30 +85,80 @@ func Garbler(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
 	if verbose {
 		fmt.Printf(" - Sending garbled circuit...\n")
 	}
-	if err := conn.SendData(key[:]); err != nil {
+	err = connPhase(ctx, conn, PhaseTables, func() error {
+		if err := conn.SendData(key[:]); err != nil {
+			return err
+		}
+		// Send garbled tables.
+		return sendGarbledTables(conn, garbled.Gates)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Send garbled tables.
-	if err := conn.SendUint32(len(garbled.Gates)); err != nil {
-		return nil, err
+	timing.Sample("Xfer", []string{FileSize(conn.Stats.Sum()).String()})
+
+	numInputs := circ.Inputs.Size()
+	numOutputs := circ.Outputs.Size()
+
+	return garblerOnline(ctx, conn, oti, circ, garbled.Wires[:numInputs],
+		garbled.Wires[circ.NumWires-numOutputs:], inputs, shares, timing,
+		verbose)
+}
+
+// sendGarbledTables sends the garbled tables of the circuit gates.
+func sendGarbledTables(conn *p2p.Conn, gates [][]ot.Label) error {
+	if err := conn.SendUint32(len(gates)); err != nil {
+		return err
 	}
 	var labelData ot.LabelData
-	for _, data := range garbled.Gates {
+	for _, data := range gates {
 		if err := conn.SendUint32(len(data)); err != nil {
-			return nil, err
+			return err
 		}
 		for _, d := range data {
 			if err := conn.SendLabel(d, &labelData); err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
+	return nil
+}
+
+// garblerOnline runs the online phase of the garbler protocol: it
+// sends garbler's input labels, transfers evaluator's input labels
+// with OT, and resolves the result from evaluator's output labels.
+func garblerOnline(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit, inputWires, outputWires []ot.Wire, inputs,
+	shares *big.Int, timing *Timing, verbose bool) ([]*big.Int, error) {
+
+	err := connPhase(ctx, conn, PhaseOT, func() error {
+		return garblerInputs(conn, oti, circ.Inputs, inputWires, inputs,
+			shares, timing, verbose)
+	})
+	if err != nil {
+		return nil, Abort(conn, err)
+	}
+	var result []*big.Int
+	err = connPhase(ctx, conn, PhaseResult, func() (err error) {
+		result, err = garblerResult(conn, circ.Outputs, outputWires, timing)
+		return
+	})
+	return result, Abort(conn, err)
+}
+
+// garblerInputs sends garbler's input labels and transfers
+// evaluator's input labels with OT. The inputWires hold the wires of
+// both the garbler's and evaluator's inputs.
+func garblerInputs(conn *p2p.Conn, oti ot.OT, args IO, inputWires []ot.Wire,
+	inputs, shares *big.Int, timing *Timing, verbose bool) error {
+
+	ioStats := conn.Stats.Sum()
 
 	// Select our inputs.
 	var n1 []ot.Label
-	for i := 0; i < int(circ.Inputs[0].Type.Bits); i++ {
-		wire := garbled.Wires[i]
+	for i := 0; i < int(args[0].Type.Bits); i++ {
+		wire := inputWires[i]
 
 		var n ot.Label
 
@@ -96,65 +171,81 @@ func Garbler(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
 	}
 
 	// Send our inputs.
+	var labelData ot.LabelData
 	for idx, i := range n1 {
 		if verbose && false {
 			fmt.Printf("N1[%d]:\t%s\n", idx, i)
 		}
 		if err := conn.SendLabel(i, &labelData); err != nil {
-			return nil, err
+			return err
 		}
 	}
-	ioStats := conn.Stats.Sum()
-	timing.Sample("Xfer", []string{FileSize(ioStats).String()})
+	xfer := conn.Stats.Sum() - ioStats
+	ioStats = conn.Stats.Sum()
+	timing.Sample("Inputs", []string{FileSize(xfer).String()})
 	if verbose {
 		fmt.Printf(" - Processing messages...\n")
 	}
 
 	// Init oblivious transfer.
-	err = oti.InitSender(conn)
+	err := oti.InitSender(conn)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	xfer := conn.Stats.Sum() - ioStats
+	xfer = conn.Stats.Sum() - ioStats
 	ioStats = conn.Stats.Sum()
 	timing.Sample("OT Init", []string{FileSize(xfer).String()})
 
 	// Peer OTs its inputs.
 	offset, err := conn.ReceiveUint32()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	count, err := conn.ReceiveUint32()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if offset != int(circ.Inputs[0].Type.Bits) ||
-		count != int(circ.Inputs[1].Type.Bits) {
-		return nil, fmt.Errorf("peer can't OT wires [%d...%d[",
-			offset, offset+count)
+	if offset != int(args[0].Type.Bits) || count != int(args[1].Type.Bits) {
+		return fmt.Errorf("%w: peer can't OT wires [%d...%d[",
+			ErrProtocolMismatch, offset, offset+count)
 	}
-	err = oti.Send(garbled.Wires[offset : offset+count])
+	err = oti.Send(ShareWires(inputWires[offset:offset+count], shares))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	xfer = conn.Stats.Sum() - ioStats
-	ioStats = conn.Stats.Sum()
 	timing.Sample("OT", []string{FileSize(xfer).String()})
 
-	// Resolve result values.
+	return nil
+}
+
+// garblerResult resolves the result values from evaluator's output
+// labels and sends the decoding bits of the evaluator's outputs.
+func garblerResult(conn *p2p.Conn, outputs IO, outputWires []ot.Wire,
+	timing *Timing) ([]*big.Int, error) {
+
+	ioStats := conn.Stats.Sum()
 
-	result := big.NewInt(0)
+	// Resolve result values. The evaluator returns labels only for
+	// the outputs we are allowed to learn. Our result values of the
+	// shared outputs are their masks.
+	mask, err := NewShareMask(outputs)
+	if err != nil {
+		return nil, err
+	}
+	result := new(big.Int).Set(mask)
 	var label ot.Label
+	var labelData ot.LabelData
 
-	for i := 0; i < circ.Outputs.Size(); i++ {
+	for i, visible := range outputs.Visible(true) {
+		if !visible {
+			continue
+		}
 		err := conn.ReceiveLabel(&label, &labelData)
 		if err != nil {
 			return nil, err
 		}
-		if i == 0 {
-			timing.Sample("Eval", nil)
-		}
-		wire := garbled.Wires[circ.NumWires-circ.Outputs.Size()+i]
+		wire := outputWires[i]
 
 		var bit uint
 		if label.Equal(wire.L0) {
@@ -162,20 +253,46 @@ func Garbler(conn *p2p.Conn, oti ot.OT, circ *Circuit, inputs *big.Int,
 		} else if label.Equal(wire.L1) {
 			bit = 1
 		} else {
-			return nil, fmt.Errorf("unknown label %s for result %d", label, i)
+			return nil, fmt.Errorf("%w: unknown label %s for result %d",
+				ErrInvalidOutputLabel, label, i)
 		}
 		result = big.NewInt(0).SetBit(result, i, bit)
 	}
-	data := result.Bytes()
-	if err := conn.SendData(data); err != nil {
+	timing.Sample("Eval", nil)
+
+	// Send decoding bits for the outputs the evaluator is allowed to
+	// learn.
+	err = conn.SendData(DecodingBits(outputs, outputWires, mask))
+	if err != nil {
 		return nil, err
 	}
 	if err := conn.Flush(); err != nil {
 		return nil, err
 	}
 
-	xfer = conn.Stats.Sum() - ioStats
+	xfer := conn.Stats.Sum() - ioStats
 	timing.Sample("Result", []string{FileSize(xfer).String()})
 
-	return circ.Outputs.Split(result), nil
+	return outputs.SplitVisible(result, true), nil
+}
+
+// DecodingBits returns the output decoding bits for the outputs that
+// are visible to the evaluator. The decoding bit of an output is the
+// permute bit of its zero label so the evaluator resolves the output
+// value as the XOR of its label's permute bit and the decoding bit.
+// The decoding bits of the shared outputs are XORed with the mask
+// (see NewShareMask) so the evaluator resolves the masked value.
+func DecodingBits(outputs IO, outputWires []ot.Wire, mask *big.Int) []byte {
+	bits := new(big.Int)
+	for i, visible := range outputs.Visible(false) {
+		if !visible {
+			continue
+		}
+		var bit uint
+		if outputWires[i].L0.S() {
+			bit = 1
+		}
+		bits.SetBit(bits, i, bit^mask.Bit(i))
+	}
+	return bits.Bytes()
 }
@@ -0,0 +1,547 @@
+//
+// gmw.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// gmwMaxChunk specifies the maximum size of a data message in the
+// GMW message exchanges. Larger messages are split into chunks that
+// fit into the connection read buffer.
+const gmwMaxChunk = 256 * 1024
+
+// GMW runs the semi-honest GMW protocol on the P2P network. The
+// parties hold XOR shares of all circuit wires. The XOR, XNOR, and
+// INV gates are evaluated locally and the AND and OR gates with Beaver
+// triples that the parties generate with oblivious transfers before
+// the evaluation. The circuit is evaluated level by level, see
+// AssignLevels, with one communication round for the AND and OR gates
+// of each level. All parties learn all outputs.
+//
+// The protocol run is aborted when the context is done; see
+// WithTimeouts for per-phase time limits. The input sharing and the
+// triple generation run in the OT phase, the circuit evaluation in
+// the tables phase, and the output reconstruction in the result
+// phase.
+func GMW(ctx context.Context, nw *p2p.Network, circ *Circuit,
+	inputs *big.Int, verbose bool) ([]*big.Int, error) {
+
+	numPlayers := len(nw.Peers) + 1
+	if numPlayers != len(circ.Inputs) {
+		return nil, fmt.Errorf("%w: %d players for %d-party circuit",
+			ErrProtocolMismatch, numPlayers, len(circ.Inputs))
+	}
+	if len(circ.Gates) > 0 && circ.Stats[NumLevels] == 0 {
+		return nil, fmt.Errorf("circuit levels not assigned")
+	}
+
+	timing := NewTiming()
+
+	g := newGMW(nw, circ)
+
+	// Input sharing and triple generation.
+	if verbose {
+		fmt.Printf(" - Sharing inputs and generating %d triples\n",
+			len(g.a))
+	}
+	err := runPhase(ctx, PhaseOT, nw.WithContext, func() error {
+		if err := g.shareInputs(inputs); err != nil {
+			return err
+		}
+		return g.triples()
+	})
+	if err != nil {
+		return nil, err
+	}
+	ioStats := nw.Stats().Sum()
+	timing.Sample("Triples", []string{FileSize(ioStats).String()})
+
+	// Circuit evaluation.
+	if verbose {
+		fmt.Printf(" - Evaluating %d levels\n", len(g.levels))
+	}
+	var rounds int
+	err = runPhase(ctx, PhaseTables, nw.WithContext, func() error {
+		var err error
+		rounds, err = g.eval()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	xfer := nw.Stats().Sum() - ioStats
+	ioStats = nw.Stats().Sum()
+	timing.Sample("Eval", []string{FileSize(xfer).String(),
+		fmt.Sprintf("%d rounds", rounds)})
+
+	// Output reconstruction.
+	var result *big.Int
+	err = runPhase(ctx, PhaseResult, nw.WithContext, func() error {
+		var err error
+		result, err = g.result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	xfer = nw.Stats().Sum() - ioStats
+	timing.Sample("Result", []string{FileSize(xfer).String()})
+
+	return circ.Outputs.Split(result), nil
+}
+
+// gmw holds a party's state of the GMW protocol. All bit vectors
+// hold one bit per byte.
+type gmw struct {
+	nw     *p2p.Network
+	circ   *Circuit
+	player int
+	// shares holds our XOR shares of the wire values.
+	shares []byte
+	// levels holds the gate indices by gate level.
+	levels [][]int
+	// triple maps the AND and OR gate indices to their triples.
+	triple []int
+	// a, b, and c hold our shares of the Beaver triples.
+	a []byte
+	b []byte
+	c []byte
+}
+
+func newGMW(nw *p2p.Network, circ *Circuit) *gmw {
+	g := &gmw{
+		nw:     nw,
+		circ:   circ,
+		player: nw.ID,
+		shares: make([]byte, circ.NumWires),
+		levels: make([][]int, circ.Stats[NumLevels]),
+		triple: make([]int, len(circ.Gates)),
+	}
+	var count int
+	for idx, gate := range circ.Gates {
+		g.levels[gate.Level] = append(g.levels[gate.Level], idx)
+		switch gate.Op {
+		case AND, OR:
+			g.triple[idx] = count
+			count++
+		default:
+			g.triple[idx] = -1
+		}
+	}
+	g.a = make([]byte, count)
+	g.b = make([]byte, count)
+	g.c = make([]byte, count)
+
+	return g
+}
+
+// one returns our share of the constant 1. The party 0 holds the
+// constant and the other parties hold zero shares.
+func (g *gmw) one() byte {
+	if g.player == 0 {
+		return 1
+	}
+	return 0
+}
+
+// shareInputs shares our inputs to the peers and receives our shares
+// of the peers' inputs.
+func (g *gmw) shareInputs(inputs *big.Int) error {
+	offsets := make([]int, len(g.circ.Inputs)+1)
+	for idx, arg := range g.circ.Inputs {
+		offsets[idx+1] = offsets[idx] + int(arg.Type.Bits)
+	}
+	ours := g.shares[offsets[g.player]:offsets[g.player+1]]
+	for i := range ours {
+		ours[i] = byte(inputs.Bit(i))
+	}
+
+	peerShares := make(map[int][]byte)
+	for id := range g.nw.Peers {
+		share, err := randomBits(len(ours))
+		if err != nil {
+			return err
+		}
+		for i := range ours {
+			ours[i] ^= share[i]
+		}
+		peerShares[id] = packBits(share)
+	}
+
+	received, err := exchangeAll(g.nw, func(id int) []byte {
+		return peerShares[id]
+	})
+	if err != nil {
+		return err
+	}
+	for id, data := range received {
+		theirs := g.shares[offsets[id]:offsets[id+1]]
+		if err := unpackBits(data, theirs); err != nil {
+			return fmt.Errorf("input shares from peer %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// triples generates the Beaver triples (a, b, c) with c = a AND b for
+// the AND and OR gates. Each party picks random shares of a and b.
+// The cross terms of a AND b are shared pairwise with oblivious
+// transfers. If the network is preprocessed with a dealer, the
+// function uses the dealt triples.
+func (g *gmw) triples() error {
+	if dealt := g.nw.Dealt(); dealt != nil {
+		a, b, c, err := dealt.Triples(len(g.a))
+		if err != nil {
+			return err
+		}
+		g.a, g.b, g.c = a.Bits, b.Bits, c.Bits
+		return nil
+	}
+
+	var err error
+	g.a, err = randomBits(len(g.a))
+	if err != nil {
+		return err
+	}
+	g.b, err = randomBits(len(g.b))
+	if err != nil {
+		return err
+	}
+	for i := range g.c {
+		g.c[i] = g.a[i] & g.b[i]
+	}
+
+	type crossResult struct {
+		peer  int
+		cross []byte
+		err   error
+	}
+	results := make(chan crossResult, len(g.nw.Peers))
+	for id, peer := range g.nw.Peers {
+		go func(id int, peer *p2p.Peer) {
+			cross, err := crossTerms(peer, g.player, id, g.a, g.b)
+			results <- crossResult{
+				peer:  id,
+				cross: cross,
+				err:   err,
+			}
+		}(id, peer)
+	}
+	for range g.nw.Peers {
+		result := <-results
+		if result.err != nil {
+			return fmt.Errorf("triples with peer %d failed: %w",
+				result.peer, result.err)
+		}
+		for i, bit := range result.cross {
+			g.c[i] ^= bit
+		}
+	}
+	return nil
+}
+
+// crossTerms computes our shares of the cross terms a_we AND b_peer
+// and a_peer AND b_we with the peer. In the transfer where we are the
+// sender, we send the messages r and r XOR a_we and the peer chooses
+// with b_peer; our share is r and the peer's share is r XOR (a_we AND
+// b_peer). The roles are reversed in the second transfer. The party
+// with the smaller ID runs the sender first.
+func crossTerms(p *p2p.Peer, we, peer int, a, b []byte) ([]byte, error) {
+	conn := p.Conn()
+	count := len(a)
+	cross := make([]byte, count)
+
+	send := func() error {
+		sender := p.OT()
+		if err := sender.InitSender(conn); err != nil {
+			return err
+		}
+		wires := make([]ot.Wire, count)
+		for i := 0; i < count; i++ {
+			r, err := ot.NewLabel(rand.Reader)
+			if err != nil {
+				return err
+			}
+			cross[i] ^= byte(r.D1 & 1)
+			wires[i].L0 = r
+			r.D1 ^= uint64(a[i])
+			wires[i].L1 = r
+		}
+		return sender.Send(wires)
+	}
+	receive := func() error {
+		receiver := p.OT()
+		if err := receiver.InitReceiver(conn); err != nil {
+			return err
+		}
+		flags := make([]bool, count)
+		for i := 0; i < count; i++ {
+			flags[i] = b[i] != 0
+		}
+		labels := make([]ot.Label, count)
+		if err := receiver.Receive(flags, labels); err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			cross[i] ^= byte(labels[i].D1 & 1)
+		}
+		return nil
+	}
+
+	if err := pairwise(we, peer, send, receive); err != nil {
+		return nil, err
+	}
+	return cross, nil
+}
+
+// pairwise runs the send and receive steps of a pairwise protocol
+// with the peer. The party with the smaller ID runs the send step
+// first and the peer runs the steps in the opposite order.
+func pairwise(we, peer int, send, receive func() error) error {
+	steps := []func() error{send, receive}
+	if we > peer {
+		steps[0], steps[1] = receive, send
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eval evaluates the circuit level by level. The function returns
+// the number of communication rounds.
+func (g *gmw) eval() (int, error) {
+	var rounds int
+	s := g.shares
+
+	for _, level := range g.levels {
+		var ands []int
+		for _, idx := range level {
+			gate := &g.circ.Gates[idx]
+			switch gate.Op {
+			case XOR:
+				s[gate.Output] = s[gate.Input0] ^ s[gate.Input1]
+			case XNOR:
+				s[gate.Output] = s[gate.Input0] ^ s[gate.Input1] ^ g.one()
+			case INV:
+				s[gate.Output] = s[gate.Input0] ^ g.one()
+			case AND, OR:
+				ands = append(ands, idx)
+			default:
+				return rounds, fmt.Errorf("invalid gate %s", gate.Op)
+			}
+		}
+		if len(ands) == 0 {
+			continue
+		}
+		rounds++
+
+		// Open d = x XOR a and e = y XOR b for the gates.
+		de := make([]byte, 2*len(ands))
+		for i, idx := range ands {
+			gate := &g.circ.Gates[idx]
+			t := g.triple[idx]
+			de[2*i] = s[gate.Input0] ^ g.a[t]
+			de[2*i+1] = s[gate.Input1] ^ g.b[t]
+		}
+		msg := packBits(de)
+		received, err := exchangeAll(g.nw, func(id int) []byte {
+			return msg
+		})
+		if err != nil {
+			return rounds, err
+		}
+		peerDE := make([]byte, len(de))
+		for id, data := range received {
+			if err := unpackBits(data, peerDE); err != nil {
+				return rounds, fmt.Errorf("openings from peer %d: %w",
+					id, err)
+			}
+			for i := range de {
+				de[i] ^= peerDE[i]
+			}
+		}
+
+		// x AND y = c XOR d AND b XOR e AND a XOR d AND e
+		for i, idx := range ands {
+			gate := &g.circ.Gates[idx]
+			t := g.triple[idx]
+			d := de[2*i]
+			e := de[2*i+1]
+			z := g.c[t] ^ d&g.b[t] ^ e&g.a[t] ^ d&e&g.one()
+			if gate.Op == OR {
+				z ^= s[gate.Input0] ^ s[gate.Input1]
+			}
+			s[gate.Output] = z
+		}
+	}
+	return rounds, nil
+}
+
+// result reconstructs the circuit outputs from the parties' output
+// shares.
+func (g *gmw) result() (*big.Int, error) {
+	size := g.circ.Outputs.Size()
+	out := make([]byte, size)
+	copy(out, g.shares[g.circ.NumWires-size:])
+
+	msg := packBits(out)
+	received, err := exchangeAll(g.nw, func(id int) []byte {
+		return msg
+	})
+	if err != nil {
+		return nil, err
+	}
+	theirs := make([]byte, size)
+	for id, data := range received {
+		if err := unpackBits(data, theirs); err != nil {
+			return nil, fmt.Errorf("output shares from peer %d: %w",
+				id, err)
+		}
+		for i := range out {
+			out[i] ^= theirs[i]
+		}
+	}
+
+	result := new(big.Int)
+	for i, bit := range out {
+		if bit != 0 {
+			result.SetBit(result, i, 1)
+		}
+	}
+	return result, nil
+}
+
+// exchangeAll sends the peer-specific data to all peers of the
+// network and returns the data that the peers sent to us. The
+// messages are sent and received concurrently so the exchange takes
+// one communication round.
+func exchangeAll(nw *p2p.Network, data func(id int) []byte) (
+	map[int][]byte, error) {
+	type recvResult struct {
+		peer int
+		data []byte
+		err  error
+	}
+	sent := make(chan error, len(nw.Peers))
+	recvd := make(chan recvResult, len(nw.Peers))
+
+	for id, peer := range nw.Peers {
+		conn := peer.Conn()
+		msg := data(id)
+		go func() {
+			sent <- sendChunked(conn, msg)
+		}()
+		go func(id int) {
+			data, err := receiveChunked(conn)
+			recvd <- recvResult{
+				peer: id,
+				data: data,
+				err:  err,
+			}
+		}(id)
+	}
+
+	var firstErr error
+	result := make(map[int][]byte)
+	for range nw.Peers {
+		if err := <-sent; err != nil && firstErr == nil {
+			firstErr = err
+		}
+		r := <-recvd
+		if r.err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("peer %d: %w", r.peer, r.err)
+		}
+		result[r.peer] = r.data
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+func sendChunked(conn *p2p.Conn, data []byte) error {
+	if err := conn.SendUint32(len(data)); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > gmwMaxChunk {
+			n = gmwMaxChunk
+		}
+		if err := conn.SendData(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return conn.Flush()
+}
+
+func receiveChunked(conn *p2p.Conn) ([]byte, error) {
+	size, err := conn.ReceiveUint32()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, size)
+	for len(result) < size {
+		data, err := conn.ReceiveData()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data...)
+	}
+	if len(result) != size {
+		return nil, fmt.Errorf("%w: received %d bytes, expected %d",
+			ErrProtocolMismatch, len(result), size)
+	}
+	return result, nil
+}
+
+// randomBits returns count random bits, one bit per byte.
+func randomBits(count int) ([]byte, error) {
+	buf := make([]byte, (count+7)/8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	result := make([]byte, count)
+	if err := unpackBits(buf, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// packBits packs the bits, one bit per byte, into bytes.
+func packBits(bits []byte) []byte {
+	result := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		result[i/8] |= (bit & 1) << (i % 8)
+	}
+	return result
+}
+
+// unpackBits unpacks the packed bits into bits, one bit per byte.
+func unpackBits(data, bits []byte) error {
+	if len(data) != (len(bits)+7)/8 {
+		return fmt.Errorf("%w: got %d bytes for %d bits",
+			ErrProtocolMismatch, len(data), len(bits))
+	}
+	for i := range bits {
+		bits[i] = (data[i/8] >> (i % 8)) & 1
+	}
+	return nil
+}
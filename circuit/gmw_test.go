@@ -0,0 +1,144 @@
+//
+// gmw_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// newTestNetworks creates and connects count networks on the loopback
+// interface.
+func newTestNetworks(t *testing.T, count int) []*p2p.Network {
+	config := new(p2p.Config)
+	for i := 0; i < count; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Peers = append(config.Peers, p2p.PeerConfig{
+			ID:   i,
+			Addr: l.Addr().String(),
+		})
+		l.Close()
+	}
+
+	var networks []*p2p.Network
+	for i := 0; i < count; i++ {
+		nw, err := p2p.NewConfigNetwork(config, i, nil)
+		if err != nil {
+			t.Fatalf("NewConfigNetwork failed: %s", err)
+		}
+		t.Cleanup(func() {
+			nw.Close()
+		})
+		networks = append(networks, nw)
+	}
+
+	errs := make(chan error)
+	for _, nw := range networks {
+		go func(nw *p2p.Network) {
+			errs <- nw.Connect(context.Background())
+		}(nw)
+	}
+	for range networks {
+		if err := <-errs; err != nil {
+			t.Fatalf("Connect failed: %s", err)
+		}
+	}
+	return networks
+}
+
+func TestGMW(t *testing.T) {
+	for _, numPlayers := range []int{2, 3, 4} {
+		t.Run(fmt.Sprintf("%d-party", numPlayers), func(t *testing.T) {
+			testGMW(t, numPlayers)
+		})
+	}
+}
+
+func testGMW(t *testing.T, numPlayers int) {
+	inputSizes := make([]int, numPlayers)
+	for i := range inputSizes {
+		inputSizes[i] = 16
+	}
+	circ := newRandomCircuit(inputSizes, 512, 32)
+
+	rnd := mathrand.New(mathrand.NewSource(int64(numPlayers)))
+	inputs := make([]*big.Int, numPlayers)
+	for i := range inputs {
+		inputs[i] = big.NewInt(rnd.Int63n(1 << 16))
+	}
+	expected, err := circ.Compute(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks := newTestNetworks(t, numPlayers)
+
+	type gmwResult struct {
+		player int
+		result []*big.Int
+		err    error
+	}
+	results := make(chan gmwResult)
+	for i, nw := range networks {
+		go func(player int, nw *p2p.Network) {
+			result, err := GMW(context.Background(), nw, circ,
+				inputs[player], false)
+			results <- gmwResult{
+				player: player,
+				result: result,
+				err:    err,
+			}
+		}(i, nw)
+	}
+	for range networks {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("player %d: GMW failed: %s", r.player, r.err)
+		}
+		if len(r.result) != len(expected) {
+			t.Fatalf("player %d: got %d results, expected %d", r.player,
+				len(r.result), len(expected))
+		}
+		for i := range expected {
+			if r.result[i].Cmp(expected[i]) != 0 {
+				t.Errorf("player %d: result %d: got %v, expected %v",
+					r.player, i, r.result[i], expected[i])
+			}
+		}
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	bits := []byte{1, 0, 1, 1, 0, 0, 0, 1, 1, 0, 1}
+	packed := packBits(bits)
+	if len(packed) != 2 {
+		t.Fatalf("packed %d bits into %d bytes", len(bits), len(packed))
+	}
+	unpacked := make([]byte, len(bits))
+	if err := unpackBits(packed, unpacked); err != nil {
+		t.Fatal(err)
+	}
+	for i := range bits {
+		if unpacked[i] != bits[i] {
+			t.Errorf("bit %d: got %v, expected %v", i, unpacked[i], bits[i])
+		}
+	}
+	if err := unpackBits(packed, make([]byte, 17)); err == nil {
+		t.Errorf("unpackBits accepted invalid data length")
+	}
+}
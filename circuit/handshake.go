@@ -0,0 +1,133 @@
+//
+// handshake.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+const (
+	// ProtocolVersion specifies the garbler-evaluator protocol
+	// version.
+	ProtocolVersion = 1
+
+	// GarblingScheme names the garbling scheme of the protocol.
+	GarblingScheme = "half-gates"
+)
+
+// Handshake describes the protocol run that the peers are about to
+// start.
+type Handshake struct {
+	Version     int
+	Scheme      string
+	OT          string
+	Fingerprint []byte
+}
+
+// NewHandshake creates a handshake for the OT and the program
+// fingerprint. The fingerprint identifies the circuit or the program
+// of the protocol run.
+func NewHandshake(oti ot.OT, fingerprint []byte) *Handshake {
+	return &Handshake{
+		Version:     ProtocolVersion,
+		Scheme:      GarblingScheme,
+		OT:          fmt.Sprintf("%T", oti),
+		Fingerprint: fingerprint,
+	}
+}
+
+// Verify verifies that the peer's handshake matches ours. The peers
+// must have identical program fingerprints.
+func (h *Handshake) Verify(peer *Handshake) error {
+	if peer.Version != h.Version {
+		return fmt.Errorf("%w: protocol version %d, expected %d",
+			ErrProtocolMismatch, peer.Version, h.Version)
+	}
+	if peer.Scheme != h.Scheme {
+		return fmt.Errorf("%w: garbling scheme %s, expected %s",
+			ErrProtocolMismatch, peer.Scheme, h.Scheme)
+	}
+	if peer.OT != h.OT {
+		return fmt.Errorf("%w: OT %s, expected %s",
+			ErrProtocolMismatch, peer.OT, h.OT)
+	}
+	if !bytes.Equal(peer.Fingerprint, h.Fingerprint) {
+		return fmt.Errorf("%w: program fingerprint %x, expected %x",
+			ErrProtocolMismatch, peer.Fingerprint, h.Fingerprint)
+	}
+	return nil
+}
+
+// Run runs the handshake with the peer in the tables phase: it sends
+// our handshake to the peer, receives the peer's handshake, and
+// verifies that the handshakes match. Both peers detect a mismatch so
+// the peer is not aborted.
+func (h *Handshake) Run(ctx context.Context, conn *p2p.Conn) error {
+	return connPhase(ctx, conn, PhaseTables, func() error {
+		return h.exchange(conn)
+	})
+}
+
+func (h *Handshake) exchange(conn *p2p.Conn) error {
+	if err := conn.SendUint32(h.Version); err != nil {
+		return err
+	}
+	if err := conn.SendString(h.Scheme); err != nil {
+		return err
+	}
+	if err := conn.SendString(h.OT); err != nil {
+		return err
+	}
+	if err := conn.SendData(h.Fingerprint); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	peer := new(Handshake)
+	var err error
+	peer.Version, err = conn.ReceiveUint32()
+	if err != nil {
+		return err
+	}
+	if peer.Version != h.Version {
+		// The rest of the handshake may have a different format.
+		return h.Verify(peer)
+	}
+	peer.Scheme, err = conn.ReceiveString()
+	if err != nil {
+		return err
+	}
+	peer.OT, err = conn.ReceiveString()
+	if err != nil {
+		return err
+	}
+	peer.Fingerprint, err = conn.ReceiveData()
+	if err != nil {
+		return err
+	}
+	return h.Verify(peer)
+}
+
+// circuitHandshake runs the handshake for the circuit.
+func circuitHandshake(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit) error {
+
+	fp, err := circ.Fingerprint()
+	if err != nil {
+		return err
+	}
+	return NewHandshake(oti, fp[:]).Run(ctx, conn)
+}
@@ -0,0 +1,90 @@
+//
+// handshake_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+func TestHandshakeMismatch(t *testing.T) {
+	gCirc := newRandomCircuit([]int{8, 8}, 32, 8)
+	eCirc := newRandomCircuit([]int{8, 8}, 16, 8)
+
+	gc, ec := net.Pipe()
+	defer gc.Close()
+	defer ec.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := Evaluator(context.Background(), p2p.NewConn(ec), ot.NewCO(),
+			eCirc, big.NewInt(3), false)
+		done <- err
+	}()
+
+	_, err := Garbler(context.Background(), p2p.NewConn(gc), ot.NewCO(),
+		gCirc, big.NewInt(5), false)
+	if !errors.Is(err, ErrProtocolMismatch) {
+		t.Errorf("Garbler: expected %v, got %v", ErrProtocolMismatch, err)
+	}
+	if errors.Is(err, ErrPeerAbort) {
+		t.Errorf("Garbler: unexpected peer abort: %v", err)
+	}
+	err = <-done
+	if !errors.Is(err, ErrProtocolMismatch) {
+		t.Errorf("Evaluator: expected %v, got %v", ErrProtocolMismatch, err)
+	}
+}
+
+func TestHandshakeVerify(t *testing.T) {
+	oti := ot.NewCO()
+	h := NewHandshake(oti, []byte{1, 2, 3})
+
+	tests := []struct {
+		peer *Handshake
+		ok   bool
+	}{
+		{NewHandshake(oti, []byte{1, 2, 3}), true},
+		{NewHandshake(oti, nil), false},
+		{NewHandshake(oti, []byte{1, 2, 4}), false},
+		{&Handshake{
+			Version:     ProtocolVersion + 1,
+			Scheme:      GarblingScheme,
+			OT:          h.OT,
+			Fingerprint: h.Fingerprint,
+		}, false},
+		{&Handshake{
+			Version:     ProtocolVersion,
+			Scheme:      "yao",
+			OT:          h.OT,
+			Fingerprint: h.Fingerprint,
+		}, false},
+		{&Handshake{
+			Version:     ProtocolVersion,
+			Scheme:      GarblingScheme,
+			OT:          "iknp",
+			Fingerprint: h.Fingerprint,
+		}, false},
+	}
+	for idx, test := range tests {
+		err := h.Verify(test.peer)
+		if test.ok && err != nil {
+			t.Errorf("test %d: unexpected error: %v", idx, err)
+		} else if !test.ok && !errors.Is(err, ErrProtocolMismatch) {
+			t.Errorf("test %d: expected %v, got %v", idx,
+				ErrProtocolMismatch, err)
+		}
+	}
+}
@@ -14,11 +14,62 @@ import (
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/types"
 )
 
+// Recipient specifies the parties that learn the value of an output
+// argument.
+type Recipient int
+
+// Output recipients. The RecipientShared outputs are XOR-shared
+// between the parties: the garbler gets a random mask and the
+// evaluator gets the output value XORed with the mask.
+const (
+	RecipientBoth Recipient = iota
+	RecipientGarbler
+	RecipientEvaluator
+	RecipientShared
+)
+
+var recipients = map[Recipient]string{
+	RecipientBoth:      "both",
+	RecipientGarbler:   "garbler",
+	RecipientEvaluator: "evaluator",
+	RecipientShared:    "shared",
+}
+
+func (r Recipient) String() string {
+	name, ok := recipients[r]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Recipient %d}", r)
+}
+
+// Garbler tests if the garbler learns the output value.
+func (r Recipient) Garbler() bool {
+	return r == RecipientBoth || r == RecipientGarbler
+}
+
+// Evaluator tests if the evaluator learns the output value or, for
+// shared outputs, its share of the value.
+func (r Recipient) Evaluator() bool {
+	return r != RecipientGarbler
+}
+
+// ParseRecipient parses the output recipient name.
+func ParseRecipient(val string) (Recipient, error) {
+	for r, name := range recipients {
+		if name == val {
+			return r, nil
+		}
+	}
+	return RecipientBoth, fmt.Errorf("invalid output recipient: %s", val)
+}
+
 // IOArg describes circuit input argument.
 type IOArg struct {
-	Name     string
-	Type     types.Info
-	Compound IO
+	Name      string
+	Type      types.Info
+	Compound  IO
+	Recipient Recipient
 }
 
 func (io IOArg) String() string {
@@ -0,0 +1,1416 @@
+//
+// malicious.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// MaliciousPlayer runs the BMR protocol with authenticated shares on
+// the P2P network.
+//
+// Each party i has a global key Delta_i that is both its free-XOR
+// offset and its information-theoretic MAC key. The parties hold XOR
+// shares of the wire masks lambda and of the lambda products of the
+// AND and OR gates. Every share x of party i is authenticated to each
+// peer j: party i holds the MAC M_j[x] and party j the key K_j[x]
+// such that M_j[x] = K_j[x] XOR x*Delta_j. The parties derive their
+// shares of the garbled rows locally from the MACs and keys, and
+// exchange them with Peer.ExchangeGates. During the evaluation, every
+// party checks that its decrypted keys are its own wire keys, and the
+// mask shares are opened with their MACs. A party that corrupts its
+// mask shares, garbled rows, or opened values is detected and the
+// protocol is aborted with ErrInvalidMAC, ErrCorruptGarbledTable, or
+// ErrProtocolMismatch. The peers see the abort as ErrPeerAbort.
+//
+// The preprocessing creates the authenticated shares with correlated
+// oblivious transfers, see authenticate. Before any value is opened,
+// the parties check with a random linear combination of the MACs
+// that every peer used the same Delta_j for all keys, see
+// checkCorrelation. A party that breaks the correlation is detected
+// with ErrInvalidCorrelation except with the probability 2^-128. The
+// lambda products are verified by sacrificing tripleChecks random
+// products for each AND and OR gate, see checkProducts. A party that
+// corrupts its product shares is detected with ErrInvalidTriple
+// except with the probability 2^-tripleChecks per gate. After the
+// input wires are opened, the parties compare digests of the masked
+// inputs and the input wire keys, so that all parties evaluate the
+// same inputs.
+//
+// The protection is not complete. The oblivious transfers that
+// compute the cross terms of the lambda products are not bound to the
+// authenticated shares. A corrupt party can use inconsistent inputs
+// in them and learn single mask bits from whether the sacrifice
+// fails, with the probability 1/2 of being detected for each guessed
+// bit. The preprocessing of the dealer mode relies on the trusted
+// dealer and has no correlation check.
+// All parties learn all outputs.
+func MaliciousPlayer(ctx context.Context, nw *p2p.Network, circ *Circuit,
+	inputs *big.Int, verbose bool) ([]*big.Int, error) {
+
+	b, err := newAuthBMR(nw, circ)
+	if err != nil {
+		return nil, err
+	}
+	return b.run(ctx, inputs, verbose)
+}
+
+// tripleChecks specifies the number of random products that the
+// parties sacrifice to check the lambda product of each AND and OR
+// gate.
+const tripleChecks = 40
+
+// authStage specifies the protocol stages where tests can tamper
+// with the party's state.
+type authStage int
+
+const (
+	stageCorrelation authStage = iota
+	stageProducts
+	stageGates
+	stageInputs
+	stageInputCheck
+	stageOutputs
+)
+
+// authBMR holds a party's state of the BMR protocol with
+// authenticated shares.
+type authBMR struct {
+	nw    *p2p.Network
+	circ  *Circuit
+	n     int
+	self  int
+	alg   cipher.Block
+	delta ot.Label
+
+	// checks specifies the number of sacrificed products per gate.
+	checks int
+
+	// lambda holds our wire mask shares and key0 our wire keys for
+	// the value 0.
+	lambda []byte
+	key0   []ot.Label
+	// macs[j][w] holds the MAC of our lambda[w] for peer j and
+	// keys[j][w] our MAC key for peer j's lambda[w].
+	macs [][]ot.Label
+	keys [][]ot.Label
+
+	// triple maps the AND and OR gate indices to their product
+	// shares. The prod holds our shares of lambda_u*lambda_v for the
+	// gates, and prodMacs and prodKeys their MACs and keys.
+	triple   []int
+	prod     []byte
+	prodMacs [][]ot.Label
+	prodKeys [][]ot.Label
+
+	// gates holds our shares of the garbled rows and tables the
+	// garbled rows, indexed by the target party and the gate.
+	gates  *GateValues
+	tables *GateValues
+
+	// masked holds the public masked wire values and wireKeys the
+	// parties' active wire keys.
+	masked   []byte
+	wireKeys [][]ot.Label
+
+	tamper func(b *authBMR, stage authStage)
+}
+
+func newAuthBMR(nw *p2p.Network, circ *Circuit) (*authBMR, error) {
+	n := len(nw.Peers) + 1
+	if n != len(circ.Inputs) {
+		return nil, fmt.Errorf("%w: %d players for %d-party circuit",
+			ErrProtocolMismatch, n, len(circ.Inputs))
+	}
+	// The PRF is the fixed-key AES hash of the garbling scheme. The
+	// key is public.
+	alg, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		return nil, err
+	}
+	b := &authBMR{
+		nw:       nw,
+		circ:     circ,
+		n:        n,
+		self:     nw.ID,
+		alg:      alg,
+		checks:   tripleChecks,
+		lambda:   make([]byte, circ.NumWires),
+		key0:     make([]ot.Label, circ.NumWires),
+		macs:     make([][]ot.Label, n),
+		keys:     make([][]ot.Label, n),
+		triple:   make([]int, len(circ.Gates)),
+		prodMacs: make([][]ot.Label, n),
+		prodKeys: make([][]ot.Label, n),
+	}
+	var count int
+	for idx, gate := range circ.Gates {
+		switch gate.Op {
+		case AND, OR:
+			b.triple[idx] = count
+			count++
+		default:
+			b.triple[idx] = -1
+		}
+	}
+	b.prod = make([]byte, count)
+
+	return b, nil
+}
+
+func (b *authBMR) run(ctx context.Context, inputs *big.Int,
+	verbose bool) ([]*big.Int, error) {
+
+	timing := NewTiming()
+
+	if verbose {
+		fmt.Printf(" - Preprocessing: authenticated shares\n")
+	}
+	err := runPhase(ctx, PhaseOT, b.nw.WithContext, b.preprocess)
+	if err != nil {
+		return nil, b.abort(err)
+	}
+	ioStats := b.nw.Stats().Sum()
+	timing.Sample("Preprocess", []string{FileSize(ioStats).String()})
+
+	if verbose {
+		fmt.Printf(" - Garbling: exchange gates\n")
+	}
+	b.garble()
+	b.stage(stageGates)
+	err = runPhase(ctx, PhaseTables, b.nw.WithContext, b.exchangeGates)
+	if err != nil {
+		return nil, b.abort(err)
+	}
+	xfer := b.nw.Stats().Sum() - ioStats
+	ioStats = b.nw.Stats().Sum()
+	timing.Sample("Garble", []string{FileSize(xfer).String()})
+
+	if verbose {
+		fmt.Printf(" - Inputs\n")
+	}
+	b.stage(stageInputs)
+	err = runPhase(ctx, PhaseOT, b.nw.WithContext, func() error {
+		return b.inputs(inputs)
+	})
+	if err != nil {
+		return nil, b.abort(err)
+	}
+	xfer = b.nw.Stats().Sum() - ioStats
+	ioStats = b.nw.Stats().Sum()
+	timing.Sample("Inputs", []string{FileSize(xfer).String()})
+
+	if verbose {
+		fmt.Printf(" - Evaluating\n")
+	}
+	if err := b.eval(); err != nil {
+		return nil, b.abort(err)
+	}
+	timing.Sample("Eval", nil)
+
+	b.stage(stageOutputs)
+	var result *big.Int
+	err = runPhase(ctx, PhaseResult, b.nw.WithContext, func() error {
+		var err error
+		result, err = b.result()
+		return err
+	})
+	if err != nil {
+		return nil, b.abort(err)
+	}
+	xfer = b.nw.Stats().Sum() - ioStats
+	timing.Sample("Result", []string{FileSize(xfer).String()})
+
+	return b.circ.Outputs.Split(result), nil
+}
+
+func (b *authBMR) stage(stage authStage) {
+	if b.tamper != nil {
+		b.tamper(b, stage)
+	}
+}
+
+// abort reports the local protocol error err to all peers and
+// returns err.
+func (b *authBMR) abort(err error) error {
+	for _, peer := range b.nw.Peers {
+		Abort(peer.Conn(), err)
+	}
+	return err
+}
+
+// isRoot tests if the wire has random mask and key shares. The masks
+// and keys of the other wires are derived from the gate inputs.
+func (b *authBMR) isRoot(w Wire, gateOp []Operation) bool {
+	if int(w) < b.circ.Inputs.Size() {
+		return true
+	}
+	op := gateOp[w]
+	return op == AND || op == OR
+}
+
+// preprocess creates our authenticated mask and product shares. If
+// the network is preprocessed with a dealer, the function uses the
+// dealt authenticated shares.
+func (b *authBMR) preprocess() error {
+	// Random masks and keys for the input wires and for the AND and
+	// OR gate outputs.
+	gateOp := make([]Operation, b.circ.NumWires)
+	for _, gate := range b.circ.Gates {
+		gateOp[gate.Output] = gate.Op
+	}
+	var roots []Wire
+	for w := 0; w < b.circ.NumWires; w++ {
+		if b.isRoot(Wire(w), gateOp) {
+			roots = append(roots, Wire(w))
+		}
+	}
+	if dealt := b.nw.Dealt(); dealt != nil {
+		return b.preprocessDealt(dealt, roots)
+	}
+
+	var err error
+	b.delta, err = ot.NewLabel(rand.Reader)
+	if err != nil {
+		return err
+	}
+	rootMasks, err := randomBits(len(roots))
+	if err != nil {
+		return err
+	}
+	for i, w := range roots {
+		b.lambda[w] = rootMasks[i]
+		b.key0[w], err = ot.NewLabel(rand.Reader)
+		if err != nil {
+			return err
+		}
+	}
+	b.deriveMasks()
+
+	// Shares of the lambda products and of the sacrificed products
+	// x*lambda_v. The sacrificed triple k of the gate triple t is at
+	// the index t*b.checks+k of x and z.
+	numProds := len(b.prod)
+	numChecks := numProds * b.checks
+	x, err := randomBits(numChecks)
+	if err != nil {
+		return err
+	}
+	lu := make([]byte, numProds, numProds+numChecks)
+	lv := make([]byte, numProds, numProds+numChecks)
+	for idx, gate := range b.circ.Gates {
+		t := b.triple[idx]
+		if t >= 0 {
+			lu[t] = b.lambda[gate.Input0]
+			lv[t] = b.lambda[gate.Input1]
+		}
+	}
+	lu = append(lu, x...)
+	for t := 0; t < numProds; t++ {
+		for k := 0; k < b.checks; k++ {
+			lv = append(lv, lv[t])
+		}
+	}
+	products := make([]byte, len(lu))
+	for i := range products {
+		products[i] = lu[i] & lv[i]
+	}
+	err = b.perPeer(func(id int, peer *p2p.Peer) (func(), error) {
+		cross, err := crossTerms(peer, b.self, id, lu, lv)
+		return func() {
+			for i, bit := range cross {
+				products[i] ^= bit
+			}
+		}, err
+	})
+	if err != nil {
+		return err
+	}
+	copy(b.prod, products)
+	z := products[numProds:]
+	b.stage(stageProducts)
+
+	// Authenticate the root masks, the products, the sacrificed
+	// triples, and the random masks of the correlation check.
+	masks, err := randomBits(labelBits)
+	if err != nil {
+		return err
+	}
+	bits := make([]byte, 0, len(roots)+numProds+2*numChecks+labelBits)
+	for _, w := range roots {
+		bits = append(bits, b.lambda[w])
+	}
+	bits = append(bits, b.prod...)
+	bits = append(bits, x...)
+	bits = append(bits, z...)
+	bits = append(bits, masks...)
+
+	allMacs := make([][]ot.Label, b.n)
+	allKeys := make([][]ot.Label, b.n)
+	err = b.perPeer(func(id int, peer *p2p.Peer) (func(), error) {
+		macs, keys, err := authenticate(peer, b.self, id, bits, b.delta)
+		return func() {
+			allMacs[id] = macs
+			allKeys[id] = keys
+		}, err
+	})
+	if err != nil {
+		return err
+	}
+	b.stage(stageCorrelation)
+	if err := b.checkCorrelation(bits, allMacs, allKeys); err != nil {
+		return err
+	}
+
+	check := &sacrifice{
+		x:     x,
+		z:     z,
+		xMacs: make([][]ot.Label, b.n),
+		xKeys: make([][]ot.Label, b.n),
+		zMacs: make([][]ot.Label, b.n),
+		zKeys: make([][]ot.Label, b.n),
+	}
+	for id := range b.nw.Peers {
+		macs := allMacs[id]
+		keys := allKeys[id]
+		b.macs[id] = make([]ot.Label, b.circ.NumWires)
+		b.keys[id] = make([]ot.Label, b.circ.NumWires)
+		for i, w := range roots {
+			b.macs[id][w] = macs[i]
+			b.keys[id][w] = keys[i]
+		}
+		macs = macs[len(roots):]
+		keys = keys[len(roots):]
+		b.prodMacs[id] = macs[:numProds]
+		b.prodKeys[id] = keys[:numProds]
+		check.xMacs[id] = macs[numProds : numProds+numChecks]
+		check.xKeys[id] = keys[numProds : numProds+numChecks]
+		check.zMacs[id] = macs[numProds+numChecks : numProds+2*numChecks]
+		check.zKeys[id] = keys[numProds+numChecks : numProds+2*numChecks]
+	}
+	b.deriveMACs()
+
+	return b.checkProducts(check)
+}
+
+// checkCorrelation verifies that the authenticated bits of all
+// parties are consistent with the global keys Delta before any of
+// them is opened. For the public random coefficients chi_h from
+// GF(2^128), each party i opens
+//
+//	X_i = sum_h chi_h*x_i[h]
+//
+// and the parties compute X = sum_i X_i. The last labelBits bits
+// are random masks with the coefficients X^k so that X_i does not
+// reveal anything about the other bits. If the MACs are correct, the
+// parties' shares of sum_h chi_h*x[h]*Delta_j XOR X*Delta_j sum to 0
+// for each party j. The parties commit to their shares before opening
+// them so no party can choose its shares after seeing the others.
+//
+// A party that authenticates different bits to different peers, uses
+// a Delta that does not match its keys, or opens an inconsistent X_i
+// is detected with ErrInvalidCorrelation. It can pass the check only
+// by guessing bits of an honest party's Delta, and each guess fails
+// with the probability 1/2.
+func (b *authBMR) checkCorrelation(bits []byte,
+	macs, keys [][]ot.Label) error {
+
+	count := len(bits) - labelBits
+	stream, err := b.coinStream()
+	if err != nil {
+		return err
+	}
+	var data ot.LabelData
+	chi := make([]ot.Label, len(bits))
+	buf := make([]byte, count*len(data))
+	stream.XORKeyStream(buf, buf)
+	for h := 0; h < count; h++ {
+		chi[h].SetBytes(buf[h*len(data):])
+	}
+	for k := 0; k < labelBits; k++ {
+		chi[count+k] = gfBasis(k)
+	}
+
+	var x ot.Label
+	for h, bit := range bits {
+		if bit != 0 {
+			x.Xor(chi[h])
+		}
+	}
+	msg := append([]byte(nil), x.Bytes(&data)...)
+	received, err := exchangeAll(b.nw, func(id int) []byte {
+		return msg
+	})
+	if err != nil {
+		return err
+	}
+	sum := x
+	for id, msg := range received {
+		if len(msg) != len(data) {
+			return fmt.Errorf("%w: peer %d: invalid combination length %d",
+				ErrProtocolMismatch, id, len(msg))
+		}
+		var l ot.Label
+		l.SetBytes(msg)
+		sum.Xor(l)
+	}
+
+	// Our shares of the check values of all parties j.
+	z := make([]ot.Label, b.n)
+	ourKeys := make([]ot.Label, len(bits))
+	for id := range b.nw.Peers {
+		z[id] = gfCombine(chi, macs[id])
+		for h, key := range keys[id] {
+			ourKeys[h].Xor(key)
+		}
+	}
+	sum.Xor(x)
+	z[b.self] = gfMul(sum, b.delta)
+	z[b.self].Xor(gfCombine(chi, ourKeys))
+	msg = nil
+	for _, l := range z {
+		msg = append(msg, l.Bytes(&data)...)
+	}
+	opened, err := b.commitExchange(msg)
+	if err != nil {
+		return err
+	}
+	for id, msg := range opened {
+		if len(msg) != b.n*len(data) {
+			return fmt.Errorf("%w: peer %d: invalid check value length %d",
+				ErrProtocolMismatch, id, len(msg))
+		}
+		for j := range z {
+			var l ot.Label
+			l.SetBytes(msg[j*len(data):])
+			z[j].Xor(l)
+		}
+	}
+	for j, l := range z {
+		if !l.Equal(ot.Label{}) {
+			return fmt.Errorf("%w: MACs for the key of party %d",
+				ErrInvalidCorrelation, j)
+		}
+	}
+	return nil
+}
+
+// preprocessDealt creates our authenticated mask and product shares
+// from the dealt authenticated bits and AND triples (a, b, c). The
+// parties open d = lambda_u XOR a and e = lambda_v XOR b with their
+// MACs, and the product is c XOR d*b XOR e*a XOR d*e.
+func (b *authBMR) preprocessDealt(dealt *p2p.Dealt, roots []Wire) error {
+	masks, err := dealt.Bits.Take(len(roots))
+	if err != nil {
+		return err
+	}
+	if masks.MACs == nil {
+		return fmt.Errorf("%w: dealt shares are not authenticated",
+			ErrProtocolMismatch)
+	}
+	b.delta = dealt.Delta
+	for id := range b.nw.Peers {
+		b.macs[id] = make([]ot.Label, b.circ.NumWires)
+		b.keys[id] = make([]ot.Label, b.circ.NumWires)
+	}
+	for i, w := range roots {
+		b.lambda[w] = masks.Bits[i]
+		b.key0[w], err = ot.NewLabel(rand.Reader)
+		if err != nil {
+			return err
+		}
+		for id := range b.nw.Peers {
+			b.macs[id][w] = masks.MACs[id][i]
+			b.keys[id][w] = masks.Keys[id][i]
+		}
+	}
+	b.deriveMasks()
+	b.deriveMACs()
+
+	numProds := len(b.prod)
+	ta, tb, tc, err := dealt.Triples(numProds)
+	if err != nil {
+		return err
+	}
+	de := newAuthValues(2*numProds, b.nw.Peers)
+	for idx, gate := range b.circ.Gates {
+		t := b.triple[idx]
+		if t < 0 {
+			continue
+		}
+		de.add(t, b.lambda[gate.Input0], b.wireAuth(gate.Input0))
+		de.add(t, ta.Bits[t], sharesAuth(ta, t))
+		de.add(numProds+t, b.lambda[gate.Input1], b.wireAuth(gate.Input1))
+		de.add(numProds+t, tb.Bits[t], sharesAuth(tb, t))
+	}
+	opened, err := b.open(de, "dealt triple mask")
+	if err != nil {
+		return err
+	}
+
+	prod := newAuthValues(numProds, b.nw.Peers)
+	for t := 0; t < numProds; t++ {
+		d := opened[t]
+		e := opened[numProds+t]
+		prod.add(t, tc.Bits[t], sharesAuth(tc, t))
+		if d != 0 {
+			prod.add(t, tb.Bits[t], sharesAuth(tb, t))
+		}
+		if e != 0 {
+			prod.add(t, ta.Bits[t], sharesAuth(ta, t))
+		}
+		if d&e != 0 {
+			// The party 0 adds the constant 1 and the peers add their
+			// Delta to their keys of its share.
+			if b.self == 0 {
+				prod.bits[t] ^= 1
+			} else {
+				prod.keys[0][t].Xor(b.delta)
+			}
+		}
+	}
+	b.prod = prod.bits
+	for id := range b.nw.Peers {
+		b.prodMacs[id] = prod.macs[id]
+		b.prodKeys[id] = prod.keys[id]
+	}
+	return nil
+}
+
+// wireAuth returns the function that returns the MAC and key of our
+// mask share of the wire w for the peer.
+func (b *authBMR) wireAuth(w Wire) func(id int) (ot.Label, ot.Label) {
+	return func(id int) (ot.Label, ot.Label) {
+		return b.macs[id][w], b.keys[id][w]
+	}
+}
+
+// sharesAuth returns the function that returns the MAC and key of
+// our dealt share i for the peer.
+func sharesAuth(s *p2p.Shares, i int) func(id int) (ot.Label, ot.Label) {
+	return func(id int) (ot.Label, ot.Label) {
+		return s.MACs[id][i], s.Keys[id][i]
+	}
+}
+
+// sacrifice holds our shares of the sacrificed triples (x, lambda_v,
+// z) of the gate triples and their MACs and keys.
+type sacrifice struct {
+	x     []byte
+	z     []byte
+	xMacs [][]ot.Label
+	xKeys [][]ot.Label
+	zMacs [][]ot.Label
+	zKeys [][]ot.Label
+}
+
+// checkProducts verifies the gate triples (lambda_u, lambda_v, prod)
+// by sacrificing the triples (x, lambda_v, z). For the random public
+// challenge bit r of each sacrificed triple, the parties open
+//
+//	d = r*lambda_u ^ x
+//	c = r*prod ^ z ^ d*lambda_v
+//
+// with their MACs and verify that c is 0. If prod or z has an error,
+// c is 0 with the probability 1/2 so a corrupt gate triple passes all
+// checks of its gate with the probability 2^-b.checks.
+func (b *authBMR) checkProducts(check *sacrifice) error {
+	numProds := len(b.prod)
+	gates := make([]Gate, numProds)
+	for idx, gate := range b.circ.Gates {
+		if t := b.triple[idx]; t >= 0 {
+			gates[t] = gate
+		}
+	}
+	r, err := b.coinToss(numProds * b.checks)
+	if err != nil {
+		return err
+	}
+
+	// Open d.
+	d := newAuthValues(len(r), b.nw.Peers)
+	for i := range r {
+		t := i / b.checks
+		u := gates[t].Input0
+		d.add(i, check.x[i], func(id int) (ot.Label, ot.Label) {
+			return check.xMacs[id][i], check.xKeys[id][i]
+		})
+		if r[i] != 0 {
+			d.add(i, b.lambda[u], b.wireAuth(u))
+		}
+	}
+	opened, err := b.open(d, "sacrifice mask")
+	if err != nil {
+		return err
+	}
+
+	// Open c.
+	c := newAuthValues(len(r), b.nw.Peers)
+	for i := range r {
+		t := i / b.checks
+		v := gates[t].Input1
+		c.add(i, check.z[i], func(id int) (ot.Label, ot.Label) {
+			return check.zMacs[id][i], check.zKeys[id][i]
+		})
+		if r[i] != 0 {
+			c.add(i, b.prod[t],
+				func(id int) (ot.Label, ot.Label) {
+					return b.prodMacs[id][t], b.prodKeys[id][t]
+				})
+		}
+		if opened[i] != 0 {
+			c.add(i, b.lambda[v], b.wireAuth(v))
+		}
+	}
+	opened, err = b.open(c, "sacrifice check")
+	if err != nil {
+		return err
+	}
+	for i, bit := range opened {
+		if bit != 0 {
+			return fmt.Errorf("%w: product of gate triple %d",
+				ErrInvalidTriple, i/b.checks)
+		}
+	}
+	return nil
+}
+
+// authValues holds our shares of authenticated values and their MACs
+// and keys, indexed by the peer and the value.
+type authValues struct {
+	peers map[int]*p2p.Peer
+	bits  []byte
+	macs  [][]ot.Label
+	keys  [][]ot.Label
+}
+
+func newAuthValues(count int, peers map[int]*p2p.Peer) *authValues {
+	n := len(peers) + 1
+	v := &authValues{
+		peers: peers,
+		bits:  make([]byte, count),
+		macs:  make([][]ot.Label, n),
+		keys:  make([][]ot.Label, n),
+	}
+	for id := range peers {
+		v.macs[id] = make([]ot.Label, count)
+		v.keys[id] = make([]ot.Label, count)
+	}
+	return v
+}
+
+// add adds the authenticated share with its MACs and keys to the
+// value i.
+func (v *authValues) add(i int, share byte,
+	auth func(id int) (mac, key ot.Label)) {
+
+	v.bits[i] ^= share
+	for id := range v.peers {
+		mac, key := auth(id)
+		v.macs[id][i].Xor(mac)
+		v.keys[id][i].Xor(key)
+	}
+}
+
+// open opens the authenticated values to all parties and returns the
+// values.
+func (b *authBMR) open(v *authValues, what string) ([]byte, error) {
+	received, err := exchangeAll(b.nw, func(id int) []byte {
+		return encodeOpening(v.bits, v.macs[id])
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, len(v.bits))
+	copy(result, v.bits)
+	for id, msg := range received {
+		bits, err := b.verifyOpening(id, v.keys[id], msg,
+			func(i int) string {
+				return fmt.Sprintf("%s %d", what, i)
+			})
+		if err != nil {
+			return nil, err
+		}
+		for i := range result {
+			result[i] ^= bits[i]
+		}
+	}
+	return result, nil
+}
+
+// coinToss returns count random bits that all parties agree on.
+func (b *authBMR) coinToss(count int) ([]byte, error) {
+	stream, err := b.coinStream()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, (count+7)/8)
+	stream.XORKeyStream(buf, buf)
+
+	result := make([]byte, count)
+	if err := unpackBits(buf, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// coinStream returns a random key stream that all parties agree on.
+// The parties commit to their random seeds before opening them so no
+// party can choose the stream.
+func (b *authBMR) coinStream() (cipher.Stream, error) {
+	var seed [32]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	seeds, err := b.commitExchange(seed[:])
+	if err != nil {
+		return nil, err
+	}
+	key := seed
+	for id, data := range seeds {
+		if len(data) != len(seed) {
+			return nil, fmt.Errorf("%w: peer %d: invalid seed length %d",
+				ErrProtocolMismatch, id, len(data))
+		}
+		for i := range key {
+			key[i] ^= data[i]
+		}
+	}
+	alg, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(alg, make([]byte, alg.BlockSize())), nil
+}
+
+// commitExchange exchanges the data with all peers so that the
+// parties commit to their data before opening it. The function
+// returns the peers' data.
+func (b *authBMR) commitExchange(data []byte) (map[int][]byte, error) {
+	var nonce [32]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	msg := append(nonce[:], data...)
+	commit := sha256.Sum256(msg)
+	commits, err := exchangeAll(b.nw, func(id int) []byte {
+		return commit[:]
+	})
+	if err != nil {
+		return nil, err
+	}
+	opened, err := exchangeAll(b.nw, func(id int) []byte {
+		return msg
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int][]byte)
+	for id, msg := range opened {
+		digest := sha256.Sum256(msg)
+		if len(msg) < len(nonce) ||
+			string(digest[:]) != string(commits[id]) {
+			return nil, fmt.Errorf("%w: peer %d: data does not match "+
+				"commitment", ErrProtocolMismatch, id)
+		}
+		result[id] = msg[len(nonce):]
+	}
+	return result, nil
+}
+
+// perPeer runs the function f with all peers concurrently. The
+// function returns a completion function that is called for
+// successful runs from the calling goroutine.
+func (b *authBMR) perPeer(
+	f func(id int, peer *p2p.Peer) (func(), error)) error {
+	type peerResult struct {
+		peer int
+		done func()
+		err  error
+	}
+	results := make(chan peerResult, len(b.nw.Peers))
+	for id, peer := range b.nw.Peers {
+		go func(id int, peer *p2p.Peer) {
+			done, err := f(id, peer)
+			results <- peerResult{
+				peer: id,
+				done: done,
+				err:  err,
+			}
+		}(id, peer)
+	}
+	var firstErr error
+	for range b.nw.Peers {
+		result := <-results
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("peer %d: %w", result.peer, result.err)
+			}
+			continue
+		}
+		result.done()
+	}
+	return firstErr
+}
+
+// deriveMasks derives the masks and keys of the XOR, XNOR, and INV
+// gate outputs. The party 0 holds the constant 1 of the XNOR and INV
+// gates.
+func (b *authBMR) deriveMasks() {
+	for _, gate := range b.circ.Gates {
+		switch gate.Op {
+		case XOR, XNOR:
+			b.lambda[gate.Output] = b.lambda[gate.Input0] ^
+				b.lambda[gate.Input1]
+			k := b.key0[gate.Input0]
+			k.Xor(b.key0[gate.Input1])
+			b.key0[gate.Output] = k
+		case INV:
+			b.lambda[gate.Output] = b.lambda[gate.Input0]
+			b.key0[gate.Output] = b.key0[gate.Input0]
+		default:
+			continue
+		}
+		if gate.Op != XOR && b.self == 0 {
+			b.lambda[gate.Output] ^= 1
+		}
+	}
+}
+
+// deriveMACs derives the MACs and keys of the XOR, XNOR, and INV gate
+// output masks. When the party 0 adds the constant 1 to its share,
+// the peers add their Delta to their keys of the share.
+func (b *authBMR) deriveMACs() {
+	for _, gate := range b.circ.Gates {
+		if gate.Op == AND || gate.Op == OR {
+			continue
+		}
+		for id := range b.nw.Peers {
+			mac := b.macs[id][gate.Input0]
+			key := b.keys[id][gate.Input0]
+			if gate.Op != INV {
+				mac.Xor(b.macs[id][gate.Input1])
+				key.Xor(b.keys[id][gate.Input1])
+			}
+			if gate.Op != XOR && id == 0 {
+				key.Xor(b.delta)
+			}
+			b.macs[id][gate.Output] = mac
+			b.keys[id][gate.Output] = key
+		}
+	}
+}
+
+// deltaShare returns our share of x*Delta_j for the authenticated
+// bit x, given our share, our MACs, and our keys of the bit.
+func (b *authBMR) deltaShare(j int, share byte,
+	macs, keys func(id int) ot.Label) ot.Label {
+	if j != b.self {
+		return macs(j)
+	}
+	var result ot.Label
+	if share != 0 {
+		result = b.delta
+	}
+	for id := range b.nw.Peers {
+		result.Xor(keys(id))
+	}
+	return result
+}
+
+// wireShare returns our share of lambda_w*Delta_j.
+func (b *authBMR) wireShare(j int, w Wire) ot.Label {
+	return b.deltaShare(j, b.lambda[w],
+		func(id int) ot.Label {
+			return b.macs[id][w]
+		},
+		func(id int) ot.Label {
+			return b.keys[id][w]
+		})
+}
+
+// prodShare returns our share of lambda_u*lambda_v*Delta_j for the
+// gate triple t.
+func (b *authBMR) prodShare(j, t int) ot.Label {
+	return b.deltaShare(j, b.prod[t],
+		func(id int) ot.Label {
+			return b.prodMacs[id][t]
+		},
+		func(id int) ot.Label {
+			return b.prodKeys[id][t]
+		})
+}
+
+// prf computes the PRF of the wire keys a and b for the gate g and
+// the target party j.
+func (b *authBMR) prf(a, c ot.Label, g, j int, data *ot.LabelData) ot.Label {
+	return encrypt(b.alg, a, c, ot.Label{}, uint32(g*b.n+j), data)
+}
+
+// rowCoefficients returns the coefficients of chi for the gate
+// operation and the row (alpha, beta):
+//
+//	chi = lambda_u*lambda_v ^ cu*lambda_u ^ cv*lambda_v ^ c0 ^ lambda_w
+func rowCoefficients(op Operation, alpha, beta byte) (cu, cv, c0 byte) {
+	if op == OR {
+		return 1 ^ beta, 1 ^ alpha, alpha | beta
+	}
+	return beta, alpha, alpha & beta
+}
+
+// garble computes our shares of the garbled rows. The garbled row
+// (alpha, beta) of the AND and OR gate g for the party j is:
+//
+//	G_j = XOR_i F(k_i[u, alpha], k_i[v, beta], g, j) ^ k_j[w, 0] ^
+//	      chi(alpha, beta)*Delta_j
+//
+// where chi is the masked gate output for the row.
+func (b *authBMR) garble() {
+	b.gates = newZeroGateValues(len(b.circ.Gates), b.n)
+	rows := [][]ot.Label{nil, nil, nil, nil}
+	var data ot.LabelData
+
+	for g, gate := range b.circ.Gates {
+		t := b.triple[g]
+		if t < 0 {
+			continue
+		}
+		for j := 0; j < b.n; j++ {
+			rows[0] = b.gates.Ag[j]
+			rows[1] = b.gates.Bg[j]
+			rows[2] = b.gates.Cg[j]
+			rows[3] = b.gates.Dg[j]
+
+			prod := b.prodShare(j, t)
+			lu := b.wireShare(j, gate.Input0)
+			lv := b.wireShare(j, gate.Input1)
+			lw := b.wireShare(j, gate.Output)
+
+			for row := 0; row < 4; row++ {
+				alpha := byte(row >> 1)
+				beta := byte(row & 1)
+
+				ku := b.key0[gate.Input0]
+				if alpha != 0 {
+					ku.Xor(b.delta)
+				}
+				kv := b.key0[gate.Input1]
+				if beta != 0 {
+					kv.Xor(b.delta)
+				}
+				share := b.prf(ku, kv, g, j, &data)
+				if j == b.self {
+					share.Xor(b.key0[gate.Output])
+				}
+
+				cu, cv, c0 := rowCoefficients(gate.Op, alpha, beta)
+				share.Xor(prod)
+				share.Xor(lw)
+				if cu != 0 {
+					share.Xor(lu)
+				}
+				if cv != 0 {
+					share.Xor(lv)
+				}
+				if c0 != 0 && j == b.self {
+					share.Xor(b.delta)
+				}
+				rows[row][g] = share
+			}
+		}
+	}
+}
+
+func newZeroGateValues(numGates, numPlayers int) *GateValues {
+	v := &GateValues{
+		Ag: make([][]ot.Label, numPlayers),
+		Bg: make([][]ot.Label, numPlayers),
+		Cg: make([][]ot.Label, numPlayers),
+		Dg: make([][]ot.Label, numPlayers),
+	}
+	for p := 0; p < numPlayers; p++ {
+		v.Ag[p] = make([]ot.Label, numGates)
+		v.Bg[p] = make([]ot.Label, numGates)
+		v.Cg[p] = make([]ot.Label, numGates)
+		v.Dg[p] = make([]ot.Label, numGates)
+	}
+	return v
+}
+
+// exchangeGates exchanges the garbled row shares with the peers and
+// combines the garbled tables.
+func (b *authBMR) exchangeGates() error {
+	b.tables = newZeroGateValues(len(b.circ.Gates), b.n)
+	gs := b.gates
+	b.addGates(gs.Ag, gs.Bg, gs.Cg, gs.Dg)
+
+	return b.perPeer(func(id int, peer *p2p.Peer) (func(), error) {
+		ra, rb, rc, rd, _, err := peer.ExchangeGates(
+			gs.Ag, gs.Bg, gs.Cg, gs.Dg, new(big.Int))
+		if err != nil {
+			return nil, err
+		}
+		for _, values := range [][][]ot.Label{ra, rb, rc, rd} {
+			if err := b.checkGates(values); err != nil {
+				return nil, err
+			}
+		}
+		return func() {
+			b.addGates(ra, rb, rc, rd)
+		}, nil
+	})
+}
+
+// checkGates checks that the gate values have a value for each party
+// and gate.
+func (b *authBMR) checkGates(values [][]ot.Label) error {
+	if len(values) != b.n {
+		return fmt.Errorf("%w: gate values for %d parties",
+			ErrProtocolMismatch, len(values))
+	}
+	for _, arr := range values {
+		if len(arr) != len(b.circ.Gates) {
+			return fmt.Errorf("%w: %d gate values, expected %d",
+				ErrProtocolMismatch, len(arr), len(b.circ.Gates))
+		}
+	}
+	return nil
+}
+
+// addGates adds the garbled row shares to the garbled tables.
+func (b *authBMR) addGates(ag, bg, cg, dg [][]ot.Label) {
+	add := func(dst, src [][]ot.Label) {
+		for p := range dst {
+			for g := range dst[p] {
+				dst[p][g].Xor(src[p][g])
+			}
+		}
+	}
+	add(b.tables.Ag, ag)
+	add(b.tables.Bg, bg)
+	add(b.tables.Cg, cg)
+	add(b.tables.Dg, dg)
+}
+
+// openMsg encodes our shares of the wires' masks with their MACs for
+// the peer.
+func (b *authBMR) openMsg(peer int, wires []Wire) []byte {
+	bits := make([]byte, len(wires))
+	macs := make([]ot.Label, len(wires))
+	for i, w := range wires {
+		bits[i] = b.lambda[w]
+		macs[i] = b.macs[peer][w]
+	}
+	return encodeOpening(bits, macs)
+}
+
+// verifyOpen verifies the peer's mask shares of the wires with our
+// MAC keys and returns the shares.
+func (b *authBMR) verifyOpen(peer int, wires []Wire, msg []byte) (
+	[]byte, error) {
+
+	keys := make([]ot.Label, len(wires))
+	for i, w := range wires {
+		keys[i] = b.keys[peer][w]
+	}
+	return b.verifyOpening(peer, keys, msg, func(i int) string {
+		return fmt.Sprintf("mask of wire %d", wires[i])
+	})
+}
+
+// encodeOpening encodes the shares with their MACs.
+func encodeOpening(bits []byte, macs []ot.Label) []byte {
+	msg := packBits(bits)
+	var data ot.LabelData
+	for _, mac := range macs {
+		msg = append(msg, mac.Bytes(&data)...)
+	}
+	return msg
+}
+
+// verifyOpening verifies the peer's opened shares with our MAC keys
+// and returns the shares. The name function names the shares in
+// errors.
+func (b *authBMR) verifyOpening(peer int, keys []ot.Label, msg []byte,
+	name func(i int) string) ([]byte, error) {
+
+	bitsLen := (len(keys) + 7) / 8
+	if len(msg) != bitsLen+len(keys)*len(ot.LabelData{}) {
+		return nil, fmt.Errorf("%w: peer %d: invalid opening length %d",
+			ErrProtocolMismatch, peer, len(msg))
+	}
+	bits := make([]byte, len(keys))
+	if err := unpackBits(msg[:bitsLen], bits); err != nil {
+		return nil, err
+	}
+	var data ot.LabelData
+	for i, key := range keys {
+		off := bitsLen + i*len(data)
+		copy(data[:], msg[off:])
+		var mac ot.Label
+		mac.SetData(&data)
+
+		expected := key
+		if bits[i] != 0 {
+			expected.Xor(b.delta)
+		}
+		if !mac.Equal(expected) {
+			return nil, fmt.Errorf("%w: peer %d: %s",
+				ErrInvalidMAC, peer, name(i))
+		}
+	}
+	return bits, nil
+}
+
+// inputs opens the input wire masks to their owners, exchanges the
+// masked input values, and exchanges the parties' keys of the masked
+// input values. Finally the parties check that they all received the
+// same masked input values and keys.
+func (b *authBMR) inputs(inputs *big.Int) error {
+	owned := make([][]Wire, b.n)
+	var w Wire
+	for idx, arg := range b.circ.Inputs {
+		for i := 0; i < int(arg.Type.Bits); i++ {
+			owned[idx] = append(owned[idx], w)
+			w++
+		}
+	}
+	numInputs := int(w)
+
+	// Open the masks of the input wires to their owners.
+	received, err := exchangeAll(b.nw, func(id int) []byte {
+		return b.openMsg(id, owned[id])
+	})
+	if err != nil {
+		return err
+	}
+	mask := make([]byte, len(owned[b.self]))
+	for i, w := range owned[b.self] {
+		mask[i] = b.lambda[w]
+	}
+	for id, msg := range received {
+		bits, err := b.verifyOpen(id, owned[b.self], msg)
+		if err != nil {
+			return err
+		}
+		for i := range mask {
+			mask[i] ^= bits[i]
+		}
+	}
+
+	// Exchange the masked input values.
+	b.masked = make([]byte, b.circ.NumWires)
+	for i, w := range owned[b.self] {
+		b.masked[w] = byte(inputs.Bit(i)) ^ mask[i]
+	}
+	ours := make([]byte, len(owned[b.self]))
+	for i, w := range owned[b.self] {
+		ours[i] = b.masked[w]
+	}
+	msg := packBits(ours)
+	received, err = exchangeAll(b.nw, func(id int) []byte {
+		return msg
+	})
+	if err != nil {
+		return err
+	}
+	for id, data := range received {
+		theirs := make([]byte, len(owned[id]))
+		if err := unpackBits(data, theirs); err != nil {
+			return err
+		}
+		for i, w := range owned[id] {
+			b.masked[w] = theirs[i]
+		}
+	}
+
+	// Exchange the keys of the masked input values.
+	b.wireKeys = make([][]ot.Label, b.circ.NumWires)
+	for i := range b.wireKeys {
+		b.wireKeys[i] = make([]ot.Label, b.n)
+	}
+	var data ot.LabelData
+	msg = nil
+	for w := 0; w < numInputs; w++ {
+		k := b.key0[w]
+		if b.masked[w] != 0 {
+			k.Xor(b.delta)
+		}
+		b.wireKeys[w][b.self] = k
+		msg = append(msg, k.Bytes(&data)...)
+	}
+	received, err = exchangeAll(b.nw, func(id int) []byte {
+		return msg
+	})
+	if err != nil {
+		return err
+	}
+	for id, keys := range received {
+		if len(keys) != numInputs*len(data) {
+			return fmt.Errorf("%w: peer %d: %d bytes of input keys",
+				ErrProtocolMismatch, id, len(keys))
+		}
+		for w := 0; w < numInputs; w++ {
+			copy(data[:], keys[w*len(data):])
+			b.wireKeys[w][id].SetData(&data)
+		}
+	}
+	b.stage(stageInputCheck)
+
+	return b.checkInputs(numInputs)
+}
+
+// checkInputs checks that all parties have the same masked input
+// values and input keys. Without the check, a party could send
+// different inputs to different peers and learn from their failures
+// in the evaluation.
+func (b *authBMR) checkInputs(numInputs int) error {
+	h := sha256.New()
+	h.Write(packBits(b.masked[:numInputs]))
+	var data ot.LabelData
+	for w := 0; w < numInputs; w++ {
+		for _, k := range b.wireKeys[w] {
+			h.Write(k.Bytes(&data))
+		}
+	}
+	digest := h.Sum(nil)
+	received, err := exchangeAll(b.nw, func(id int) []byte {
+		return digest
+	})
+	if err != nil {
+		return err
+	}
+	for id, data := range received {
+		if string(data) != string(digest) {
+			return fmt.Errorf("%w: peer %d received different inputs",
+				ErrProtocolMismatch, id)
+		}
+	}
+	return nil
+}
+
+// eval evaluates the garbled circuit. The function verifies that our
+// decrypted keys are our wire keys.
+func (b *authBMR) eval() error {
+	var data ot.LabelData
+	for g, gate := range b.circ.Gates {
+		u := gate.Input0
+		v := gate.Input1
+		w := gate.Output
+
+		switch gate.Op {
+		case XOR, XNOR:
+			b.masked[w] = b.masked[u] ^ b.masked[v]
+			for i := 0; i < b.n; i++ {
+				k := b.wireKeys[u][i]
+				k.Xor(b.wireKeys[v][i])
+				b.wireKeys[w][i] = k
+			}
+
+		case INV:
+			b.masked[w] = b.masked[u]
+			copy(b.wireKeys[w], b.wireKeys[u])
+
+		case AND, OR:
+			var table [][]ot.Label
+			switch b.masked[u]<<1 | b.masked[v] {
+			case 0:
+				table = b.tables.Ag
+			case 1:
+				table = b.tables.Bg
+			case 2:
+				table = b.tables.Cg
+			default:
+				table = b.tables.Dg
+			}
+			for j := 0; j < b.n; j++ {
+				k := table[j][g]
+				for i := 0; i < b.n; i++ {
+					k.Xor(b.prf(b.wireKeys[u][i], b.wireKeys[v][i], g, j,
+						&data))
+				}
+				b.wireKeys[w][j] = k
+			}
+			k := b.wireKeys[w][b.self]
+			if k.Equal(b.key0[w]) {
+				b.masked[w] = 0
+			} else {
+				k.Xor(b.delta)
+				if !k.Equal(b.key0[w]) {
+					return fmt.Errorf("%w: gate %d", ErrCorruptGarbledTable, g)
+				}
+				b.masked[w] = 1
+			}
+
+		default:
+			return fmt.Errorf("invalid gate %s", gate.Op)
+		}
+	}
+	return nil
+}
+
+// result verifies that all parties evaluated the same masked values
+// and opens the output wire masks.
+func (b *authBMR) result() (*big.Int, error) {
+	digest := sha256.Sum256(b.masked)
+	received, err := exchangeAll(b.nw, func(id int) []byte {
+		return digest[:]
+	})
+	if err != nil {
+		return nil, err
+	}
+	for id, data := range received {
+		if string(data) != string(digest[:]) {
+			return nil, fmt.Errorf("%w: peer %d evaluated different values",
+				ErrProtocolMismatch, id)
+		}
+	}
+
+	size := b.circ.Outputs.Size()
+	outputs := make([]Wire, size)
+	for i := range outputs {
+		outputs[i] = Wire(b.circ.NumWires - size + i)
+	}
+	received, err = exchangeAll(b.nw, func(id int) []byte {
+		return b.openMsg(id, outputs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	bits := make([]byte, size)
+	for i, w := range outputs {
+		bits[i] = b.masked[w] ^ b.lambda[w]
+	}
+	for id, msg := range received {
+		theirs, err := b.verifyOpen(id, outputs, msg)
+		if err != nil {
+			return nil, err
+		}
+		for i := range bits {
+			bits[i] ^= theirs[i]
+		}
+	}
+
+	result := new(big.Int)
+	for i, bit := range bits {
+		if bit != 0 {
+			result.SetBit(result, i, 1)
+		}
+	}
+	return result, nil
+}
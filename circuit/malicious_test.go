@@ -0,0 +1,219 @@
+//
+// malicious_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// testTripleChecks specifies the number of sacrificed products per
+// gate in the tests. The tamper tests corrupt all products of the
+// circuit so one check per gate detects them.
+const testTripleChecks = 1
+
+type maliciousResult struct {
+	player int
+	result []*big.Int
+	err    error
+}
+
+func runMalicious(t *testing.T, circ *Circuit, inputs []*big.Int,
+	tamperer int, tamper func(b *authBMR, stage authStage)) []maliciousResult {
+
+	networks := newTestNetworks(t, len(inputs))
+
+	results := make(chan maliciousResult)
+	for i, nw := range networks {
+		go func(player int, nw *p2p.Network) {
+			b, err := newAuthBMR(nw, circ)
+			if err != nil {
+				results <- maliciousResult{
+					player: player,
+					err:    err,
+				}
+				return
+			}
+			b.checks = testTripleChecks
+			if player == tamperer {
+				b.tamper = tamper
+			}
+			result, err := b.run(context.Background(), inputs[player], false)
+			results <- maliciousResult{
+				player: player,
+				result: result,
+				err:    err,
+			}
+		}(i, nw)
+	}
+	sorted := make([]maliciousResult, len(networks))
+	for range networks {
+		r := <-results
+		sorted[r.player] = r
+	}
+	return sorted
+}
+
+func newMaliciousTest(numPlayers int) (*Circuit, []*big.Int, []*big.Int,
+	error) {
+
+	inputSizes := make([]int, numPlayers)
+	for i := range inputSizes {
+		inputSizes[i] = 8
+	}
+	circ := newRandomCircuit(inputSizes, 256, 16)
+
+	rnd := mathrand.New(mathrand.NewSource(int64(numPlayers)))
+	inputs := make([]*big.Int, numPlayers)
+	for i := range inputs {
+		inputs[i] = big.NewInt(rnd.Int63n(1 << 8))
+	}
+	expected, err := circ.Compute(inputs)
+	return circ, inputs, expected, err
+}
+
+func TestMaliciousPlayer(t *testing.T) {
+	for _, numPlayers := range []int{3, 4, 5} {
+		t.Run(fmt.Sprintf("%d-party", numPlayers), func(t *testing.T) {
+			circ, inputs, expected, err := newMaliciousTest(numPlayers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range runMalicious(t, circ, inputs, -1, nil) {
+				if r.err != nil {
+					t.Fatalf("player %d: failed: %s", r.player, r.err)
+				}
+				if len(r.result) != len(expected) {
+					t.Fatalf("player %d: got %d results, expected %d",
+						r.player, len(r.result), len(expected))
+				}
+				for i := range expected {
+					if r.result[i].Cmp(expected[i]) != 0 {
+						t.Errorf("player %d: result %d: got %v, expected %v",
+							r.player, i, r.result[i], expected[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+var maliciousTests = []struct {
+	name   string
+	stage  authStage
+	tamper func(b *authBMR)
+	detect error
+}{
+	{
+		name:  "global key",
+		stage: stageCorrelation,
+		tamper: func(b *authBMR) {
+			b.delta.D1 ^= 1
+		},
+		detect: ErrInvalidCorrelation,
+	},
+	{
+		name:  "lambda products",
+		stage: stageProducts,
+		tamper: func(b *authBMR) {
+			for t := range b.prod {
+				b.prod[t] ^= 1
+			}
+		},
+		detect: ErrInvalidTriple,
+	},
+	{
+		name:  "garbled rows",
+		stage: stageGates,
+		tamper: func(b *authBMR) {
+			for g, t := range b.triple {
+				if t >= 0 {
+					b.gates.Ag[0][g].D0 ^= 1
+					b.gates.Bg[0][g].D0 ^= 1
+					b.gates.Cg[0][g].D0 ^= 1
+					b.gates.Dg[0][g].D0 ^= 1
+					return
+				}
+			}
+		},
+		detect: ErrCorruptGarbledTable,
+	},
+	{
+		name:  "input mask",
+		stage: stageInputs,
+		tamper: func(b *authBMR) {
+			b.lambda[0] ^= 1
+		},
+		detect: ErrInvalidMAC,
+	},
+	{
+		name:  "masked input",
+		stage: stageInputCheck,
+		tamper: func(b *authBMR) {
+			b.masked[0] ^= 1
+		},
+		detect: ErrProtocolMismatch,
+	},
+	{
+		name:  "output mask",
+		stage: stageOutputs,
+		tamper: func(b *authBMR) {
+			b.lambda[b.circ.NumWires-1] ^= 1
+		},
+		detect: ErrInvalidMAC,
+	},
+}
+
+func TestMaliciousPlayerTamper(t *testing.T) {
+	for _, test := range maliciousTests {
+		for _, numPlayers := range []int{3, 5} {
+			name := fmt.Sprintf("%s/%d-party", test.name, numPlayers)
+			t.Run(name, func(t *testing.T) {
+				circ, inputs, _, err := newMaliciousTest(numPlayers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tamperer := numPlayers - 1
+				results := runMalicious(t, circ, inputs, tamperer,
+					func(b *authBMR, stage authStage) {
+						if stage == test.stage {
+							test.tamper(b)
+						}
+					})
+
+				var detected bool
+				for _, r := range results {
+					if r.player == tamperer {
+						continue
+					}
+					if r.err == nil {
+						t.Errorf("player %d: tampering not detected",
+							r.player)
+						continue
+					}
+					if errors.Is(r.err, test.detect) {
+						detected = true
+					} else if !errors.Is(r.err, ErrPeerAbort) {
+						t.Errorf("player %d: unexpected error: %s",
+							r.player, r.err)
+					}
+				}
+				if !detected {
+					t.Errorf("no player detected %s", test.detect)
+				}
+			})
+		}
+	}
+}
@@ -15,6 +15,11 @@ import (
 const (
 	// MAGIC is a magic number for the QCL circuit format version 0.
 	MAGIC = 0x63726300 // crc0
+
+	// MAGIC1 is a magic number for the QCL circuit format version
+	// 1. The version 1 adds the output recipients to the I/O
+	// arguments.
+	MAGIC1 = 0x63726301 // crc1
 )
 
 var (
@@ -36,7 +41,7 @@ func (c *Circuit) MarshalFormat(out io.Writer, format string) error {
 // Marshal marshals circuit in the QCL circuit format.
 func (c *Circuit) Marshal(out io.Writer) error {
 	var data = []interface{}{
-		uint32(MAGIC),
+		uint32(MAGIC1),
 		uint32(c.NumGates),
 		uint32(c.NumWires),
 		uint32(len(c.Inputs)),
@@ -93,6 +98,9 @@ func marshalIOArg(out io.Writer, arg IOArg) error {
 	if err := binary.Write(out, bo, uint32(arg.Type.Bits)); err != nil {
 		return err
 	}
+	if err := binary.Write(out, bo, uint32(arg.Recipient)); err != nil {
+		return err
+	}
 	if err := binary.Write(out, bo, uint32(len(arg.Compound))); err != nil {
 		return err
 	}
@@ -0,0 +1,82 @@
+//
+// mux_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+func TestMux(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16}, 512, 8)
+
+	gc, ec := net.Pipe()
+	gmux := p2p.NewMux(gc)
+	emux := p2p.NewMux(ec)
+	defer gmux.Close()
+	defer emux.Close()
+
+	const numSessions = 4
+
+	type result struct {
+		id     uint32
+		result []*big.Int
+		err    error
+	}
+	gr := make(chan result)
+	er := make(chan result)
+
+	for i := 0; i < numSessions; i++ {
+		go func(id uint32) {
+			s, err := gmux.Open(id)
+			if err != nil {
+				gr <- result{err: err}
+				return
+			}
+			r, err := Garbler(context.Background(), s.Conn, ot.NewCO(),
+				circ, big.NewInt(int64(id)), false)
+			gr <- result{id: id, result: r, err: err}
+		}(uint32(i + 1))
+	}
+	for i := 0; i < numSessions; i++ {
+		s, err := emux.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(s *p2p.Stream) {
+			r, err := Evaluator(context.Background(), s.Conn, ot.NewCO(),
+				circ, big.NewInt(int64(s.ID+10)), false)
+			er <- result{id: s.ID, result: r, err: err}
+		}(s)
+	}
+
+	for _, c := range []chan result{gr, er} {
+		for i := 0; i < numSessions; i++ {
+			r := <-c
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			expected, err := circ.Compute([]*big.Int{
+				big.NewInt(int64(r.id)), big.NewInt(int64(r.id + 10)),
+			})
+			if err != nil {
+				t.Fatalf("Compute failed: %s", err)
+			}
+			if len(r.result) != 1 || r.result[0].Cmp(expected[0]) != 0 {
+				t.Errorf("session %d: got %v, expected %v", r.id, r.result,
+					expected)
+			}
+		}
+	}
+}
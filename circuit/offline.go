@@ -0,0 +1,510 @@
+//
+// offline.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+const (
+	// OfflineMagic is a magic number for the offline garbled circuit
+	// format.
+	OfflineMagic = 0x71636f67 // qcog
+
+	// OfflineVersion specifies the offline garbled circuit format
+	// version.
+	OfflineVersion = 1
+)
+
+// Offline garbled circuit parts.
+const (
+	// OfflineGarbler is the garbler's part of the offline garbled
+	// circuit: R and the input and output wire labels.
+	OfflineGarbler = 1 << iota
+
+	// OfflineEvaluator is the evaluator's part of the offline garbled
+	// circuit: the garbled tables.
+	OfflineEvaluator
+
+	// OfflineAll contains all parts of the offline garbled circuit.
+	OfflineAll = OfflineGarbler | OfflineEvaluator
+)
+
+var (
+	// ErrConsumed is returned when an offline garbled circuit is
+	// used more than once.
+	ErrConsumed = errors.New("offline garbled circuit already consumed")
+)
+
+// OfflineID identifies an offline garbled circuit.
+type OfflineID [16]byte
+
+func (id OfflineID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Offline contains a circuit that is garbled ahead of the online
+// protocol run. The garbler and evaluator parts are stored
+// separately: the garbler keeps its part secret and ships the
+// evaluator part to the evaluator before the online phase. An offline
+// garbled circuit must be used only once.
+type Offline struct {
+	ID          OfflineID
+	Fingerprint [32]byte
+	Parts       int
+	Key         []byte
+	R           ot.Label
+	Inputs      []ot.Wire
+	Outputs     []ot.Wire
+	Gates       [][]ot.Label
+	consumed    bool
+}
+
+// Fingerprint computes the SHA-256 fingerprint of the circuit.
+func (c *Circuit) Fingerprint() ([32]byte, error) {
+	var result [32]byte
+
+	h := sha256.New()
+	w := bufio.NewWriter(h)
+	if err := c.Marshal(w); err != nil {
+		return result, err
+	}
+	if err := w.Flush(); err != nil {
+		return result, err
+	}
+	copy(result[:], h.Sum(nil))
+
+	return result, nil
+}
+
+// GarbleOffline garbles the circuit for an offline protocol run.
+func (c *Circuit) GarbleOffline() (*Offline, error) {
+	offline := &Offline{
+		Parts: OfflineAll,
+		Key:   make([]byte, 32),
+	}
+	if _, err := rand.Read(offline.ID[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(offline.Key); err != nil {
+		return nil, err
+	}
+	fp, err := c.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	offline.Fingerprint = fp
+
+	var garbled *Garbled
+	if c.Parallel() {
+		garbled, err = c.GarbleParallel(offline.Key, 0)
+	} else {
+		garbled, err = c.Garble(offline.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	offline.R = garbled.R
+	offline.Inputs = garbled.Wires[:c.Inputs.Size()]
+	offline.Outputs = garbled.Wires[c.NumWires-c.Outputs.Size():]
+	offline.Gates = garbled.Gates
+
+	return offline, nil
+}
+
+// Verify verifies that the offline garbled circuit contains the parts
+// and that it is garbled from the circuit.
+func (o *Offline) Verify(c *Circuit, parts int) error {
+	if o.consumed {
+		return ErrConsumed
+	}
+	if o.Parts&parts != parts {
+		return fmt.Errorf("offline garbled circuit parts %x, expected %x",
+			o.Parts, parts)
+	}
+	fp, err := c.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(fp[:], o.Fingerprint[:]) {
+		return fmt.Errorf("offline garbled circuit is not garbled from %s", c)
+	}
+	if parts&OfflineGarbler != 0 {
+		if len(o.Inputs) != c.Inputs.Size() ||
+			len(o.Outputs) != c.Outputs.Size() {
+			return fmt.Errorf("offline garbled circuit I/O mismatch")
+		}
+	}
+	if parts&OfflineEvaluator != 0 && len(o.Gates) != c.NumGates {
+		return fmt.Errorf("offline garbled circuit has %d gates, expected %d",
+			len(o.Gates), c.NumGates)
+	}
+	return nil
+}
+
+func (o *Offline) consume(tracker Tracker) error {
+	if o.consumed {
+		return ErrConsumed
+	}
+	if err := tracker.Consume(o.ID); err != nil {
+		return err
+	}
+	o.consumed = true
+	return nil
+}
+
+// Marshal marshals the argument parts of the offline garbled circuit.
+func (o *Offline) Marshal(out io.Writer, parts int) error {
+	if o.Parts&parts != parts {
+		return fmt.Errorf("offline garbled circuit parts %x, expected %x",
+			o.Parts, parts)
+	}
+	w := bufio.NewWriter(out)
+
+	var data = []interface{}{
+		uint32(OfflineMagic),
+		uint32(OfflineVersion),
+		uint32(parts),
+		o.ID,
+		o.Fingerprint,
+		uint32(len(o.Key)),
+		o.Key,
+	}
+	for _, v := range data {
+		if err := binary.Write(w, bo, v); err != nil {
+			return err
+		}
+	}
+
+	var labelData ot.LabelData
+
+	if parts&OfflineGarbler != 0 {
+		if _, err := w.Write(o.R.Bytes(&labelData)); err != nil {
+			return err
+		}
+		// The L1 labels are L0 xor R so it is enough to store L0.
+		for _, wires := range [][]ot.Wire{o.Inputs, o.Outputs} {
+			if err := binary.Write(w, bo, uint32(len(wires))); err != nil {
+				return err
+			}
+			for _, wire := range wires {
+				if _, err := w.Write(wire.L0.Bytes(&labelData)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if parts&OfflineEvaluator != 0 {
+		if err := binary.Write(w, bo, uint32(len(o.Gates))); err != nil {
+			return err
+		}
+		for _, row := range o.Gates {
+			if err := w.WriteByte(byte(len(row))); err != nil {
+				return err
+			}
+			for _, label := range row {
+				if _, err := w.Write(label.Bytes(&labelData)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// UnmarshalOffline unmarshals an offline garbled circuit of the
+// circuit c. The wire and gate counts are validated against the
+// circuit before they are allocated.
+func UnmarshalOffline(in io.Reader, c *Circuit) (*Offline, error) {
+	r := bufio.NewReader(in)
+
+	var header struct {
+		Magic       uint32
+		Version     uint32
+		Parts       uint32
+		ID          OfflineID
+		Fingerprint [32]byte
+		KeyLen      uint32
+	}
+	if err := binary.Read(r, bo, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != OfflineMagic {
+		return nil, fmt.Errorf("invalid offline garbled circuit magic %x",
+			header.Magic)
+	}
+	if header.Version != OfflineVersion {
+		return nil, fmt.Errorf("unsupported offline garbled circuit version %d",
+			header.Version)
+	}
+	if header.Parts&^OfflineAll != 0 {
+		return nil, fmt.Errorf("invalid offline garbled circuit parts %x",
+			header.Parts)
+	}
+	if header.KeyLen > 32 {
+		return nil, fmt.Errorf("invalid offline garbled circuit key length %d",
+			header.KeyLen)
+	}
+	fp, err := c.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fp[:], header.Fingerprint[:]) {
+		return nil, fmt.Errorf("offline garbled circuit is not garbled from %s",
+			c)
+	}
+	o := &Offline{
+		ID:          header.ID,
+		Fingerprint: header.Fingerprint,
+		Parts:       int(header.Parts),
+		Key:         make([]byte, header.KeyLen),
+	}
+	if _, err := io.ReadFull(r, o.Key); err != nil {
+		return nil, err
+	}
+
+	var labelData ot.LabelData
+
+	readLabel := func(label *ot.Label) error {
+		if _, err := io.ReadFull(r, labelData[:]); err != nil {
+			return err
+		}
+		label.SetData(&labelData)
+		return nil
+	}
+
+	if o.Parts&OfflineGarbler != 0 {
+		if err := readLabel(&o.R); err != nil {
+			return nil, err
+		}
+		sizes := []int{c.Inputs.Size(), c.Outputs.Size()}
+		for idx, wires := range []*[]ot.Wire{&o.Inputs, &o.Outputs} {
+			var count uint32
+			if err := binary.Read(r, bo, &count); err != nil {
+				return nil, err
+			}
+			if int(count) != sizes[idx] {
+				return nil, fmt.Errorf("offline garbled circuit I/O mismatch")
+			}
+			*wires = make([]ot.Wire, 0, count)
+			for i := 0; i < int(count); i++ {
+				var wire ot.Wire
+				if err := readLabel(&wire.L0); err != nil {
+					return nil, err
+				}
+				wire.L1 = wire.L0
+				wire.L1.Xor(o.R)
+				*wires = append(*wires, wire)
+			}
+		}
+	}
+	if o.Parts&OfflineEvaluator != 0 {
+		var count uint32
+		if err := binary.Read(r, bo, &count); err != nil {
+			return nil, err
+		}
+		if int(count) != c.NumGates {
+			return nil, fmt.Errorf(
+				"offline garbled circuit has %d gates, expected %d",
+				count, c.NumGates)
+		}
+		o.Gates = make([][]ot.Label, count)
+		for i := 0; i < int(count); i++ {
+			n, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if n > 4 {
+				return nil, fmt.Errorf(
+					"corrupted garbled table: gate %d: %d rows", i, n)
+			}
+			row := make([]ot.Label, n)
+			for j := 0; j < int(n); j++ {
+				if err := readLabel(&row[j]); err != nil {
+					return nil, err
+				}
+			}
+			o.Gates[i] = row
+		}
+	}
+
+	return o, nil
+}
+
+// LoadOffline loads an offline garbled circuit of the circuit c from
+// the file.
+func LoadOffline(file string, c *Circuit) (*Offline, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return UnmarshalOffline(f, c)
+}
+
+// Tracker tracks the consumption of offline garbled circuits. Reusing
+// an offline garbled circuit would leak the garbler's wire labels so
+// each circuit must be consumed only once.
+type Tracker interface {
+	// Consume marks the offline garbled circuit consumed. The
+	// function returns ErrConsumed if the circuit has already been
+	// consumed.
+	Consume(id OfflineID) error
+}
+
+// MemoryTracker implements an in-memory consumption tracker.
+type MemoryTracker struct {
+	m    sync.Mutex
+	used map[OfflineID]bool
+}
+
+// NewMemoryTracker creates a new in-memory consumption tracker.
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{
+		used: make(map[OfflineID]bool),
+	}
+}
+
+// Consume implements Tracker.Consume.
+func (t *MemoryTracker) Consume(id OfflineID) error {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.used[id] {
+		return ErrConsumed
+	}
+	t.used[id] = true
+	return nil
+}
+
+// DirTracker implements a persistent consumption tracker. It records
+// consumed circuits as marker files in a directory. The markers are
+// created exclusively so the tracker works also between processes
+// sharing the directory.
+type DirTracker struct {
+	dir string
+}
+
+// NewDirTracker creates a new directory consumption tracker.
+func NewDirTracker(dir string) (*DirTracker, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DirTracker{
+		dir: dir,
+	}, nil
+}
+
+// Consume implements Tracker.Consume.
+func (t *DirTracker) Consume(id OfflineID) error {
+	f, err := os.OpenFile(filepath.Join(t.dir, id.String()),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrConsumed
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// GarblerOffline runs the garbler with the offline garbled circuit on
+// the P2P network. The offline circuit must contain the garbler part
+// and it is consumed from the tracker before the online phase starts.
+func GarblerOffline(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit, offline *Offline, tracker Tracker, inputs *big.Int,
+	verbose bool) ([]*big.Int, error) {
+
+	timing := NewTiming()
+
+	if err := offline.Verify(circ, OfflineGarbler); err != nil {
+		return nil, err
+	}
+	if err := offline.consume(tracker); err != nil {
+		return nil, err
+	}
+	err := NewHandshake(oti, offline.Fingerprint[:]).Run(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		fmt.Printf(" - Using offline garbled circuit %s...\n", offline.ID)
+	}
+	if err := conn.SendData(offline.ID[:]); err != nil {
+		return nil, err
+	}
+
+	result, err := garblerOnline(ctx, conn, oti, circ, offline.Inputs,
+		offline.Outputs, inputs, nil, timing, verbose)
+
+	// Forget the wire labels.
+	offline.Inputs = nil
+	offline.Outputs = nil
+
+	return result, err
+}
+
+// EvaluatorOffline runs the evaluator with the offline garbled
+// circuit on the P2P network. The offline circuit must contain the
+// evaluator part and it is consumed from the tracker before the
+// online phase starts.
+func EvaluatorOffline(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	circ *Circuit, offline *Offline, tracker Tracker, inputs *big.Int,
+	verbose bool) ([]*big.Int, error) {
+
+	timing := NewTiming()
+
+	if err := offline.Verify(circ, OfflineEvaluator); err != nil {
+		return nil, err
+	}
+	err := NewHandshake(oti, offline.Fingerprint[:]).Run(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		fmt.Printf(" - Waiting for offline circuit ID...\n")
+	}
+	var id []byte
+	err = connPhase(ctx, conn, PhaseTables, func() (err error) {
+		id, err = conn.ReceiveData()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(id, offline.ID[:]) {
+		return nil, Abort(conn, fmt.Errorf("%w: offline garbled circuit "+
+			"mismatch: got %x, expected %s", ErrProtocolMismatch, id,
+			offline.ID))
+	}
+	if err := offline.consume(tracker); err != nil {
+		return nil, Abort(conn, err)
+	}
+	timing.Sample("Wait", nil)
+
+	return evaluatorOnline(ctx, conn, oti, circ, offline.Key, offline.Gates,
+		inputs, timing, verbose)
+}
@@ -0,0 +1,191 @@
+//
+// offline_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+type protocolResult struct {
+	result []*big.Int
+	err    error
+}
+
+func runOffline(t *testing.T, circ *Circuit, g, e *Offline,
+	gTracker, eTracker Tracker, gInput, eInput *big.Int) (
+	[]*big.Int, []*big.Int, error, error) {
+
+	gc, ec := net.Pipe()
+	done := make(chan protocolResult)
+
+	go func() {
+		conn := p2p.NewConn(ec)
+		result, err := EvaluatorOffline(context.Background(), conn, ot.NewCO(),
+			circ, e, eTracker, eInput, false)
+		conn.Close()
+		done <- protocolResult{
+			result: result,
+			err:    err,
+		}
+	}()
+
+	conn := p2p.NewConn(gc)
+	gResult, gErr := GarblerOffline(context.Background(), conn, ot.NewCO(),
+		circ, g, gTracker, gInput, false)
+	conn.Close()
+
+	eResult := <-done
+	return gResult, eResult.result, gErr, eResult.err
+}
+
+func TestOffline(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16}, 512, 8)
+
+	offline, err := circ.GarbleOffline()
+	if err != nil {
+		t.Fatalf("GarbleOffline failed: %s", err)
+	}
+
+	var gBuf, eBuf bytes.Buffer
+	if err := offline.Marshal(&gBuf, OfflineGarbler); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	if err := offline.Marshal(&eBuf, OfflineEvaluator); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	g, err := UnmarshalOffline(&gBuf, circ)
+	if err != nil {
+		t.Fatalf("UnmarshalOffline failed: %s", err)
+	}
+	e, err := UnmarshalOffline(&eBuf, circ)
+	if err != nil {
+		t.Fatalf("UnmarshalOffline failed: %s", err)
+	}
+	if err := e.Verify(circ, OfflineGarbler); err == nil {
+		t.Errorf("evaluator part contains garbler part")
+	}
+
+	gInput := big.NewInt(0x1234)
+	eInput := big.NewInt(0xabcd)
+
+	expected, err := circ.Compute([]*big.Int{gInput, eInput})
+	if err != nil {
+		t.Fatalf("Compute failed: %s", err)
+	}
+
+	gTracker := NewMemoryTracker()
+	eTracker := NewMemoryTracker()
+
+	gResult, eResult, gErr, eErr := runOffline(t, circ, g, e,
+		gTracker, eTracker, gInput, eInput)
+	if gErr != nil || eErr != nil {
+		t.Fatalf("offline protocol failed: garbler=%v, evaluator=%v",
+			gErr, eErr)
+	}
+	for idx, result := range [][]*big.Int{gResult, eResult} {
+		if result[0].Cmp(expected[0]) != 0 {
+			t.Errorf("party %d: got %v, expected %v", idx, result[0],
+				expected[0])
+		}
+	}
+
+	// Reloaded offline circuit must not be accepted by the tracker.
+	gBuf.Reset()
+	if err := offline.Marshal(&gBuf, OfflineGarbler); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	g, err = UnmarshalOffline(&gBuf, circ)
+	if err != nil {
+		t.Fatalf("UnmarshalOffline failed: %s", err)
+	}
+	_, _, gErr, _ = runOffline(t, circ, g, e, gTracker, eTracker,
+		gInput, eInput)
+	if gErr != ErrConsumed {
+		t.Errorf("offline circuit reused: %v", gErr)
+	}
+
+	// Evaluator must abort the garbler when its part is reused.
+	gBuf.Reset()
+	if err := offline.Marshal(&gBuf, OfflineGarbler); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	g, err = UnmarshalOffline(&gBuf, circ)
+	if err != nil {
+		t.Fatalf("UnmarshalOffline failed: %s", err)
+	}
+	eBuf.Reset()
+	if err := offline.Marshal(&eBuf, OfflineEvaluator); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	e, err = UnmarshalOffline(&eBuf, circ)
+	if err != nil {
+		t.Fatalf("UnmarshalOffline failed: %s", err)
+	}
+	_, _, gErr, eErr = runOffline(t, circ, g, e, NewMemoryTracker(),
+		eTracker, gInput, eInput)
+	if eErr != ErrConsumed {
+		t.Errorf("evaluator offline circuit reused: %v", eErr)
+	}
+	if !errors.Is(gErr, ErrPeerAbort) {
+		t.Errorf("garbler not aborted: %v", gErr)
+	}
+}
+
+func TestOfflineUnmarshal(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16}, 512, 8)
+
+	offline, err := circ.GarbleOffline()
+	if err != nil {
+		t.Fatalf("GarbleOffline failed: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := offline.Marshal(&buf, OfflineEvaluator); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	data := buf.Bytes()
+
+	other := newRandomCircuit([]int{16, 16}, 256, 8)
+	if _, err := UnmarshalOffline(bytes.NewReader(data), other); err == nil {
+		t.Errorf("offline circuit accepted for another circuit")
+	}
+
+	// Corrupt the gate count that follows the header and the key.
+	corrupted := append([]byte(nil), data...)
+	offset := 4 + 4 + 4 + len(offline.ID) + len(offline.Fingerprint) + 4 +
+		len(offline.Key)
+	copy(corrupted[offset:], []byte{0xff, 0xff, 0xff, 0xff})
+	_, err = UnmarshalOffline(bytes.NewReader(corrupted), circ)
+	if err == nil {
+		t.Errorf("corrupted gate count accepted")
+	}
+}
+
+func TestDirTracker(t *testing.T) {
+	tracker, err := NewDirTracker(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirTracker failed: %s", err)
+	}
+	var id OfflineID
+	id[0] = 42
+
+	if err := tracker.Consume(id); err != nil {
+		t.Fatalf("Consume failed: %s", err)
+	}
+	if err := tracker.Consume(id); err != ErrConsumed {
+		t.Errorf("Consume succeeded twice: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+//
+// parallel.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"runtime"
+	"sync"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+)
+
+const (
+	// ParallelMinWidth specifies the minimum number of gates a level
+	// must have before its gates are processed in parallel. Narrower
+	// levels are processed sequentially since the synchronization
+	// overhead would exceed the gain.
+	ParallelMinWidth = 256
+)
+
+// Parallel tests if the circuit benefits from level-parallel
+// garbling and evaluation. The circuit levels must have been assigned
+// with AssignLevels.
+func (c *Circuit) Parallel() bool {
+	return runtime.GOMAXPROCS(0) > 1 &&
+		c.Stats[MaxWidth] >= ParallelMinWidth
+}
+
+// Schedule defines the level-parallel processing order of the
+// circuit gates.
+type Schedule struct {
+	// Levels hold the gate indices by gate level.
+	Levels [][]int
+	// Tweaks hold the garbling tweak IDs for gates. The IDs are
+	// allocated in the gate order so they match the sequential
+	// garbling and evaluation.
+	Tweaks []uint32
+}
+
+// Schedule creates the level-parallel processing schedule for the
+// circuit. The function assigns circuit levels if they are not
+// already assigned.
+func (c *Circuit) Schedule() *Schedule {
+	if c.Stats[NumLevels] == 0 && len(c.Gates) > 0 {
+		c.AssignLevels()
+	}
+	sched := &Schedule{
+		Levels: make([][]int, c.Stats[NumLevels]),
+		Tweaks: make([]uint32, len(c.Gates)),
+	}
+
+	var id uint32
+	for idx, gate := range c.Gates {
+		sched.Levels[gate.Level] = append(sched.Levels[gate.Level], idx)
+		sched.Tweaks[idx] = id
+
+		switch gate.Op {
+		case AND:
+			id += 2
+		case OR, INV:
+			id++
+		}
+	}
+	return sched
+}
+
+// GarbleParallel garbles the circuit with level-parallel
+// workers. Gates of the same level are garbled concurrently by
+// workers goroutines. If workers is 0, the function uses GOMAXPROCS
+// workers. The garbled tables are identical to the ones Garble
+// creates for the same R and input wire labels.
+func (c *Circuit) GarbleParallel(key []byte, workers int) (*Garbled, error) {
+	garbled, err := c.newGarbled()
+	if err != nil {
+		return nil, err
+	}
+	err = c.garbleParallel(key, workers, garbled)
+	if err != nil {
+		return nil, err
+	}
+	return garbled, nil
+}
+
+func (c *Circuit) garbleParallel(key []byte, workers int,
+	garbled *Garbled) error {
+
+	return c.parallel(key, workers,
+		func(alg cipher.Block, g int, id uint32, data *ot.LabelData) error {
+			table, err := c.Gates[g].garble(garbled.Wires, alg, garbled.R,
+				&id, data)
+			if err != nil {
+				return err
+			}
+			garbled.Gates[g] = table
+			return nil
+		})
+}
+
+// EvalParallel evaluates the circuit with level-parallel
+// workers. Gates of the same level are evaluated concurrently by
+// workers goroutines. If workers is 0, the function uses GOMAXPROCS
+// workers.
+func (c *Circuit) EvalParallel(key []byte, wires []ot.Label,
+	garbled [][]ot.Label, workers int) error {
+
+	return c.parallel(key, workers,
+		func(alg cipher.Block, g int, id uint32, data *ot.LabelData) error {
+			return c.Gates[g].eval(wires, alg, garbled[g], &id, data)
+		})
+}
+
+type gateFunc func(alg cipher.Block, g int, id uint32,
+	data *ot.LabelData) error
+
+// parallel calls the function f for all gates of the circuit, level
+// by level. The gates of each level are split evenly between workers.
+func (c *Circuit) parallel(key []byte, workers int, f gateFunc) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	sched := c.Schedule()
+
+	// Each worker has its own cipher instance.
+	algs := make([]cipher.Block, workers)
+	for i := 0; i < workers; i++ {
+		alg, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		algs[i] = alg
+	}
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	var data ot.LabelData
+
+	for _, level := range sched.Levels {
+		if workers == 1 || len(level) < ParallelMinWidth {
+			for _, g := range level {
+				err := f(algs[0], g, sched.Tweaks[g], &data)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		chunk := (len(level) + workers - 1) / workers
+		for w := 0; w < workers; w++ {
+			start := w * chunk
+			if start >= len(level) {
+				break
+			}
+			end := start + chunk
+			if end > len(level) {
+				end = len(level)
+			}
+			wg.Add(1)
+			go func(w int, gates []int) {
+				defer wg.Done()
+
+				var data ot.LabelData
+				for _, g := range gates {
+					err := f(algs[w], g, sched.Tweaks[g], &data)
+					if err != nil {
+						errs[w] = err
+						return
+					}
+				}
+			}(w, level[start:end])
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+//
+// parallel_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/types"
+)
+
+func newRandomCircuit(inputs []int, numGates, numOutputs int) *Circuit {
+	rnd := mathrand.New(mathrand.NewSource(42))
+	ops := []Operation{XOR, XNOR, AND, OR, INV}
+
+	var numInputs int
+	var args IO
+	for idx, bits := range inputs {
+		args = append(args, IOArg{
+			Name: fmt.Sprintf("i%d", idx),
+			Type: types.Info{
+				Type:       types.TUint,
+				IsConcrete: true,
+				Bits:       types.Size(bits),
+			},
+		})
+		numInputs += bits
+	}
+
+	circ := &Circuit{
+		NumGates: numGates,
+		NumWires: numInputs + numGates,
+		Inputs:   args,
+		Outputs: IO{
+			{
+				Name: "o0",
+				Type: types.Info{
+					Type:       types.TUint,
+					IsConcrete: true,
+					Bits:       types.Size(numOutputs),
+				},
+			},
+		},
+	}
+	for i := 0; i < numGates; i++ {
+		out := numInputs + i
+		op := ops[rnd.Intn(len(ops))]
+		circ.Gates = append(circ.Gates, Gate{
+			Input0: Wire(rnd.Intn(out)),
+			Input1: Wire(rnd.Intn(out)),
+			Output: Wire(out),
+			Op:     op,
+		})
+		circ.Stats[op]++
+	}
+	circ.AssignLevels()
+
+	return circ
+}
+
+func TestGarbleParallel(t *testing.T) {
+	circ := newRandomCircuit([]int{1024}, 8192, 64)
+	if circ.Stats[MaxWidth] < ParallelMinWidth {
+		t.Fatalf("circuit too narrow: %v", circ.Stats)
+	}
+	var key [32]byte
+
+	seq, err := circ.Garble(key[:])
+	if err != nil {
+		t.Fatalf("Garble failed: %s", err)
+	}
+
+	par := &Garbled{
+		R:     seq.R,
+		Wires: make([]ot.Wire, circ.NumWires),
+		Gates: make([][]ot.Label, circ.NumGates),
+	}
+	copy(par.Wires, seq.Wires[:circ.Inputs.Size()])
+
+	err = circ.garbleParallel(key[:], 4, par)
+	if err != nil {
+		t.Fatalf("GarbleParallel failed: %s", err)
+	}
+	for g := 0; g < circ.NumGates; g++ {
+		if len(seq.Gates[g]) != len(par.Gates[g]) {
+			t.Fatalf("gate %d: table length mismatch: %d != %d",
+				g, len(seq.Gates[g]), len(par.Gates[g]))
+		}
+		for i := range seq.Gates[g] {
+			if !seq.Gates[g][i].Equal(par.Gates[g][i]) {
+				t.Fatalf("gate %d: table %d mismatch", g, i)
+			}
+		}
+	}
+	for w := 0; w < circ.NumWires; w++ {
+		if !seq.Wires[w].L0.Equal(par.Wires[w].L0) ||
+			!seq.Wires[w].L1.Equal(par.Wires[w].L1) {
+			t.Fatalf("wire %d mismatch", w)
+		}
+	}
+}
+
+func TestEvalParallel(t *testing.T) {
+	circ := newRandomCircuit([]int{1024}, 8192, 64)
+	var key [32]byte
+
+	garbled, err := circ.GarbleParallel(key[:], 4)
+	if err != nil {
+		t.Fatalf("GarbleParallel failed: %s", err)
+	}
+
+	rnd := mathrand.New(mathrand.NewSource(7))
+	input := new(big.Int)
+	seq := make([]ot.Label, circ.NumWires)
+	par := make([]ot.Label, circ.NumWires)
+
+	for i := 0; i < circ.Inputs.Size(); i++ {
+		if rnd.Intn(2) == 1 {
+			input.SetBit(input, i, 1)
+			seq[i] = garbled.Wires[i].L1
+		} else {
+			seq[i] = garbled.Wires[i].L0
+		}
+		par[i] = seq[i]
+	}
+
+	if err := circ.Eval(key[:], seq, garbled.Gates); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if err := circ.EvalParallel(key[:], par, garbled.Gates, 4); err != nil {
+		t.Fatalf("EvalParallel failed: %s", err)
+	}
+
+	expected, err := circ.Compute([]*big.Int{input})
+	if err != nil {
+		t.Fatalf("Compute failed: %s", err)
+	}
+
+	result := new(big.Int)
+	for i := 0; i < circ.Outputs.Size(); i++ {
+		w := circ.NumWires - circ.Outputs.Size() + i
+		if !seq[w].Equal(par[w]) {
+			t.Fatalf("output %d mismatch", i)
+		}
+		if par[w].Equal(garbled.Wires[w].L1) {
+			result.SetBit(result, i, 1)
+		} else if !par[w].Equal(garbled.Wires[w].L0) {
+			t.Fatalf("unknown label for output %d", i)
+		}
+	}
+	if result.Cmp(expected[0]) != 0 {
+		t.Errorf("result mismatch: got %x, expected %x", result, expected[0])
+	}
+}
+
+func BenchmarkGarbleSequential(b *testing.B) {
+	circ := newRandomCircuit([]int{1024}, 65536, 64)
+	var key [32]byte
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := circ.Garble(key[:])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGarbleParallel(b *testing.B) {
+	circ := newRandomCircuit([]int{1024}, 65536, 64)
+	var key [32]byte
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := circ.GarbleParallel(key[:], 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -81,13 +81,21 @@ func ParseQCLC(in io.Reader) (*Circuit, error) {
 	if err := binary.Read(r, bo, &header); err != nil {
 		return nil, err
 	}
+	var version int
+	switch header.Magic {
+	case MAGIC:
+	case MAGIC1:
+		version = 1
+	default:
+		return nil, fmt.Errorf("invalid circuit magic %x", header.Magic)
+	}
 	var inputs, outputs IO
 	var inputWires, outputWires int
 
 	wiresSeen := make(Seen, header.NumWires)
 
 	for i := 0; i < int(header.NumInputs); i++ {
-		arg, err := parseIOArg(r)
+		arg, err := parseIOArg(r, version)
 		if err != nil {
 			return nil, err
 		}
@@ -95,7 +103,7 @@ func ParseQCLC(in io.Reader) (*Circuit, error) {
 		inputWires += int(arg.Type.Bits)
 	}
 	for i := 0; i < int(header.NumOutputs); i++ {
-		out, err := parseIOArg(r)
+		out, err := parseIOArg(r, version)
 		if err != nil {
 			return nil, err
 		}
@@ -210,7 +218,7 @@ func ParseQCLC(in io.Reader) (*Circuit, error) {
 	}, nil
 }
 
-func parseIOArg(r *bufio.Reader) (arg IOArg, err error) {
+func parseIOArg(r *bufio.Reader, version int) (arg IOArg, err error) {
 	name, err := parseString(r)
 	if err != nil {
 		return arg, err
@@ -230,12 +238,22 @@ func parseIOArg(r *bufio.Reader) (arg IOArg, err error) {
 	}
 	arg.Type.Bits = types.Size(ui32)
 
+	if version >= 1 {
+		if err := binary.Read(r, bo, &ui32); err != nil {
+			return arg, err
+		}
+		arg.Recipient = Recipient(ui32)
+		if _, ok := recipients[arg.Recipient]; !ok {
+			return arg, fmt.Errorf("invalid output recipient %d", ui32)
+		}
+	}
+
 	// Compound
 	if err := binary.Read(r, bo, &ui32); err != nil {
 		return arg, err
 	}
 	for i := 0; i < int(ui32); i++ {
-		c, err := parseIOArg(r)
+		c, err := parseIOArg(r, version)
 		if err != nil {
 			return arg, err
 		}
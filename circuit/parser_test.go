@@ -26,3 +26,37 @@ func TestParse(t *testing.T) {
 		t.Fatalf("Parse failed: %s", err)
 	}
 }
+
+func TestParseQCLCRecipients(t *testing.T) {
+	circ, err := ParseBristol(bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	circ.Outputs[0].Recipient = RecipientEvaluator
+
+	var buf bytes.Buffer
+	if err := circ.Marshal(&buf); err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	parsed, err := ParseQCLC(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ParseQCLC failed: %s", err)
+	}
+	if parsed.Outputs[0].Recipient != RecipientEvaluator {
+		t.Errorf("got recipient %s, expected %s",
+			parsed.Outputs[0].Recipient, RecipientEvaluator)
+	}
+
+	fp, err := circ.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint failed: %s", err)
+	}
+	circ.Outputs[0].Recipient = RecipientBoth
+	fpBoth, err := circ.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint failed: %s", err)
+	}
+	if fp == fpBoth {
+		t.Errorf("fingerprint does not cover the output recipients")
+	}
+}
@@ -9,6 +9,7 @@
 package circuit
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"math/big"
@@ -17,9 +18,11 @@ import (
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
 )
 
-// Player runs the BMR protocol client on the P2P network.
-func Player(nw *p2p.Network, circ *Circuit, inputs *big.Int, verbose bool) (
-	[]*big.Int, error) {
+// Player runs the BMR protocol client on the P2P network. The
+// protocol run is aborted when the context is done; see WithTimeouts
+// for per-phase time limits.
+func Player(ctx context.Context, nw *p2p.Network, circ *Circuit,
+	inputs *big.Int, verbose bool) ([]*big.Int, error) {
 
 	numPlayers := len(nw.Peers) + 1
 	player := nw.ID
@@ -65,53 +68,81 @@ func Player(nw *p2p.Network, circ *Circuit, inputs *big.Int, verbose bool) (
 
 	// OTs with peers.
 
-	lambdaResults := make(chan OTLambdaResult)
-
-	for peerID, peer := range nw.Peers {
-		go func(peerID int, peer *p2p.Peer) {
-			x1, result, err := func(peer *p2p.Peer) (
-				*big.Int, *big.Int, error) {
+	luv := new(big.Int)
 
-				// Random X1
-				buf := make([]byte, len(circ.Gates)/8+1)
-				_, err := rand.Read(buf[:])
-				if err != nil {
-					return nil, nil, err
+	err = runPhase(ctx, PhaseOT, nw.WithContext, func() error {
+		if dealt := nw.Dealt(); dealt != nil {
+			// Compute lu AND lv with the dealt AND triples.
+			var gates []int
+			var x, y []byte
+			for g, gate := range circ.Gates {
+				if gate.Op == AND || gate.Op == OR {
+					gates = append(gates, g)
+					x = append(x, byte(lu.Bit(g)))
+					y = append(y, byte(lv.Bit(g)))
 				}
-				x1 := new(big.Int).SetBytes(buf)
-				shift := len(buf)*8 - len(circ.Gates)
-				x1.Rsh(x1, uint(shift))
-
-				// X2.
-				x2 := new(big.Int).Xor(lu, x1)
+			}
+			prod, err := dealtProducts(nw, dealt, x, y)
+			if err != nil {
+				return err
+			}
+			for i, g := range gates {
+				luv.SetBit(luv, g, uint(prod[i]))
+			}
+			return nil
+		}
 
-				result, err := peer.OTLambda(len(circ.Gates), lv, x1, x2)
-				if err != nil {
-					return nil, nil, err
+		lambdaResults := make(chan OTLambdaResult, len(nw.Peers))
+
+		for peerID, peer := range nw.Peers {
+			go func(peerID int, peer *p2p.Peer) {
+				x1, result, err := func(peer *p2p.Peer) (
+					*big.Int, *big.Int, error) {
+
+					// Random X1
+					buf := make([]byte, len(circ.Gates)/8+1)
+					_, err := rand.Read(buf[:])
+					if err != nil {
+						return nil, nil, err
+					}
+					x1 := new(big.Int).SetBytes(buf)
+					shift := len(buf)*8 - len(circ.Gates)
+					x1.Rsh(x1, uint(shift))
+
+					// X2.
+					x2 := new(big.Int).Xor(lu, x1)
+
+					result, err := peer.OTLambda(len(circ.Gates), lv, x1, x2)
+					if err != nil {
+						return nil, nil, err
+					}
+					return x1, result, nil
+				}(peer)
+				lambdaResults <- OTLambdaResult{
+					peerID: peerID,
+					x1:     x1,
+					result: result,
+					err:    err,
 				}
-				return x1, result, nil
-			}(peer)
-			lambdaResults <- OTLambdaResult{
-				peerID: peerID,
-				x1:     x1,
-				result: result,
-				err:    err,
-			}
-		}(peerID, peer)
-	}
+			}(peerID, peer)
+		}
 
-	// Compute lu AND lv.
-	luv := new(big.Int)
-	luv.And(lu, lv)
+		// Compute lu AND lv.
+		luv.And(lu, lv)
 
-	for i := 0; i < len(nw.Peers); i++ {
-		result := <-lambdaResults
-		if result.err != nil {
-			return nil, fmt.Errorf("OT-Lambda with peer %d failed: %s",
-				result.peerID, result.err)
+		for i := 0; i < len(nw.Peers); i++ {
+			result := <-lambdaResults
+			if result.err != nil {
+				return fmt.Errorf("OT-Lambda with peer %d failed: %s",
+					result.peerID, result.err)
+			}
+			luv.Xor(luv, result.x1)
+			luv.Xor(luv, result.result)
 		}
-		luv.Xor(luv, result.x1)
-		luv.Xor(luv, result.result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	fmt.Printf("Luv: %s\n", luv.Text(2))
@@ -179,8 +210,13 @@ func Player(nw *p2p.Network, circ *Circuit, inputs *big.Int, verbose bool) (
 
 		for g, gate := range circ.Gates {
 			switch gate.Op {
-			case XOR, XNOR:
-			case INV:
+			case XOR, XNOR, INV:
+				// The transfers of these gates are not used. The
+				// messages have the offset R so that all transfers
+				// with the peer are correlated.
+				X2LongAg[peerID][g] = garbled.R
+				X2LongBg[peerID][g] = garbled.R
+				X2LongCg[peerID][g] = garbled.R
 
 			default:
 				rand1, err := ot.NewLabel(rand.Reader)
@@ -229,46 +265,52 @@ func Player(nw *p2p.Network, circ *Circuit, inputs *big.Int, verbose bool) (
 
 	// OTs with peers.
 
-	rResults := make(chan OTRResult)
-
-	for peerID, peer := range nw.Peers {
-		go func(peerID int, peer *p2p.Peer) {
-			ra, rb, rc, err := peer.OTR(Ag, Bg, Cg,
-				X1LongAg[peerID], X2LongAg[peerID],
-				X1LongBg[peerID], X2LongBg[peerID],
-				X1LongCg[peerID], X2LongCg[peerID])
-
-			rResults <- OTRResult{
-				peerID: peerID,
-				Ra:     ra,
-				Rb:     rb,
-				Rc:     rc,
-				err:    err,
-			}
-		}(peerID, peer)
-	}
-
-	for i := 0; i < len(nw.Peers); i++ {
-		result := <-rResults
-		if result.err != nil {
-			return nil, fmt.Errorf("OT-R with peer %d failed: %s",
-				result.peerID, result.err)
+	err = runPhase(ctx, PhaseOT, nw.WithContext, func() error {
+		rResults := make(chan OTRResult, len(nw.Peers))
+
+		for peerID, peer := range nw.Peers {
+			go func(peerID int, peer *p2p.Peer) {
+				ra, rb, rc, err := peer.OTR(Ag, Bg, Cg,
+					X1LongAg[peerID], X2LongAg[peerID],
+					X1LongBg[peerID], X2LongBg[peerID],
+					X1LongCg[peerID], X2LongCg[peerID])
+
+				rResults <- OTRResult{
+					peerID: peerID,
+					Ra:     ra,
+					Rb:     rb,
+					Rc:     rc,
+					err:    err,
+				}
+			}(peerID, peer)
 		}
-		for g, gate := range circ.Gates {
-			switch gate.Op {
-			case XOR, XNOR:
-			case INV:
 
-			default:
-				Gs.Ag[result.peerID][g].Xor(result.Ra[g])
-				Gs.Bg[result.peerID][g].Xor(result.Rb[g])
-				Gs.Cg[result.peerID][g].Xor(result.Rc[g])
+		for i := 0; i < len(nw.Peers); i++ {
+			result := <-rResults
+			if result.err != nil {
+				return fmt.Errorf("OT-R with peer %d failed: %s",
+					result.peerID, result.err)
+			}
+			for g, gate := range circ.Gates {
+				switch gate.Op {
+				case XOR, XNOR:
+				case INV:
 
-				Gs.Dg[result.peerID][g].Xor(result.Ra[g])
-				Gs.Dg[result.peerID][g].Xor(result.Rb[g])
-				Gs.Dg[result.peerID][g].Xor(result.Rc[g])
+				default:
+					Gs.Ag[result.peerID][g].Xor(result.Ra[g])
+					Gs.Bg[result.peerID][g].Xor(result.Rb[g])
+					Gs.Cg[result.peerID][g].Xor(result.Rc[g])
+
+					Gs.Dg[result.peerID][g].Xor(result.Ra[g])
+					Gs.Dg[result.peerID][g].Xor(result.Rb[g])
+					Gs.Dg[result.peerID][g].Xor(result.Rc[g])
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	xfer := nw.Stats().Sum() - ioStats
@@ -289,33 +331,40 @@ func Player(nw *p2p.Network, circ *Circuit, inputs *big.Int, verbose bool) (
 
 	// Exchange gates with peers.
 
-	gResultsC := make(chan GateResults)
-
-	for peerID, peer := range nw.Peers {
-		go func(peerID int, peer *p2p.Peer) {
-			ra, rb, rc, rd, ro, err := peer.ExchangeGates(
-				Gs.Ag, Gs.Bg, Gs.Cg, Gs.Dg, Lo)
-			gResultsC <- GateResults{
-				peerID: peerID,
-				Ra:     ra,
-				Rb:     rb,
-				Rc:     rc,
-				Rd:     rd,
-				Ro:     ro,
-				err:    err,
-			}
-		}(peerID, peer)
-	}
-
 	var gResults []GateResults
-	for i := 0; i < len(nw.Peers); i++ {
-		gResults = append(gResults, <-gResultsC)
+	err = runPhase(ctx, PhaseTables, nw.WithContext, func() error {
+		gResultsC := make(chan GateResults, len(nw.Peers))
+
+		for peerID, peer := range nw.Peers {
+			go func(peerID int, peer *p2p.Peer) {
+				ra, rb, rc, rd, ro, err := peer.ExchangeGates(
+					Gs.Ag, Gs.Bg, Gs.Cg, Gs.Dg, Lo)
+				gResultsC <- GateResults{
+					peerID: peerID,
+					Ra:     ra,
+					Rb:     rb,
+					Rc:     rc,
+					Rd:     rd,
+					Ro:     ro,
+					err:    err,
+				}
+			}(peerID, peer)
+		}
+
+		for i := 0; i < len(nw.Peers); i++ {
+			result := <-gResultsC
+			if result.err != nil {
+				return fmt.Errorf("gate exchange with peer %d failed: %s",
+					result.peerID, result.err)
+			}
+			gResults = append(gResults, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	for _, result := range gResults {
-		if result.err != nil {
-			return nil, fmt.Errorf("gate exchange with peer %d failed: %s",
-				result.peerID, result.err)
-		}
 		for p := 0; p < numPlayers; p++ {
 			for g, gate := range circ.Gates {
 				switch gate.Op {
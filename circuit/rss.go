@@ -0,0 +1,356 @@
+//
+// rss.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// RSS runs the three-party replicated secret sharing protocol of
+// ABY3 on the P2P network. The protocol is secure against one
+// semi-honest party. Each wire value x is split into three XOR
+// shares x0, x1, and x2, and the party i holds the shares x_i and
+// x_{i+1}. The XOR, XNOR, and INV gates are evaluated locally. For
+// the AND and OR gates, each party computes its XOR share of the
+// output from its replicated shares, masks it with a correlated zero
+// share, and sends it to the previous party. The protocol needs no
+// oblivious transfers and sends one bit per AND and OR gate per
+// party. The circuit is evaluated level by level, see AssignLevels,
+// with one communication round for each level. All parties learn all
+// outputs.
+//
+// The protocol run is aborted when the context is done; see
+// WithTimeouts for per-phase time limits. The input sharing runs in
+// the OT phase, the circuit evaluation in the tables phase, and the
+// output reconstruction in the result phase.
+func RSS(ctx context.Context, nw *p2p.Network, circ *Circuit,
+	inputs *big.Int, verbose bool) ([]*big.Int, error) {
+
+	if len(nw.Peers) != 2 || len(circ.Inputs) != 3 {
+		return nil, fmt.Errorf("%w: RSS needs 3 players and a 3-party "+
+			"circuit, got %d players for %d-party circuit",
+			ErrProtocolMismatch, len(nw.Peers)+1, len(circ.Inputs))
+	}
+	if len(circ.Gates) > 0 && circ.Stats[NumLevels] == 0 {
+		return nil, fmt.Errorf("circuit levels not assigned")
+	}
+
+	timing := NewTiming()
+
+	r, err := newRSS(nw, circ)
+	if err != nil {
+		return nil, err
+	}
+
+	// Input sharing and zero share keys.
+	if verbose {
+		fmt.Printf(" - Sharing inputs\n")
+	}
+	err = runPhase(ctx, PhaseOT, nw.WithContext, func() error {
+		if err := r.shareInputs(inputs); err != nil {
+			return err
+		}
+		return r.initZeros()
+	})
+	if err != nil {
+		return nil, err
+	}
+	ioStats := nw.Stats().Sum()
+	timing.Sample("Inputs", []string{FileSize(ioStats).String()})
+
+	// Circuit evaluation.
+	if verbose {
+		fmt.Printf(" - Evaluating %d levels\n", len(r.levels))
+	}
+	var rounds int
+	err = runPhase(ctx, PhaseTables, nw.WithContext, func() error {
+		var err error
+		rounds, err = r.eval()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	xfer := nw.Stats().Sum() - ioStats
+	ioStats = nw.Stats().Sum()
+	timing.Sample("Eval", []string{FileSize(xfer).String(),
+		fmt.Sprintf("%d rounds", rounds)})
+
+	// Output reconstruction.
+	var result *big.Int
+	err = runPhase(ctx, PhaseResult, nw.WithContext, func() error {
+		var err error
+		result, err = r.result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	xfer = nw.Stats().Sum() - ioStats
+	timing.Sample("Result", []string{FileSize(xfer).String()})
+
+	return circ.Outputs.Split(result), nil
+}
+
+// rss holds a party's state of the replicated secret sharing
+// protocol. All bit vectors hold one bit per byte.
+type rss struct {
+	nw     *p2p.Network
+	circ   *Circuit
+	player int
+	next   *p2p.Peer
+	prev   *p2p.Peer
+	// s0 and s1 hold our shares x_i and x_{i+1} of the wire values.
+	s0 []byte
+	s1 []byte
+	// levels holds the gate indices by gate level.
+	levels [][]int
+	// ours and theirs create the correlated zero shares from our key
+	// and from the next party's key.
+	ours   cipher.Stream
+	theirs cipher.Stream
+}
+
+func newRSS(nw *p2p.Network, circ *Circuit) (*rss, error) {
+	r := &rss{
+		nw:     nw,
+		circ:   circ,
+		player: nw.ID,
+		next:   nw.Peers[(nw.ID+1)%3],
+		prev:   nw.Peers[(nw.ID+2)%3],
+		s0:     make([]byte, circ.NumWires),
+		s1:     make([]byte, circ.NumWires),
+		levels: make([][]int, circ.Stats[NumLevels]),
+	}
+	if r.next == nil || r.prev == nil {
+		return nil, fmt.Errorf("invalid RSS player %d", nw.ID)
+	}
+	for idx, gate := range circ.Gates {
+		r.levels[gate.Level] = append(r.levels[gate.Level], idx)
+	}
+	return r, nil
+}
+
+// one returns our shares of the constant 1. The share x0 holds the
+// constant and the other shares are zero.
+func (r *rss) one() (byte, byte) {
+	switch r.player {
+	case 0:
+		return 1, 0
+	case 2:
+		return 0, 1
+	default:
+		return 0, 0
+	}
+}
+
+// shareInputs shares our inputs to the peers and receives our shares
+// of the peers' inputs.
+func (r *rss) shareInputs(inputs *big.Int) error {
+	offsets := make([]int, len(r.circ.Inputs)+1)
+	for idx, arg := range r.circ.Inputs {
+		offsets[idx+1] = offsets[idx] + int(arg.Type.Bits)
+	}
+	count := offsets[r.player+1] - offsets[r.player]
+
+	// Split the inputs into three XOR shares.
+	var shares [3][]byte
+	var err error
+	for i := 0; i < 2; i++ {
+		shares[i], err = randomBits(count)
+		if err != nil {
+			return err
+		}
+	}
+	shares[2] = make([]byte, count)
+	for i := range shares[2] {
+		shares[2][i] = byte(inputs.Bit(i)) ^ shares[0][i] ^ shares[1][i]
+	}
+	copy(r.s0[offsets[r.player]:], shares[r.player])
+	copy(r.s1[offsets[r.player]:], shares[(r.player+1)%3])
+
+	received, err := exchangeAll(r.nw, func(id int) []byte {
+		msg := packBits(shares[id])
+		return append(msg, packBits(shares[(id+1)%3])...)
+	})
+	if err != nil {
+		return err
+	}
+	for id, data := range received {
+		s0 := r.s0[offsets[id]:offsets[id+1]]
+		s1 := r.s1[offsets[id]:offsets[id+1]]
+		n := (len(s0) + 7) / 8
+		if len(data) != 2*n {
+			return fmt.Errorf("%w: input shares from peer %d",
+				ErrProtocolMismatch, id)
+		}
+		if err := unpackBits(data[:n], s0); err != nil {
+			return err
+		}
+		if err := unpackBits(data[n:], s1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initZeros creates the keys of the correlated zero shares. Each
+// party sends its key to the previous party so the party i holds the
+// keys k_i and k_{i+1}. The party's zero share is F(k_i) XOR
+// F(k_{i+1}) and the shares of all parties XOR to zero.
+func (r *rss) initZeros() error {
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	data, err := r.ring(key[:])
+	if err != nil {
+		return err
+	}
+	if len(data) != len(key) {
+		return fmt.Errorf("%w: invalid zero share key",
+			ErrProtocolMismatch)
+	}
+	r.ours, err = newPRG(key[:])
+	if err != nil {
+		return err
+	}
+	r.theirs, err = newPRG(data)
+	return err
+}
+
+func newPRG(key []byte) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, make([]byte, block.BlockSize())), nil
+}
+
+// zeros returns count bits of our correlated zero share.
+func (r *rss) zeros(count int) []byte {
+	buf := make([]byte, (count+7)/8)
+	r.ours.XORKeyStream(buf, buf)
+	r.theirs.XORKeyStream(buf, buf)
+	result := make([]byte, count)
+	unpackBits(buf, result)
+	return result
+}
+
+// ring sends the data to the previous party and receives the next
+// party's data.
+func (r *rss) ring(data []byte) ([]byte, error) {
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sendChunked(r.prev.Conn(), data)
+	}()
+	result, err := receiveChunked(r.next.Conn())
+	if serr := <-sent; serr != nil {
+		return nil, fmt.Errorf("peer %d: %w", (r.player+2)%3, serr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("peer %d: %w", (r.player+1)%3, err)
+	}
+	return result, nil
+}
+
+// eval evaluates the circuit level by level. The function returns
+// the number of communication rounds.
+func (r *rss) eval() (int, error) {
+	var rounds int
+	one0, one1 := r.one()
+
+	for _, level := range r.levels {
+		var ands []int
+		for _, idx := range level {
+			gate := &r.circ.Gates[idx]
+			u := gate.Input0
+			v := gate.Input1
+			w := gate.Output
+			switch gate.Op {
+			case XOR:
+				r.s0[w] = r.s0[u] ^ r.s0[v]
+				r.s1[w] = r.s1[u] ^ r.s1[v]
+			case XNOR:
+				r.s0[w] = r.s0[u] ^ r.s0[v] ^ one0
+				r.s1[w] = r.s1[u] ^ r.s1[v] ^ one1
+			case INV:
+				r.s0[w] = r.s0[u] ^ one0
+				r.s1[w] = r.s1[u] ^ one1
+			case AND, OR:
+				ands = append(ands, idx)
+			default:
+				return rounds, fmt.Errorf("invalid gate %s", gate.Op)
+			}
+		}
+		if len(ands) == 0 {
+			continue
+		}
+		rounds++
+
+		// z_i = x_i y_i XOR x_i y_{i+1} XOR x_{i+1} y_i XOR alpha_i
+		z := r.zeros(len(ands))
+		for i, idx := range ands {
+			gate := &r.circ.Gates[idx]
+			x0 := r.s0[gate.Input0]
+			x1 := r.s1[gate.Input0]
+			y0 := r.s0[gate.Input1]
+			y1 := r.s1[gate.Input1]
+			z[i] ^= x0&y0 ^ x0&y1 ^ x1&y0
+			if gate.Op == OR {
+				z[i] ^= x0 ^ y0
+			}
+		}
+		data, err := r.ring(packBits(z))
+		if err != nil {
+			return rounds, err
+		}
+		theirs := make([]byte, len(z))
+		if err := unpackBits(data, theirs); err != nil {
+			return rounds, err
+		}
+		for i, idx := range ands {
+			w := r.circ.Gates[idx].Output
+			r.s0[w] = z[i]
+			r.s1[w] = theirs[i]
+		}
+	}
+	return rounds, nil
+}
+
+// result reconstructs the circuit outputs. Each party sends its
+// share x_{i+1} to the previous party that misses it.
+func (r *rss) result() (*big.Int, error) {
+	size := r.circ.Outputs.Size()
+	offset := r.circ.NumWires - size
+
+	data, err := r.ring(packBits(r.s1[offset:]))
+	if err != nil {
+		return nil, err
+	}
+	theirs := make([]byte, size)
+	if err := unpackBits(data, theirs); err != nil {
+		return nil, err
+	}
+
+	result := new(big.Int)
+	for i := range theirs {
+		if r.s0[offset+i]^r.s1[offset+i]^theirs[i] != 0 {
+			result.SetBit(result, i, 1)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+//
+// rss_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+func TestRSS(t *testing.T) {
+	circ := newRandomCircuit([]int{16, 16, 16}, 4096, 32)
+
+	rnd := mathrand.New(mathrand.NewSource(3))
+	inputs := make([]*big.Int, 3)
+	for i := range inputs {
+		inputs[i] = big.NewInt(rnd.Int63n(1 << 16))
+	}
+	expected, err := circ.Compute(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks := newTestNetworks(t, 3)
+
+	results := make(chan maliciousResult)
+	for i, nw := range networks {
+		go func(player int, nw *p2p.Network) {
+			result, err := RSS(context.Background(), nw, circ,
+				inputs[player], false)
+			results <- maliciousResult{
+				player: player,
+				result: result,
+				err:    err,
+			}
+		}(i, nw)
+	}
+	for range networks {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("player %d: RSS failed: %s", r.player, r.err)
+		}
+		for i := range expected {
+			if r.result[i].Cmp(expected[i]) != 0 {
+				t.Errorf("player %d: result %d: got %v, expected %v",
+					r.player, i, r.result[i], expected[i])
+			}
+		}
+	}
+
+	// The AND and OR gates send one bit per gate per party. The
+	// framing, input sharing, and output reconstruction add a small
+	// overhead per round.
+	sent := networks[0].Stats().Sent.Load()
+	limit := uint64(numProducts(circ)/8) + 32*(circ.Stats[NumLevels]+8)
+	if sent > limit {
+		t.Errorf("player 0 sent %d bytes, expected at most %d", sent,
+			limit)
+	}
+}
+
+func TestRSSPlayers(t *testing.T) {
+	circ := newRandomCircuit([]int{8, 8}, 64, 8)
+	networks := newTestNetworks(t, 2)
+	_, err := RSS(context.Background(), networks[0], circ, big.NewInt(0),
+		false)
+	if err == nil {
+		t.Errorf("RSS accepted 2 players")
+	}
+}
@@ -0,0 +1,349 @@
+//
+// session.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Reactive multi-round computation. The session runs a sequence of
+// round circuits over the same connection and free-XOR offset R. The
+// round circuit is compiled from a QCL main function with the
+// signature:
+//
+//	func main(g G, e E, state S) (S, ...)
+//
+// where g is the garbler's input, e is the evaluator's input, and
+// state is the secret state from the previous round. The first return
+// value is the next state and the remaining return values are the
+// round outputs which are delivered as specified by their
+// recipients. The state is never decoded: the evaluator keeps the
+// state output labels of round N and uses them directly as the state
+// input labels of round N+1. The state of the first round is zero.
+
+// checkRoundCircuit verifies that the circuit is a valid round
+// circuit.
+func checkRoundCircuit(circ *Circuit) error {
+	if len(circ.Inputs) != 3 {
+		return fmt.Errorf("invalid round circuit: %d inputs, expected 3",
+			len(circ.Inputs))
+	}
+	if len(circ.Outputs) < 1 {
+		return fmt.Errorf("invalid round circuit: no state output")
+	}
+	if circ.Inputs[2].Type.Bits != circ.Outputs[0].Type.Bits {
+		return fmt.Errorf("invalid round circuit: state %s, next state %s",
+			circ.Inputs[2].Type, circ.Outputs[0].Type)
+	}
+	return nil
+}
+
+// GarblerSession implements the garbler side of a reactive
+// multi-round computation.
+type GarblerSession struct {
+	conn    *p2p.Conn
+	oti     ot.OT
+	r       ot.Label
+	state   []ot.Wire
+	round   int
+	verbose bool
+}
+
+// NewGarblerSession creates a new garbler session for the
+// connection.
+func NewGarblerSession(conn *p2p.Conn, oti ot.OT, verbose bool) (
+	*GarblerSession, error) {
+
+	r, err := ot.NewLabel(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	r.SetS(true)
+
+	return &GarblerSession{
+		conn:    conn,
+		oti:     oti,
+		r:       r,
+		verbose: verbose,
+	}, nil
+}
+
+// Round runs the next round of the session with the round circuit
+// and garbler's inputs. The function returns the round outputs
+// without the state. The round is aborted when the context is done.
+func (s *GarblerSession) Round(ctx context.Context, circ *Circuit,
+	inputs *big.Int) ([]*big.Int, error) {
+
+	result, err := s.runRound(ctx, circ, inputs)
+	return result, Abort(s.conn, err)
+}
+
+func (s *GarblerSession) runRound(ctx context.Context, circ *Circuit,
+	inputs *big.Int) ([]*big.Int, error) {
+
+	if err := checkRoundCircuit(circ); err != nil {
+		return nil, err
+	}
+	stateBits := int(circ.Inputs[2].Type.Bits)
+	if s.state != nil && len(s.state) != stateBits {
+		return nil, fmt.Errorf("round %d: state size mismatch: %d != %d",
+			s.round, stateBits, len(s.state))
+	}
+	if err := circuitHandshake(ctx, s.conn, s.oti, circ); err != nil {
+		return nil, err
+	}
+
+	timing := NewTiming()
+	if s.verbose {
+		fmt.Printf(" - Garbling round %d...\n", s.round)
+	}
+
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The input wires of the garbler and evaluator get fresh labels
+	// and the state wires take the labels of the previous round's
+	// state outputs.
+	garbled := &Garbled{
+		R:     s.r,
+		Wires: make([]ot.Wire, circ.NumWires),
+		Gates: make([][]ot.Label, circ.NumGates),
+	}
+	offset := int(circ.Inputs[0].Type.Bits + circ.Inputs[1].Type.Bits)
+	for i := 0; i < offset; i++ {
+		garbled.Wires[i], err = makeLabels(s.r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	first := s.state == nil
+	if first {
+		for i := 0; i < stateBits; i++ {
+			garbled.Wires[offset+i], err = makeLabels(s.r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		copy(garbled.Wires[offset:], s.state)
+	}
+
+	if circ.Parallel() {
+		err = circ.garbleParallel(key[:], 0, garbled)
+	} else {
+		err = circ.garble(key[:], garbled)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timing.Sample("Garble", nil)
+
+	ioStats := s.conn.Stats.Sum()
+
+	// Send round info and garbled tables.
+	err = connPhase(ctx, s.conn, PhaseTables, func() error {
+		if err := s.conn.SendUint32(s.round); err != nil {
+			return err
+		}
+		if err := s.conn.SendData(key[:]); err != nil {
+			return err
+		}
+		if err := sendGarbledTables(s.conn, garbled.Gates); err != nil {
+			return err
+		}
+		if first {
+			// Initial state is zero.
+			var labelData ot.LabelData
+			for i := 0; i < stateBits; i++ {
+				err := s.conn.SendLabel(garbled.Wires[offset+i].L0,
+					&labelData)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	xfer := s.conn.Stats.Sum() - ioStats
+	timing.Sample("Xfer", []string{FileSize(xfer).String()})
+
+	err = connPhase(ctx, s.conn, PhaseOT, func() error {
+		return garblerInputs(s.conn, s.oti, circ.Inputs[:2], garbled.Wires,
+			inputs, nil, timing, s.verbose)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outputWires := garbled.Wires[circ.NumWires-circ.Outputs.Size():]
+	var result []*big.Int
+	err = connPhase(ctx, s.conn, PhaseResult, func() (err error) {
+		result, err = garblerResult(s.conn, circ.Outputs[1:],
+			outputWires[stateBits:], timing)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.state = make([]ot.Wire, stateBits)
+	copy(s.state, outputWires)
+	s.round++
+
+	return result, nil
+}
+
+// EvaluatorSession implements the evaluator side of a reactive
+// multi-round computation.
+type EvaluatorSession struct {
+	conn    *p2p.Conn
+	oti     ot.OT
+	state   []ot.Label
+	round   int
+	verbose bool
+}
+
+// NewEvaluatorSession creates a new evaluator session for the
+// connection.
+func NewEvaluatorSession(conn *p2p.Conn, oti ot.OT,
+	verbose bool) *EvaluatorSession {
+
+	return &EvaluatorSession{
+		conn:    conn,
+		oti:     oti,
+		verbose: verbose,
+	}
+}
+
+// Round runs the next round of the session with the round circuit
+// and evaluator's inputs. The function returns the round outputs
+// without the state. The round is aborted when the context is done.
+func (s *EvaluatorSession) Round(ctx context.Context, circ *Circuit,
+	inputs *big.Int) ([]*big.Int, error) {
+
+	result, err := s.runRound(ctx, circ, inputs)
+	return result, Abort(s.conn, err)
+}
+
+func (s *EvaluatorSession) runRound(ctx context.Context, circ *Circuit,
+	inputs *big.Int) ([]*big.Int, error) {
+
+	if err := checkRoundCircuit(circ); err != nil {
+		return nil, err
+	}
+	stateBits := int(circ.Inputs[2].Type.Bits)
+	if s.state != nil && len(s.state) != stateBits {
+		return nil, fmt.Errorf("round %d: state size mismatch: %d != %d",
+			s.round, stateBits, len(s.state))
+	}
+	if err := circuitHandshake(ctx, s.conn, s.oti, circ); err != nil {
+		return nil, err
+	}
+
+	timing := NewTiming()
+
+	// Receive round info and garbled tables.
+	var key []byte
+	var garbled [][]ot.Label
+	wires := make([]ot.Label, circ.NumWires)
+	offset := int(circ.Inputs[0].Type.Bits + circ.Inputs[1].Type.Bits)
+
+	err := connPhase(ctx, s.conn, PhaseTables, func() error {
+		round, err := s.conn.ReceiveUint32()
+		if err != nil {
+			return err
+		}
+		if round != s.round {
+			return fmt.Errorf("%w: round mismatch: got %d, expected %d",
+				ErrProtocolMismatch, round, s.round)
+		}
+		key, err = s.conn.ReceiveData()
+		if err != nil {
+			return err
+		}
+		timing.Sample("Wait", nil)
+
+		garbled, err = receiveGarbledTables(s.conn, circ)
+		if err != nil {
+			return err
+		}
+
+		if s.state == nil {
+			var label ot.Label
+			var labelData ot.LabelData
+			for i := 0; i < stateBits; i++ {
+				err := s.conn.ReceiveLabel(&label, &labelData)
+				if err != nil {
+					return err
+				}
+				wires[offset+i] = label
+			}
+		} else {
+			copy(wires[offset:], s.state)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	timing.Sample("Recv", nil)
+
+	err = connPhase(ctx, s.conn, PhaseOT, func() error {
+		return evaluatorInputs(s.conn, s.oti, circ.Inputs[:2], wires, inputs,
+			timing, s.verbose)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if circ.Parallel() {
+		err = circ.EvalParallel(key, wires, garbled, 0)
+	} else {
+		err = circ.Eval(key, wires, garbled)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timing.Sample("Eval", nil)
+
+	outputs := wires[circ.NumWires-circ.Outputs.Size():]
+	var result []*big.Int
+	err = connPhase(ctx, s.conn, PhaseResult, func() (err error) {
+		result, err = evaluatorResult(s.conn, circ.Outputs[1:],
+			outputs[stateBits:], timing)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.state = make([]ot.Label, stateBits)
+	copy(s.state, outputs)
+	s.round++
+
+	return result, nil
+}
@@ -0,0 +1,66 @@
+//
+// share.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+)
+
+// NewShareMask creates a random mask for the secret-shared outputs
+// (RecipientShared). The mask is the garbler's share of the outputs
+// and the evaluator receives the outputs XORed with the mask. The
+// mask bits of other outputs are zero.
+func NewShareMask(outputs IO) (*big.Int, error) {
+	size := outputs.Size()
+	buf := make([]byte, (size+7)/8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	random := new(big.Int).SetBytes(buf)
+
+	mask := new(big.Int)
+	var bit int
+	for _, arg := range outputs {
+		for i := 0; i < int(arg.Type.Bits); i++ {
+			if arg.Recipient == RecipientShared {
+				mask.SetBit(mask, bit, random.Bit(bit))
+			}
+			bit++
+		}
+	}
+	return mask, nil
+}
+
+// ShareWires applies the garbler's shares to the evaluator's input
+// wires. The function swaps the wire labels of the input bits that
+// are set in shares. When the evaluator queries the labels with its
+// shares, it receives the labels of the recombined value, i.e. the
+// XOR of the evaluator's and garbler's shares. The recombination
+// happens without any gates and the evaluator side of the protocol
+// is unchanged. If shares is nil, the function returns wires as-is.
+func ShareWires(wires []ot.Wire, shares *big.Int) []ot.Wire {
+	if shares == nil || shares.Sign() == 0 {
+		return wires
+	}
+	result := make([]ot.Wire, len(wires))
+	for i, w := range wires {
+		if shares.Bit(i) == 1 {
+			result[i] = ot.Wire{
+				L0: w.L1,
+				L1: w.L0,
+			}
+		} else {
+			result[i] = w
+		}
+	}
+	return result
+}
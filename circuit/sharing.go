@@ -0,0 +1,536 @@
+//
+// sharing.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/types"
+)
+
+// SharingScheme names the secret sharing scheme in the protocol
+// handshake, see Handshake.
+const SharingScheme = "additive-sharing"
+
+// Sharing implements the two-party secret sharing protocols of the
+// arithmetic backend. The values are shared either as XOR shares of
+// their bits (the boolean domain) or as additive shares modulo 2^k
+// for k <= 64 (the arithmetic domain). The boolean circuits are
+// evaluated with the GMW protocol, see GMW. The arithmetic additions
+// are local and the multiplications use Beaver triples that the
+// parties generate with Gilboa's OT-based multiplication. The values
+// are converted between the domains with A2B and B2A. The protocols
+// are secure against a semi-honest peer.
+//
+// All bit vectors hold one bit per byte and the arithmetic shares are
+// reduced modulo 2^k.
+type Sharing struct {
+	nw     *p2p.Network
+	player int
+	peerID int
+	peer   *p2p.Peer
+	adders map[int]*Circuit
+}
+
+// NewSharing creates a new secret sharing for the two-party network.
+func NewSharing(nw *p2p.Network) (*Sharing, error) {
+	if len(nw.Peers) != 1 {
+		return nil, fmt.Errorf("%w: sharing needs 2 players, got %d",
+			ErrProtocolMismatch, len(nw.Peers)+1)
+	}
+	s := &Sharing{
+		nw:     nw,
+		player: nw.ID,
+		adders: make(map[int]*Circuit),
+	}
+	for id, peer := range nw.Peers {
+		s.peerID = id
+		s.peer = peer
+	}
+	return s, nil
+}
+
+// Player returns our player number.
+func (s *Sharing) Player() int {
+	return s.player
+}
+
+// ShareInputs shares the players' inputs in the boolean domain. The
+// sizes specify the input sizes of the players in bits. The function
+// returns our shares of all inputs in the player order.
+func (s *Sharing) ShareInputs(sizes []int, inputs *big.Int) ([]byte, error) {
+	if len(sizes) != 2 {
+		return nil, fmt.Errorf("%w: %d inputs for 2 players",
+			ErrProtocolMismatch, len(sizes))
+	}
+	offsets := []int{0, sizes[0], sizes[0] + sizes[1]}
+	result := make([]byte, offsets[2])
+
+	mask, err := randomBits(sizes[s.player])
+	if err != nil {
+		return nil, err
+	}
+	ours := result[offsets[s.player]:offsets[s.player+1]]
+	for i := range ours {
+		ours[i] = byte(inputs.Bit(i)) ^ mask[i]
+	}
+	data, err := s.exchange(packBits(mask))
+	if err != nil {
+		return nil, err
+	}
+	theirs := result[offsets[s.peerID]:offsets[s.peerID+1]]
+	if err := unpackBits(data, theirs); err != nil {
+		return nil, fmt.Errorf("input shares: %w", err)
+	}
+	return result, nil
+}
+
+// Eval evaluates the boolean circuit with the GMW protocol. The in
+// holds our shares of the circuit input wires. The function returns
+// our shares of the numOutputs output wires that are the last wires
+// of the circuit.
+func (s *Sharing) Eval(circ *Circuit, in []byte, numOutputs int) (
+	[]byte, error) {
+
+	if len(in)+numOutputs > circ.NumWires {
+		return nil, fmt.Errorf("%d inputs and %d outputs for %d wires",
+			len(in), numOutputs, circ.NumWires)
+	}
+	if len(circ.Gates) > 0 && circ.Stats[NumLevels] == 0 {
+		circ.AssignLevels()
+	}
+	g := newGMW(s.nw, circ)
+	copy(g.shares, in)
+	if len(g.a) > 0 {
+		if err := g.triples(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := g.eval(); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), g.shares[circ.NumWires-numOutputs:]...), nil
+}
+
+// Open opens the boolean shares to both players.
+func (s *Sharing) Open(bits []byte) ([]byte, error) {
+	data, err := s.exchange(packBits(bits))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, len(bits))
+	if err := unpackBits(data, result); err != nil {
+		return nil, fmt.Errorf("opened shares: %w", err)
+	}
+	for i := range result {
+		result[i] ^= bits[i]
+	}
+	return result, nil
+}
+
+// OpenOutputs opens the boolean shares of the outputs to the players
+// that the output recipients allow. The player 0 is the garbler and
+// the player 1 the evaluator. The players keep their shares of the
+// shared outputs. The function returns zero bits for the outputs
+// that we don't learn.
+func (s *Sharing) OpenOutputs(outputs IO, bits []byte) ([]byte, error) {
+	if outputs.Size() != len(bits) {
+		return nil, fmt.Errorf("%d shares for %d output bits",
+			len(bits), outputs.Size())
+	}
+	garbler := s.player == 0
+	learns := func(r Recipient, garbler bool) bool {
+		if garbler {
+			return r.Garbler()
+		}
+		return r == RecipientBoth || r == RecipientEvaluator
+	}
+
+	// Send our shares of the outputs that the peer learns.
+	var send []byte
+	var count int
+	var bit int
+	for _, arg := range outputs {
+		for i := 0; i < int(arg.Type.Bits); i++ {
+			if learns(arg.Recipient, !garbler) {
+				send = append(send, bits[bit])
+			}
+			if learns(arg.Recipient, garbler) {
+				count++
+			}
+			bit++
+		}
+	}
+	data, err := s.exchange(packBits(send))
+	if err != nil {
+		return nil, err
+	}
+	theirs := make([]byte, count)
+	if err := unpackBits(data, theirs); err != nil {
+		return nil, fmt.Errorf("opened shares: %w", err)
+	}
+
+	result := make([]byte, len(bits))
+	bit = 0
+	for _, arg := range outputs {
+		for i := 0; i < int(arg.Type.Bits); i++ {
+			switch {
+			case arg.Recipient == RecipientShared:
+				result[bit] = bits[bit]
+			case learns(arg.Recipient, garbler):
+				result[bit] = bits[bit] ^ theirs[0]
+				theirs = theirs[1:]
+			}
+			bit++
+		}
+	}
+	return result, nil
+}
+
+// Constant returns our arithmetic share of the public constant. The
+// player 0 holds the constant and the player 1 holds a zero share.
+func (s *Sharing) Constant(k int, c uint64) uint64 {
+	if s.player == 0 {
+		return c & arithMask(k)
+	}
+	return 0
+}
+
+// OpenArith opens the arithmetic shares modulo 2^k to both players.
+func (s *Sharing) OpenArith(k int, x []uint64) ([]uint64, error) {
+	data, err := s.exchange(packUint64s(x))
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := unpackUint64s(data, len(x))
+	if err != nil {
+		return nil, fmt.Errorf("opened shares: %w", err)
+	}
+	mask := arithMask(k)
+	result := make([]uint64, len(x))
+	for i := range x {
+		result[i] = (x[i] + theirs[i]) & mask
+	}
+	return result, nil
+}
+
+// Mul multiplies the arithmetic shares x and y pairwise modulo 2^k
+// with Beaver triples. The multiplications take one communication
+// round after the triple generation.
+func (s *Sharing) Mul(k int, x, y []uint64) ([]uint64, error) {
+	if len(x) != len(y) {
+		return nil, fmt.Errorf("multiplying %d values with %d values",
+			len(x), len(y))
+	}
+	a, b, c, err := s.triples(k, len(x))
+	if err != nil {
+		return nil, err
+	}
+
+	// Open d = x - a and e = y - b.
+	de := make([]uint64, 2*len(x))
+	for i := range x {
+		de[2*i] = x[i] - a[i]
+		de[2*i+1] = y[i] - b[i]
+	}
+	de, err = s.OpenArith(k, de)
+	if err != nil {
+		return nil, err
+	}
+
+	// x*y = c + d*b + e*a + d*e
+	mask := arithMask(k)
+	z := make([]uint64, len(x))
+	for i := range z {
+		d := de[2*i]
+		e := de[2*i+1]
+		z[i] = c[i] + d*b[i] + e*a[i]
+		if s.player == 0 {
+			z[i] += d * e
+		}
+		z[i] &= mask
+	}
+	return z, nil
+}
+
+// triples generates count arithmetic Beaver triples (a, b, c) with
+// c = a*b modulo 2^k. Each player picks random shares of a and b.
+// The cross terms a_0*b_1 and a_1*b_0 are shared with Gilboa's
+// multiplication that takes k oblivious transfers per term.
+func (s *Sharing) triples(k, count int) (a, b, c []uint64, err error) {
+	a, err = randomUint64s(count)
+	if err != nil {
+		return
+	}
+	b, err = randomUint64s(count)
+	if err != nil {
+		return
+	}
+	mask := arithMask(k)
+	for i := range a {
+		a[i] &= mask
+		b[i] &= mask
+	}
+	cross, err := s.gilboa(k, a, b)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("triples failed: %w", err)
+	}
+	c = make([]uint64, count)
+	for i := range c {
+		c[i] = (a[i]*b[i] + cross[i]) & mask
+	}
+	return
+}
+
+// gilboa computes our shares of the cross terms a_we*b_peer and
+// a_peer*b_we modulo 2^k. In the transfers where we are the sender,
+// we send the messages r_j and r_j + a_we*2^j for each bit j and the
+// peer chooses with the bit j of b_peer. Our share is -sum(r_j) and
+// the peer's share is sum(r_j) + a_we*b_peer.
+func (s *Sharing) gilboa(k int, a, b []uint64) ([]uint64, error) {
+	conn := s.peer.Conn()
+	cross := make([]uint64, len(a))
+
+	send := func() error {
+		sender := s.peer.OT()
+		if err := sender.InitSender(conn); err != nil {
+			return err
+		}
+		wires := make([]ot.Wire, len(a)*k)
+		for i := range a {
+			for j := 0; j < k; j++ {
+				r, err := ot.NewLabel(rand.Reader)
+				if err != nil {
+					return err
+				}
+				cross[i] -= r.D1
+				wires[i*k+j].L0 = r
+				r.D1 += a[i] << j
+				wires[i*k+j].L1 = r
+			}
+		}
+		return sender.Send(wires)
+	}
+	receive := func() error {
+		receiver := s.peer.OT()
+		if err := receiver.InitReceiver(conn); err != nil {
+			return err
+		}
+		flags := make([]bool, len(b)*k)
+		for i := range b {
+			for j := 0; j < k; j++ {
+				flags[i*k+j] = (b[i]>>j)&1 != 0
+			}
+		}
+		labels := make([]ot.Label, len(flags))
+		if err := receiver.Receive(flags, labels); err != nil {
+			return err
+		}
+		for i := range b {
+			for j := 0; j < k; j++ {
+				cross[i] += labels[i*k+j].D1
+			}
+		}
+		return nil
+	}
+
+	if err := pairwise(s.player, s.peerID, send, receive); err != nil {
+		return nil, err
+	}
+	return cross, nil
+}
+
+// B2A converts the boolean shares of a value to arithmetic shares
+// modulo 2^k. The value is zero-extended or truncated to k bits. For
+// each bit b = b_0 XOR b_1, the players share the product b_0*b_1
+// with one oblivious transfer: the player 0 sends the messages r and
+// r + b_0 and the player 1 chooses with b_1. Since b = b_0 + b_1 -
+// 2*b_0*b_1, the player 0's share is sum(2^i*(b_0 + 2r)) and the
+// player 1's share is sum(2^i*(b_1 - 2t)) where t is the received
+// message.
+func (s *Sharing) B2A(k int, bits []byte) (uint64, error) {
+	if len(bits) > k {
+		bits = bits[:k]
+	}
+	conn := s.peer.Conn()
+	var x uint64
+
+	if s.player == 0 {
+		sender := s.peer.OT()
+		if err := sender.InitSender(conn); err != nil {
+			return 0, err
+		}
+		wires := make([]ot.Wire, len(bits))
+		for i, bit := range bits {
+			r, err := ot.NewLabel(rand.Reader)
+			if err != nil {
+				return 0, err
+			}
+			x += (uint64(bit) + 2*r.D1) << i
+			wires[i].L0 = r
+			r.D1 += uint64(bit)
+			wires[i].L1 = r
+		}
+		if err := sender.Send(wires); err != nil {
+			return 0, err
+		}
+	} else {
+		receiver := s.peer.OT()
+		if err := receiver.InitReceiver(conn); err != nil {
+			return 0, err
+		}
+		flags := make([]bool, len(bits))
+		for i, bit := range bits {
+			flags[i] = bit != 0
+		}
+		labels := make([]ot.Label, len(bits))
+		if err := receiver.Receive(flags, labels); err != nil {
+			return 0, err
+		}
+		for i, bit := range bits {
+			x += (uint64(bit) - 2*labels[i].D1) << i
+		}
+	}
+	return x & arithMask(k), nil
+}
+
+// A2B converts the arithmetic shares of a value modulo 2^k to boolean
+// shares of its k bits. The players share the bits of their
+// arithmetic shares and add them with a k-bit ripple-carry adder.
+// The conversion takes k communication rounds.
+func (s *Sharing) A2B(k int, x uint64) ([]byte, error) {
+	adder, ok := s.adders[k]
+	if !ok {
+		adder = newAdderCircuit(k)
+		s.adders[k] = adder
+	}
+	in := make([]byte, 2*k)
+	ours := in[s.player*k : (s.player+1)*k]
+	for i := range ours {
+		ours[i] = byte(x>>i) & 1
+	}
+	return s.Eval(adder, in, k)
+}
+
+// newAdderCircuit creates a ripple-carry adder circuit that adds two
+// k-bit inputs modulo 2^k. The carry c_{i+1} is c_i XOR ((a_i XOR
+// c_i) AND (b_i XOR c_i)) so each bit takes one AND gate.
+func newAdderCircuit(k int) *Circuit {
+	arg := func(name string) IOArg {
+		return IOArg{
+			Name: name,
+			Type: types.Info{
+				Type:       types.TUint,
+				IsConcrete: true,
+				Bits:       types.Size(k),
+			},
+		}
+	}
+	circ := &Circuit{
+		Inputs:  IO{arg("a"), arg("b")},
+		Outputs: IO{arg("s")},
+	}
+
+	// Intermediate wires follow the inputs and the outputs are the
+	// last k wires.
+	next := Wire(2 * k)
+	numWires := 3*k + 4*(k-1) + 1
+	out := Wire(numWires - k)
+	gate := func(op Operation, i0, i1 Wire) Wire {
+		o := next
+		next++
+		circ.Gates = append(circ.Gates, Gate{
+			Input0: i0,
+			Input1: i1,
+			Output: o,
+			Op:     op,
+		})
+		circ.Stats[op]++
+		return o
+	}
+	output := func(op Operation, i0, i1 Wire, bit int) {
+		circ.Gates = append(circ.Gates, Gate{
+			Input0: i0,
+			Input1: i1,
+			Output: out + Wire(bit),
+			Op:     op,
+		})
+		circ.Stats[op]++
+	}
+
+	a := func(i int) Wire { return Wire(i) }
+	b := func(i int) Wire { return Wire(k + i) }
+
+	output(XOR, a(0), b(0), 0)
+	carry := gate(AND, a(0), b(0))
+	for i := 1; i < k; i++ {
+		t1 := gate(XOR, a(i), carry)
+		t2 := gate(XOR, b(i), carry)
+		output(XOR, t1, b(i), i)
+		t3 := gate(AND, t1, t2)
+		carry = gate(XOR, carry, t3)
+	}
+	circ.NumGates = len(circ.Gates)
+	circ.NumWires = numWires
+	circ.AssignLevels()
+
+	return circ
+}
+
+// exchange sends the data to the peer and returns the peer's data.
+func (s *Sharing) exchange(data []byte) ([]byte, error) {
+	received, err := exchangeAll(s.nw, func(id int) []byte {
+		return data
+	})
+	if err != nil {
+		return nil, err
+	}
+	return received[s.peerID], nil
+}
+
+// arithMask returns the mask of the k-bit arithmetic values.
+func arithMask(k int) uint64 {
+	if k >= 64 {
+		return 0xffffffffffffffff
+	}
+	return (1 << k) - 1
+}
+
+func randomUint64s(count int) ([]uint64, error) {
+	buf := make([]byte, count*8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return unpackUint64s(buf, count)
+}
+
+func packUint64s(values []uint64) []byte {
+	result := make([]byte, len(values)*8)
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(result[i*8:], v)
+	}
+	return result
+}
+
+func unpackUint64s(data []byte, count int) ([]uint64, error) {
+	if len(data) != count*8 {
+		return nil, fmt.Errorf("%w: got %d bytes for %d values",
+			ErrProtocolMismatch, len(data), count)
+	}
+	result := make([]uint64, count)
+	for i := range result {
+		result[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return result, nil
+}
@@ -0,0 +1,161 @@
+//
+// sharing_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"fmt"
+	mathrand "math/rand"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// runSharing runs the function f for both players of a two-party
+// sharing and returns the players' results.
+func runSharing(t *testing.T,
+	f func(s *Sharing) ([]uint64, error)) [2][]uint64 {
+
+	networks := newTestNetworks(t, 2)
+
+	type sharingResult struct {
+		player int
+		result []uint64
+		err    error
+	}
+	results := make(chan sharingResult)
+	for _, nw := range networks {
+		go func(nw *p2p.Network) {
+			s, err := NewSharing(nw)
+			if err != nil {
+				results <- sharingResult{
+					player: nw.ID,
+					err:    err,
+				}
+				return
+			}
+			result, err := f(s)
+			results <- sharingResult{
+				player: nw.ID,
+				result: result,
+				err:    err,
+			}
+		}(nw)
+	}
+	var sorted [2][]uint64
+	for range networks {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("player %d: %s", r.player, r.err)
+		}
+		sorted[r.player] = r.result
+	}
+	return sorted
+}
+
+func TestSharingMul(t *testing.T) {
+	for _, k := range []int{1, 8, 32, 64} {
+		t.Run(fmt.Sprintf("%d-bit", k), func(t *testing.T) {
+			rnd := mathrand.New(mathrand.NewSource(int64(k)))
+			mask := arithMask(k)
+			x := make([]uint64, 16)
+			y := make([]uint64, len(x))
+			for i := range x {
+				x[i] = rnd.Uint64() & mask
+				y[i] = rnd.Uint64() & mask
+			}
+
+			results := runSharing(t, func(s *Sharing) ([]uint64, error) {
+				// The player 0 shares x and the player 1 shares y.
+				xs := make([]uint64, len(x))
+				ys := make([]uint64, len(y))
+				for i := range x {
+					if s.Player() == 0 {
+						xs[i] = x[i]
+					} else {
+						ys[i] = y[i]
+					}
+				}
+				z, err := s.Mul(k, xs, ys)
+				if err != nil {
+					return nil, err
+				}
+				return s.OpenArith(k, z)
+			})
+			for player, result := range results {
+				for i := range x {
+					expected := (x[i] * y[i]) & mask
+					if result[i] != expected {
+						t.Errorf("player %d: %d*%d=%d, expected %d",
+							player, x[i], y[i], result[i], expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSharingConvert(t *testing.T) {
+	values := []uint64{0, 1, 42, 0xffffffff, 0x8000000000000001}
+	for _, k := range []int{8, 32, 64} {
+		t.Run(fmt.Sprintf("%d-bit", k), func(t *testing.T) {
+			results := runSharing(t, func(s *Sharing) ([]uint64, error) {
+				var result []uint64
+				for _, v := range values {
+					// Share v as bits: player 0 holds v XOR r and
+					// player 1 holds r.
+					bits := make([]byte, 64)
+					for i := range bits {
+						if s.Player() == 0 {
+							bits[i] = byte(v>>i)&1 ^ byte(i&1)
+						} else {
+							bits[i] = byte(i & 1)
+						}
+					}
+					x, err := s.B2A(k, bits)
+					if err != nil {
+						return nil, err
+					}
+					opened, err := s.OpenArith(k, []uint64{x})
+					if err != nil {
+						return nil, err
+					}
+					result = append(result, opened[0])
+
+					bits, err = s.A2B(k, x)
+					if err != nil {
+						return nil, err
+					}
+					bits, err = s.Open(bits)
+					if err != nil {
+						return nil, err
+					}
+					var b uint64
+					for i, bit := range bits {
+						b |= uint64(bit) << i
+					}
+					result = append(result, b)
+				}
+				return result, nil
+			})
+			for player, result := range results {
+				for i, v := range values {
+					expected := v & arithMask(k)
+					if result[2*i] != expected {
+						t.Errorf("player %d: B2A(%x)=%x, expected %x",
+							player, v, result[2*i], expected)
+					}
+					if result[2*i+1] != expected {
+						t.Errorf("player %d: A2B(%x)=%x, expected %x",
+							player, v, result[2*i+1], expected)
+					}
+				}
+			}
+		})
+	}
+}
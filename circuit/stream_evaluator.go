@@ -7,6 +7,7 @@
 package circuit
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"fmt"
@@ -86,12 +87,43 @@ func (stream *StreamEval) InitCircuit(numWires, numTmpWires int) {
 	}
 }
 
-// StreamEvaluator runs the stream evaluator on the connection.
-func StreamEvaluator(conn *p2p.Conn, oti ot.OT, inputFlag []string,
-	verbose bool) (IO, []*big.Int, error) {
+// StreamEvaluator runs the stream evaluator on the connection. The
+// program describes the program that we expect the garbler to
+// stream. The evaluator verifies the program fingerprint, the input
+// and output descriptions and, if the program has its steps, all
+// streamed circuits against the program. The protocol run is
+// aborted when the context is done; see WithTimeouts for per-phase
+// time limits.
+func StreamEvaluator(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	program *StreamProgram, inputFlag []string, verbose bool) (
+	IO, []*big.Int, error) {
+
+	ph := NewPhases(ctx, conn.WithContext)
+	outputs, result, err := streamEvaluator(ph, conn, oti, program,
+		inputFlag, verbose)
+	err = ph.Wrap(err)
+	ph.Leave()
+
+	return outputs, result, Abort(conn, err)
+}
+
+func streamEvaluator(ph *Phases, conn *p2p.Conn, oti ot.OT,
+	program *StreamProgram, inputFlag []string, verbose bool) (
+	IO, []*big.Int, error) {
 
 	timing := NewTiming()
 
+	if err := ph.Enter(PhaseTables); err != nil {
+		return nil, nil, err
+	}
+	if program == nil || len(program.Fingerprint) == 0 {
+		return nil, nil, fmt.Errorf("stream program fingerprint missing")
+	}
+	if err := NewHandshake(oti, program.Fingerprint).exchange(conn); err != nil {
+		return nil, nil, err
+	}
+	verifier := newStreamVerifier(program)
+
 	// Receive program info.
 	if verbose {
 		fmt.Printf(" - Waiting for program info...\n")
@@ -105,18 +137,18 @@ func StreamEvaluator(conn *p2p.Conn, oti ot.OT, inputFlag []string,
 		return nil, nil, err
 	}
 	// Peer input.
-	in1, err := receiveArgument(conn)
+	in1, err := ReceiveArgument(conn)
 	if err != nil {
 		return nil, nil, err
 	}
 	// Our input.
-	in2, err := receiveArgument(conn)
+	in2, err := ReceiveArgument(conn)
 	if err != nil {
 		return nil, nil, err
 	}
 	inputs, err := in2.Parse(inputFlag)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidInput, err)
 	}
 	// Program outputs.
 	numOutputs, err := conn.ReceiveUint32()
@@ -125,13 +157,17 @@ func StreamEvaluator(conn *p2p.Conn, oti ot.OT, inputFlag []string,
 	}
 	var outputs IO
 	for i := 0; i < numOutputs; i++ {
-		out, err := receiveArgument(conn)
+		out, err := ReceiveArgument(conn)
 		if err != nil {
 			return nil, nil, err
 		}
 		outputs = append(outputs, out)
 	}
 
+	if err := program.verifyIO(IO{in1, in2}, outputs); err != nil {
+		return nil, nil, err
+	}
+
 	numSteps, err := conn.ReceiveUint32()
 	if err != nil {
 		return nil, nil, err
@@ -149,6 +185,9 @@ func StreamEvaluator(conn *p2p.Conn, oti ot.OT, inputFlag []string,
 	}
 
 	// Receive peer inputs.
+	if err := ph.Enter(PhaseOT); err != nil {
+		return nil, nil, err
+	}
 	var label ot.Label
 	var labelData ot.LabelData
 	for w := 0; w < int(in1.Type.Bits); w++ {
@@ -196,6 +235,9 @@ func StreamEvaluator(conn *p2p.Conn, oti ot.OT, inputFlag []string,
 	if verbose {
 		fmt.Printf(" - Evaluating program...\n")
 	}
+	if err := ph.Enter(PhaseTables); err != nil {
+		return nil, nil, err
+	}
 	var garbled [4]ot.Label
 	var lastStep int
 
@@ -246,6 +288,12 @@ loop:
 					}
 				}
 			}
+			if verifier != nil {
+				err := verifier.circuit(step, numGates, numTmpWires, numWires)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
 			streaming.InitCircuit(numWires, numTmpWires)
 			var id uint32
 			for i := 0; i < numGates; i++ {
@@ -300,8 +348,12 @@ loop:
 						return nil, nil, err
 					}
 				default:
-					return nil, nil, fmt.Errorf("invalid operation %s",
-						Operation(gop))
+					return nil, nil, fmt.Errorf("%w: invalid operation %s",
+						ErrCorruptGarbledTable, Operation(gop))
+				}
+				if verifier != nil {
+					verifier.gate(Operation(gop), aIndex, aTmp, bIndex, bTmp,
+						cIndex, cTmp)
 				}
 				switch Operation(gop) {
 				case XOR, XNOR:
@@ -352,8 +404,8 @@ loop:
 				case AND:
 					if tableCount != 2 {
 						return nil, nil,
-							fmt.Errorf("corrupted ciruit: AND table size: %d",
-								tableCount)
+							fmt.Errorf("%w: AND table size: %d",
+								ErrCorruptGarbledTable, tableCount)
 					}
 					sa := a.S()
 					sb := b.S()
@@ -384,8 +436,8 @@ loop:
 						index--
 						if index >= tableCount {
 							return nil, nil,
-								fmt.Errorf("corrupted circuit: index %d >= %d",
-									index, tableCount)
+								fmt.Errorf("%w: index %d >= %d",
+									ErrCorruptGarbledTable, index, tableCount)
 						}
 						c = garbled[index]
 					}
@@ -399,8 +451,8 @@ loop:
 						index--
 						if index >= tableCount {
 							return nil, nil,
-								fmt.Errorf("corrupted circuit: index %d >= %d",
-									index, tableCount)
+								fmt.Errorf("%w: index %d >= %d",
+									ErrCorruptGarbledTable, index, tableCount)
 						}
 						c = garbled[index]
 					}
@@ -410,29 +462,50 @@ loop:
 				}
 				streaming.Set(cTmp, cIndex, output)
 			}
+			if verifier != nil {
+				if err := verifier.done(); err != nil {
+					return nil, nil, err
+				}
+			}
 
 		case OpReturn:
 			xfer := conn.Stats.Sum() - ioStats
 			ioStats = conn.Stats.Sum()
 			timing.Sample("Eval", []string{FileSize(xfer).String()})
 
-			var labels []ot.Label
+			if err := ph.Enter(PhaseResult); err != nil {
+				return nil, nil, err
+			}
+
+			var ids []Wire
 			for i := 0; i < outputs.Size(); i++ {
 				id, err := conn.ReceiveUint32()
 				if err != nil {
 					return nil, nil, err
 				}
-				label := streaming.Get(false, id)
-				labels = append(labels, label)
+				ids = append(ids, Wire(id))
+			}
+			if verifier != nil {
+				if err := verifier.ret(ids); err != nil {
+					return nil, nil, err
+				}
+			}
+			var labels []ot.Label
+			for _, id := range ids {
+				labels = append(labels, streaming.Get(false, int(id)))
 			}
 
-			// Resolve result values.
+			// Resolve result values. We return labels only for the
+			// outputs the garbler is allowed to learn.
 			if err := conn.SendUint32(OpResult); err != nil {
 				return nil, nil, err
 			}
 			var labelData ot.LabelData
-			for _, l := range labels {
-				if err := conn.SendLabel(l, &labelData); err != nil {
+			for i, visible := range outputs.Visible(true) {
+				if !visible {
+					continue
+				}
+				if err := conn.SendLabel(labels[i], &labelData); err != nil {
 					return nil, nil, err
 				}
 			}
@@ -440,25 +513,55 @@ loop:
 				return nil, nil, err
 			}
 
-			result, err := conn.ReceiveData()
+			data, err := conn.ReceiveData()
 			if err != nil {
 				return nil, nil, err
 			}
-			rawResult = new(big.Int).SetBytes(result)
+			rawResult = decodeResult(outputs, labels, data)
 			break loop
 
 		default:
-			return nil, nil, fmt.Errorf("unknown operation %d", op)
+			return nil, nil, fmt.Errorf("%w: unknown operation %d",
+				ErrProtocolMismatch, op)
 		}
 	}
 
 	xfer = conn.Stats.Sum() - ioStats
 	timing.Sample("Result", []string{FileSize(xfer).String()})
 
-	return outputs, outputs.Split(rawResult), nil
+	return outputs, outputs.SplitVisible(rawResult, false), nil
+}
+
+// SendArgument sends the argument description to the connection.
+func SendArgument(conn *p2p.Conn, arg IOArg) error {
+	if err := conn.SendString(arg.Name); err != nil {
+		return err
+	}
+	if err := conn.SendString(arg.Type.String()); err != nil {
+		return err
+	}
+	if err := conn.SendUint32(int(arg.Type.Bits)); err != nil {
+		return err
+	}
+	if err := conn.SendUint32(int(arg.Recipient)); err != nil {
+		return err
+	}
+
+	if err := conn.SendUint32(len(arg.Compound)); err != nil {
+		return err
+	}
+	for _, a := range arg.Compound {
+		if err := SendArgument(conn, a); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func receiveArgument(conn *p2p.Conn) (arg IOArg, err error) {
+// ReceiveArgument receives an argument description from the
+// connection, see SendArgument.
+func ReceiveArgument(conn *p2p.Conn) (arg IOArg, err error) {
 	name, err := conn.ReceiveString()
 	if err != nil {
 		return arg, err
@@ -471,7 +574,12 @@ func receiveArgument(conn *p2p.Conn) (arg IOArg, err error) {
 	if err != nil {
 		return arg, err
 	}
+	recipient, err := conn.ReceiveUint32()
+	if err != nil {
+		return arg, err
+	}
 	arg.Name = name
+	arg.Recipient = Recipient(recipient)
 	arg.Type, err = types.Parse(t)
 	if err != nil {
 		return arg, err
@@ -483,7 +591,7 @@ func receiveArgument(conn *p2p.Conn) (arg IOArg, err error) {
 		return arg, err
 	}
 	for i := 0; i < count; i++ {
-		a, err := receiveArgument(conn)
+		a, err := ReceiveArgument(conn)
 		if err != nil {
 			return arg, err
 		}
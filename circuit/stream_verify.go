@@ -0,0 +1,244 @@
+//
+// stream_verify.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package circuit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// StreamProgram describes the program that the stream evaluator
+// expects the garbler to stream.
+type StreamProgram struct {
+	// Fingerprint identifies the program in the protocol handshake.
+	Fingerprint []byte
+
+	// Inputs and Outputs describe the program's arguments and return
+	// values, including the output recipients. The garbler's
+	// descriptions must match them.
+	Inputs  IO
+	Outputs IO
+
+	// Steps describe the circuits of the streamed program. If Steps
+	// is nil, the streamed circuits are not verified.
+	Steps []StreamStep
+
+	// Return holds the wire IDs of the program's return values.
+	Return []Wire
+}
+
+// StreamStep describes a circuit of the streamed program.
+type StreamStep struct {
+	Step     int
+	NumGates int
+	NumWires int
+	NumIDs   int
+	// Hash is the hash of the circuit gates with their input and
+	// output wire IDs as they appear in the stream.
+	Hash [32]byte
+}
+
+// NewStreamStep creates the stream step for the circuit c of the
+// program step. The in and out specify the circuit's input and output
+// wire IDs.
+func NewStreamStep(step int, c *Circuit, in, out []Wire) StreamStep {
+	firstTmp := Wire(len(in))
+	firstOut := Wire(c.NumWires - len(out))
+
+	resolve := func(w Wire) (Wire, bool) {
+		if w < firstTmp {
+			return in[w], false
+		} else if w >= firstOut {
+			return out[w-firstOut], false
+		}
+		return w, true
+	}
+
+	gh := newGateHash()
+	for i := 0; i < len(c.Gates); i++ {
+		g := &c.Gates[i]
+
+		var b Wire
+		var bTmp bool
+
+		a, aTmp := resolve(g.Input0)
+		if g.Op != INV {
+			b, bTmp = resolve(g.Input1)
+		}
+		o, oTmp := resolve(g.Output)
+		gh.add(g.Op, a, aTmp, b, bTmp, o, oTmp)
+	}
+
+	return StreamStep{
+		Step:     step,
+		NumGates: c.NumGates,
+		NumWires: c.NumWires,
+		NumIDs:   int(maxWire(maxWire(0, in), out) + 1),
+		Hash:     gh.sum(),
+	}
+}
+
+// gateHash hashes the streamed gates.
+type gateHash struct {
+	h   hash.Hash
+	buf [13]byte
+}
+
+func newGateHash() *gateHash {
+	return &gateHash{
+		h: sha256.New(),
+	}
+}
+
+func (gh *gateHash) add(op Operation, a Wire, aTmp bool, b Wire, bTmp bool,
+	c Wire, cTmp bool) {
+
+	flags := byte(op)
+	if aTmp {
+		flags |= 0b10000000
+	}
+	if bTmp {
+		flags |= 0b01000000
+	}
+	if cTmp {
+		flags |= 0b00100000
+	}
+	gh.buf[0] = flags
+	binary.BigEndian.PutUint32(gh.buf[1:], uint32(a))
+	binary.BigEndian.PutUint32(gh.buf[5:], uint32(b))
+	binary.BigEndian.PutUint32(gh.buf[9:], uint32(c))
+	gh.h.Write(gh.buf[:])
+}
+
+func (gh *gateHash) sum() (result [32]byte) {
+	copy(result[:], gh.h.Sum(nil))
+	gh.h.Reset()
+	return
+}
+
+// verifyIO verifies that the garbler's input and output descriptions
+// match the program.
+func (program *StreamProgram) verifyIO(inputs, outputs IO) error {
+	if len(inputs) != len(program.Inputs) {
+		return fmt.Errorf("%w: program has %d inputs, expected %d",
+			ErrProtocolMismatch, len(inputs), len(program.Inputs))
+	}
+	for i, arg := range inputs {
+		if !equalArg(arg, program.Inputs[i]) {
+			return fmt.Errorf("%w: input %d is %s, expected %s",
+				ErrProtocolMismatch, i, arg, program.Inputs[i])
+		}
+	}
+	if len(outputs) != len(program.Outputs) {
+		return fmt.Errorf("%w: program has %d outputs, expected %d",
+			ErrProtocolMismatch, len(outputs), len(program.Outputs))
+	}
+	for i, arg := range outputs {
+		if !equalArg(arg, program.Outputs[i]) {
+			return fmt.Errorf("%w: output %d is %s (%s), expected %s (%s)",
+				ErrProtocolMismatch, i, arg, arg.Recipient,
+				program.Outputs[i], program.Outputs[i].Recipient)
+		}
+	}
+	return nil
+}
+
+func equalArg(a, b IOArg) bool {
+	if a.Name != b.Name || a.Type.String() != b.Type.String() ||
+		a.Type.Bits != b.Type.Bits || a.Recipient != b.Recipient ||
+		len(a.Compound) != len(b.Compound) {
+		return false
+	}
+	for i := range a.Compound {
+		if !equalArg(a.Compound[i], b.Compound[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// streamVerifier verifies the streamed circuits against the expected
+// program.
+type streamVerifier struct {
+	program *StreamProgram
+	next    int
+	step    *StreamStep
+	hash    *gateHash
+}
+
+func newStreamVerifier(program *StreamProgram) *streamVerifier {
+	if program == nil || program.Steps == nil {
+		return nil
+	}
+	return &streamVerifier{
+		program: program,
+		hash:    newGateHash(),
+	}
+}
+
+// circuit verifies the header of the next streamed circuit.
+func (v *streamVerifier) circuit(step, numGates, numWires, numIDs int) error {
+	if v.next >= len(v.program.Steps) {
+		return fmt.Errorf("%w: unexpected circuit for step %d",
+			ErrProtocolMismatch, step)
+	}
+	v.step = &v.program.Steps[v.next]
+	v.next++
+
+	if step != v.step.Step {
+		return fmt.Errorf("%w: circuit for step %d, expected %d",
+			ErrProtocolMismatch, step, v.step.Step)
+	}
+	if numGates != v.step.NumGates || numWires != v.step.NumWires ||
+		numIDs != v.step.NumIDs {
+		return fmt.Errorf("%w: step %d: circuit #g=%d #w=%d #id=%d, "+
+			"expected #g=%d #w=%d #id=%d", ErrProtocolMismatch, step,
+			numGates, numWires, numIDs,
+			v.step.NumGates, v.step.NumWires, v.step.NumIDs)
+	}
+	return nil
+}
+
+// gate adds the streamed gate to the circuit hash.
+func (v *streamVerifier) gate(op Operation, a int, aTmp bool, b int,
+	bTmp bool, c int, cTmp bool) {
+
+	v.hash.add(op, Wire(a), aTmp, Wire(b), bTmp, Wire(c), cTmp)
+}
+
+// done verifies the gates of the streamed circuit.
+func (v *streamVerifier) done() error {
+	if v.hash.sum() != v.step.Hash {
+		return fmt.Errorf("%w: step %d: circuit gates differ",
+			ErrProtocolMismatch, v.step.Step)
+	}
+	return nil
+}
+
+// ret verifies the return wire IDs of the streamed program.
+func (v *streamVerifier) ret(ids []Wire) error {
+	if v.next != len(v.program.Steps) {
+		return fmt.Errorf("%w: program returned after %d circuits, "+
+			"expected %d", ErrProtocolMismatch, v.next,
+			len(v.program.Steps))
+	}
+	if len(ids) != len(v.program.Return) {
+		return fmt.Errorf("%w: program returns %d wires, expected %d",
+			ErrProtocolMismatch, len(ids), len(v.program.Return))
+	}
+	for i, id := range ids {
+		if id != v.program.Return[i] {
+			return fmt.Errorf("%w: return wire %d is %d, expected %d",
+				ErrProtocolMismatch, i, id, v.program.Return[i])
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@
 package compiler
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"math/big"
@@ -126,13 +127,13 @@ func TestArithmetics(t *testing.T) {
 				gerr := make(chan error)
 
 				go func() {
-					_, err := circuit.Garbler(p2p.NewConn(gio), ot.NewCO(),
-						circ, gInput, false)
+					_, err := circuit.Garbler(context.Background(),
+						p2p.NewConn(gio), ot.NewCO(), circ, gInput, false)
 					gerr <- err
 				}()
 
-				result, err := circuit.Evaluator(p2p.NewConn(eio),
-					ot.NewCO(), circ, eInput, false)
+				result, err := circuit.Evaluator(context.Background(),
+					p2p.NewConn(eio), ot.NewCO(), circ, eInput, false)
 				if err != nil {
 					t.Fatalf("Evaluator failed: %s\n", err)
 				}
@@ -179,13 +180,13 @@ func BenchmarkMult(b *testing.B) {
 	gerr := make(chan error)
 
 	go func() {
-		_, err := circuit.Garbler(p2p.NewConn(gio), ot.NewCO(), circ, gInput,
-			false)
+		_, err := circuit.Garbler(context.Background(), p2p.NewConn(gio),
+			ot.NewCO(), circ, gInput, false)
 		gerr <- err
 	}()
 
-	_, err = circuit.Evaluator(p2p.NewConn(eio), ot.NewCO(), circ, eInput,
-		false)
+	_, err = circuit.Evaluator(context.Background(), p2p.NewConn(eio),
+		ot.NewCO(), circ, eInput, false)
 	if err != nil {
 		b.Fatalf("Evaluator failed: %s\n", err)
 	}
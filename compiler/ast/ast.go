@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"sort"
 	"strings"
 	"unicode"
 
@@ -30,6 +31,10 @@ var (
 	_ AST = &Call{}
 	_ AST = &Return{}
 	_ AST = &For{}
+	_ AST = &ForRange{}
+	_ AST = &Switch{}
+	_ AST = &Break{}
+	_ AST = &Continue{}
 	_ AST = &Binary{}
 	_ AST = &Unary{}
 	_ AST = &Slice{}
@@ -38,6 +43,7 @@ var (
 	_ AST = &BasicLit{}
 	_ AST = &CompositeLit{}
 	_ AST = &Make{}
+	_ AST = &FuncLit{}
 )
 
 func indent(w io.Writer, indent int) {
@@ -57,6 +63,7 @@ const (
 	TypeStruct
 	TypePointer
 	TypeAlias
+	TypeFunc
 )
 
 // TypeInfo contains AST type information.
@@ -69,6 +76,8 @@ type TypeInfo struct {
 	TypeName     string
 	StructFields []StructField
 	AliasType    *TypeInfo
+	Params       []*Variable
+	Results      []*Variable
 	Methods      map[string]*Func
 	Annotations  Annotations
 }
@@ -103,11 +112,27 @@ func (ti *TypeInfo) Equal(o *TypeInfo) bool {
 	case TypeAlias:
 		return ti.AliasType.Equal(o.AliasType)
 
+	case TypeFunc:
+		return equalVars(ti.Params, o.Params) &&
+			equalVars(ti.Results, o.Results)
+
 	default:
 		panic("unsupported type")
 	}
 }
 
+func equalVars(a, b []*Variable) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx, v := range a {
+		if !v.Type.Equal(b[idx].Type) {
+			return false
+		}
+	}
+	return true
+}
+
 // StructField contains AST structure field information.
 type StructField struct {
 	utils.Point
@@ -177,6 +202,29 @@ func (ti *TypeInfo) format(pp bool) string {
 	case TypePointer:
 		return fmt.Sprintf("%s*%s", str, ti.ElementType)
 
+	case TypeFunc:
+		str += "func("
+		for idx, param := range ti.Params {
+			if idx > 0 {
+				str += ", "
+			}
+			str += param.Type.String()
+		}
+		str += ")"
+		if len(ti.Results) == 1 {
+			str += " " + ti.Results[0].Type.String()
+		} else if len(ti.Results) > 1 {
+			str += " ("
+			for idx, result := range ti.Results {
+				if idx > 0 {
+					str += ", "
+				}
+				str += result.Type.String()
+			}
+			str += ")"
+		}
+		return str
+
 	default:
 		return fmt.Sprintf("%s{TypeInfo %d}", str, ti.Type)
 	}
@@ -297,6 +345,9 @@ func (ti *TypeInfo) Resolve(env *Env, ctx *Codegen, gen *ssa.Generator) (
 			ElementType: &elInfo,
 		}, nil
 
+	case TypeFunc:
+		return types.Func, nil
+
 	default:
 		return result, ctx.Errorf(ti, "can't resolve type %s", ti)
 	}
@@ -492,6 +543,16 @@ func (ast *Func) String() string {
 	return str
 }
 
+// Signature returns the function type of the function.
+func (ast *Func) Signature() *TypeInfo {
+	return &TypeInfo{
+		Point:   ast.Point,
+		Type:    TypeFunc,
+		Params:  ast.Args,
+		Results: ast.Return,
+	}
+}
+
 // ConstantDef implements an AST constant definition.
 type ConstantDef struct {
 	utils.Point
@@ -597,20 +658,120 @@ func (ast *Return) String() string {
 	return fmt.Sprintf("return %v", ast.Exprs)
 }
 
-// For implements an AST for statement.
+// For implements an AST for statement. The Label is empty for
+// unlabeled loops. The Init, Cond, and Inc are nil if the statement
+// does not have them. The Max specifies the public maximum number of
+// iterations for loops with secret conditions and it is 0 for
+// unbounded loops.
 type For struct {
 	utils.Point
-	Init AST
-	Cond AST
-	Inc  AST
-	Body List
+	Label string
+	Max   int
+	Init  AST
+	Cond  AST
+	Inc   AST
+	Body  List
 }
 
 func (ast *For) String() string {
+	if ast.Init == nil && ast.Inc == nil {
+		if ast.Cond == nil {
+			return fmt.Sprintf("for %s", ast.Body)
+		}
+		return fmt.Sprintf("for %s %s", ast.Cond, ast.Body)
+	}
 	return fmt.Sprintf("for %s; %s; %s %s",
 		ast.Init, ast.Cond, ast.Inc, ast.Body)
 }
 
+// ForRange implements an AST for statement with a range clause. The
+// Key and Value are nil if the range clause does not define them.
+type ForRange struct {
+	utils.Point
+	Label  string
+	Key    AST
+	Value  AST
+	Define bool
+	Expr   AST
+	Body   List
+}
+
+func (ast *ForRange) String() string {
+	if ast.Key == nil {
+		return fmt.Sprintf("for range %s %s", ast.Expr, ast.Body)
+	}
+	op := "="
+	if ast.Define {
+		op = ":="
+	}
+	if ast.Value == nil {
+		return fmt.Sprintf("for %s %s range %s %s",
+			ast.Key, op, ast.Expr, ast.Body)
+	}
+	return fmt.Sprintf("for %s, %s %s range %s %s",
+		ast.Key, ast.Value, op, ast.Expr, ast.Body)
+}
+
+// Switch implements an AST switch statement. The tagless switch
+// statements have nil Expr. The Label is empty for unlabeled switch
+// statements.
+type Switch struct {
+	utils.Point
+	Label string
+	Expr  AST
+	Cases []*Case
+}
+
+func (ast *Switch) String() string {
+	if ast.Expr == nil {
+		return "switch"
+	}
+	return fmt.Sprintf("switch %s", ast.Expr)
+}
+
+// Case implements a switch statement case clause. The default clause
+// has no expressions.
+type Case struct {
+	utils.Point
+	Exprs []AST
+	Body  List
+}
+
+func (c *Case) String() string {
+	if len(c.Exprs) == 0 {
+		return "default"
+	}
+	return fmt.Sprintf("case %v", c.Exprs)
+}
+
+// Break implements an AST break statement. The Label is empty for
+// unlabeled break statements.
+type Break struct {
+	utils.Point
+	Label string
+}
+
+func (ast *Break) String() string {
+	if len(ast.Label) == 0 {
+		return "break"
+	}
+	return fmt.Sprintf("break %s", ast.Label)
+}
+
+// Continue implements an AST continue statement. The Label is empty
+// for unlabeled continue statements.
+type Continue struct {
+	utils.Point
+	Label string
+}
+
+func (ast *Continue) String() string {
+	if len(ast.Label) == 0 {
+		return "continue"
+	}
+	return fmt.Sprintf("continue %s", ast.Label)
+}
+
 // BinaryType defines binary expression types.
 type BinaryType int
 
@@ -833,3 +994,117 @@ func (ast *Make) String() string {
 	}
 	return str + ")"
 }
+
+// FuncLit implements function literals. The Locals contain the
+// arguments, the return values, and the variables that the function
+// body defines. The Assigned contain the other variables that the
+// function body assigns i.e. the captured variables that the function
+// can modify.
+type FuncLit struct {
+	utils.Point
+	Func     *Func
+	Locals   map[string]bool
+	Assigned []string
+}
+
+// NewFuncLit creates a new function literal for the function.
+func NewFuncLit(loc utils.Point, f *Func) *FuncLit {
+	lit := &FuncLit{
+		Point:  loc,
+		Func:   f,
+		Locals: make(map[string]bool),
+	}
+	for _, arg := range f.Args {
+		lit.Locals[arg.Name] = true
+	}
+	for _, ret := range f.Return {
+		lit.Locals[ret.Name] = true
+	}
+	assigned := make(map[string]bool)
+	scanVars(f.Body, lit.Locals, assigned)
+	for name := range assigned {
+		if !lit.Locals[name] {
+			lit.Assigned = append(lit.Assigned, name)
+		}
+	}
+	sort.Strings(lit.Assigned)
+
+	return lit
+}
+
+func (ast *FuncLit) String() string {
+	return "func" + strings.TrimPrefix(ast.Func.String(),
+		"func "+ast.Func.Name)
+}
+
+// scanVars collects the variables that the statement defines and
+// assigns. The function does not descend into nested function
+// literals since they have their own variables.
+func scanVars(stmt AST, defined, assigned map[string]bool) {
+	switch stmt := stmt.(type) {
+	case List:
+		for _, s := range stmt {
+			scanVars(s, defined, assigned)
+		}
+
+	case *VariableDef:
+		for _, name := range stmt.Names {
+			defined[name] = true
+		}
+
+	case *ConstantDef:
+		defined[stmt.Name] = true
+
+	case *Assign:
+		for _, lv := range stmt.LValues {
+			scanLValue(lv, stmt.Define, defined, assigned)
+		}
+
+	case *If:
+		scanVars(stmt.True, defined, assigned)
+		scanVars(stmt.False, defined, assigned)
+
+	case *For:
+		scanVars(stmt.Init, defined, assigned)
+		scanVars(stmt.Inc, defined, assigned)
+		scanVars(stmt.Body, defined, assigned)
+
+	case *ForRange:
+		scanLValue(stmt.Key, stmt.Define, defined, assigned)
+		scanLValue(stmt.Value, stmt.Define, defined, assigned)
+		scanVars(stmt.Body, defined, assigned)
+
+	case *Switch:
+		for _, c := range stmt.Cases {
+			scanVars(c.Body, defined, assigned)
+		}
+	}
+}
+
+func scanLValue(lv AST, define bool, defined, assigned map[string]bool) {
+	switch lv := lv.(type) {
+	case *VariableRef:
+		name := lv.Name.Name
+		if len(lv.Name.Package) > 0 {
+			// Structure field.
+			name = lv.Name.Package
+		}
+		if define {
+			defined[name] = true
+		} else {
+			assigned[name] = true
+		}
+
+	case *Index:
+		scanLValue(lv.Expr, false, defined, assigned)
+	}
+}
+
+// Closure implements function values. The closure binds the function
+// literal to the compilation and to the bindings of the block that
+// evaluated the literal.
+type Closure struct {
+	Lit      *FuncLit
+	Start    *ssa.Block
+	Bindings *ssa.Bindings
+}
@@ -29,6 +29,7 @@ type Codegen struct {
 	Types          map[types.ID]*TypeInfo
 	Native         map[string]*circuit.Circuit
 	HeapID         int
+	LoopID         int
 }
 
 // NewCodegen creates a new compilation.
@@ -121,6 +122,51 @@ func (ctx *Codegen) LookupFunc(block *ssa.Block, ref *VariableRef) (
 	return called, nil
 }
 
+// LookupClosure resolves the function value that the reference
+// names. The function returns nil if the name is not bound to a
+// function value.
+func (ctx *Codegen) LookupClosure(block *ssa.Block, ref *VariableRef) (
+	*Closure, error) {
+
+	if len(ref.Name.Package) > 0 {
+		return nil, nil
+	}
+	b, ok := block.Bindings.Get(ref.Name.Name)
+	if !ok {
+		pkg, found := ctx.Packages[ref.Name.Defined]
+		if found {
+			b, ok = pkg.Bindings.Get(ref.Name.Name)
+		}
+	}
+	if !ok || b.Type.Type != types.TFunc {
+		return nil, nil
+	}
+	v, ok := b.Bound.(*ssa.Value)
+	if ok && v.Const {
+		closure, ok := v.ConstValue.(*Closure)
+		if ok {
+			return closure, nil
+		}
+	}
+	return nil, ctx.Errorf(ref, "cannot call %s: function value is not constant",
+		ref)
+}
+
+// ClosureBindings returns the bindings of the closure's captured
+// variables. If the compilation that evaluated the function literal
+// is still active, the variables are resolved from its current
+// bindings. Otherwise they are resolved from the bindings of the
+// block that evaluated the literal. The closure call's compilation
+// must be on the top of the compilation stack.
+func (ctx *Codegen) ClosureBindings(closure *Closure) *ssa.Bindings {
+	for i := len(ctx.Stack) - 2; i >= 0; i-- {
+		if ctx.Stack[i].Start == closure.Start {
+			return ctx.Stack[i+1].CallerBindings
+		}
+	}
+	return closure.Bindings
+}
+
 // LRValue implements value as l-value or r-value. The LRValues have
 // two types: 1) base type that specifies the base memory location
 // containing the value, and 2) value type that specifies the wires of
@@ -204,7 +250,7 @@ func (lrv *LRValue) ConstValue() (ssa.Value, bool, error) {
 		return lrv.value, false, nil
 
 	case types.TBool, types.TInt, types.TUint, types.TFloat, types.TString,
-		types.TStruct, types.TArray:
+		types.TStruct, types.TArray, types.TFunc:
 		return lrv.value, true, nil
 
 	default:
@@ -374,15 +420,18 @@ func (ctx *Codegen) Scope() ssa.Scope {
 	return 0
 }
 
-// PushCompilation pushes a new compilation to the compilation stack.
+// PushCompilation pushes a new compilation to the compilation
+// stack. The callerBindings are the bindings of the caller's block at
+// the call site.
 func (ctx *Codegen) PushCompilation(start, ret, caller *ssa.Block,
-	called *Func) {
+	callerBindings *ssa.Bindings, called *Func) {
 
 	ctx.Stack = append(ctx.Stack, Compilation{
-		Start:  start,
-		Return: ret,
-		Caller: caller,
-		Called: called,
+		Start:          start,
+		Return:         ret,
+		Caller:         caller,
+		CallerBindings: callerBindings,
+		Called:         called,
 	})
 }
 
@@ -417,14 +466,97 @@ func (ctx *Codegen) HeapVar() string {
 	return name
 }
 
+// PushLoop pushes a new loop to the current compilation. The loop's
+// flag variables get unique names so that the nested and inlined
+// loops don't share flags.
+func (ctx *Codegen) PushLoop(label string, isSwitch bool) *Loop {
+	loop := &Loop{
+		Label: label,
+		Break: fmt.Sprintf("%%break%d", ctx.LoopID),
+	}
+	if !isSwitch {
+		loop.Continue = fmt.Sprintf("%%continue%d", ctx.LoopID)
+	}
+	ctx.LoopID++
+
+	c := &ctx.Stack[len(ctx.Stack)-1]
+	c.Loops = append(c.Loops, loop)
+
+	return loop
+}
+
+// PopLoop pops the innermost loop of the current compilation.
+func (ctx *Codegen) PopLoop() {
+	c := &ctx.Stack[len(ctx.Stack)-1]
+	if len(c.Loops) == 0 {
+		panic("loop stack underflow")
+	}
+	c.Loops = c.Loops[:len(c.Loops)-1]
+}
+
+// Loops returns the enclosing loops of the current compilation.
+func (ctx *Codegen) Loops() []*Loop {
+	if len(ctx.Stack) == 0 {
+		return nil
+	}
+	return ctx.Stack[len(ctx.Stack)-1].Loops
+}
+
+// LookupLoop resolves the target loop of the break or continue
+// statement. The unlabeled statements target the innermost loop. The
+// unlabeled break statements also target the switch statements.
+func (ctx *Codegen) LookupLoop(locator utils.Locator, label string,
+	isContinue bool) (*Loop, error) {
+
+	loops := ctx.Loops()
+	for i := len(loops) - 1; i >= 0; i-- {
+		loop := loops[i]
+		if len(label) > 0 {
+			if loop.Label != label {
+				continue
+			}
+			if isContinue && len(loop.Continue) == 0 {
+				return nil, ctx.Errorf(locator, "invalid continue label %s",
+					label)
+			}
+			return loop, nil
+		}
+		if isContinue && len(loop.Continue) == 0 {
+			continue
+		}
+		return loop, nil
+	}
+	switch {
+	case len(label) > 0 && isContinue:
+		return nil, ctx.Errorf(locator, "invalid continue label %s", label)
+	case len(label) > 0:
+		return nil, ctx.Errorf(locator, "invalid break label %s", label)
+	case isContinue:
+		return nil, ctx.Errorf(locator, "continue is not in a loop")
+	default:
+		return nil, ctx.Errorf(locator, "break is not in a loop or switch")
+	}
+}
+
+// Loop describes an enclosing for loop or switch statement. The
+// break and continue statements set the loop's boolean flag
+// variables. The switch statements have no continue flag.
+type Loop struct {
+	Label    string
+	Break    string
+	Continue string
+}
+
 // Compilation contains information about a compilation
 // scope. Toplevel, each function call, and each nested block specify
 // their own scope with their own variable bindings.
 type Compilation struct {
-	Start  *ssa.Block
-	Return *ssa.Block
-	Caller *ssa.Block
-	Called *Func
+	Start          *ssa.Block
+	Return         *ssa.Block
+	Caller         *ssa.Block
+	CallerBindings *ssa.Bindings
+	Called         *Func
+	Loops          []*Loop
 	// XXX Bindings
 	// XXX Parent scope.
 }
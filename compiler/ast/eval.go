@@ -154,6 +154,30 @@ func (ast *For) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
 	return ssa.Undefined, false, nil
 }
 
+// Eval implements the compiler.ast.AST.Eval for for-range statements.
+func (ast *ForRange) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+	return ssa.Undefined, false, nil
+}
+
+// Eval implements the compiler.ast.AST.Eval for switch statements.
+func (ast *Switch) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+	return ssa.Undefined, false, nil
+}
+
+// Eval implements the compiler.ast.AST.Eval for break statements.
+func (ast *Break) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+	return ssa.Undefined, false, nil
+}
+
+// Eval implements the compiler.ast.AST.Eval for continue statements.
+func (ast *Continue) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+	return ssa.Undefined, false, nil
+}
+
 // Eval implements the compiler.ast.AST.Eval for binary expressions.
 func (ast *Binary) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
 	ssa.Value, bool, error) {
@@ -580,3 +604,23 @@ func (ast *Make) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
 
 	return gen.Constant(typeInfo, types.Undefined), true, nil
 }
+
+// Eval implements the compiler.ast.AST.Eval for function literals.
+func (ast *FuncLit) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+
+	closure := &Closure{
+		Lit:      ast,
+		Bindings: env.Bindings,
+	}
+	if len(ctx.Stack) > 0 {
+		closure.Start = ctx.Start()
+	}
+
+	v := gen.AnonVal(types.Func)
+	v.Name = "$" + ast.Func.Name
+	v.Const = true
+	v.ConstValue = closure
+
+	return v, true, nil
+}
@@ -8,6 +8,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/ssa"
@@ -63,7 +64,7 @@ func (pkg *Package) Compile(ctx *Codegen) (*ssa.Program, Annotations, error) {
 	}
 
 	// Main block derives package's bindings from block with NextBlock().
-	ctx.PushCompilation(gen.NextBlock(block), gen.Block(), nil, main)
+	ctx.PushCompilation(gen.NextBlock(block), gen.Block(), nil, nil, main)
 
 	// Arguments.
 	var inputs circuit.IO
@@ -113,6 +114,10 @@ func (pkg *Package) Compile(ctx *Codegen) (*ssa.Program, Annotations, error) {
 	}
 
 	// Return values
+	recipients, err := outputRecipients(ctx, main)
+	if err != nil {
+		return nil, nil, err
+	}
 	var outputs circuit.IO
 	for idx, rt := range main.Return {
 		if idx >= len(returnVars) {
@@ -139,10 +144,14 @@ func (pkg *Package) Compile(ctx *Codegen) (*ssa.Program, Annotations, error) {
 		}
 
 		v := returnVars[idx]
-		outputs = append(outputs, circuit.IOArg{
+		output := circuit.IOArg{
 			Name: v.String(),
 			Type: v.Type,
-		})
+		}
+		if recipients != nil {
+			output.Recipient = recipients[idx]
+		}
+		outputs = append(outputs, output)
 	}
 
 	steps := init.Serialize()
@@ -170,6 +179,40 @@ func (pkg *Package) Compile(ctx *Codegen) (*ssa.Program, Annotations, error) {
 	return program, main.Annotations, nil
 }
 
+// outputRecipients parses the output recipients from the @Recipient
+// annotation of the main function. The annotation lists the recipient
+// (garbler, evaluator, both, or shared) of each return value:
+//
+//	// @Recipient garbler both
+//	func main(a, b int32) (int32, bool) {
+//
+// The function returns nil if the annotation is not specified.
+func outputRecipients(ctx *Codegen, main *Func) ([]circuit.Recipient, error) {
+	var result []circuit.Recipient
+	for _, annotation := range main.Annotations {
+		parts := strings.Fields(annotation)
+		if len(parts) == 0 || parts[0] != "@Recipient" {
+			continue
+		}
+		if result != nil {
+			return nil, ctx.Errorf(main, "duplicate @Recipient annotation")
+		}
+		if len(parts)-1 != len(main.Return) {
+			return nil, ctx.Errorf(main,
+				"@Recipient: got %d recipients, expected %d",
+				len(parts)-1, len(main.Return))
+		}
+		for _, part := range parts[1:] {
+			r, err := circuit.ParseRecipient(part)
+			if err != nil {
+				return nil, ctx.Errorf(main, "@Recipient: %s", err)
+			}
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
 func flattenStruct(t types.Info) circuit.IO {
 	var result circuit.IO
 	if t.Type != types.TStruct {
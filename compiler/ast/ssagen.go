@@ -24,7 +24,7 @@ func (ast List) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 
 	var err error
 
-	for _, b := range ast {
+	for idx, b := range ast {
 		if block.Dead {
 			warn := true
 			ret, ok := b.(*Return)
@@ -40,11 +40,137 @@ func (ast List) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 		if err != nil {
 			return nil, nil, err
 		}
+		if idx+1 >= len(ast) || len(ctx.Loops()) == 0 {
+			continue
+		}
+		// Check if break or continue skips the rest of the list.
+		rest := ast[idx+1:]
+		guarded, active := guardLoops(block, ctx, rest)
+		if !active {
+			switch b.(type) {
+			case *Break, *Continue:
+				ctx.logger.Warningf(rest[0].Location(), "unreachable code")
+			}
+			break
+		}
+		if guarded != nil {
+			return guarded.SSA(block, ctx, gen)
+		}
 	}
 
 	return block, nil, nil
 }
 
+// guardLoops guards the statements with the break and continue flags
+// of the enclosing loops. It returns false if any of the flags is set
+// at compile time and the statements must be skipped. If some of the
+// flags are secret, guardLoops returns an if statement that runs the
+// statements only when none of the secret flags are set. The flags
+// are known to be unset inside the if statement so it clears them
+// before the statements.
+func guardLoops(block *ssa.Block, ctx *Codegen, stmts List) (AST, bool) {
+	loc := stmts[0].Location()
+
+	var cond AST
+	clear := &setFlags{
+		Point: loc,
+	}
+	for _, loop := range ctx.Loops() {
+		for _, name := range []string{loop.Break, loop.Continue} {
+			if len(name) == 0 {
+				continue
+			}
+			set, ok := flagValue(block, name)
+			if ok {
+				if set {
+					return nil, false
+				}
+				continue
+			}
+			var ref AST = &VariableRef{
+				Point: loc,
+				Name: Identifier{
+					Name: name,
+				},
+			}
+			if cond != nil {
+				ref = &Binary{
+					Point: loc,
+					Left:  cond,
+					Op:    BinaryOr,
+					Right: ref,
+				}
+			}
+			cond = ref
+			clear.Names = append(clear.Names, name)
+		}
+	}
+	if cond == nil {
+		return nil, true
+	}
+	body := List{clear}
+	return &If{
+		Point: loc,
+		Expr: &Unary{
+			Point: loc,
+			Type:  UnaryNot,
+			Expr:  cond,
+		},
+		True: append(body, stmts...),
+	}, true
+}
+
+// flagValue returns the value of the loop flag variable if it is
+// known at compile time.
+func flagValue(block *ssa.Block, name string) (bool, bool) {
+	b, ok := block.Bindings.Get(name)
+	if !ok {
+		return false, true
+	}
+	v, ok := b.Bound.(*ssa.Value)
+	if !ok || !v.Const {
+		return false, false
+	}
+	set, ok := v.ConstValue.(bool)
+	return set, ok
+}
+
+// setFlag sets the loop flag variable to the constant value.
+func setFlag(block *ssa.Block, ctx *Codegen, gen *ssa.Generator,
+	name string, value bool) {
+
+	c := gen.Constant(value, types.Bool)
+	gen.AddConstant(c)
+	block.Bindings.Set(gen.NewVal(name, types.Bool, ctx.Scope()), &c)
+}
+
+// setFlags sets the loop flag variables in the generated statements.
+type setFlags struct {
+	utils.Point
+	Names []string
+	Value bool
+}
+
+func (ast *setFlags) String() string {
+	return fmt.Sprintf("set %v=%v", ast.Names, ast.Value)
+}
+
+// SSA implements the compiler.ast.AST.SSA for loop flag updates.
+func (ast *setFlags) SSA(block *ssa.Block, ctx *Codegen,
+	gen *ssa.Generator) (*ssa.Block, []ssa.Value, error) {
+
+	for _, name := range ast.Names {
+		setFlag(block, ctx, gen, name, ast.Value)
+	}
+	return block, nil, nil
+}
+
+// Eval implements the compiler.ast.AST.Eval for loop flag updates.
+func (ast *setFlags) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+	return ssa.Undefined, false, nil
+}
+
 // SSA implements the compiler.ast.AST.SSA for function definitions.
 func (ast *Func) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	*ssa.Block, []ssa.Value, error) {
@@ -383,8 +509,29 @@ func (ast *Assign) SSA(block *ssa.Block, ctx *Codegen,
 				}
 				lValue = gen.NewVal(b.Name, b.Type, ctx.Scope())
 			}
+			if (lValue.Type.Type == types.TFunc) !=
+				(rv.Type.Type == types.TFunc) {
+				return nil, nil, ctx.Errorf(ast,
+					"cannot use %s as type %s in assignment",
+					rv.Type, lValue.Type)
+			}
+
+			// Integer constants get the variable's type so that the
+			// bound value has the variable's width.
+			if rv.Const && lValue.Type.Concrete() &&
+				rv.Type.Bits != lValue.Type.Bits &&
+				(lValue.Type.Type == types.TInt ||
+					lValue.Type.Type == types.TUint) &&
+				lValue.Type.CanAssignConst(rv.Type) {
+				gen.RemoveConstant(rv)
+				rv = gen.Constant(rv.ConstValue, lValue.Type)
+				gen.AddConstant(rv)
+			}
 
-			block.AddInstr(ssa.NewMovInstr(rv, lValue))
+			// The function values are bound at compile time.
+			if rv.Type.Type != types.TFunc {
+				block.AddInstr(ssa.NewMovInstr(rv, lValue))
+			}
 			block.Bindings.Set(lValue, &rv)
 
 		case *Index:
@@ -612,6 +759,143 @@ func (ast *If) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	return next, nil, nil
 }
 
+// SSA implements the compiler.ast.AST.SSA for switch statements. The
+// switch statement is lowered into an if-else chain where each case
+// compares the tag value with its expressions. The tag expression is
+// evaluated once before the cases. The default clause is the final
+// else branch.
+func (ast *Switch) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
+	*ssa.Block, []ssa.Value, error) {
+
+	loop := ctx.PushLoop(ast.Label, true)
+	setFlag(block, ctx, gen, loop.Break, false)
+
+	var tag AST
+	if ast.Expr != nil {
+		var v []ssa.Value
+		var err error
+		block, v, err = ast.Expr.SSA(block, ctx, gen)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(v) == 0 {
+			return nil, nil, ctx.Errorf(ast.Expr, "%s used as value", ast.Expr)
+		} else if len(v) > 1 {
+			return nil, nil, ctx.Errorf(ast.Expr,
+				"multiple-value %s used in single-value context", ast.Expr)
+		}
+		tag = &ssaValue{
+			Point: ast.Expr.Location(),
+			Value: v[0],
+		}
+	}
+
+	var stmt AST
+	for _, c := range ast.Cases {
+		if len(c.Exprs) == 0 {
+			stmt = c.Body
+		}
+	}
+	for i := len(ast.Cases) - 1; i >= 0; i-- {
+		c := ast.Cases[i]
+		if len(c.Exprs) == 0 {
+			continue
+		}
+		var cond AST
+		for _, expr := range c.Exprs {
+			test := expr
+			if tag != nil {
+				test = &Binary{
+					Point: expr.Location(),
+					Left:  tag,
+					Op:    BinaryEq,
+					Right: expr,
+				}
+			}
+			if cond == nil {
+				cond = test
+			} else {
+				cond = &Binary{
+					Point: expr.Location(),
+					Left:  cond,
+					Op:    BinaryOr,
+					Right: test,
+				}
+			}
+		}
+		ifStmt := &If{
+			Point: c.Point,
+			Expr:  cond,
+			True:  c.Body,
+		}
+		if stmt != nil {
+			ifStmt.False = stmt
+		}
+		stmt = ifStmt
+	}
+	if stmt != nil {
+		var err error
+		block, _, err = stmt.SSA(block, ctx, gen)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	ctx.PopLoop()
+
+	return block, nil, nil
+}
+
+// ssaValue is an already computed value in the AST. It refers to
+// the switch tag value in the lowered case conditions.
+type ssaValue struct {
+	utils.Point
+	Value ssa.Value
+}
+
+func (ast *ssaValue) String() string {
+	return ast.Value.String()
+}
+
+// SSA implements the compiler.ast.AST.SSA for computed values.
+func (ast *ssaValue) SSA(block *ssa.Block, ctx *Codegen,
+	gen *ssa.Generator) (*ssa.Block, []ssa.Value, error) {
+
+	gen.AddConstant(ast.Value)
+	return block, []ssa.Value{ast.Value}, nil
+}
+
+// Eval implements the compiler.ast.AST.Eval for computed values.
+func (ast *ssaValue) Eval(env *Env, ctx *Codegen, gen *ssa.Generator) (
+	ssa.Value, bool, error) {
+	return ast.Value, ast.Value.Const, nil
+}
+
+// SSA implements the compiler.ast.AST.SSA for break statements.
+func (ast *Break) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
+	*ssa.Block, []ssa.Value, error) {
+
+	loop, err := ctx.LookupLoop(ast, ast.Label, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	setFlag(block, ctx, gen, loop.Break, true)
+
+	return block, nil, nil
+}
+
+// SSA implements the compiler.ast.AST.SSA for continue statements.
+func (ast *Continue) SSA(block *ssa.Block, ctx *Codegen,
+	gen *ssa.Generator) (*ssa.Block, []ssa.Value, error) {
+
+	loop, err := ctx.LookupLoop(ast, ast.Label, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	setFlag(block, ctx, gen, loop.Continue, true)
+
+	return block, nil, nil
+}
+
 // SSA implements the compiler.ast.AST.SSA for call expressions.
 func (ast *Call) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	*ssa.Block, []ssa.Value, error) {
@@ -641,11 +925,20 @@ func (ast *Call) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 		callValues = append(callValues, v)
 	}
 
-	// Resolve called.
-	called, err := ctx.LookupFunc(block, ast.Ref)
+	// Resolve called. The function values shadow the functions.
+	var called *Func
+	closure, err := ctx.LookupClosure(block, ast.Ref)
 	if err != nil {
 		return nil, nil, err
 	}
+	if closure != nil {
+		called = closure.Lit.Func
+	} else {
+		called, err = ctx.LookupFunc(block, ast.Ref)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 	if called == nil {
 		// Check builtin functions.
 		bi, ok := builtins[ast.Ref.Name.Name]
@@ -778,7 +1071,21 @@ func (ast *Call) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	rblock := gen.Block()
 	rblock.Bindings = block.Bindings.Clone()
 
-	ctx.PushCompilation(gen.Block(), gen.Block(), rblock, called)
+	ctx.PushCompilation(gen.Block(), gen.Block(), rblock, block.Bindings,
+		called)
+
+	// Closure calls see their captured variables. The variables that
+	// the function literal defines are not visible to its body.
+	var captured *ssa.Bindings
+	if closure != nil {
+		captured = ctx.ClosureBindings(closure)
+		for _, b := range captured.Values {
+			if !closure.Lit.Locals[b.Name] {
+				ctx.Start().Bindings.Values = append(
+					ctx.Start().Bindings.Values, b)
+			}
+		}
+	}
 
 	// Define arguments.
 	for idx, arg := range called.Args {
@@ -801,6 +1108,28 @@ func (ast *Call) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 		a.PtrInfo = args[idx].PtrInfo
 		ctx.Start().Bindings.Set(a, &args[idx])
 
+		if typeInfo.Type == types.TFunc {
+			// Check the function value's signature. The function
+			// values are bound to the arguments at compile time.
+			fn, ok := args[idx].ConstValue.(*Closure)
+			if !ok {
+				return nil, nil, ctx.Errorf(ast.Exprs[idx],
+					"function value %s is not constant", ast.Exprs[idx])
+			}
+			if arg.Type.Type == TypeFunc {
+				ok, err := funcAssignable(arg.Type, fn.Lit.Func, env, ctx,
+					gen)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !ok {
+					return nil, nil, ctx.Errorf(ast.Exprs[idx],
+						"cannot use %s as type %s in argument to %s",
+						fn.Lit.Func.Signature(), arg.Type, called.Name)
+				}
+			}
+			continue
+		}
 		block.AddInstr(ssa.NewMovInstr(args[idx], a))
 	}
 	// This for method calls.
@@ -864,6 +1193,23 @@ func (ast *Call) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 		return nil, nil, err
 	}
 
+	// Update the captured variables that the closure assigned.
+	if closure != nil {
+		retCtx := ssa.NewReturnBindingCTX()
+		for _, name := range closure.Lit.Assigned {
+			b, ok := captured.Get(name)
+			if !ok {
+				continue
+			}
+			v, _, ok := ctx.Start().ReturnBinding(retCtx, name, ctx.Return(),
+				gen)
+			if !ok {
+				continue
+			}
+			captured.Set(gen.NewVal(b.Name, v.Type, b.Scope), &v)
+		}
+	}
+
 	block.SetNext(ctx.Start())
 
 	rblock.Bindings = block.Bindings.Clone()
@@ -921,6 +1267,54 @@ func (ast *Call) error(ctx *Codegen, message string, have [][]ssa.Value,
 	return ctx.Errorf(ast, "%s)", message)
 }
 
+// funcAssignable tests if the function can be used as a value of the
+// function type. The template types of the function type match the
+// instances of the template and vice versa.
+func funcAssignable(ti *TypeInfo, f *Func, env *Env, ctx *Codegen,
+	gen *ssa.Generator) (bool, error) {
+
+	if len(ti.Params) != len(f.Args) || len(ti.Results) != len(f.Return) {
+		return false, nil
+	}
+	for idx, param := range ti.Params {
+		ok, err := typeAssignable(param.Type, f.Args[idx].Type, env, ctx, gen)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	for idx, result := range ti.Results {
+		ok, err := typeAssignable(result.Type, f.Return[idx].Type, env, ctx,
+			gen)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func typeAssignable(a, b *TypeInfo, env *Env, ctx *Codegen,
+	gen *ssa.Generator) (bool, error) {
+
+	at, err := a.Resolve(env, ctx, gen)
+	if err != nil {
+		return false, err
+	}
+	bt, err := b.Resolve(env, ctx, gen)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case at.Concrete() && bt.Concrete():
+		return at.Equal(bt), nil
+	case bt.Concrete():
+		return at.Instantiate(bt), nil
+	case at.Concrete():
+		return bt.Instantiate(at), nil
+	default:
+		return at.Type == bt.Type, nil
+	}
+}
+
 // SSA implements the compiler.ast.AST.SSA for return statements.
 func (ast *Return) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	*ssa.Block, []ssa.Value, error) {
@@ -1023,6 +1417,17 @@ func (ast *Return) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 			result[idx].Type.Type = typeInfo.Type
 		}
 
+		// The function values are bound at compile time.
+		if typeInfo.Type == types.TFunc {
+			if result[idx].Type.Type != types.TFunc {
+				return nil, nil, ctx.Errorf(ast,
+					"invalid value %v for return value %v",
+					result[idx].Type, typeInfo)
+			}
+			block.Bindings.Set(v, &result[idx])
+			continue
+		}
+
 		// if !ssa.LValueFor(typeInfo, result[idx]) {
 		// 	fmt.Println("ssa not lval")
 		// 	fmt.Println(typeInfo.String())
@@ -1086,10 +1491,29 @@ func (ast *Return) error(ctx *Codegen, message string, have [][]ssa.Value,
 	return ctx.Errorf(ast, "%s)", message)
 }
 
-// SSA implements the compiler.ast.AST.SSA for for statements.
+// SSA implements the compiler.ast.AST.SSA for for statements. The
+// loop is unrolled at compile time. The break and continue statements
+// set the loop's flag variables. If a flag is set at compile time,
+// the rest of the iteration is skipped and the break flag stops
+// unrolling. The secret flags guard the rest of the loop so that its
+// assignments are masked when the flags are set. The unrolling
+// continues until the loop condition is false. The init and increment
+// statements are evaluated at compile time so the unrolled iterations
+// see the loop variables as constants. The increment is also assigned
+// to the loop variables under the secret flags so that the variables
+// keep their values from the iteration where the loop terminated.
+//
+// The loop condition must be compile-time constant unless the loop
+// has a public maximum number of iterations. The bounded loops are
+// unrolled at most Max times and a secret condition sets the break
+// flag at the beginning of the iteration where it does not hold.
 func (ast *For) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	*ssa.Block, []ssa.Value, error) {
 
+	loop := ctx.PushLoop(ast.Label, false)
+	setFlag(block, ctx, gen, loop.Break, false)
+	setFlag(block, ctx, gen, loop.Continue, false)
+
 	// Use the same env for the whole for-loop unrolling.
 	env := NewEnv(block)
 
@@ -1105,52 +1529,369 @@ func (ast *For) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 		}
 	}
 
+	// The loop variables that the increment assigns. The values hold
+	// their bindings after the latest increment.
+	vars := incrementVars(ast.Inc)
+	values := make(map[string]ssa.Binding)
+	for _, ref := range vars {
+		if b, ok := env.Get(ref.Name.Name); ok {
+			values[ref.Name.Name] = b
+		}
+	}
+
 	// Expand body as long as condition is true.
-	for i := 0; ; i++ {
+	for i := 0; ast.Max == 0 || i < ast.Max; i++ {
 		if i >= gen.Params.MaxLoopUnroll {
 			return nil, nil, ctx.Errorf(ast,
 				"for-loop unroll limit exceeded: %d", i)
 		}
-		constVal, ok, err := ast.Cond.Eval(env, ctx, gen)
-		if err != nil {
-			return nil, nil, err
-		}
-		if !ok {
-			return nil, nil, ctx.Errorf(ast.Cond,
-				"condition is not compile-time constant: %s", ast.Cond)
+		stmts := ast.Body
+		if ast.Cond != nil {
+			constVal, ok, err := ast.Cond.Eval(env, ctx, gen)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				val, ok := constVal.ConstValue.(bool)
+				if !ok {
+					return nil, nil, ctx.Errorf(ast.Cond,
+						"condition is not boolean expression")
+				}
+				if !val {
+					// Loop completed.
+					break
+				}
+			} else if ast.Max == 0 {
+				return nil, nil, ctx.Errorf(ast.Cond,
+					"condition is not compile-time constant: %s", ast.Cond)
+			} else {
+				loc := ast.Cond.Location()
+				stmts = append(List{
+					&If{
+						Point: loc,
+						Expr: &Unary{
+							Point: loc,
+							Type:  UnaryNot,
+							Expr:  ast.Cond,
+						},
+						True: List{
+							&setFlags{
+								Point: loc,
+								Names: []string{loop.Break},
+								Value: true,
+							},
+						},
+					},
+				}, stmts...)
+			}
 		}
-		val, ok := constVal.ConstValue.(bool)
-		if !ok {
-			return nil, nil, ctx.Errorf(ast.Cond,
-				"condition is not boolean expression")
+		block.Bindings = env.Bindings
+
+		// Each iteration starts with the continue flag cleared.
+		if set, ok := flagValue(block, loop.Continue); !ok || set {
+			setFlag(block, ctx, gen, loop.Continue, false)
 		}
-		if !val {
-			// Loop completed.
-			break
+		var body AST = stmts
+		if len(stmts) > 0 {
+			guarded, active := guardLoops(block, ctx, stmts)
+			if !active {
+				// Loop terminated at compile time.
+				break
+			}
+			if guarded != nil {
+				body = guarded
+			}
 		}
-		block.Bindings = env.Bindings
 
 		// Expand block.
-		block, _, err = ast.Body.SSA(block, ctx, gen)
+		var err error
+		block, _, err = body.SSA(block, ctx, gen)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		// Increment.
 		env = NewEnv(block)
-		_, ok, err = ast.Inc.Eval(env, ctx, gen)
+		if ast.Inc == nil {
+			continue
+		}
+		_, ok, err := ast.Inc.Eval(env, ctx, gen)
 		if err != nil {
 			return nil, nil, err
 		}
 		if !ok {
-			return nil, nil, ctx.Errorf(ast.Init,
+			return nil, nil, ctx.Errorf(ast.Inc,
 				"increment statement is not compile-time constant: %s", ast.Inc)
 		}
+		block, err = assignIncrement(block, ctx, gen, loop, env, vars, values)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+	restoreBindings(block, gen, vars, values)
+	ctx.PopLoop()
 
 	return block, nil, nil
 }
 
+// incrementVars returns the variables that the for-loop increment
+// statement assigns.
+func incrementVars(inc AST) []*VariableRef {
+	assign, ok := inc.(*Assign)
+	if !ok {
+		return nil
+	}
+	var result []*VariableRef
+	for _, lv := range assign.LValues {
+		ref, ok := lv.(*VariableRef)
+		if ok && len(ref.Name.Package) == 0 {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// assignIncrement assigns the compile-time incremented loop variables
+// of env to the loop variables. The values hold the variables'
+// bindings after the previous increment and they are updated to the
+// assigned bindings. The assignment is guarded by the secret loop
+// flags and skipped if the loop has terminated at compile time.
+func assignIncrement(block *ssa.Block, ctx *Codegen, gen *ssa.Generator,
+	loop *Loop, env *Env, vars []*VariableRef,
+	values map[string]ssa.Binding) (*ssa.Block, error) {
+
+	if len(vars) == 0 {
+		return block, nil
+	}
+
+	// The continue flag does not skip the increment.
+	if set, ok := flagValue(block, loop.Continue); !ok || set {
+		setFlag(block, ctx, gen, loop.Continue, false)
+	}
+	restoreBindings(block, gen, vars, values)
+
+	assign := &Assign{
+		Point: vars[0].Point,
+	}
+	for _, ref := range vars {
+		b, ok := env.Get(ref.Name.Name)
+		if !ok {
+			return nil, ctx.Errorf(ref, "undefined: %s", ref.Name)
+		}
+		assign.LValues = append(assign.LValues, ref)
+		assign.Exprs = append(assign.Exprs, &ssaValue{
+			Point: ref.Point,
+			Value: b.Value(block, gen),
+		})
+	}
+	var stmt AST = assign
+	guarded, active := guardLoops(block, ctx, List{assign})
+	if !active {
+		return block, nil
+	}
+	if guarded != nil {
+		stmt = guarded
+	}
+	block, _, err := stmt.SSA(block, ctx, gen)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range vars {
+		if b, ok := block.Bindings.Get(ref.Name.Name); ok {
+			values[ref.Name.Name] = b
+		}
+	}
+	return block, nil
+}
+
+// restoreBindings sets the bindings of the variables' values to the
+// block.
+func restoreBindings(block *ssa.Block, gen *ssa.Generator,
+	vars []*VariableRef, values map[string]ssa.Binding) {
+
+	for _, ref := range vars {
+		b, ok := values[ref.Name.Name]
+		if !ok {
+			continue
+		}
+		v := b.Value(block, gen)
+		block.Bindings.Set(gen.NewVal(b.Name, b.Type, b.Scope), &v)
+	}
+}
+
+// SSA implements the compiler.ast.AST.SSA for for-range
+// statements. The range expression is evaluated once and the
+// statement is rewritten into an unrolled for statement over the
+// indices of the range expression. The integer range expressions must
+// be compile-time constant. The string elements are bytes.
+func (ast *ForRange) SSA(block *ssa.Block, ctx *Codegen,
+	gen *ssa.Generator) (*ssa.Block, []ssa.Value, error) {
+
+	var val ssa.Value
+	env := NewEnv(block)
+	constVal, ok, err := ast.Expr.Eval(env, ctx, gen)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		block.Bindings = env.Bindings
+		val = constVal
+	} else {
+		var v []ssa.Value
+		block, v, err = ast.Expr.SSA(block, ctx, gen)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(v) == 0 {
+			return nil, nil, ctx.Errorf(ast.Expr, "%s used as value", ast.Expr)
+		} else if len(v) > 1 {
+			return nil, nil, ctx.Errorf(ast.Expr,
+				"multiple-value %s used in single-value context", ast.Expr)
+		}
+		val = v[0]
+	}
+
+	t := val.Type
+	if t.Type == types.TPtr {
+		t = *t.ElementType
+	}
+	var count types.Size
+	var elementType types.Info
+	switch t.Type {
+	case types.TInt, types.TUint:
+		if !val.Const {
+			return nil, nil, ctx.Errorf(ast.Expr,
+				"range over non-constant integer %s", ast.Expr)
+		}
+		if ast.Value != nil {
+			return nil, nil, ctx.Errorf(ast.Value,
+				"range over %s permits only one iteration variable",
+				ast.Expr)
+		}
+		count, err = val.ConstInt()
+		if err != nil {
+			return nil, nil, ctx.Errorf(ast.Expr, "%s", err)
+		}
+
+	case types.TString:
+		count = t.Bits / types.ByteBits
+		elementType = types.Byte
+
+	case types.TArray:
+		count = t.ArraySize
+		elementType = *t.ElementType
+
+	default:
+		return nil, nil, ctx.Errorf(ast.Expr, "cannot range over %s (type %s)",
+			ast.Expr, val.Type)
+	}
+
+	// The iteration variables are defined once before the loop and
+	// assigned in each iteration.
+	if ast.Define {
+		vars := []AST{ast.Key, ast.Value}
+		varTypes := []types.Info{types.Int32, elementType}
+		for idx, v := range vars {
+			if v == nil || isBlank(v) {
+				continue
+			}
+			ref, ok := v.(*VariableRef)
+			if !ok || len(ref.Name.Package) > 0 {
+				return nil, nil, ctx.Errorf(v,
+					"non-name %s on left side of :=", v)
+			}
+			initVal, err := initValue(varTypes[idx])
+			if err != nil {
+				return nil, nil, ctx.Errorf(v, "%s", err)
+			}
+			init := gen.Constant(initVal, varTypes[idx])
+			gen.AddConstant(init)
+			block.Bindings.Set(gen.NewVal(ref.Name.Name, varTypes[idx],
+				ctx.Scope()), &init)
+		}
+	}
+
+	index := &VariableRef{
+		Point: ast.Point,
+		Name: Identifier{
+			Name: fmt.Sprintf("%%range%d", ctx.LoopID),
+		},
+	}
+	var body List
+	if ast.Key != nil && !isBlank(ast.Key) {
+		body = append(body, &Assign{
+			Point:   ast.Key.Location(),
+			LValues: []AST{ast.Key},
+			Exprs:   []AST{index},
+		})
+	}
+	if ast.Value != nil && !isBlank(ast.Value) {
+		body = append(body, &Assign{
+			Point:   ast.Value.Location(),
+			LValues: []AST{ast.Value},
+			Exprs: []AST{
+				&Index{
+					Point: ast.Value.Location(),
+					Expr: &ssaValue{
+						Point: ast.Expr.Location(),
+						Value: val,
+					},
+					Index: index,
+				},
+			},
+		})
+	}
+	body = append(body, ast.Body...)
+
+	loop := &For{
+		Point: ast.Point,
+		Label: ast.Label,
+		Init: &Assign{
+			Point:   ast.Point,
+			LValues: []AST{index},
+			Exprs: []AST{
+				&BasicLit{
+					Point: ast.Point,
+					Value: int32(0),
+				},
+			},
+			Define: true,
+		},
+		Cond: &Binary{
+			Point: ast.Point,
+			Left:  index,
+			Op:    BinaryLt,
+			Right: &BasicLit{
+				Point: ast.Point,
+				Value: int32(count),
+			},
+		},
+		Inc: &Assign{
+			Point:   ast.Point,
+			LValues: []AST{index},
+			Exprs: []AST{
+				&Binary{
+					Point: ast.Point,
+					Left:  index,
+					Op:    BinaryPlus,
+					Right: &BasicLit{
+						Point: ast.Point,
+						Value: int32(1),
+					},
+				},
+			},
+		},
+		Body: body,
+	}
+	return loop.SSA(block, ctx, gen)
+}
+
+// isBlank tests if the AST is the blank identifier.
+func isBlank(ast AST) bool {
+	ref, ok := ast.(*VariableRef)
+	return ok && len(ref.Name.Package) == 0 && ref.Name.Name == "_"
+}
+
 func isPowerOf2(ast AST, env *Env, ctx *Codegen, gen *ssa.Generator) (
 	uint64, bool) {
 
@@ -1995,3 +2736,14 @@ func (ast *Make) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
 	*ssa.Block, []ssa.Value, error) {
 	return nil, nil, fmt.Errorf("Make.SSA not supported")
 }
+
+// SSA implements the compiler.ast.AST.SSA for function literals.
+func (ast *FuncLit) SSA(block *ssa.Block, ctx *Codegen, gen *ssa.Generator) (
+	*ssa.Block, []ssa.Value, error) {
+
+	v, _, err := ast.Eval(NewEnv(block), ctx, gen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, []ssa.Value{v}, nil
+}
@@ -7,6 +7,8 @@
 package compiler
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math/big"
@@ -27,6 +29,7 @@ import (
 type Compiler struct {
 	params   *utils.Params
 	packages map[string]*ast.Package
+	sources  map[string]map[string][]byte
 	pkgPath  string
 }
 
@@ -41,6 +44,7 @@ func New(params *utils.Params) *Compiler {
 	return &Compiler{
 		params:   params,
 		packages: make(map[string]*ast.Package),
+		sources:  make(map[string]map[string][]byte),
 	}
 }
 
@@ -109,33 +113,105 @@ func (c *Compiler) compile(source string, in io.Reader, inputSizes [][]int) (
 }
 
 // StreamFile compiles the input program and uses the streaming mode
-// to garble and stream the circuit to the evaluator node.
-func (c *Compiler) StreamFile(conn *p2p.Conn, oti ot.OT, file string,
-	input []string, inputSizes [][]int) (circuit.IO, []*big.Int, error) {
+// to garble and stream the circuit to the evaluator node. The
+// optional shareFlag specifies our shares of the evaluator's
+// secret-shared input. The protocol run is aborted when the context
+// is done.
+func (c *Compiler) StreamFile(ctx context.Context, conn *p2p.Conn,
+	oti ot.OT, file string, input, shareFlag []string, inputSizes [][]int) (
+	circuit.IO, []*big.Int, error) {
 
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer f.Close()
-	return c.Stream(conn, oti, file, f, input, inputSizes)
+	return c.Stream(ctx, conn, oti, file, f, input, shareFlag, inputSizes)
 }
 
-func (c *Compiler) Stream(conn *p2p.Conn, oti ot.OT, source string,
-	in io.Reader, inputFlag []string, inputSizes [][]int) (
-	circuit.IO, []*big.Int, error) {
+// StreamProgramFile compiles the input file and returns the
+// description of the circuits that the garbler streams for the
+// program. The stream evaluator uses the description to verify the
+// streamed program, see circuit.StreamEvaluator.
+func (c *Compiler) StreamProgramFile(file string, inputSizes [][]int) (
+	*circuit.StreamProgram, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.StreamProgram(file, f, inputSizes)
+}
+
+// StreamProgram compiles the input program and returns the
+// description of the circuits that the garbler streams for the
+// program. The compiler parameters and the input sizes must match the
+// garbler's. The stream evaluator uses the description to verify the
+// streamed program, see circuit.StreamEvaluator.
+func (c *Compiler) StreamProgram(source string, in io.Reader,
+	inputSizes [][]int) (*circuit.StreamProgram, error) {
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	logger := utils.NewLogger(os.Stdout)
+	pkg, err := c.parse(source, bytes.NewReader(data), logger,
+		ast.NewPackage("main", source, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	cg := ast.NewCodegen(logger, pkg, c.packages, c.params, inputSizes)
+
+	program, _, err := pkg.Compile(cg)
+	if err != nil {
+		return nil, err
+	}
+	if len(program.Inputs) != 2 {
+		return nil,
+			fmt.Errorf("invalid program for 2-party computation: %d parties",
+				len(program.Inputs))
+	}
+	result, err := program.StreamProgram(c.params)
+	if err != nil {
+		return nil, err
+	}
+	result.Fingerprint = Fingerprint(data, c.imports(pkg), inputSizes)
+
+	return result, nil
+}
+
+// Stream compiles the input program and uses the streaming mode to
+// garble and stream the circuit to the evaluator node. The program is
+// identified to the evaluator with its Fingerprint. The protocol run
+// is aborted when the context is done.
+func (c *Compiler) Stream(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	source string, in io.Reader, inputFlag, shareFlag []string,
+	inputSizes [][]int) (circuit.IO, []*big.Int, error) {
 
 	timing := circuit.NewTiming()
 
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, nil, err
+	}
 	logger := utils.NewLogger(os.Stdout)
-	pkg, err := c.parse(source, in, logger, ast.NewPackage("main", source, nil))
+	pkg, err := c.parse(source, bytes.NewReader(data), logger,
+		ast.NewPackage("main", source, nil))
 	if err != nil {
 		return nil, nil, err
 	}
 
-	ctx := ast.NewCodegen(logger, pkg, c.packages, c.params, inputSizes)
+	fp := Fingerprint(data, c.imports(pkg), inputSizes)
+	if err := circuit.NewHandshake(oti, fp).Run(ctx, conn); err != nil {
+		return nil, nil, err
+	}
+
+	cg := ast.NewCodegen(logger, pkg, c.packages, c.params, inputSizes)
 
-	program, _, err := pkg.Compile(ctx)
+	program, _, err := pkg.Compile(cg)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -149,7 +225,16 @@ func (c *Compiler) Stream(conn *p2p.Conn, oti ot.OT, source string,
 	}
 	input, err := program.Inputs[0].Parse(inputFlag)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, circuit.Abort(conn,
+			fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err))
+	}
+	var shares *big.Int
+	if len(shareFlag) > 0 {
+		shares, err = program.Inputs[1].Parse(shareFlag)
+		if err != nil {
+			return nil, nil, circuit.Abort(conn,
+				fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err))
+		}
 	}
 
 	fmt.Printf(" + In1: %s\n", program.Inputs[0])
@@ -157,7 +242,8 @@ func (c *Compiler) Stream(conn *p2p.Conn, oti ot.OT, source string,
 	fmt.Printf(" - Out: %s\n", program.Outputs)
 	fmt.Printf(" -  In: %s\n", inputFlag)
 
-	out, bits, err := program.Stream(conn, oti, c.params, input, timing)
+	out, bits, err := program.Stream(ctx, conn, oti, c.params, input, shares,
+		timing)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -167,6 +253,91 @@ func (c *Compiler) Stream(conn *p2p.Conn, oti ot.OT, source string,
 	return out, bits, err
 }
 
+// ArithmeticFile compiles the input program and runs it with the
+// two-party arithmetic secret sharing backend on the P2P network. The
+// protocol run is aborted when the context is done.
+func (c *Compiler) ArithmeticFile(ctx context.Context, nw *p2p.Network,
+	file string, inputFlag []string, inputSizes [][]int) (
+	circuit.IO, []*big.Int, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return c.Arithmetic(ctx, nw, file, f, inputFlag, inputSizes)
+}
+
+// Arithmetic compiles the input program and runs it with the
+// two-party arithmetic secret sharing backend on the P2P network, see
+// ssa.Program.Arithmetic. The inputFlag specifies our input as the
+// player nw.ID of the program. The program is identified to the peer
+// with its Fingerprint. The protocol run is aborted when the context
+// is done.
+func (c *Compiler) Arithmetic(ctx context.Context, nw *p2p.Network,
+	source string, in io.Reader, inputFlag []string, inputSizes [][]int) (
+	circuit.IO, []*big.Int, error) {
+
+	timing := circuit.NewTiming()
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nw.Peers) != 1 {
+		return nil, nil, fmt.Errorf("%w: arithmetic backend needs 2 players",
+			circuit.ErrProtocolMismatch)
+	}
+	logger := utils.NewLogger(os.Stdout)
+	pkg, err := c.parse(source, bytes.NewReader(data), logger,
+		ast.NewPackage("main", source, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fp := Fingerprint(data, c.imports(pkg), inputSizes)
+	for _, peer := range nw.Peers {
+		hs := circuit.NewHandshake(peer.OT(), fp)
+		hs.Scheme = circuit.SharingScheme
+		if err := hs.Run(ctx, peer.Conn()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cg := ast.NewCodegen(logger, pkg, c.packages, c.params, inputSizes)
+
+	program, _, err := pkg.Compile(cg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timing.Sample("Compile", nil)
+
+	if len(program.Inputs) != 2 {
+		return nil, nil,
+			fmt.Errorf("invalid program for 2-party computation: %d parties",
+				len(program.Inputs))
+	}
+	input, err := program.Inputs[nw.ID].Parse(inputFlag)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err)
+	}
+
+	if c.params.Verbose {
+		for idx, arg := range program.Inputs {
+			if idx == nw.ID {
+				fmt.Printf(" + In%d: %s\n", idx, arg)
+			} else {
+				fmt.Printf(" - In%d: %s\n", idx, arg)
+			}
+		}
+		fmt.Printf(" - Out: %s\n", program.Outputs)
+		fmt.Printf(" -  In: %s\n", inputFlag)
+	}
+
+	return program.Arithmetic(ctx, nw, c.params, input, timing)
+}
+
 func (c *Compiler) parse(source string, in io.Reader, logger *utils.Logger,
 	pkg *ast.Package) (*ast.Package, error) {
 
@@ -284,14 +455,18 @@ func (c *Compiler) parsePkg(alias, name, source string) (*ast.Package, error) {
 			fmt.Printf(" - parsing @%v\n", fp[len(c.pkgPath):])
 		}
 
-		f, err := pkgEmbed.PkgFS.Open(fp)
+		data, err := pkgEmbed.PkgFS.ReadFile(fp)
 		if err != nil {
 			fmt.Printf("pkg not found: %s\n", err)
 			return nil, fmt.Errorf("error reading package %s: %s", name, err)
 		}
-		defer f.Close()
+		if c.sources[name] == nil {
+			c.sources[name] = make(map[string][]byte)
+		}
+		c.sources[name][fp] = data
 
-		pkg, err = c.parse(fp, f, utils.NewLogger(os.Stdout), pkg)
+		pkg, err = c.parse(fp, bytes.NewReader(data),
+			utils.NewLogger(os.Stdout), pkg)
 		if err != nil {
 			return nil, err
 		}
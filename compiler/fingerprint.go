@@ -0,0 +1,88 @@
+//
+// fingerprint.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/ast"
+)
+
+// Fingerprint computes the SHA-256 fingerprint of the QCL program
+// source, the sources of the packages that the program imports, and
+// the input sizes of the parties. The imports map the package file
+// names to their sources. The streaming mode handshake uses the
+// fingerprint to verify that the garbler and the evaluator agree on
+// the streamed program.
+func Fingerprint(source []byte, imports map[string][]byte,
+	inputSizes [][]int) []byte {
+
+	h := sha256.New()
+	writeData(h, source)
+
+	var names []string
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeUint32(h, len(names))
+	for _, name := range names {
+		writeData(h, []byte(name))
+		writeData(h, imports[name])
+	}
+
+	for _, sizes := range inputSizes {
+		writeUint32(h, len(sizes))
+		for _, size := range sizes {
+			writeUint32(h, size)
+		}
+	}
+	return h.Sum(nil)
+}
+
+func writeUint32(h hash.Hash, v int) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(v))
+	h.Write(buf[:])
+}
+
+func writeData(h hash.Hash, data []byte) {
+	writeUint32(h, len(data))
+	h.Write(data)
+}
+
+// imports returns the sources of the packages that the package pkg
+// imports directly or indirectly. The packages must be parsed.
+func (c *Compiler) imports(pkg *ast.Package) map[string][]byte {
+	result := make(map[string][]byte)
+	seen := make(map[string]bool)
+
+	var walk func(pkg *ast.Package)
+	walk = func(pkg *ast.Package) {
+		for alias, name := range pkg.Imports {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			for file, data := range c.sources[name] {
+				result[file] = data
+			}
+			imported, ok := c.packages[alias]
+			if ok {
+				walk(imported)
+			}
+		}
+	}
+	walk(pkg)
+
+	return result
+}
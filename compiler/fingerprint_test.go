@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var mulCode = `
+package main
+
+func main(a, b int32) int32 {
+    return a * b
+}
+`
+
+func TestFingerprintMismatch(t *testing.T) {
+	gr, ew := io.Pipe()
+	er, gw := io.Pipe()
+
+	gio := newReadWriter(gr, gw)
+	eio := newReadWriter(er, ew)
+
+	gerr := make(chan error)
+	go func() {
+		_, _, err := New(utils.NewParams()).Stream(context.Background(),
+			p2p.NewConn(gio), ot.NewCO(), "{data}",
+			strings.NewReader(mulCode), []string{"1"}, nil, nil)
+		gerr <- err
+	}()
+
+	// The evaluator expects a different program.
+	fp := Fingerprint([]byte(recipientCode), nil, nil)
+	_, _, err := circuit.StreamEvaluator(context.Background(),
+		p2p.NewConn(eio), ot.NewCO(), &circuit.StreamProgram{
+			Fingerprint: fp,
+		}, []string{"2"}, false)
+	if !errors.Is(err, circuit.ErrProtocolMismatch) {
+		t.Errorf("StreamEvaluator: expected %v, got %v",
+			circuit.ErrProtocolMismatch, err)
+	}
+	err = <-gerr
+	if !errors.Is(err, circuit.ErrProtocolMismatch) {
+		t.Errorf("Stream: expected %v, got %v", circuit.ErrProtocolMismatch,
+			err)
+	}
+}
+
+var verifyCode = `
+package main
+
+func main(a, b int32) int32 {
+    return a + b
+}
+`
+
+func TestStreamVerify(t *testing.T) {
+	program, err := New(utils.NewParams()).StreamProgram("{data}",
+		strings.NewReader(mulCode), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Steps) == 0 {
+		t.Fatalf("no circuits in stream program")
+	}
+	fp := program.Fingerprint
+
+	// The garbler streams a different program with the evaluator's
+	// fingerprint.
+	mismatch, err := New(utils.NewParams()).StreamProgram("{data}",
+		strings.NewReader(verifyCode), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatch.Fingerprint = fp
+
+	// The garbler streams different gates for a circuit.
+	tampered := *program
+	tampered.Steps = append([]circuit.StreamStep(nil), program.Steps...)
+	tampered.Steps[0].Hash[0] ^= 1
+
+	// The garbler sends different output recipients.
+	relabeled := *program
+	relabeled.Outputs = append(circuit.IO(nil), program.Outputs...)
+	relabeled.Outputs[0].Recipient = circuit.RecipientEvaluator
+
+	tests := []struct {
+		program *circuit.StreamProgram
+		err     error
+	}{
+		{program, nil},
+		{mismatch, circuit.ErrProtocolMismatch},
+		{&tampered, circuit.ErrProtocolMismatch},
+		{&relabeled, circuit.ErrProtocolMismatch},
+	}
+	for idx, test := range tests {
+		gr, ew := io.Pipe()
+		er, gw := io.Pipe()
+
+		gio := newReadWriter(gr, gw)
+		eio := newReadWriter(er, ew)
+
+		gerr := make(chan error)
+		go func() {
+			_, _, err := New(utils.NewParams()).Stream(context.Background(),
+				p2p.NewConn(gio), ot.NewCO(), "{data}",
+				strings.NewReader(mulCode), []string{"6"}, nil, nil)
+			gerr <- err
+		}()
+
+		_, result, err := circuit.StreamEvaluator(context.Background(),
+			p2p.NewConn(eio), ot.NewCO(), test.program, []string{"7"},
+			false)
+		if test.err == nil {
+			if err != nil {
+				t.Fatalf("test %d: StreamEvaluator failed: %s", idx, err)
+			}
+			if err := <-gerr; err != nil {
+				t.Fatalf("test %d: Stream failed: %s", idx, err)
+			}
+			if len(result) != 1 || result[0].Int64() != 42 {
+				t.Errorf("test %d: got %v, expected 42", idx, result)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("test %d: StreamEvaluator: expected %v, got %v",
+				idx, test.err, err)
+		}
+		// Drain the rest of the stream so the garbler reaches the
+		// abort message.
+		go io.Copy(io.Discard, er)
+		err = <-gerr
+		if !errors.Is(err, circuit.ErrPeerAbort) {
+			t.Errorf("test %d: Stream: expected %v, got %v", idx,
+				circuit.ErrPeerAbort, err)
+		}
+	}
+}
+
+var importCode = `
+package main
+
+import (
+    "math"
+)
+
+func main(a, b uint32) uint {
+    return math.MaxUint(a, b)
+}
+`
+
+func TestFingerprintImports(t *testing.T) {
+	program, err := New(utils.NewParams()).StreamProgram("{data}",
+		strings.NewReader(importCode), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := Fingerprint([]byte(importCode), nil, nil)
+	if bytes.Equal(program.Fingerprint, fp) {
+		t.Errorf("fingerprint does not cover imported packages")
+	}
+}
@@ -38,6 +38,13 @@ const (
 	TSymConst
 	TSymType
 	TSymFor
+	TSymSwitch
+	TSymCase
+	TSymDefault
+	TSymFallthrough
+	TSymBreak
+	TSymContinue
+	TSymRange
 	TDefAssign
 	TMultEq
 	TDivEq
@@ -65,43 +72,50 @@ const (
 )
 
 var tokenTypes = map[TokenType]string{
-	TIdentifier: "identifier",
-	TConstant:   "constant",
-	TSymPackage: "package",
-	TSymImport:  "import",
-	TSymFunc:    "func",
-	TSymIf:      "if",
-	TSymElse:    "else",
-	TSymReturn:  "return",
-	TSymStruct:  "struct",
-	TSymVar:     "var",
-	TSymConst:   "const",
-	TSymType:    "type",
-	TSymFor:     "for",
-	TDefAssign:  ":=",
-	TMultEq:     "*=",
-	TDivEq:      "/=",
-	TLshiftEq:   "<<=",
-	TLshift:     "<<",
-	TRshiftEq:   ">>=",
-	TRshift:     ">>",
-	TPlusPlus:   "++",
-	TPlusEq:     "+=",
-	TMinusMinus: "--",
-	TMinusEq:    "-=",
-	TOrEq:       "|=",
-	TXorEq:      "^=",
-	TAndEq:      "&=",
-	TLt:         "<",
-	TLe:         "<=",
-	TGt:         ">",
-	TGe:         ">=",
-	TEq:         "==",
-	TNeq:        "!=",
-	TAnd:        "&&",
-	TOr:         "||",
-	TBitClear:   "&^",
-	TSend:       "<-",
+	TIdentifier:     "identifier",
+	TConstant:       "constant",
+	TSymPackage:     "package",
+	TSymImport:      "import",
+	TSymFunc:        "func",
+	TSymIf:          "if",
+	TSymElse:        "else",
+	TSymReturn:      "return",
+	TSymStruct:      "struct",
+	TSymVar:         "var",
+	TSymConst:       "const",
+	TSymType:        "type",
+	TSymFor:         "for",
+	TSymSwitch:      "switch",
+	TSymCase:        "case",
+	TSymDefault:     "default",
+	TSymFallthrough: "fallthrough",
+	TSymBreak:       "break",
+	TSymContinue:    "continue",
+	TSymRange:       "range",
+	TDefAssign:      ":=",
+	TMultEq:         "*=",
+	TDivEq:          "/=",
+	TLshiftEq:       "<<=",
+	TLshift:         "<<",
+	TRshiftEq:       ">>=",
+	TRshift:         ">>",
+	TPlusPlus:       "++",
+	TPlusEq:         "+=",
+	TMinusMinus:     "--",
+	TMinusEq:        "-=",
+	TOrEq:           "|=",
+	TXorEq:          "^=",
+	TAndEq:          "&=",
+	TLt:             "<",
+	TLe:             "<=",
+	TGt:             ">",
+	TGe:             ">=",
+	TEq:             "==",
+	TNeq:            "!=",
+	TAnd:            "&&",
+	TOr:             "||",
+	TBitClear:       "&^",
+	TSend:           "<-",
 }
 
 func (t TokenType) String() string {
@@ -166,17 +180,24 @@ func (t TokenType) UnaryType() ast.UnaryType {
 }
 
 var symbols = map[string]TokenType{
-	"import":  TSymImport,
-	"const":   TSymConst,
-	"type":    TSymType,
-	"for":     TSymFor,
-	"else":    TSymElse,
-	"func":    TSymFunc,
-	"if":      TSymIf,
-	"package": TSymPackage,
-	"return":  TSymReturn,
-	"struct":  TSymStruct,
-	"var":     TSymVar,
+	"import":      TSymImport,
+	"const":       TSymConst,
+	"type":        TSymType,
+	"for":         TSymFor,
+	"else":        TSymElse,
+	"func":        TSymFunc,
+	"if":          TSymIf,
+	"package":     TSymPackage,
+	"return":      TSymReturn,
+	"struct":      TSymStruct,
+	"var":         TSymVar,
+	"switch":      TSymSwitch,
+	"case":        TSymCase,
+	"default":     TSymDefault,
+	"fallthrough": TSymFallthrough,
+	"break":       TSymBreak,
+	"continue":    TSymContinue,
+	"range":       TSymRange,
 }
 
 // Token specifies an input token.
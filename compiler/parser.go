@@ -9,6 +9,7 @@ package compiler
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -22,6 +23,8 @@ type Parser struct {
 	logger   *utils.Logger
 	lexer    *Lexer
 	pkg      *ast.Package
+	funcName string
+	funcLits int
 }
 
 // NewParser creates a new parser.
@@ -517,80 +520,138 @@ func (p *Parser) parseFunc(annotations ast.Annotations) (*ast.Func, error) {
 	if err != nil {
 		return nil, err
 	}
+	p.funcName = name.StrVal
+	p.funcLits = 0
 
-	_, err = p.needToken('(')
+	arguments, returnValues, namedReturnValues, err := p.parseSignature(false)
 	if err != nil {
 		return nil, err
 	}
+	_, err = p.needToken('{')
+	if err != nil {
+		return nil, err
+	}
+
+	body, end, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	p.funcName = ""
+
+	return ast.NewFunc(name.From, name.StrVal, arguments, returnValues,
+		namedReturnValues, body, end, annotations), nil
+}
+
+// Signature  = Parameters [ Result ] .
+// Result     = Parameters | Type .
+// Parameters = "(" [ ParameterList [ "," ] ] ")" .
+//
+// The parameters of function types can omit their names.
+func (p *Parser) parseSignature(isType bool) (
+	[]*ast.Variable, []*ast.Variable, bool, error) {
+
+	_, err := p.needToken('(')
+	if err != nil {
+		return nil, nil, false, err
+	}
 
 	// Argument list.
 
 	var arguments []*ast.Variable
+	var unnamed []*ast.TypeInfo
 
 	t, err := p.lexer.Get()
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 	if t.Type != ')' {
 		p.lexer.Unget(t)
 		for {
-			t, err = p.needToken(TIdentifier)
+			// Argument name or type of unnamed argument.
+			ti, err := p.parseType()
 			if err != nil {
-				return nil, err
+				return nil, nil, false, err
 			}
-			arg := &ast.Variable{
-				Point: t.From,
-				Name:  t.StrVal,
-			}
-
 			t, err = p.lexer.Get()
 			if err != nil {
-				return nil, err
-			}
-			if t.Type == ',' {
-				arguments = append(arguments, arg)
-				continue
+				return nil, nil, false, err
 			}
-			p.lexer.Unget(t)
+			if t.Type == ',' || t.Type == ')' {
+				unnamed = append(unnamed, ti)
+			} else {
+				p.lexer.Unget(t)
+				if !ti.IsIdentifier() {
+					return nil, nil, false, p.errf(t.From,
+						"unexpected %s, expecting comma or )", t)
+				}
 
-			// Type.
-			typeInfo, err := p.parseType()
-			if err != nil {
-				return nil, err
-			}
-			arg.Type = typeInfo
+				// Type.
+				typeInfo, err := p.parseType()
+				if err != nil {
+					return nil, nil, false, err
+				}
 
-			// All untyped arguments get this type.
-			for i := len(arguments) - 1; i >= 0; i-- {
-				if arguments[i].Type != nil {
-					break
+				// All untyped arguments get this type.
+				for _, id := range unnamed {
+					if !id.IsIdentifier() {
+						return nil, nil, false, p.errf(id.Point,
+							"mixed named and unnamed parameters")
+					}
+					arguments = append(arguments, &ast.Variable{
+						Point: id.Point,
+						Name:  id.Name.Name,
+						Type:  typeInfo,
+					})
 				}
-				arguments[i].Type = typeInfo
-			}
+				unnamed = nil
 
-			// Append new argument.
-			arguments = append(arguments, arg)
+				// Append new argument.
+				arguments = append(arguments, &ast.Variable{
+					Point: ti.Point,
+					Name:  ti.Name.Name,
+					Type:  typeInfo,
+				})
 
-			t, err = p.lexer.Get()
-			if err != nil {
-				return nil, err
+				t, err = p.lexer.Get()
+				if err != nil {
+					return nil, nil, false, err
+				}
 			}
 			if t.Type == ')' {
 				break
 			}
 			if t.Type != ',' {
-				return nil, p.errUnexpected(t, ',')
+				return nil, nil, false, p.errUnexpected(t, ',')
 			}
 		}
 	}
+	if len(unnamed) > 0 {
+		if len(arguments) > 0 {
+			return nil, nil, false, p.errf(unnamed[0].Point,
+				"mixed named and unnamed parameters")
+		}
+		if !isType {
+			return nil, nil, false, p.errf(unnamed[0].Point,
+				"missing parameter name")
+		}
+		for _, ti := range unnamed {
+			arguments = append(arguments, &ast.Variable{
+				Point: ti.Point,
+				Type:  ti,
+			})
+		}
+	}
 
 	// Return values.
 	var returnValues []*ast.Variable
 	var namedReturnValues bool
 
+	if !p.sameLine(t.To) {
+		return arguments, nil, false, nil
+	}
 	n, err := p.lexer.Get()
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 	switch n.Type {
 	case '(':
@@ -599,12 +660,12 @@ func (p *Parser) parseFunc(annotations ast.Annotations) (*ast.Func, error) {
 		for {
 			typeInfo, err := p.parseType()
 			if err != nil {
-				return nil, err
+				return nil, nil, false, err
 			}
 			// Peek next token.
 			n, err = p.lexer.Get()
 			if err != nil {
-				return nil, err
+				return nil, nil, false, err
 			}
 			p.lexer.Unget(n)
 
@@ -614,7 +675,7 @@ func (p *Parser) parseFunc(annotations ast.Annotations) (*ast.Func, error) {
 
 			case ')':
 				if namedReturnValues {
-					return nil, p.errf(typeInfo.Point,
+					return nil, nil, false, p.errf(typeInfo.Point,
 						"mixing named and unnamed return values")
 				}
 				identifiers = append(identifiers, typeInfo)
@@ -629,21 +690,21 @@ func (p *Parser) parseFunc(annotations ast.Annotations) (*ast.Func, error) {
 				// typeInfo is named return variable and the next
 				// component is its type.
 				if !typeInfo.IsIdentifier() {
-					return nil, p.errf(n.From,
+					return nil, nil, false, p.errf(n.From,
 						"unexpected %s, expecting comma or )", n)
 				}
 				identifiers = append(identifiers, typeInfo)
 
 				typeInfo, err = p.parseType()
 				if err != nil {
-					return nil, err
+					return nil, nil, false, err
 				}
 				// Add current list of identifiers to return
 				// values. All elements in identifiers must be
 				// identifiers.
 				for _, id := range identifiers {
 					if !id.IsIdentifier() {
-						return nil, p.errf(id.Point,
+						return nil, nil, false, p.errf(id.Point,
 							"mixing named and unnamed return values")
 					}
 					returnValues = append(returnValues, &ast.Variable{
@@ -656,45 +717,32 @@ func (p *Parser) parseFunc(annotations ast.Annotations) (*ast.Func, error) {
 			}
 			n, err = p.lexer.Get()
 			if err != nil {
-				return nil, err
+				return nil, nil, false, err
 			}
 			if n.Type == ')' {
 				break
 			}
 			if n.Type != ',' {
-				return nil, p.errUnexpected(n, ',')
+				return nil, nil, false, p.errUnexpected(n, ',')
 			}
 		}
-		_, err = p.needToken('{')
-		if err != nil {
-			return nil, err
-		}
-
-	case '{':
 
-	default:
+	case TIdentifier, '[', '*', TSymFunc:
 		p.lexer.Unget(n)
 		typeInfo, err := p.parseType()
 		if err != nil {
-			return nil, err
+			return nil, nil, false, err
 		}
 		returnValues = append(returnValues, &ast.Variable{
 			Point: n.From,
 			Type:  typeInfo,
 		})
-		_, err = p.needToken('{')
-		if err != nil {
-			return nil, err
-		}
-	}
 
-	body, end, err := p.parseBlock()
-	if err != nil {
-		return nil, err
+	default:
+		p.lexer.Unget(n)
 	}
 
-	return ast.NewFunc(name.From, name.StrVal, arguments, returnValues,
-		namedReturnValues, body, end, annotations), nil
+	return arguments, returnValues, namedReturnValues, nil
 }
 
 func (p *Parser) parseBlock() (ast.List, utils.Point, error) {
@@ -849,37 +897,89 @@ func (p *Parser) parseStatement(needLBrace bool) (ast.AST, error) {
 		}, nil
 
 	case TSymFor:
-		var init ast.AST
+		max, err := p.parseMaxIterations(tStmt.From)
+		if err != nil {
+			return nil, err
+		}
+		var init, cond, inc ast.AST
 		n, err := p.lexer.Get()
 		if err != nil {
 			return nil, err
 		}
-		p.lexer.Unget(n)
-		if n.Type != ';' {
-			init, err = p.parseStatement(false)
+		switch n.Type {
+		case TSymRange:
+			expr, err := p.parseExpr(true)
+			if err != nil {
+				return nil, err
+			}
+			init = &ast.ForRange{
+				Point: n.From,
+				Expr:  expr,
+			}
+
+		case ';', '{':
+			p.lexer.Unget(n)
+
+		default:
+			p.lexer.Unget(n)
+			init, err = p.parseSimpleStatement(true, true)
 			if err != nil {
 				return nil, err
 			}
 		}
-		_, err = p.needToken(';')
-		if err != nil {
-			return nil, err
-		}
-		cond, err := p.parseExpr(false)
-		if err != nil {
-			return nil, err
-		}
-		_, err = p.needToken(';')
-		if err != nil {
-			return nil, err
+		if r, ok := init.(*ast.ForRange); ok {
+			if max > 0 {
+				return nil, p.errf(tStmt.From,
+					"@MaxIterations not supported for range loops")
+			}
+			_, err = p.needToken('{')
+			if err != nil {
+				return nil, err
+			}
+			r.Point = tStmt.From
+			r.Body, _, err = p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+			return r, nil
 		}
-		inc, err := p.parseStatement(true)
+		n, err = p.lexer.Get()
 		if err != nil {
 			return nil, err
 		}
-		_, err = p.needToken('{')
-		if err != nil {
-			return nil, err
+		if n.Type == '{' {
+			// Condition-only and infinite loops.
+			if init != nil {
+				list, ok := init.(ast.List)
+				if !ok || len(list) != 1 {
+					return nil, p.errf(tStmt.From,
+						"expected for loop condition")
+				}
+				cond = list[0]
+				init = nil
+			}
+		} else {
+			p.lexer.Unget(n)
+			_, err = p.needToken(';')
+			if err != nil {
+				return nil, err
+			}
+			cond, err = p.parseExpr(false)
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.needToken(';')
+			if err != nil {
+				return nil, err
+			}
+			inc, err = p.parseStatement(true)
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.needToken('{')
+			if err != nil {
+				return nil, err
+			}
 		}
 		body, _, err := p.parseBlock()
 		if err != nil {
@@ -887,111 +987,322 @@ func (p *Parser) parseStatement(needLBrace bool) (ast.AST, error) {
 		}
 		return &ast.For{
 			Point: tStmt.From,
+			Max:   max,
 			Init:  init,
 			Cond:  cond,
 			Inc:   inc,
 			Body:  body,
 		}, nil
 
-	default:
-		p.lexer.Unget(tStmt)
-		lvalues, err := p.parseExprList(needLBrace)
+	case TSymSwitch:
+		var expr ast.AST
+		n, err := p.lexer.Get()
 		if err != nil {
 			return nil, err
 		}
-		t, err := p.lexer.Get()
+		p.lexer.Unget(n)
+		if n.Type != '{' {
+			expr, err = p.parseExpr(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = p.needToken('{')
 		if err != nil {
 			return nil, err
 		}
-		switch t.Type {
-		case '=', TDefAssign:
-			values, err := p.parseExprList(needLBrace)
+		cases, err := p.parseCases()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Switch{
+			Point: tStmt.From,
+			Expr:  expr,
+			Cases: cases,
+		}, nil
+
+	case TSymFallthrough:
+		return nil, p.errf(tStmt.From, "fallthrough statement not supported")
+
+	case TSymBreak, TSymContinue:
+		var label string
+		if p.sameLine(tStmt.To) {
+			t, err := p.lexer.Get()
 			if err != nil {
 				return nil, err
 			}
-			return &ast.Assign{
-				Point:   t.From,
-				LValues: lvalues,
-				Exprs:   values,
-				Define:  t.Type == TDefAssign,
+			if t.Type == TIdentifier {
+				label = t.StrVal
+			} else {
+				p.lexer.Unget(t)
+			}
+		}
+		if tStmt.Type == TSymBreak {
+			return &ast.Break{
+				Point: tStmt.From,
+				Label: label,
 			}, nil
+		}
+		return &ast.Continue{
+			Point: tStmt.From,
+			Label: label,
+		}, nil
 
-		case TPlusEq, TMinusEq, TMultEq, TDivEq, TOrEq, TXorEq, TAndEq,
-			TLshiftEq, TRshiftEq:
-			if len(lvalues) != 1 {
-				return nil, p.errf(tStmt.From, "expected 1 expression")
-			}
-			var op ast.BinaryType
-			switch t.Type {
-			case TPlusEq:
-				op = ast.BinaryPlus
-			case TMinusEq:
-				op = ast.BinaryMinus
-			case TMultEq:
-				op = ast.BinaryMult
-			case TDivEq:
-				op = ast.BinaryDiv
-			case TOrEq:
-				op = ast.BinaryBor
-			case TXorEq:
-				op = ast.BinaryBxor
-			case TAndEq:
-				op = ast.BinaryBand
-			case TLshiftEq:
-				op = ast.BinaryLshift
-			case TRshiftEq:
-				op = ast.BinaryRshift
-			default:
-				panic(t.Type)
-			}
-			value, err := p.parseExpr(needLBrace)
+	default:
+		p.lexer.Unget(tStmt)
+		return p.parseSimpleStatement(needLBrace, false)
+	}
+}
+
+// parseSimpleStatement parses assignments, increment and decrement
+// statements, and expression statements. If rangeOk is true, the
+// statement can be a for statement's range clause which is returned
+// as an ast.ForRange without the loop body.
+func (p *Parser) parseSimpleStatement(needLBrace, rangeOk bool) (
+	ast.AST, error) {
+
+	first, err := p.lexer.Get()
+	if err != nil {
+		return nil, err
+	}
+	p.lexer.Unget(first)
+	start := first.From
+
+	lvalues, err := p.parseExprList(needLBrace)
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.lexer.Get()
+	if err != nil {
+		return nil, err
+	}
+	switch t.Type {
+	case '=', TDefAssign:
+		if rangeOk {
+			n, err := p.lexer.Get()
 			if err != nil {
 				return nil, err
 			}
-			return &ast.Assign{
-				Point:   t.From,
-				LValues: lvalues,
-				Exprs: []ast.AST{
-					&ast.Binary{
+			if n.Type == TSymRange {
+				if len(lvalues) > 2 {
+					return nil, p.errf(start,
+						"range clause permits at most two iteration variables")
+				}
+				expr, err := p.parseExpr(true)
+				if err != nil {
+					return nil, err
+				}
+				r := &ast.ForRange{
+					Point:  start,
+					Key:    lvalues[0],
+					Define: t.Type == TDefAssign,
+					Expr:   expr,
+				}
+				if len(lvalues) > 1 {
+					r.Value = lvalues[1]
+				}
+				return r, nil
+			}
+			p.lexer.Unget(n)
+		}
+		values, err := p.parseExprList(needLBrace)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Assign{
+			Point:   t.From,
+			LValues: lvalues,
+			Exprs:   values,
+			Define:  t.Type == TDefAssign,
+		}, nil
+
+	case TPlusEq, TMinusEq, TMultEq, TDivEq, TOrEq, TXorEq, TAndEq,
+		TLshiftEq, TRshiftEq:
+		if len(lvalues) != 1 {
+			return nil, p.errf(start, "expected 1 expression")
+		}
+		var op ast.BinaryType
+		switch t.Type {
+		case TPlusEq:
+			op = ast.BinaryPlus
+		case TMinusEq:
+			op = ast.BinaryMinus
+		case TMultEq:
+			op = ast.BinaryMult
+		case TDivEq:
+			op = ast.BinaryDiv
+		case TOrEq:
+			op = ast.BinaryBor
+		case TXorEq:
+			op = ast.BinaryBxor
+		case TAndEq:
+			op = ast.BinaryBand
+		case TLshiftEq:
+			op = ast.BinaryLshift
+		case TRshiftEq:
+			op = ast.BinaryRshift
+		default:
+			panic(t.Type)
+		}
+		value, err := p.parseExpr(needLBrace)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Assign{
+			Point:   t.From,
+			LValues: lvalues,
+			Exprs: []ast.AST{
+				&ast.Binary{
+					Point: t.From,
+					Left:  lvalues[0],
+					Op:    op,
+					Right: value,
+				},
+			},
+		}, nil
+
+	case TPlusPlus, TMinusMinus:
+		if len(lvalues) != 1 {
+			return nil, p.errf(start, "expected 1 expression")
+		}
+
+		var op ast.BinaryType
+		if t.Type == TPlusPlus {
+			op = ast.BinaryPlus
+		} else {
+			op = ast.BinaryMinus
+		}
+		return &ast.Assign{
+			Point:   t.From,
+			LValues: lvalues,
+			Exprs: []ast.AST{
+				&ast.Binary{
+					Point: t.From,
+					Left:  lvalues[0],
+					Op:    op,
+					Right: &ast.BasicLit{
 						Point: t.From,
-						Left:  lvalues[0],
-						Op:    op,
-						Right: value,
+						Value: int32(1),
 					},
 				},
-			}, nil
+			},
+		}, nil
+
+	case ':':
+		var ref *ast.VariableRef
+		if len(lvalues) == 1 {
+			ref, _ = lvalues[0].(*ast.VariableRef)
+		}
+		if ref == nil || len(ref.Name.Package) > 0 {
+			return nil, p.errf(t.From, "unexpected %s", t)
+		}
+		stmt, err := p.parseStatement(needLBrace)
+		if err != nil {
+			return nil, err
+		}
+		switch s := stmt.(type) {
+		case *ast.For:
+			s.Label = ref.Name.Name
+			if s.Max == 0 {
+				s.Max, err = p.parseMaxIterations(start)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case *ast.ForRange:
+			s.Label = ref.Name.Name
+		case *ast.Switch:
+			s.Label = ref.Name.Name
+		default:
+			return nil, p.errf(start,
+				"label %s must label a for or switch statement",
+				ref.Name.Name)
+		}
+		return stmt, nil
+
+	default:
+		p.lexer.Unget(t)
+		return ast.List(lvalues), nil
+	}
+}
+
+// parseMaxIterations parses the @MaxIterations annotation of the
+// statement at the location. The annotation specifies the public
+// maximum number of iterations for loops with secret conditions. The
+// function returns 0 if the statement does not have the annotation.
+func (p *Parser) parseMaxIterations(loc utils.Point) (int, error) {
+	for _, ann := range p.lexer.Annotations(loc) {
+		parts := strings.Fields(ann)
+		if len(parts) == 0 || parts[0] != "@MaxIterations" {
+			continue
+		}
+		if len(parts) != 2 {
+			return 0, p.errf(loc, "invalid @MaxIterations annotation: %s",
+				strings.TrimSpace(ann))
+		}
+		max, err := strconv.Atoi(parts[1])
+		if err != nil || max <= 0 {
+			return 0, p.errf(loc, "invalid @MaxIterations: %s", parts[1])
+		}
+		return max, nil
+	}
+	return 0, nil
+}
 
-		case TPlusPlus, TMinusMinus:
-			if len(lvalues) != 1 {
-				return nil, p.errf(tStmt.From, "expected 1 expression")
+// parseCases parses the case clauses of a switch statement up to the
+// closing '}'.
+func (p *Parser) parseCases() ([]*ast.Case, error) {
+	var cases []*ast.Case
+	var def *ast.Case
+	for {
+		t, err := p.lexer.Get()
+		if err != nil {
+			return nil, err
+		}
+		c := &ast.Case{
+			Point: t.From,
+		}
+		switch t.Type {
+		case '}':
+			return cases, nil
+
+		case TSymCase:
+			c.Exprs, err = p.parseExprList(false)
+			if err != nil {
+				return nil, err
 			}
 
-			var op ast.BinaryType
-			if t.Type == TPlusPlus {
-				op = ast.BinaryPlus
-			} else {
-				op = ast.BinaryMinus
+		case TSymDefault:
+			if def != nil {
+				return nil, p.errf(t.From,
+					"multiple defaults in switch (first at %s)", def.Point)
 			}
-			return &ast.Assign{
-				Point:   t.From,
-				LValues: lvalues,
-				Exprs: []ast.AST{
-					&ast.Binary{
-						Point: t.From,
-						Left:  lvalues[0],
-						Op:    op,
-						Right: &ast.BasicLit{
-							Point: t.From,
-							Value: int32(1),
-						},
-					},
-				},
-			}, nil
+			def = c
 
 		default:
+			return nil, p.errf(t.From,
+				"unexpected %s, expected case or default", t)
+		}
+		_, err = p.needToken(':')
+		if err != nil {
+			return nil, err
+		}
+		for {
+			t, err := p.lexer.Get()
+			if err != nil {
+				return nil, err
+			}
 			p.lexer.Unget(t)
-			return ast.List(lvalues), nil
+			if t.Type == TSymCase || t.Type == TSymDefault || t.Type == '}' {
+				break
+			}
+			stmt, err := p.parseStatement(false)
+			if err != nil {
+				return nil, err
+			}
+			c.Body = append(c.Body, stmt)
 		}
+		cases = append(cases, c)
 	}
 }
 
@@ -1468,6 +1779,9 @@ func (p *Parser) parseOperand(needLBrace bool) (ast.AST, error) {
 			return nil, p.errf(n.From, "unexpected token '%s'", n.Type)
 		}
 
+	case TSymFunc: // FunctionLit
+		return p.parseFuncLit(t)
+
 	case '(': // '(' Expression ')'
 		expr, err := p.parseExpr(false)
 		if err != nil {
@@ -1485,6 +1799,31 @@ func (p *Parser) parseOperand(needLBrace bool) (ast.AST, error) {
 	}
 }
 
+// FunctionLit = "func" Signature FunctionBody .
+func (p *Parser) parseFuncLit(t *Token) (ast.AST, error) {
+	arguments, returnValues, namedReturnValues, err := p.parseSignature(false)
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.needToken('{')
+	if err != nil {
+		return nil, err
+	}
+	body, end, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	p.funcLits++
+	name := fmt.Sprintf("func%d", p.funcLits)
+	if len(p.funcName) > 0 {
+		name = p.funcName + "." + name
+	}
+
+	return ast.NewFuncLit(t.From, ast.NewFunc(t.From, name, arguments,
+		returnValues, namedReturnValues, body, end, nil)), nil
+}
+
 func (p *Parser) parseArrayCast(typeInfo *ast.TypeInfo) (ast.AST, error) {
 	expr, err := p.parseExpr(false)
 	if err != nil {
@@ -1643,7 +1982,8 @@ func (p *Parser) parseCompositeLitValue(typeInfo *ast.TypeInfo) (
 
 // Type      = TypeName | TypeLit | "(" Type ")" .
 // TypeName  = identifier | QualifiedIdent .
-// TypeLit   = ArrayType | StructType | PointerType | SliceType .
+// TypeLit   = ArrayType | StructType | PointerType | SliceType | FuncType .
+// FuncType  = "func" Signature .
 func (p *Parser) parseType() (*ast.TypeInfo, error) {
 	t, err := p.lexer.Get()
 	if err != nil {
@@ -1738,6 +2078,18 @@ func (p *Parser) parseType() (*ast.TypeInfo, error) {
 			ElementType: elType,
 		}, nil
 
+	case TSymFunc:
+		params, results, _, err := p.parseSignature(true)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.TypeInfo{
+			Point:   t.From,
+			Type:    ast.TypeFunc,
+			Params:  params,
+			Results: results,
+		}, nil
+
 	default:
 		return nil, p.errf(t.From,
 			"unexpected token '%s' while parsing type", t)
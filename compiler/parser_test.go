@@ -112,6 +112,98 @@ func max(a, b int4) int4 {
 `,
 	`
 package main
+func main(a, b int4) int4 {
+    switch a {
+    case 1, 2:
+        return b
+    case 3:
+    default:
+        a++
+    }
+    switch {
+    case a > b:
+        return a
+    }
+    return b
+}
+`,
+	`
+package main
+
+func main(a, b int) int {
+outer:
+    for i := 0; i < 10; i++ {
+        for j := 0; j < 10; j++ {
+            if a == j {
+                continue outer
+            }
+            if b == j {
+                break outer
+            }
+            if a == b {
+                break
+            }
+            continue
+        }
+    }
+    return a
+}
+`,
+	`
+package main
+
+func main(a, b []int) int {
+    var sum int
+    for i, v := range a {
+        sum += i * v
+    }
+    for _, v := range b {
+        sum += v
+    }
+    for i := range a {
+        sum += i
+    }
+    for range 10 {
+        sum++
+    }
+    return sum
+}
+`,
+	`
+package main
+
+func main(a, b int) int {
+    // @MaxIterations 10
+    for a < b {
+        a++
+    }
+    // @MaxIterations 10
+    for {
+        if a > b {
+            break
+        }
+        a += 2
+    }
+    return a
+}
+`,
+	`
+package main
+
+func apply(f func(int, int) int, less func(a, b int) bool) bool {
+    return less(f(1, 2), 4)
+}
+
+func main(a, b int) (int, bool) {
+    add := func(x, y int) int {
+        return x + y
+    }
+    var sub func(x, y int) (r int)
+    return add(a, b), apply(add, func(a, b int) bool { return a < b })
+}
+`,
+	`
+package main
 
 type Foo struct {
 	A []byte ` + "`json:\"AButDifferent\"`" + `
@@ -0,0 +1,311 @@
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package compiler
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var recipientCode = `
+package main
+
+// @Recipient garbler evaluator both
+func main(a, b int8) (int8, int8, int8) {
+    return a + b, a - b, a * b
+}
+`
+
+var recipientTests = []struct {
+	g, e      int64
+	garbler   []*big.Int
+	evaluator []*big.Int
+}{
+	{
+		g:         11,
+		e:         3,
+		garbler:   []*big.Int{big.NewInt(14), nil, big.NewInt(33)},
+		evaluator: []*big.Int{nil, big.NewInt(8), big.NewInt(33)},
+	},
+	{
+		g:         0,
+		e:         1,
+		garbler:   []*big.Int{big.NewInt(1), nil, big.NewInt(0)},
+		evaluator: []*big.Int{nil, big.NewInt(0xff), big.NewInt(0)},
+	},
+}
+
+func checkRecipientResult(t *testing.T, party string,
+	result, expected []*big.Int) {
+
+	if len(result) != len(expected) {
+		t.Fatalf("%s: got %d results, expected %d", party, len(result),
+			len(expected))
+	}
+	for idx, r := range result {
+		if expected[idx] == nil {
+			if r != nil {
+				t.Errorf("%s: result %d delivered: %v", party, idx, r)
+			}
+		} else if r == nil {
+			t.Errorf("%s: result %d not delivered", party, idx)
+		} else if r.Cmp(expected[idx]) != 0 {
+			t.Errorf("%s: result %d: got %v, expected %v", party, idx,
+				r, expected[idx])
+		}
+	}
+}
+
+func TestRecipient(t *testing.T) {
+	circ, _, err := New(utils.NewParams()).Compile(recipientCode, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	expected := []circuit.Recipient{
+		circuit.RecipientGarbler,
+		circuit.RecipientEvaluator,
+		circuit.RecipientBoth,
+	}
+	for idx, r := range expected {
+		if circ.Outputs[idx].Recipient != r {
+			t.Fatalf("output %d: recipient %s, expected %s", idx,
+				circ.Outputs[idx].Recipient, r)
+		}
+	}
+
+	for _, test := range recipientTests {
+		gr, ew := io.Pipe()
+		er, gw := io.Pipe()
+
+		gio := newReadWriter(gr, gw)
+		eio := newReadWriter(er, ew)
+
+		done := make(chan []*big.Int)
+		gerr := make(chan error)
+
+		go func() {
+			result, err := circuit.Garbler(context.Background(),
+				p2p.NewConn(gio), ot.NewCO(), circ, big.NewInt(test.g), false)
+			gerr <- err
+			done <- result
+		}()
+
+		result, err := circuit.Evaluator(context.Background(), p2p.NewConn(eio),
+			ot.NewCO(), circ, big.NewInt(test.e), false)
+		if err != nil {
+			t.Fatalf("Evaluator failed: %s", err)
+		}
+		if err := <-gerr; err != nil {
+			t.Fatalf("Garbler failed: %s", err)
+		}
+		checkRecipientResult(t, "garbler", <-done, test.garbler)
+		checkRecipientResult(t, "evaluator", result, test.evaluator)
+	}
+}
+
+func TestRecipientStream(t *testing.T) {
+	program, err := New(utils.NewParams()).StreamProgram("{data}",
+		strings.NewReader(recipientCode), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range recipientTests {
+		gr, ew := io.Pipe()
+		er, gw := io.Pipe()
+
+		gio := newReadWriter(gr, gw)
+		eio := newReadWriter(er, ew)
+
+		done := make(chan []*big.Int)
+		gerr := make(chan error)
+
+		go func() {
+			_, result,
+				err := New(utils.NewParams()).Stream(context.Background(),
+				p2p.NewConn(gio), ot.NewCO(), "{data}",
+				strings.NewReader(recipientCode),
+				[]string{big.NewInt(test.g).String()}, nil, nil)
+			gerr <- err
+			done <- result
+		}()
+
+		_, result, err := circuit.StreamEvaluator(context.Background(),
+			p2p.NewConn(eio), ot.NewCO(), program,
+			[]string{big.NewInt(test.e).String()}, false)
+		if err != nil {
+			t.Fatalf("StreamEvaluator failed: %s", err)
+		}
+		if err := <-gerr; err != nil {
+			t.Fatalf("Stream failed: %s", err)
+		}
+		checkRecipientResult(t, "garbler", <-done, test.garbler)
+		checkRecipientResult(t, "evaluator", result, test.evaluator)
+	}
+}
+
+func TestRecipientInvalid(t *testing.T) {
+	for _, code := range []string{
+		`
+package main
+// @Recipient garbler
+func main(a, b int8) (int8, int8) {
+    return a, b
+}
+`,
+		`
+package main
+// @Recipient nobody
+func main(a, b int8) int8 {
+    return a + b
+}
+`,
+	} {
+		_, _, err := New(utils.NewParams()).Compile(code, nil)
+		if err == nil {
+			t.Errorf("invalid @Recipient annotation accepted:%s", code)
+		}
+	}
+}
+
+var shareCode = []string{
+	`
+package main
+
+// @Recipient shared
+func main(a, b int16) int16 {
+    return a * b
+}
+`,
+	`
+package main
+
+func main(a, b int16) int16 {
+    return a + b
+}
+`,
+}
+
+func TestShares(t *testing.T) {
+	var circs []*circuit.Circuit
+	for _, code := range shareCode {
+		circ, _, err := New(utils.NewParams()).Compile(code, nil)
+		if err != nil {
+			t.Fatalf("failed to compile: %s", err)
+		}
+		circs = append(circs, circ)
+	}
+
+	run := func(circ *circuit.Circuit, g, e, shares *big.Int) (
+		[]*big.Int, []*big.Int) {
+
+		gr, ew := io.Pipe()
+		er, gw := io.Pipe()
+
+		gio := newReadWriter(gr, gw)
+		eio := newReadWriter(er, ew)
+
+		done := make(chan []*big.Int)
+		gerr := make(chan error)
+
+		go func() {
+			result, err := circuit.GarblerShared(context.Background(),
+				p2p.NewConn(gio), ot.NewCO(), circ, g, shares, false)
+			gerr <- err
+			done <- result
+		}()
+
+		result, err := circuit.Evaluator(context.Background(), p2p.NewConn(eio),
+			ot.NewCO(), circ, e, false)
+		if err != nil {
+			t.Fatalf("Evaluator failed: %s", err)
+		}
+		if err := <-gerr; err != nil {
+			t.Fatalf("Garbler failed: %s", err)
+		}
+		return <-done, result
+	}
+
+	// Shared output: 7*6 = 42.
+	gShare, eShare := run(circs[0], big.NewInt(7), big.NewInt(6), nil)
+	value := new(big.Int).Xor(gShare[0], eShare[0])
+	if value.Int64() != 42 {
+		t.Fatalf("shares %v^%v=%v, expected 42", gShare[0], eShare[0],
+			value)
+	}
+
+	// Shared input: 100 + 42 = 142.
+	gResult, eResult := run(circs[1], big.NewInt(100), eShare[0], gShare[0])
+	for idx, result := range [][]*big.Int{gResult, eResult} {
+		if result[0].Int64() != 142 {
+			t.Errorf("party %d: got %v, expected 142", idx, result[0])
+		}
+	}
+}
+
+func TestSharesStream(t *testing.T) {
+	run := func(code string, g, e int64, shares []string) (
+		[]*big.Int, []*big.Int) {
+
+		program, err := New(utils.NewParams()).StreamProgram("{data}",
+			strings.NewReader(code), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gr, ew := io.Pipe()
+		er, gw := io.Pipe()
+
+		gio := newReadWriter(gr, gw)
+		eio := newReadWriter(er, ew)
+
+		done := make(chan []*big.Int)
+		gerr := make(chan error)
+
+		go func() {
+			_, result,
+				err := New(utils.NewParams()).Stream(context.Background(),
+				p2p.NewConn(gio), ot.NewCO(), "{data}", strings.NewReader(code),
+				[]string{big.NewInt(g).String()}, shares, nil)
+			gerr <- err
+			done <- result
+		}()
+
+		_, result, err := circuit.StreamEvaluator(context.Background(),
+			p2p.NewConn(eio), ot.NewCO(), program,
+			[]string{big.NewInt(e).String()}, false)
+		if err != nil {
+			t.Fatalf("StreamEvaluator failed: %s", err)
+		}
+		if err := <-gerr; err != nil {
+			t.Fatalf("Stream failed: %s", err)
+		}
+		return <-done, result
+	}
+
+	gShare, eShare := run(shareCode[0], 7, 6, nil)
+	value := new(big.Int).Xor(gShare[0], eShare[0])
+	if value.Int64() != 42 {
+		t.Fatalf("shares %v^%v=%v, expected 42", gShare[0], eShare[0],
+			value)
+	}
+
+	gResult, eResult := run(shareCode[1], 100, eShare[0].Int64(),
+		[]string{gShare[0].String()})
+	for idx, result := range [][]*big.Int{gResult, eResult} {
+		if result[0].Int64() != 142 {
+			t.Errorf("party %d: got %v, expected 142", idx, result[0])
+		}
+	}
+}
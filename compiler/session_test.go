@@ -0,0 +1,126 @@
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package compiler
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var sessionBid = `
+package main
+
+func main(g, e, max int32) (int32, bool) {
+    var next int32 = max
+    if g > next {
+        next = g
+    }
+    if e > next {
+        next = e
+    }
+    return next, e > g
+}
+`
+
+var sessionReveal = `
+package main
+
+func main(g, e bool, max int32) (int32, int32) {
+    return max, max
+}
+`
+
+func TestSession(t *testing.T) {
+	bid, _, err := New(utils.NewParams()).Compile(sessionBid, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	reveal, _, err := New(utils.NewParams()).Compile(sessionReveal, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	rounds := []struct {
+		circ *circuit.Circuit
+		g, e int64
+	}{
+		{bid, 10, 7},
+		{bid, 3, 42},
+		{bid, 30, 5},
+		{reveal, 0, 0},
+	}
+
+	gr, ew := io.Pipe()
+	er, gw := io.Pipe()
+
+	gio := newReadWriter(gr, gw)
+	eio := newReadWriter(er, ew)
+
+	done := make(chan []*big.Int)
+	gerr := make(chan error)
+
+	go func() {
+		session, err := circuit.NewGarblerSession(p2p.NewConn(gio),
+			ot.NewCO(), false)
+		if err != nil {
+			gerr <- err
+			return
+		}
+		for _, round := range rounds {
+			result, err := session.Round(context.Background(), round.circ,
+				big.NewInt(round.g))
+			if err != nil {
+				gerr <- err
+				return
+			}
+			done <- result
+		}
+		gerr <- nil
+	}()
+
+	session := circuit.NewEvaluatorSession(p2p.NewConn(eio), ot.NewCO(),
+		false)
+	for idx, round := range rounds {
+		result, err := session.Round(context.Background(), round.circ,
+			big.NewInt(round.e))
+		if err != nil {
+			t.Fatalf("round %d: evaluator failed: %s", idx, err)
+		}
+		gResult := <-done
+
+		if len(result) != 1 || len(gResult) != 1 {
+			t.Fatalf("round %d: unexpected results: %v, %v", idx,
+				gResult, result)
+		}
+		if result[0].Cmp(gResult[0]) != 0 {
+			t.Errorf("round %d: result mismatch: %v != %v", idx,
+				gResult[0], result[0])
+		}
+		if round.circ == bid {
+			var expected int64
+			if round.e > round.g {
+				expected = 1
+			}
+			if result[0].Int64() != expected {
+				t.Errorf("round %d: got %v, expected %v", idx, result[0],
+					expected)
+			}
+		} else if result[0].Int64() != 42 {
+			t.Errorf("round %d: max %v, expected 42", idx, result[0])
+		}
+	}
+	if err := <-gerr; err != nil {
+		t.Fatalf("garbler failed: %s", err)
+	}
+}
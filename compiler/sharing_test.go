@@ -0,0 +1,212 @@
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package compiler
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var sharingDot = `
+package main
+
+func main(a, b [4]int32) (int32, int32, bool, int32) {
+    var sum int32
+    for i := 0; i < len(a); i++ {
+        sum += a[i] * b[i]
+    }
+    poly := 3*a[0]*a[0] + 2*b[1] + 7
+    return sum, poly, sum > poly, (sum ^ poly) & 0xff
+}
+`
+
+func newSharingNetworks(t *testing.T) []*p2p.Network {
+	config := new(p2p.Config)
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Peers = append(config.Peers, p2p.PeerConfig{
+			ID:   i,
+			Addr: l.Addr().String(),
+		})
+		l.Close()
+	}
+	var networks []*p2p.Network
+	for i := 0; i < 2; i++ {
+		nw, err := p2p.NewConfigNetwork(config, i, nil)
+		if err != nil {
+			t.Fatalf("NewConfigNetwork failed: %s", err)
+		}
+		t.Cleanup(func() {
+			nw.Close()
+		})
+		networks = append(networks, nw)
+	}
+	errs := make(chan error)
+	for _, nw := range networks {
+		go func(nw *p2p.Network) {
+			errs <- nw.Connect(context.Background())
+		}(nw)
+	}
+	for range networks {
+		if err := <-errs; err != nil {
+			t.Fatalf("Connect failed: %s", err)
+		}
+	}
+	return networks
+}
+
+func TestArithmeticSharing(t *testing.T) {
+	a := []int32{3, -5, 1000, 7}
+	b := []int32{-2, 11, 65536, 9}
+
+	var sum int32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	poly := 3*a[0]*a[0] + 2*b[1] + 7
+	var greater int64
+	if sum > poly {
+		greater = 1
+	}
+	expected := []int64{
+		int64(uint32(sum)),
+		int64(uint32(poly)),
+		greater,
+		int64(uint32((sum ^ poly) & 0xff)),
+	}
+
+	inputs := [][]string{{vector(a)}, {vector(b)}}
+
+	type sharingResult struct {
+		player int
+		result []*big.Int
+		err    error
+	}
+	results := make(chan sharingResult)
+	for _, nw := range newSharingNetworks(t) {
+		go func(nw *p2p.Network) {
+			_, result, err := New(utils.NewParams()).Arithmetic(
+				context.Background(), nw, "dot.qcl",
+				strings.NewReader(sharingDot), inputs[nw.ID], nil)
+			results <- sharingResult{
+				player: nw.ID,
+				result: result,
+				err:    err,
+			}
+		}(nw)
+	}
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("player %d: %s", r.player, r.err)
+		}
+		if len(r.result) != len(expected) {
+			t.Fatalf("player %d: got %d results, expected %d",
+				r.player, len(r.result), len(expected))
+		}
+		for i, e := range expected {
+			if r.result[i].Int64() != e {
+				t.Errorf("player %d: result %d: got %v, expected %v",
+					r.player, i, r.result[i], e)
+			}
+		}
+	}
+}
+
+// vector formats the values as an array input.
+func vector(values []int32) string {
+	result := "0x"
+	for _, v := range values {
+		result += fmt.Sprintf("%08x", uint32(v))
+	}
+	return result
+}
+
+var sharingShared = `
+package main
+
+// @Recipient shared both
+func main(a, b int8) (int8, int8) {
+    return a * b, a + b
+}
+`
+
+// runArithmetic runs the program with both players and returns the
+// players' results.
+func runArithmetic(t *testing.T, code string, inputs [][]string) (
+	[2][]*big.Int, error) {
+
+	type sharingResult struct {
+		player int
+		result []*big.Int
+		err    error
+	}
+	results := make(chan sharingResult)
+	for _, nw := range newSharingNetworks(t) {
+		go func(nw *p2p.Network) {
+			_, result, err := New(utils.NewParams()).Arithmetic(
+				context.Background(), nw, "main.qcl",
+				strings.NewReader(code), inputs[nw.ID], nil)
+			results <- sharingResult{
+				player: nw.ID,
+				result: result,
+				err:    err,
+			}
+		}(nw)
+	}
+	var sorted [2][]*big.Int
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("player %d: %w", r.player, r.err)
+		}
+		sorted[r.player] = r.result
+	}
+	return sorted, firstErr
+}
+
+func TestArithmeticRecipients(t *testing.T) {
+	for _, test := range recipientTests {
+		results, err := runArithmetic(t, recipientCode, [][]string{
+			{fmt.Sprint(test.g)},
+			{fmt.Sprint(test.e)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRecipientResult(t, "player 0", results[0], test.garbler)
+		checkRecipientResult(t, "player 1", results[1], test.evaluator)
+	}
+
+	results, err := runArithmetic(t, sharingShared, [][]string{
+		{"11"}, {"3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := new(big.Int).Xor(results[0][0], results[1][0])
+	if share.Int64() != 33 {
+		t.Errorf("shared result: got %v, expected 33", share)
+	}
+	for player, result := range results {
+		if result[1].Int64() != 14 {
+			t.Errorf("player %d: result 1: got %v, expected 14", player,
+				result[1])
+		}
+	}
+}
@@ -0,0 +1,398 @@
+//
+// arithmetic.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package ssa
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Arithmetic runs the program with the two-party secret sharing
+// backend on the P2P network, see circuit.Sharing. The integer
+// additions and multiplications run in the arithmetic domain modulo
+// 2^k and all other instructions with their boolean circuits in the
+// boolean domain. The values are converted between the domains when
+// an instruction needs them in the other domain. The player 0 is the
+// garbler and the player 1 the evaluator of the output recipients:
+// the outputs are opened only to the players that their recipients
+// allow, and the players keep their shares of the shared outputs.
+// The function returns nil for the outputs that we don't learn. The
+// protocol run is aborted when the context is done; see
+// circuit.WithTimeouts for per-phase time limits.
+func (prog *Program) Arithmetic(ctx context.Context, nw *p2p.Network,
+	params *utils.Params, inputs *big.Int, timing *circuit.Timing) (
+	circuit.IO, []*big.Int, error) {
+
+	ph := circuit.NewPhases(ctx, nw.WithContext)
+	result, err := prog.arithmetic(ph, nw, params, inputs, timing)
+	err = ph.Wrap(err)
+	ph.Leave()
+
+	if err != nil {
+		for _, peer := range nw.Peers {
+			circuit.Abort(peer.Conn(), err)
+		}
+		return nil, nil, err
+	}
+	return prog.Outputs, prog.Outputs.SplitVisible(result, nw.ID == 0), nil
+}
+
+func (prog *Program) arithmetic(ph *circuit.Phases, nw *p2p.Network,
+	params *utils.Params, inputs *big.Int, timing *circuit.Timing) (
+	*big.Int, error) {
+
+	if len(prog.Inputs) != 2 {
+		return nil, fmt.Errorf("%w: %d-party program for 2 players",
+			circuit.ErrProtocolMismatch, len(prog.Inputs))
+	}
+	sharing, err := circuit.NewSharing(nw)
+	if err != nil {
+		return nil, err
+	}
+	sink := &arithSink{
+		sharing: sharing,
+	}
+
+	// Input sharing.
+	if params.Verbose {
+		fmt.Printf(" - Sharing inputs\n")
+	}
+	if err := ph.Enter(circuit.PhaseOT); err != nil {
+		return nil, err
+	}
+	shares, err := sharing.ShareInputs([]int{
+		int(prog.Inputs[0].Type.Bits),
+		int(prog.Inputs[1].Type.Bits),
+	}, inputs)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range prog.assignInputIDs() {
+		sink.setBit(id, shares[i])
+	}
+	ioStats := nw.Stats().Sum()
+	timing.Sample("Inputs", []string{circuit.FileSize(ioStats).String()})
+
+	// Program evaluation.
+	if params.Verbose {
+		fmt.Printf(" - Evaluating %d steps\n", len(prog.Steps))
+	}
+	if err := ph.Enter(circuit.PhaseTables); err != nil {
+		return nil, err
+	}
+	returnIDs, err := prog.streamCircuits(params, sink)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(returnIDs))
+	for i, id := range returnIDs {
+		out[i], err = sink.bit(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	xfer := nw.Stats().Sum() - ioStats
+	ioStats = nw.Stats().Sum()
+	timing.Sample("Eval", []string{circuit.FileSize(xfer).String(),
+		fmt.Sprintf("%d arith", sink.numArith),
+		fmt.Sprintf("%d A2B", sink.numA2B),
+		fmt.Sprintf("%d B2A", sink.numB2A)})
+
+	// Output reconstruction.
+	if err := ph.Enter(circuit.PhaseResult); err != nil {
+		return nil, err
+	}
+	out, err = sharing.OpenOutputs(prog.Outputs, out)
+	if err != nil {
+		return nil, err
+	}
+	xfer = nw.Stats().Sum() - ioStats
+	timing.Sample("Result", []string{circuit.FileSize(xfer).String()})
+
+	result := new(big.Int)
+	for i, bit := range out {
+		if bit != 0 {
+			result.SetBit(result, i, 1)
+		}
+	}
+	return result, nil
+}
+
+// arithSink evaluates the program circuits and the arithmetic
+// instructions with the secret sharing. Each wire holds a boolean
+// share, an arithmetic value, or both. A wire of an arithmetic value
+// gets its boolean share when a circuit needs it, and an arithmetic
+// instruction converts its boolean operands to arithmetic values.
+type arithSink struct {
+	sharing *circuit.Sharing
+	// bits holds our boolean shares of the wires and known tells
+	// which wires have boolean shares.
+	bits  []byte
+	known []bool
+	// arith maps the wires to the bits of their arithmetic values.
+	arith    []arithRef
+	numArith int
+	numA2B   int
+	numB2A   int
+}
+
+// arithValue is an arithmetic value modulo 2^bits.
+type arithValue struct {
+	bits  int
+	share uint64
+	wires []circuit.Wire
+}
+
+// arithRef refers to a bit of an arithmetic value.
+type arithRef struct {
+	value *arithValue
+	bit   int
+}
+
+func (sink *arithSink) grow(id circuit.Wire) {
+	for int(id) >= len(sink.bits) {
+		sink.bits = append(sink.bits, 0)
+		sink.known = append(sink.known, false)
+		sink.arith = append(sink.arith, arithRef{})
+	}
+}
+
+// setBit sets the boolean share of the wire. The wire no longer
+// belongs to an arithmetic value.
+func (sink *arithSink) setBit(id circuit.Wire, bit byte) {
+	sink.grow(id)
+	sink.bits[id] = bit
+	sink.known[id] = true
+	sink.arith[id] = arithRef{}
+}
+
+// bit returns the boolean share of the wire. If the wire has only an
+// arithmetic value, the value is converted to the boolean domain.
+func (sink *arithSink) bit(id circuit.Wire) (byte, error) {
+	sink.grow(id)
+	if sink.known[id] {
+		return sink.bits[id], nil
+	}
+	v := sink.arith[id].value
+	if v == nil {
+		return 0, fmt.Errorf("wire %d has no value", id)
+	}
+	bits, err := sink.sharing.A2B(v.bits, v.share)
+	if err != nil {
+		return 0, err
+	}
+	sink.numA2B++
+	for i, w := range v.wires {
+		ref := sink.arith[w]
+		if ref.value == v && ref.bit == i {
+			sink.bits[w] = bits[i]
+			sink.known[w] = true
+		}
+	}
+	return sink.bits[id], nil
+}
+
+func (sink *arithSink) Circuit(step int, circ *circuit.Circuit,
+	in, out []circuit.Wire) error {
+
+	shares := make([]byte, len(in))
+	for i, id := range in {
+		var err error
+		shares[i], err = sink.bit(id)
+		if err != nil {
+			return err
+		}
+	}
+	shares, err := sink.sharing.Eval(circ, shares, len(out))
+	if err != nil {
+		return err
+	}
+	for i, id := range out {
+		sink.setBit(id, shares[i])
+	}
+	return nil
+}
+
+func (sink *arithSink) Return(ids []circuit.Wire) error {
+	return nil
+}
+
+// Instr evaluates the integer additions and multiplications of up to
+// 64 bits in the arithmetic domain. The circuits zero-extend their
+// operands to the output size so the arithmetic operations are done
+// modulo 2^k where k is the output size.
+func (sink *arithSink) Instr(instr Instr, in [][]circuit.Wire,
+	out []circuit.Wire) (bool, error) {
+
+	switch instr.Op {
+	case Iadd, Uadd, Imult, Umult:
+	default:
+		return false, nil
+	}
+	k := len(out)
+	if k == 0 || k > 64 {
+		return false, nil
+	}
+	x, err := sink.operand(instr.In[0], in[0], k)
+	if err != nil {
+		return false, err
+	}
+	y, err := sink.operand(instr.In[1], in[1], k)
+	if err != nil {
+		return false, err
+	}
+
+	var z uint64
+	switch instr.Op {
+	case Iadd, Uadd:
+		z = x.share + y.share
+	default:
+		switch {
+		case x.public && y.public:
+			z = sink.sharing.Constant(k, x.value*y.value)
+		case x.public:
+			z = x.value * y.share
+		case y.public:
+			z = x.share * y.value
+		default:
+			product, err := sink.sharing.Mul(k, []uint64{x.share},
+				[]uint64{y.share})
+			if err != nil {
+				return false, err
+			}
+			z = product[0]
+		}
+	}
+	sink.numArith++
+
+	v := &arithValue{
+		bits:  k,
+		share: z & arithMask(k),
+		wires: append([]circuit.Wire(nil), out...),
+	}
+	for i, id := range out {
+		sink.grow(id)
+		sink.known[id] = false
+		sink.arith[id] = arithRef{
+			value: v,
+			bit:   i,
+		}
+	}
+	return true, nil
+}
+
+// arithOperand is an operand of an arithmetic instruction. The public
+// operands are the program constants.
+type arithOperand struct {
+	share  uint64
+	public bool
+	value  uint64
+}
+
+// operand returns our share of the instruction input zero-extended
+// or truncated to k bits. If the wires are the low k bits of an
+// arithmetic value, the value's share is used as such. Otherwise the
+// wires are converted from the boolean domain.
+func (sink *arithSink) operand(v Value, wires []circuit.Wire, k int) (
+	arithOperand, error) {
+
+	if c, ok := constUint64(v); ok {
+		c &= arithMask(len(wires))
+		return arithOperand{
+			share:  sink.sharing.Constant(k, c),
+			public: true,
+			value:  c,
+		}, nil
+	}
+	if len(wires) >= k {
+		for i := 0; i < k; i++ {
+			sink.grow(wires[i])
+		}
+		ref := sink.arith[wires[0]]
+		match := ref.value != nil
+		for i := 0; match && i < k; i++ {
+			r := sink.arith[wires[i]]
+			match = r.value == ref.value && r.bit == i
+		}
+		if match {
+			return arithOperand{
+				share: ref.value.share & arithMask(k),
+			}, nil
+		}
+	}
+	bits := make([]byte, len(wires))
+	for i, id := range wires {
+		var err error
+		bits[i], err = sink.bit(id)
+		if err != nil {
+			return arithOperand{}, err
+		}
+	}
+	share, err := sink.sharing.B2A(k, bits)
+	if err != nil {
+		return arithOperand{}, err
+	}
+	sink.numB2A++
+	return arithOperand{
+		share: share,
+	}, nil
+}
+
+// constUint64 returns the integer constant value as uint64. Negative
+// values are returned in two's complement.
+func constUint64(v Value) (uint64, bool) {
+	if !v.Const {
+		return 0, false
+	}
+	switch val := v.ConstValue.(type) {
+	case int:
+		return uint64(val), true
+	case uint:
+		return uint64(val), true
+	case int8:
+		return uint64(val), true
+	case uint8:
+		return uint64(val), true
+	case int16:
+		return uint64(val), true
+	case uint16:
+		return uint64(val), true
+	case int32:
+		return uint64(val), true
+	case uint32:
+		return uint64(val), true
+	case int64:
+		return uint64(val), true
+	case uint64:
+		return val, true
+	case *big.Int:
+		if val.IsUint64() {
+			return val.Uint64(), true
+		}
+		if val.IsInt64() {
+			return uint64(val.Int64()), true
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+}
+
+// arithMask returns the mask of the k-bit arithmetic values.
+func arithMask(k int) uint64 {
+	if k >= 64 {
+		return 0xffffffffffffffff
+	}
+	return (1 << k) - 1
+}
@@ -0,0 +1,45 @@
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package ssa
+
+import (
+	"math/big"
+	"testing"
+)
+
+var constUint64Tests = []struct {
+	value    interface{}
+	expected uint64
+	ok       bool
+}{
+	{int(-1), 0xffffffffffffffff, true},
+	{uint(42), 42, true},
+	{int8(-2), 0xfffffffffffffffe, true},
+	{uint8(200), 200, true},
+	{int16(-3), 0xfffffffffffffffd, true},
+	{uint16(60000), 60000, true},
+	{int32(-4), 0xfffffffffffffffc, true},
+	{uint32(4000000000), 4000000000, true},
+	{int64(-5), 0xfffffffffffffffb, true},
+	{uint64(0xffffffffffffffff), 0xffffffffffffffff, true},
+	{big.NewInt(-6), 0xfffffffffffffffa, true},
+	{new(big.Int).Lsh(big.NewInt(1), 64), 0, false},
+	{"42", 0, false},
+}
+
+func TestConstUint64(t *testing.T) {
+	for _, test := range constUint64Tests {
+		v, ok := constUint64(Value{
+			Const:      true,
+			ConstValue: test.value,
+		})
+		if ok != test.ok || v != test.expected {
+			t.Errorf("constUint64(%T %v)=%x,%v, expected %x,%v",
+				test.value, test.value, v, ok, test.expected, test.ok)
+		}
+	}
+}
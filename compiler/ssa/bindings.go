@@ -193,6 +193,9 @@ func (phi *Select) Equal(other BindingValue) bool {
 	if !ok {
 		return false
 	}
+	if phi == o {
+		return true
+	}
 	if !phi.Cond.Equal(&o.Cond) {
 		return false
 	}
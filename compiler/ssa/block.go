@@ -131,12 +131,13 @@ func NewReturnBindingCTX() *ReturnBindingCTX {
 	}
 }
 
+// BindingStackState holds the state of a block while resolving its
+// return binding.
 type BindingStackState struct {
 	block    *Block
 	r        int
 	vTrue    Value
 	diffTrue bool
-	parent   *Block
 }
 
 // ReturnBinding returns the return statement binding for the argument
@@ -151,124 +152,114 @@ func (b *Block) ReturnBinding(ctx *ReturnBindingCTX, name string,
 
 func (bl *Block) returnBinding(ctx *ReturnBindingCTX, name string,
 	retBlock *Block, gen *Generator) (v Value, diff, ok bool) {
-	binding, ok := ctx.Get(bl, name)
-	if ok {
-		return binding.v, binding.diff, binding.ok
-	}
-	state := &BindingStackState{
-		block:  bl,
-		r:      0,
-		parent: nil,
-	}
-	stack := []*BindingStackState{state}
+
+	stack := []*BindingStackState{{
+		block: bl,
+	}}
 
 	for len(stack) > 0 {
 		s := stack[len(stack)-1]
 		b := s.block
-		stack = stack[:len(stack)-1]
 
-		binding, bok := ctx.Get(b, name)
-		if bok {
-			v = binding.v
-			diff = binding.diff
-			ok = binding.ok
-		} else {
+		switch s.r {
+		case 0:
+			binding, bok := ctx.Get(b, name)
+			if bok {
+				v = binding.v
+				diff = binding.diff
+				ok = binding.ok
+				stack = stack[:len(stack)-1]
+				continue
+			}
 			if b.Branch == nil || b.Next == b.Branch {
-				// Sequential block, return latest value
+				// Sequential block, return latest value.
 				if b.Next != nil {
+					s.r = 1
 					stack = append(stack, &BindingStackState{
-						block:  b.Next,
-						r:      1,
-						parent: b,
+						block: b.Next,
 					})
 					continue
-				} else {
-					bind, ok := b.Bindings.Get(name)
-					if !ok {
-						ctx.Set(b, name, v, false, false)
-						diff = false
-						ok = false
-					} else {
-						v = bind.Value(retBlock, gen)
-						ctx.Set(b, name, v, false, true)
-						diff = false
-						ok = true
-					}
 				}
+				v, diff, ok = b.ownBinding(ctx, name, retBlock, gen)
+				stack = stack[:len(stack)-1]
 			} else {
+				s.r = 2
 				stack = append(stack, &BindingStackState{
 					block: b.Branch,
-					r:     2,
 				})
-				continue
 			}
-		}
 
-		switch s.r {
 		case 1:
+			// Next block resolved. If it did not have the value,
+			// take ours.
 			if ok {
-				ctx.Set(s.parent, name, v, diff, true)
+				ctx.Set(b, name, v, diff, true)
 			} else {
-				bind, bok := s.parent.Bindings.Get(name)
-				if !bok {
-					ctx.Set(s.parent, name, v, false, false)
-					diff = false
-					ok = false
-				} else {
-					v = bind.Value(retBlock, gen)
-					ctx.Set(s.parent, name, v, false, true)
-					diff = false
-					ok = true
-				}
+				v, diff, ok = b.ownBinding(ctx, name, retBlock, gen)
 			}
+			stack = stack[:len(stack)-1]
+
 		case 2:
+			// Branch block resolved.
 			if !ok {
-				ctx.Set(s.parent, name, v, false, false)
+				ctx.Set(b, name, v, false, false)
 				diff = false
-				ok = false
-			} else {
-				stack = append(stack, &BindingStackState{
-					block:    b.Next,
-					r:        3,
-					vTrue:    v,
-					diffTrue: diff,
-					parent:   b,
-				})
+				stack = stack[:len(stack)-1]
+				continue
 			}
+			s.r = 3
+			s.vTrue = v
+			s.diffTrue = diff
+			stack = append(stack, &BindingStackState{
+				block: b.Next,
+			})
+
 		case 3:
+			// Next block resolved.
 			if !ok {
-				ctx.Set(s.parent, name, v, false, false)
+				ctx.Set(b, name, v, false, false)
 				diff = false
-				ok = false
+			} else if s.vTrue.Equal(&v) {
+				v = s.vTrue
+				diff = s.diffTrue || diff
+				ctx.Set(b, name, v, diff, true)
 			} else {
-				if s.vTrue.Equal(&v) {
-					ctx.Set(s.parent, name, s.vTrue, s.diffTrue || diff, true)
-					v = s.vTrue
-					diff = s.diffTrue || diff
-					ok = true
+				var rType types.Info
+				if s.vTrue.Type.Bits > v.Type.Bits {
+					rType = s.vTrue.Type
 				} else {
-					var rType types.Info
-					if s.vTrue.Type.Bits > v.Type.Bits {
-						rType = s.vTrue.Type
-					} else {
-						rType = v.Type
-					}
-
-					vFalse := v
-					v = gen.AnonVal(rType)
-					retBlock.AddInstr(NewPhiInstr(s.parent.BranchCond, s.vTrue, vFalse, v))
-
-					diff = s.vTrue.Type.Bits != vFalse.Type.Bits || s.diffTrue || diff
-					ctx.Set(s.parent, name, v, diff, true)
-					ok = true
+					rType = v.Type
 				}
+
+				vFalse := v
+				v = gen.AnonVal(rType)
+				retBlock.AddInstr(NewPhiInstr(b.BranchCond, s.vTrue, vFalse,
+					v))
+
+				diff = s.vTrue.Type.Bits != vFalse.Type.Bits ||
+					s.diffTrue || diff
+				ctx.Set(b, name, v, diff, true)
 			}
+			stack = stack[:len(stack)-1]
 		}
 	}
 
 	return v, diff, ok
 }
 
+func (b *Block) ownBinding(ctx *ReturnBindingCTX, name string,
+	retBlock *Block, gen *Generator) (v Value, diff, ok bool) {
+
+	bind, ok := b.Bindings.Get(name)
+	if !ok {
+		ctx.Set(b, name, v, false, false)
+		return v, false, false
+	}
+	v = bind.Value(retBlock, gen)
+	ctx.Set(b, name, v, false, true)
+	return v, false, true
+}
+
 // Serialize serializes the basic block's instructions.
 func (b *Block) Serialize() []Step {
 	seen := make(map[BlockID]bool)
@@ -286,11 +277,16 @@ func (bl *Block) serialize(code []Step, seen map[BlockID]bool) []Step {
 		}
 
 		// Have all predecessors been processed?
+		ready := true
 		for _, from := range b.From {
 			if !seen[from.ID] {
-				continue
+				ready = false
+				break
 			}
 		}
+		if !ready {
+			continue
+		}
 		seen[b.ID] = true
 
 		var label string
@@ -88,6 +88,11 @@ func (prog *Program) Circuit(cc *circuits.Compiler) error {
 			if err != nil {
 				return err
 			}
+			// The constants share their wires by value. A constant
+			// typed narrower than the shared wires uses its low bits.
+			if in.Const && types.Size(len(w)) > in.Type.Bits {
+				w = w[:in.Type.Bits]
+			}
 			wires = append(wires, w)
 		}
 		switch instr.Op {
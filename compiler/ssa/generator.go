@@ -116,8 +116,9 @@ func (gen *Generator) NewVal(name string, t types.Info, scope Scope) Value {
 
 // AddConstant adds a reference to the constant.
 func (gen *Generator) AddConstant(c Value) {
-	// Add only values which have the ConstValue set.
-	if c.ConstValue == nil {
+	// Add only values which have the ConstValue set. The function
+	// values don't have wires.
+	if c.ConstValue == nil || c.Type.Type == types.TFunc {
 		return
 	}
 	inst, ok := gen.constants[c.Name]
@@ -22,21 +22,24 @@ import (
 
 // Program implements SSA program.
 type Program struct {
-	Params      *utils.Params
-	Inputs      circuit.IO
-	Outputs     circuit.IO
-	InputWires  []*circuits.Wire
-	OutputWires []*circuits.Wire
-	Constants   map[string]ConstantInst
-	Steps       []Step
-	walloc      *WireAllocator
-	calloc      *circuits.Allocator
-	zeroWire    *circuits.Wire
-	oneWire     *circuits.Wire
-	stats       circuit.Stats
-	numWires    int
-	tInit       time.Duration
-	tGarble     time.Duration
+	Params       *utils.Params
+	Inputs       circuit.IO
+	Outputs      circuit.IO
+	InputWires   []*circuits.Wire
+	OutputWires  []*circuits.Wire
+	Constants    map[string]ConstantInst
+	Steps        []Step
+	walloc       *WireAllocator
+	calloc       *circuits.Allocator
+	zeroWire     *circuits.Wire
+	oneWire      *circuits.Wire
+	stats        circuit.Stats
+	numWires     int
+	numCached    int
+	tInit        time.Duration
+	tGarble      time.Duration
+	tInstrInit   time.Duration
+	tCircCompile time.Duration
 }
 
 // NewProgram creates a new program for the constants and program
@@ -150,9 +153,6 @@ func (prog *Program) GC() {
 		panic("empty program")
 	}
 	last := prog.Steps[len(prog.Steps)-1]
-	for _, i := range prog.Steps {
-		fmt.Println(i.Instr)
-	}
 	if last.Instr.Op != Ret {
 		panic("last instruction is not return")
 	}
@@ -7,6 +7,7 @@
 package ssa
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"math/big"
@@ -20,9 +21,26 @@ import (
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/types"
 )
 
-// Stream streams the program circuit into the P2P connection.
-func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
-	params *utils.Params, inputs *big.Int, timing *circuit.Timing) (
+// Stream streams the program circuit into the P2P connection. The
+// optional shares specify our shares of the peer's secret-shared
+// input, see circuit.GarblerShared. The protocol run is aborted when
+// the context is done; see circuit.WithTimeouts for per-phase time
+// limits.
+func (prog *Program) Stream(ctx context.Context, conn *p2p.Conn, oti ot.OT,
+	params *utils.Params, inputs, shares *big.Int, timing *circuit.Timing) (
+	circuit.IO, []*big.Int, error) {
+
+	ph := circuit.NewPhases(ctx, conn.WithContext)
+	outputs, result, err := prog.stream(ph, conn, oti, params, inputs,
+		shares, timing)
+	err = ph.Wrap(err)
+	ph.Leave()
+
+	return outputs, result, circuit.Abort(conn, err)
+}
+
+func (prog *Program) stream(ph *circuit.Phases, conn *p2p.Conn, oti ot.OT,
+	params *utils.Params, inputs, shares *big.Int, timing *circuit.Timing) (
 	circuit.IO, []*big.Int, error) {
 
 	var key [32]byte
@@ -34,15 +52,18 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 	if params.Verbose {
 		fmt.Printf(" - Sending program info...\n")
 	}
+	if err := ph.Enter(circuit.PhaseTables); err != nil {
+		return nil, nil, err
+	}
 	if err := conn.SendData(key[:]); err != nil {
 		return nil, nil, err
 	}
 	// Our input.
-	if err := sendArgument(conn, prog.Inputs[0]); err != nil {
+	if err := circuit.SendArgument(conn, prog.Inputs[0]); err != nil {
 		return nil, nil, err
 	}
 	// Peer input.
-	if err := sendArgument(conn, prog.Inputs[1]); err != nil {
+	if err := circuit.SendArgument(conn, prog.Inputs[1]); err != nil {
 		return nil, nil, err
 	}
 	// Program outputs.
@@ -50,7 +71,7 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		return nil, nil, err
 	}
 	for _, o := range prog.Outputs {
-		if err := sendArgument(conn, o); err != nil {
+		if err := circuit.SendArgument(conn, o); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -59,21 +80,16 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		return nil, nil, err
 	}
 
-	// Collect input wire IDs.
-	var ids []circuit.Wire
-	for _, w := range prog.InputWires {
-		// Program's inputs are unassigned because parser is shared
-		// between streaming and non-streaming modes.
-		w.SetID(prog.walloc.NextWireID())
-		ids = append(ids, w.ID())
-	}
-
-	streaming, err := circuit.NewStreaming(key[:], ids, conn)
+	streaming, err := circuit.NewStreaming(key[:], prog.assignInputIDs(),
+		conn)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Select our inputs.
+	if err := ph.Enter(circuit.PhaseOT); err != nil {
+		return nil, nil, err
+	}
 	var n1 []ot.Label
 	for i := 0; i < int(prog.Inputs[0].Type.Bits); i++ {
 		wire := streaming.GetInput(circuit.Wire(i))
@@ -111,8 +127,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 	timing.Sample("OT Init", []string{circuit.FileSize(xfer).String()})
 
 	// Peer OTs its inputs.
-	err = oti.Send(streaming.GetInputs(int(prog.Inputs[0].Type.Bits),
-		int(prog.Inputs[1].Type.Bits)))
+	err = oti.Send(circuit.ShareWires(
+		streaming.GetInputs(int(prog.Inputs[0].Type.Bits),
+			int(prog.Inputs[1].Type.Bits)), shares))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -120,19 +137,159 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 	ioStats = conn.Stats.Sum()
 	timing.Sample("Peer Inputs", []string{circuit.FileSize(xfer).String()})
 
-	zero, err := prog.ZeroWire(conn, streaming)
+	if err := ph.Enter(circuit.PhaseTables); err != nil {
+		return nil, nil, err
+	}
+	returnIDs, err := prog.streamCircuits(params, &garbleSink{
+		prog:      prog,
+		conn:      conn,
+		streaming: streaming,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-	one, err := prog.OneWire(conn, streaming)
+
+	xfer = conn.Stats.Sum() - ioStats
+	ioStats = conn.Stats.Sum()
+	sample := timing.Sample("Stream", []string{circuit.FileSize(xfer).String()})
+	sample.Samples = append(sample.Samples, &circuit.Sample{
+		Label: "InstrInit",
+		Abs:   prog.tInstrInit,
+	})
+	sample.Samples = append(sample.Samples, &circuit.Sample{
+		Label: "CircComp",
+		Abs:   prog.tCircCompile,
+	})
+	sample.Samples = append(sample.Samples, &circuit.Sample{
+		Label: "StreamInit",
+		Abs:   prog.tInit,
+	})
+	sample.Samples = append(sample.Samples, &circuit.Sample{
+		Label: "Garble",
+		Abs:   prog.tGarble,
+	})
+
+	// Our result values of the shared outputs are their masks.
+	mask, err := circuit.NewShareMask(prog.Outputs)
 	if err != nil {
 		return nil, nil, err
 	}
+	result := new(big.Int).Set(mask)
 
-	err = prog.DefineConstants(zero, one)
+	if err := ph.Enter(circuit.PhaseResult); err != nil {
+		return nil, nil, err
+	}
+	op, err := conn.ReceiveUint32()
 	if err != nil {
 		return nil, nil, err
 	}
+	if op != circuit.OpResult {
+		return nil, nil, fmt.Errorf("%w: unexpected operation: %d",
+			circuit.ErrProtocolMismatch, op)
+	}
+
+	// The evaluator returns labels only for the outputs we are
+	// allowed to learn.
+	var label ot.Label
+	outputWires := make([]ot.Wire, len(returnIDs))
+	for i, id := range returnIDs {
+		outputWires[i] = streaming.GetInput(id)
+	}
+
+	for i, visible := range prog.Outputs.Visible(true) {
+		if !visible {
+			continue
+		}
+		err := conn.ReceiveLabel(&label, &labelData)
+		if err != nil {
+			return nil, nil, err
+		}
+		wire := outputWires[i]
+		var bit uint
+		if label.Equal(wire.L0) {
+			bit = 0
+		} else if label.Equal(wire.L1) {
+			bit = 1
+		} else {
+			return nil, nil, fmt.Errorf("%w: unknown label %s for result %d",
+				circuit.ErrInvalidOutputLabel, label, i)
+		}
+		result.SetBit(result, i, bit)
+	}
+	data := circuit.DecodingBits(prog.Outputs, outputWires, mask)
+	if err := conn.SendData(data); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	xfer = conn.Stats.Sum() - ioStats
+	timing.Sample("Result", []string{circuit.FileSize(xfer).String()})
+
+	fmt.Printf("Max permanent wires: %d, cached circuits: %d\n",
+		prog.walloc.NextWireID(), prog.numCached)
+	fmt.Printf("#gates=%d (%s) #w=%d\n", prog.stats.Count(), prog.stats,
+		prog.numWires)
+
+	return prog.Outputs, prog.Outputs.SplitVisible(result, true), nil
+}
+
+// StreamProgram returns the description of the circuits that the
+// program streams. The stream evaluator uses the description to
+// verify that the garbler streams this program, see
+// circuit.StreamEvaluator. The program must be compiled with the same
+// parameters and input sizes as the garbler's program. The program
+// can't be streamed after this call.
+func (prog *Program) StreamProgram(params *utils.Params) (
+	*circuit.StreamProgram, error) {
+
+	sink := &verifySink{
+		program: &circuit.StreamProgram{
+			Inputs:  prog.Inputs,
+			Outputs: prog.Outputs,
+			Steps:   make([]circuit.StreamStep, 0),
+		},
+	}
+	prog.assignInputIDs()
+	if _, err := prog.streamCircuits(params, sink); err != nil {
+		return nil, err
+	}
+	return sink.program, nil
+}
+
+// assignInputIDs assigns wire IDs for the program input wires and
+// returns the IDs.
+func (prog *Program) assignInputIDs() []circuit.Wire {
+	var ids []circuit.Wire
+	for _, w := range prog.InputWires {
+		// Program's inputs are unassigned because parser is shared
+		// between streaming and non-streaming modes.
+		w.SetID(prog.walloc.NextWireID())
+		ids = append(ids, w.ID())
+	}
+	return ids
+}
+
+// streamCircuits generates the circuits of the program steps and
+// passes them to the sink. The function returns the wire IDs of the
+// program's return values.
+func (prog *Program) streamCircuits(params *utils.Params,
+	sink CircuitSink) ([]circuit.Wire, error) {
+
+	zero, err := prog.ZeroWire(sink)
+	if err != nil {
+		return nil, err
+	}
+	one, err := prog.OneWire(sink)
+	if err != nil {
+		return nil, err
+	}
+
+	err = prog.DefineConstants(zero, one)
+	if err != nil {
+		return nil, err
+	}
 
 	// Stream circuit.
 
@@ -142,9 +299,6 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 	start := time.Now()
 	lastReport := start
 
-	var dInstrInit time.Duration
-	var dCircCompile time.Duration
-
 	istats := make(map[string]circuit.Stats)
 
 	var wires [][]circuit.Wire
@@ -175,7 +329,7 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		for _, in := range instr.In {
 			w, err := prog.walloc.AssignedIDs(in, in.Type.Bits)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			wires = append(wires, w)
 		}
@@ -185,14 +339,24 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		if instr.Out != nil {
 			out, err = prog.walloc.AssignedIDs(*instr.Out, instr.Out.Type.Bits)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 		}
 
 		if params.Verbose && circuit.StreamDebug {
 			fmt.Printf("%05d: %s\n", idx, instr.String())
 		}
-		dInstrInit += time.Now().Sub(dStart)
+		prog.tInstrInit += time.Now().Sub(dStart)
+
+		if is, ok := sink.(InstrSink); ok {
+			handled, err := is.Instr(instr, wires, out)
+			if err != nil {
+				return nil, err
+			}
+			if handled {
+				continue
+			}
+		}
 
 		switch instr.Op {
 
@@ -210,12 +374,12 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		case Lshift:
 			count, err := instr.In[1].ConstInt()
 			if err != nil {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("%s: unsupported index type %T: %s",
 						instr.Op, instr.In[1], err)
 			}
 			if count < 0 {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("%s: negative shift count %d", instr.Op, count)
 			}
 			for bit := 0; bit < len(out); bit++ {
@@ -223,9 +387,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 				if bit-int(count) >= 0 && bit-int(count) < len(wires[0]) {
 					id = wires[0][bit-int(count)]
 				} else {
-					w, err := prog.ZeroWire(conn, streaming)
+					w, err := prog.ZeroWire(sink)
 					if err != nil {
-						return nil, nil, err
+						return nil, err
 					}
 					id = w.ID()
 				}
@@ -237,20 +401,20 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 			if instr.Op == Srshift {
 				signWire = wires[0][len(wires[0])-1]
 			} else {
-				zero, err := prog.ZeroWire(conn, streaming)
+				zero, err := prog.ZeroWire(sink)
 				if err != nil {
-					return nil, nil, err
+					return nil, err
 				}
 				signWire = zero.ID()
 			}
 			count, err := instr.In[1].ConstInt()
 			if err != nil {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("%s: unsupported index type %T: %s",
 						instr.Op, instr.In[1], err)
 			}
 			if count < 0 {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("%s: negative shift count %d", instr.Op, count)
 			}
 			for bit := 0; bit < len(out); bit++ {
@@ -266,18 +430,18 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		case Slice:
 			from, err := instr.In[1].ConstInt()
 			if err != nil {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("%s: unsupported index type %T: %s",
 						instr.Op, instr.In[1], err)
 			}
 			to, err := instr.In[2].ConstInt()
 			if err != nil {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("%s: unsupported index type %T: %s",
 						instr.Op, instr.In[2], err)
 			}
 			if from >= to {
-				return nil, nil, fmt.Errorf("%s: bounds out of range [%d:%d]",
+				return nil, fmt.Errorf("%s: bounds out of range [%d:%d]",
 					instr.Op, from, to)
 			}
 			for bit := from; bit < to; bit++ {
@@ -285,9 +449,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 				if int(bit) < len(wires[0]) {
 					id = wires[0][bit]
 				} else {
-					w, err := prog.ZeroWire(conn, streaming)
+					w, err := prog.ZeroWire(sink)
 					if err != nil {
-						return nil, nil, err
+						return nil, err
 					}
 					id = w.ID()
 				}
@@ -299,9 +463,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 			if instr.Op == Smov {
 				signWire = wires[0][len(wires[0])-1]
 			} else {
-				zero, err := prog.ZeroWire(conn, streaming)
+				zero, err := prog.ZeroWire(sink)
 				if err != nil {
-					return nil, nil, err
+					return nil, err
 				}
 				signWire = zero.ID()
 			}
@@ -320,16 +484,16 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 			// array[from:to] = v
 			from, err := instr.In[2].ConstInt()
 			if err != nil {
-				return nil, nil, fmt.Errorf("%s: unsupported index type %T: %s",
+				return nil, fmt.Errorf("%s: unsupported index type %T: %s",
 					instr.Op, instr.In[2], err)
 			}
 			to, err := instr.In[3].ConstInt()
 			if err != nil {
-				return nil, nil, fmt.Errorf("%s: unsupported index type %T: %s",
+				return nil, fmt.Errorf("%s: unsupported index type %T: %s",
 					instr.Op, instr.In[3], err)
 			}
 			if from < 0 || from >= to {
-				return nil, nil, fmt.Errorf("%s: bounds out of range [%d:%d]",
+				return nil, fmt.Errorf("%s: bounds out of range [%d:%d]",
 					instr.Op, from, to)
 			}
 
@@ -339,9 +503,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 					if bit < types.Size(len(wires[1])) {
 						id = wires[1][bit]
 					} else {
-						w, err := prog.ZeroWire(conn, streaming)
+						w, err := prog.ZeroWire(sink)
 						if err != nil {
-							return nil, nil, err
+							return nil, err
 						}
 						id = w.ID()
 					}
@@ -350,9 +514,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 					if idx < types.Size(len(wires[0])) {
 						id = wires[0][idx]
 					} else {
-						w, err := prog.ZeroWire(conn, streaming)
+						w, err := prog.ZeroWire(sink)
 						if err != nil {
-							return nil, nil, err
+							return nil, err
 						}
 						id = w.ID()
 					}
@@ -361,22 +525,14 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 			}
 
 		case Ret:
-			if err := conn.SendUint32(circuit.OpReturn); err != nil {
-				return nil, nil, err
-			}
 			for _, arg := range wires {
-				for _, w := range arg {
-					if err := conn.SendUint32(w.Int()); err != nil {
-						return nil, nil, err
-					}
-					returnIDs = append(returnIDs, w)
-				}
+				returnIDs = append(returnIDs, arg...)
 			}
 			if circuit.StreamDebug {
 				fmt.Printf("return=%v\n", returnIDs)
 			}
-			if err := conn.Flush(); err != nil {
-				return nil, nil, err
+			if err := sink.Return(returnIDs); err != nil {
+				return nil, err
 			}
 
 		case Circ:
@@ -396,7 +552,7 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 			for i, ret := range instr.Ret {
 				wires, err := prog.walloc.AssignedIDs(ret, ret.Type.Bits)
 				if err != nil {
-					return nil, nil, err
+					return nil, err
 				}
 				for j := 0; j < int(instr.Circ.Outputs[i].Type.Bits); j++ {
 					if j < len(wires) {
@@ -407,7 +563,7 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 				}
 			}
 			if len(oIDs) != instr.Circ.Outputs.Size() {
-				return nil, nil, fmt.Errorf("%s: output mismatch: %d vs. %d",
+				return nil, fmt.Errorf("%s: output mismatch: %d vs. %d",
 					instr.Op, len(oIDs), instr.Circ.Outputs.Size())
 			}
 			if params.Verbose && circuit.StreamDebug {
@@ -416,9 +572,9 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 			if params.Diagnostics {
 				addStats(istats, instr, instr.Circ)
 			}
-			err = prog.garble(conn, streaming, idx, instr.Circ, iIDs, oIDs)
+			err = sink.Circuit(idx, instr.Circ, iIDs, oIDs)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 
 		case GC:
@@ -427,7 +583,7 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 		default:
 			f, ok := circuitGenerators[instr.Op]
 			if !ok {
-				return nil, nil,
+				return nil,
 					fmt.Errorf("Program.StreamCircuit: %s not implemented yet",
 						instr.Op)
 			}
@@ -454,11 +610,11 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 				cc, err := circuits.NewCompiler(params, prog.calloc, nil, nil,
 					flat, cOut)
 				if err != nil {
-					return nil, nil, err
+					return nil, err
 				}
 				cacheable, err := f(cc, instr, cIn, cOut)
 				if err != nil {
-					return nil, nil, err
+					return nil, err
 				}
 				cc.ConstPropagate()
 				pruned := cc.Prune()
@@ -473,7 +629,7 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 					fmt.Printf("%05d: - %s\n", idx, circ)
 				}
 				circ.AssignLevels()
-				dCircCompile += time.Now().Sub(startTime)
+				prog.tCircCompile += time.Now().Sub(startTime)
 			}
 			if false {
 				circ.Dump()
@@ -495,79 +651,15 @@ func (prog *Program) Stream(conn *p2p.Conn, oti ot.OT,
 				oIDs = append(oIDs, w)
 			}
 
-			err = prog.garble(conn, streaming, idx, circ, iIDs, oIDs)
+			err = sink.Circuit(idx, circ, iIDs, oIDs)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 		}
 	}
+	prog.numCached = len(cache)
 
-	xfer = conn.Stats.Sum() - ioStats
-	ioStats = conn.Stats.Sum()
-	sample := timing.Sample("Stream", []string{circuit.FileSize(xfer).String()})
-	sample.Samples = append(sample.Samples, &circuit.Sample{
-		Label: "InstrInit",
-		Abs:   dInstrInit,
-	})
-	sample.Samples = append(sample.Samples, &circuit.Sample{
-		Label: "CircComp",
-		Abs:   dCircCompile,
-	})
-	sample.Samples = append(sample.Samples, &circuit.Sample{
-		Label: "StreamInit",
-		Abs:   prog.tInit,
-	})
-	sample.Samples = append(sample.Samples, &circuit.Sample{
-		Label: "Garble",
-		Abs:   prog.tGarble,
-	})
-
-	result := new(big.Int)
-
-	op, err := conn.ReceiveUint32()
-	if err != nil {
-		return nil, nil, err
-	}
-	if op != circuit.OpResult {
-		return nil, nil, fmt.Errorf("unexpected operation: %d", op)
-	}
-
-	var label ot.Label
-
-	for i := 0; i < prog.Outputs.Size(); i++ {
-		err := conn.ReceiveLabel(&label, &labelData)
-		if err != nil {
-			return nil, nil, err
-		}
-		wire := streaming.GetInput(returnIDs[i])
-		var bit uint
-		if label.Equal(wire.L0) {
-			bit = 0
-		} else if label.Equal(wire.L1) {
-			bit = 1
-		} else {
-			return nil, nil, fmt.Errorf("unknown label %s for result %d",
-				label, i)
-		}
-		result.SetBit(result, i, bit)
-	}
-	data := result.Bytes()
-	if err := conn.SendData(data); err != nil {
-		return nil, nil, err
-	}
-	if err := conn.Flush(); err != nil {
-		return nil, nil, err
-	}
-
-	xfer = conn.Stats.Sum() - ioStats
-	timing.Sample("Result", []string{circuit.FileSize(xfer).String()})
-
-	fmt.Printf("Max permanent wires: %d, cached circuits: %d\n",
-		prog.walloc.NextWireID(), len(cache))
-	fmt.Printf("#gates=%d (%s) #w=%d\n", prog.stats.Count(), prog.stats,
-		prog.numWires)
-
-	return prog.Outputs, prog.Outputs.Split(result), nil
+	return returnIDs, nil
 }
 
 func addStats(istats map[string]circuit.Stats, instr Instr,
@@ -588,8 +680,37 @@ func addStats(istats map[string]circuit.Stats, instr Instr,
 	istats[key] = stats
 }
 
-func (prog *Program) garble(conn *p2p.Conn, streaming *circuit.Streaming,
-	step int, circ *circuit.Circuit, in, out []circuit.Wire) error {
+// CircuitSink consumes the circuits of the streamed program.
+type CircuitSink interface {
+	// Circuit consumes the circuit of the program step. The in and
+	// out specify the circuit's input and output wire IDs.
+	Circuit(step int, circ *circuit.Circuit, in, out []circuit.Wire) error
+
+	// Return consumes the wire IDs of the program's return values.
+	Return(ids []circuit.Wire) error
+}
+
+// InstrSink is an optional interface of the circuit sinks that
+// evaluate program instructions without their circuits.
+type InstrSink interface {
+	// Instr consumes the program instruction. The in and out specify
+	// the wire IDs of the instruction's input and output values. The
+	// function returns false if the sink does not handle the
+	// instruction and the instruction must be streamed as a circuit.
+	Instr(instr Instr, in [][]circuit.Wire, out []circuit.Wire) (bool, error)
+}
+
+// garbleSink garbles the circuits and streams them to the evaluator.
+type garbleSink struct {
+	prog      *Program
+	conn      *p2p.Conn
+	streaming *circuit.Streaming
+}
+
+func (sink *garbleSink) Circuit(step int, circ *circuit.Circuit,
+	in, out []circuit.Wire) error {
+
+	conn := sink.conn
 
 	var maxID circuit.Wire
 	for _, id := range in {
@@ -618,10 +739,11 @@ func (prog *Program) garble(conn *p2p.Conn, streaming *circuit.Streaming,
 	if err := conn.SendUint32(int(maxID + 1)); err != nil {
 		return err
 	}
-	tInit, tGarble, err := streaming.Garble(circ, in, out)
+	tInit, tGarble, err := sink.streaming.Garble(circ, in, out)
 	if err != nil {
 		return err
 	}
+	prog := sink.prog
 	prog.tInit += tInit
 	prog.tGarble += tGarble
 	prog.stats.Add(circ.Stats)
@@ -630,9 +752,38 @@ func (prog *Program) garble(conn *p2p.Conn, streaming *circuit.Streaming,
 	return nil
 }
 
+func (sink *garbleSink) Return(ids []circuit.Wire) error {
+	if err := sink.conn.SendUint32(circuit.OpReturn); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := sink.conn.SendUint32(id.Int()); err != nil {
+			return err
+		}
+	}
+	return sink.conn.Flush()
+}
+
+// verifySink collects the stream steps of the program circuits.
+type verifySink struct {
+	program *circuit.StreamProgram
+}
+
+func (sink *verifySink) Circuit(step int, circ *circuit.Circuit,
+	in, out []circuit.Wire) error {
+
+	sink.program.Steps = append(sink.program.Steps,
+		circuit.NewStreamStep(step, circ, in, out))
+	return nil
+}
+
+func (sink *verifySink) Return(ids []circuit.Wire) error {
+	sink.program.Return = append([]circuit.Wire(nil), ids...)
+	return nil
+}
+
 // ZeroWire returns a wire with value 0.
-func (prog *Program) ZeroWire(conn *p2p.Conn, streaming *circuit.Streaming) (
-	*circuits.Wire, error) {
+func (prog *Program) ZeroWire(sink CircuitSink) (*circuits.Wire, error) {
 
 	if prog.zeroWire == nil {
 		wires, err := prog.walloc.AssignedWires(Value{
@@ -642,7 +793,7 @@ func (prog *Program) ZeroWire(conn *p2p.Conn, streaming *circuit.Streaming) (
 		if err != nil {
 			return nil, err
 		}
-		err = prog.garble(conn, streaming, 0, &circuit.Circuit{
+		err = sink.Circuit(0, &circuit.Circuit{
 			NumGates: 1,
 			NumWires: 2,
 			Inputs: []circuit.IOArg{
@@ -686,8 +837,7 @@ func (prog *Program) ZeroWire(conn *p2p.Conn, streaming *circuit.Streaming) (
 }
 
 // OneWire returns wire with value 1.
-func (prog *Program) OneWire(conn *p2p.Conn, streaming *circuit.Streaming) (
-	*circuits.Wire, error) {
+func (prog *Program) OneWire(sink CircuitSink) (*circuits.Wire, error) {
 
 	if prog.oneWire == nil {
 		wires, err := prog.walloc.AssignedWires(Value{
@@ -697,7 +847,7 @@ func (prog *Program) OneWire(conn *p2p.Conn, streaming *circuit.Streaming) (
 		if err != nil {
 			return nil, err
 		}
-		err = prog.garble(conn, streaming, 0, &circuit.Circuit{
+		err = sink.Circuit(0, &circuit.Circuit{
 			NumGates: 1,
 			NumWires: 2,
 			Inputs: []circuit.IOArg{
@@ -740,29 +890,6 @@ func (prog *Program) OneWire(conn *p2p.Conn, streaming *circuit.Streaming) (
 	return prog.oneWire, nil
 }
 
-func sendArgument(conn *p2p.Conn, arg circuit.IOArg) error {
-	if err := conn.SendString(arg.Name); err != nil {
-		return err
-	}
-	if err := conn.SendString(arg.Type.String()); err != nil {
-		return err
-	}
-	if err := conn.SendUint32(int(arg.Type.Bits)); err != nil {
-		return err
-	}
-
-	if err := conn.SendUint32(len(arg.Compound)); err != nil {
-		return err
-	}
-	for _, a := range arg.Compound {
-		if err := sendArgument(conn, a); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 // NewCircuit creates a new circuit.
 type NewCircuit func(cc *circuits.Compiler, instr Instr, in [][]*circuits.Wire,
 	out []*circuits.Wire) (cacheable bool, err error)
@@ -0,0 +1,181 @@
+//
+// client.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package outsource
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Client is a thin client of the outsourced computation. It submits
+// an input to the garbler and evaluator servers and reconstructs the
+// outputs from the servers' shares.
+type Client struct {
+	garbler   *p2p.Conn
+	evaluator *p2p.Conn
+}
+
+// NewClient creates a new client for the connections to the garbler
+// and evaluator servers.
+func NewClient(garbler, evaluator *p2p.Conn) *Client {
+	return &Client{
+		garbler:   garbler,
+		evaluator: evaluator,
+	}
+}
+
+// Dial connects to the garbler and evaluator servers at the
+// addresses.
+func Dial(ctx context.Context, garbler, evaluator string) (*Client, error) {
+	var dialer net.Dialer
+	g, err := dialer.DialContext(ctx, "tcp", garbler)
+	if err != nil {
+		return nil, err
+	}
+	e, err := dialer.DialContext(ctx, "tcp", evaluator)
+	if err != nil {
+		g.Close()
+		return nil, err
+	}
+	return NewClient(p2p.NewConn(g), p2p.NewConn(e)), nil
+}
+
+// Close closes the server connections.
+func (c *Client) Close() error {
+	err := c.garbler.Close()
+	if err2 := c.evaluator.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Run submits the input values for the circuit input argument of the
+// session and returns the circuit outputs. The clients of a session
+// agree on the session ID and each of them submits a different input
+// argument. The servers run the session when they have all its
+// inputs. The protocol run is aborted when the context is done.
+func (c *Client) Run(ctx context.Context, session string, input int,
+	values []string) (circuit.IO, []*big.Int, error) {
+
+	conns := []*p2p.Conn{c.garbler, c.evaluator}
+	for _, conn := range conns {
+		release := conn.WithContext(ctx)
+		defer release()
+	}
+
+	// Receive the argument descriptions from both servers.
+	var arg circuit.IOArg
+	var outputs circuit.IO
+	for idx, conn := range conns {
+		a, o, err := hello(conn, session, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		if idx == 0 {
+			arg = a
+			outputs = o
+		} else if a.String() != arg.String() ||
+			a.Type.Bits != arg.Type.Bits ||
+			o.String() != outputs.String() ||
+			o.Size() != outputs.Size() {
+			return nil, nil, c.abort(fmt.Errorf("%w: servers run "+
+				"different circuits", circuit.ErrProtocolMismatch))
+		}
+	}
+
+	// Share the input.
+	value, err := arg.Parse(values)
+	if err != nil {
+		return nil, nil, c.abort(fmt.Errorf("%w: %s",
+			circuit.ErrInvalidInput, err))
+	}
+	// Negative values are shared in their two's complement form.
+	bits := int(arg.Type.Bits)
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	value.And(value, limit.Sub(limit, big.NewInt(1)))
+
+	buf := make([]byte, (bits+7)/8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, nil, err
+	}
+	mask := new(big.Int).SetBytes(buf)
+	mask.Rsh(mask, uint(len(buf)*8-bits))
+	shares := []*big.Int{mask, new(big.Int).Xor(value, mask)}
+
+	for idx, conn := range conns {
+		if err := conn.SendData(shares[idx].Bytes()); err != nil {
+			return nil, nil, err
+		}
+		if err := conn.Flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Reconstruct the outputs from the servers' shares.
+	result := new(big.Int)
+	for _, conn := range conns {
+		data, err := conn.ReceiveData()
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Xor(result, new(big.Int).SetBytes(data))
+	}
+	return outputs, outputs.Split(result), nil
+}
+
+// hello sends the client hello to the server and receives the
+// descriptions of our input argument and the circuit outputs.
+func hello(conn *p2p.Conn, session string, input int) (
+	circuit.IOArg, circuit.IO, error) {
+
+	var arg circuit.IOArg
+	if err := conn.SendUint32(magic); err != nil {
+		return arg, nil, err
+	}
+	if err := conn.SendString(session); err != nil {
+		return arg, nil, err
+	}
+	if err := conn.SendUint32(input); err != nil {
+		return arg, nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		return arg, nil, err
+	}
+
+	arg, err := circuit.ReceiveArgument(conn)
+	if err != nil {
+		return arg, nil, err
+	}
+	count, err := conn.ReceiveUint32()
+	if err != nil {
+		return arg, nil, err
+	}
+	var outputs circuit.IO
+	for i := 0; i < count; i++ {
+		o, err := circuit.ReceiveArgument(conn)
+		if err != nil {
+			return arg, nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	return arg, outputs, nil
+}
+
+// abort reports the error to both servers and returns it.
+func (c *Client) abort(err error) error {
+	circuit.Abort(c.garbler, err)
+	circuit.Abort(c.evaluator, err)
+	return err
+}
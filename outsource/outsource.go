@@ -0,0 +1,93 @@
+//
+// outsource.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package outsource implements outsourced two-server computation for
+// thin clients. The clients XOR-share their inputs to two
+// non-colluding servers that run the garbler and evaluator roles of
+// the two-party protocol. The servers recombine the inputs inside
+// the circuit and the clients receive the outputs as XOR shares from
+// both servers. The clients do no garbling and no oblivious
+// transfers, and neither server learns the inputs or the outputs
+// unless the servers collude.
+package outsource
+
+import (
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/types"
+)
+
+const (
+	magic = 0x6f757473 // outs
+)
+
+// Wrap creates the circuit that the servers run for the client
+// circuit. The garbler's input holds the garbler's shares of all
+// client circuit inputs and the evaluator's input the evaluator's
+// shares. The wrapper XORs the shares into the client circuit inputs
+// and shares all outputs between the servers, see
+// circuit.RecipientShared.
+func Wrap(circ *circuit.Circuit) *circuit.Circuit {
+	n := circ.Inputs.Size()
+	arg := func(name string) circuit.IOArg {
+		return circuit.IOArg{
+			Name: name,
+			Type: types.Info{
+				Type:       types.TUint,
+				IsConcrete: true,
+				Bits:       types.Size(n),
+			},
+		}
+	}
+	result := &circuit.Circuit{
+		NumWires: circ.NumWires + 2*n,
+		Inputs:   circuit.IO{arg("g"), arg("e")},
+		Stats:    circ.Stats,
+	}
+	for _, output := range circ.Outputs {
+		output.Recipient = circuit.RecipientShared
+		result.Outputs = append(result.Outputs, output)
+	}
+
+	// The client circuit wires follow the share inputs.
+	offset := circuit.Wire(2 * n)
+	for i := 0; i < n; i++ {
+		result.Gates = append(result.Gates, circuit.Gate{
+			Input0: circuit.Wire(i),
+			Input1: circuit.Wire(n + i),
+			Output: offset + circuit.Wire(i),
+			Op:     circuit.XOR,
+		})
+	}
+	result.Stats[circuit.XOR] += uint64(n)
+	for _, gate := range circ.Gates {
+		gate.Input0 += offset
+		gate.Input1 += offset
+		gate.Output += offset
+		result.Gates = append(result.Gates, gate)
+	}
+	result.NumGates = len(result.Gates)
+	result.AssignLevels()
+
+	return result
+}
+
+// join joins the argument values into one value, see circuit.IO.Split.
+func join(io circuit.IO, values []*big.Int) *big.Int {
+	result := new(big.Int)
+	var offset int
+	for idx, arg := range io {
+		if idx < len(values) && values[idx] != nil {
+			v := new(big.Int).Lsh(values[idx], uint(offset))
+			result.Or(result, v)
+		}
+		offset += int(arg.Type.Bits)
+	}
+	return result
+}
@@ -0,0 +1,292 @@
+//
+// outsource_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package outsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var auction = `
+package main
+
+func main(a, b, c int32) (int32, int32) {
+    max := a
+    if b > max {
+        max = b
+    }
+    if c > max {
+        max = c
+    }
+    return max, a + b + c
+}
+`
+
+// newServers starts the garbler and evaluator servers for the circuit
+// and returns their addresses.
+func newServers(t *testing.T, circ *circuit.Circuit,
+	timeout time.Duration) (string, string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Connection between the servers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	gc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var addrs []string
+	errs := make(chan error, 2)
+	for _, garbler := range []bool{true, false} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, ln.Addr().String())
+		peer := p2p.NewConn(ec)
+		if garbler {
+			peer = p2p.NewConn(gc)
+		}
+		server := NewServer(circ, garbler, peer, ot.NewCO())
+		server.timeout = timeout
+		go func() {
+			errs <- server.Serve(ctx, ln)
+		}()
+	}
+	t.Cleanup(func() {
+		cancel()
+		for i := 0; i < 2; i++ {
+			if err := <-errs; !errors.Is(err, context.Canceled) {
+				t.Errorf("Serve failed: %s", err)
+			}
+		}
+		gc.Close()
+		ec.Close()
+	})
+	return addrs[0], addrs[1]
+}
+
+type clientResult struct {
+	session string
+	input   int
+	result  []*big.Int
+	err     error
+}
+
+func runClient(addrs [2]string, session string, input int,
+	value string, results chan<- clientResult) {
+
+	r := clientResult{
+		session: session,
+		input:   input,
+	}
+	client, err := Dial(context.Background(), addrs[0], addrs[1])
+	if err != nil {
+		r.err = err
+		results <- r
+		return
+	}
+	defer client.Close()
+
+	_, r.result, r.err = client.Run(context.Background(), session, input,
+		[]string{value})
+	results <- r
+}
+
+func TestOutsource(t *testing.T) {
+	circ, _, err := compiler.New(utils.NewParams()).Compile(auction, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	g, e := newServers(t, circ, sessionTimeout)
+	addrs := [2]string{g, e}
+
+	sessions := map[string][]int64{
+		"s1": {10, 42, 7},
+		"s2": {-5, 3, 100},
+		"s3": {1, 2, 3},
+	}
+	results := make(chan clientResult)
+	var count int
+	for id, inputs := range sessions {
+		for input, value := range inputs {
+			go runClient(addrs, id, input, fmt.Sprintf("%d", value), results)
+			count++
+		}
+	}
+	for i := 0; i < count; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("session %s: client %d: %s", r.session, r.input, r.err)
+		}
+		var inputs []*big.Int
+		for _, v := range sessions[r.session] {
+			inputs = append(inputs, big.NewInt(v))
+		}
+		expected, err := circ.Compute(inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.result) != len(expected) {
+			t.Fatalf("session %s: client %d: got %d results, expected %d",
+				r.session, r.input, len(r.result), len(expected))
+		}
+		for idx := range expected {
+			if r.result[idx].Cmp(expected[idx]) != 0 {
+				t.Errorf("session %s: client %d: result %d: got %v, "+
+					"expected %v", r.session, r.input, idx, r.result[idx],
+					expected[idx])
+			}
+		}
+	}
+}
+
+func TestOutsourceWrap(t *testing.T) {
+	circ, _, err := compiler.New(utils.NewParams()).Compile(auction, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	wrapped := Wrap(circ)
+
+	inputs := []*big.Int{big.NewInt(10), big.NewInt(42), big.NewInt(7)}
+	expected, err := circ.Compute(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the joined inputs into two shares.
+	value := join(circ.Inputs, inputs)
+	mask := new(big.Int).SetUint64(0x5a5a5a5a5a5a5a5a)
+	mask.Lsh(mask, 32).Or(mask, big.NewInt(0x1234abcd))
+	share := new(big.Int).Xor(value, mask)
+
+	result, err := wrapped.Compute([]*big.Int{mask, share})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if result[i].Cmp(expected[i]) != 0 {
+			t.Errorf("result %d: got %v, expected %v",
+				i, result[i], expected[i])
+		}
+	}
+	for _, arg := range wrapped.Outputs {
+		if arg.Recipient != circuit.RecipientShared {
+			t.Errorf("output %s is not shared", arg.Name)
+		}
+	}
+}
+
+func TestOutsourceInvalidInput(t *testing.T) {
+	circ, _, err := compiler.New(utils.NewParams()).Compile(auction, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	g, e := newServers(t, circ, sessionTimeout)
+
+	results := make(chan clientResult)
+	go runClient([2]string{g, e}, "s1", 0, "not-a-number", results)
+	r := <-results
+	if !errors.Is(r.err, circuit.ErrInvalidInput) {
+		t.Errorf("expected %s, got %v", circuit.ErrInvalidInput, r.err)
+	}
+
+	go runClient([2]string{g, e}, "s1", 3, "1", results)
+	r = <-results
+	if !errors.Is(r.err, circuit.ErrPeerAbort) {
+		t.Errorf("expected %s, got %v", circuit.ErrPeerAbort, r.err)
+	}
+}
+
+func TestOutsourceTimeout(t *testing.T) {
+	circ, _, err := compiler.New(utils.NewParams()).Compile(auction, nil)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	g, e := newServers(t, circ, 200*time.Millisecond)
+	addrs := [2]string{g, e}
+
+	// An incomplete session times out.
+	results := make(chan clientResult)
+	go runClient(addrs, "s1", 0, "1", results)
+	r := <-results
+	if !errors.Is(r.err, circuit.ErrPeerAbort) {
+		t.Errorf("incomplete session: expected %s, got %v",
+			circuit.ErrPeerAbort, r.err)
+	}
+
+	// A session that is complete only at the garbler is rejected by
+	// the evaluator.
+	errs := make(chan error)
+	for input := range circ.Inputs {
+		go func(input int) {
+			nc, err := net.Dial("tcp", g)
+			if err != nil {
+				errs <- err
+				return
+			}
+			conn := p2p.NewConn(nc)
+			defer conn.Close()
+			if _, _, err := hello(conn, "s2", input); err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.SendData([]byte{1}); err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.Flush(); err != nil {
+				errs <- err
+				return
+			}
+			_, err = conn.ReceiveData()
+			errs <- err
+		}(input)
+	}
+	for range circ.Inputs {
+		if err := <-errs; !errors.Is(err, circuit.ErrPeerAbort) {
+			t.Errorf("garbler-only session: expected %s, got %v",
+				circuit.ErrPeerAbort, err)
+		}
+	}
+
+	// The servers still run complete sessions.
+	inputs := []int64{3, 9, 4}
+	for input, value := range inputs {
+		go runClient(addrs, "s3", input, fmt.Sprintf("%d", value), results)
+	}
+	for range inputs {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("client %d: %s", r.input, r.err)
+		}
+		if r.result[0].Int64() != 9 || r.result[1].Int64() != 16 {
+			t.Errorf("client %d: got %v, expected [9 16]", r.input, r.result)
+		}
+	}
+}
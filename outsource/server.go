@@ -0,0 +1,411 @@
+//
+// server.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package outsource
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"time"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+const (
+	// sessionTimeout limits how long a session waits for its inputs.
+	sessionTimeout = 30 * time.Second
+
+	sessionAck  = 1
+	sessionNack = 0
+)
+
+// Server runs the garbler or the evaluator role of the outsourced
+// computation. The server collects the clients' input shares by
+// session and runs the complete sessions with its peer server. The
+// garbler server decides the order of the sessions and announces
+// each session to the evaluator server before running it. The
+// evaluator acknowledges the session when it has all the session's
+// inputs, and it rejects the session if its inputs don't arrive in
+// the session timeout. The sessions that don't get all their inputs
+// in the session timeout are dropped and their clients aborted.
+type Server struct {
+	circ     *circuit.Circuit
+	wrapped  *circuit.Circuit
+	garbler  bool
+	peer     *p2p.Conn
+	oti      ot.OT
+	timeout  time.Duration
+	sessions map[string]*session
+}
+
+// session holds the clients and their input shares of a session.
+type session struct {
+	id      string
+	created time.Time
+	clients []*p2p.Conn
+	shares  []*big.Int
+	count   int
+}
+
+// abort aborts the session's clients.
+func (sess *session) abort(reason string) {
+	for _, conn := range sess.clients {
+		if conn != nil {
+			conn.Abort(p2p.AbortInternal, reason)
+			conn.Close()
+		}
+	}
+}
+
+// submission is a client's input share.
+type submission struct {
+	conn    *p2p.Conn
+	session string
+	input   int
+	share   *big.Int
+}
+
+// NewServer creates a new server for the client circuit. The garbler
+// specifies the server's role and the peer is the connection to the
+// other server.
+func NewServer(circ *circuit.Circuit, garbler bool, peer *p2p.Conn,
+	oti ot.OT) *Server {
+
+	return &Server{
+		circ:     circ,
+		wrapped:  Wrap(circ),
+		garbler:  garbler,
+		peer:     peer,
+		oti:      oti,
+		timeout:  sessionTimeout,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Serve accepts clients from the listener and runs the sessions until
+// the context is done. The function closes the listener when it
+// returns. The clients of the failed sessions are aborted.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	submissions := make(chan *submission)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		ln.Close()
+	}()
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn *p2p.Conn) {
+				sub, err := s.receive(ctx, conn)
+				if err != nil {
+					log.Printf("outsource: %s: %s\n", nc.RemoteAddr(), err)
+					circuit.Abort(conn, err)
+					conn.Close()
+					return
+				}
+				select {
+				case submissions <- sub:
+				case <-done:
+					conn.Close()
+				}
+			}(p2p.NewConn(nc))
+		}
+	}()
+
+	ticker := time.NewTicker(s.timeout)
+	defer ticker.Stop()
+
+	for {
+		var sess *session
+		var err error
+		if s.garbler {
+			sess, err = s.nextSession(ctx, submissions, ticker.C)
+		} else {
+			sess, err = s.announcedSession(ctx, submissions, ticker.C)
+		}
+		if err != nil {
+			return err
+		}
+		if sess == nil {
+			continue
+		}
+		if err := s.run(ctx, sess); err != nil {
+			return err
+		}
+	}
+}
+
+// receive receives the client's input share.
+func (s *Server) receive(ctx context.Context, conn *p2p.Conn) (
+	*submission, error) {
+
+	release := conn.WithContext(ctx)
+	defer release()
+
+	m, err := conn.ReceiveUint32()
+	if err != nil {
+		return nil, err
+	}
+	if m != magic {
+		return nil, fmt.Errorf("%w: invalid magic 0x%x",
+			circuit.ErrProtocolMismatch, m)
+	}
+	id, err := conn.ReceiveString()
+	if err != nil {
+		return nil, err
+	}
+	input, err := conn.ReceiveUint32()
+	if err != nil {
+		return nil, err
+	}
+	if input >= len(s.circ.Inputs) {
+		return nil, fmt.Errorf("%w: input %d for %d-input circuit",
+			circuit.ErrProtocolMismatch, input, len(s.circ.Inputs))
+	}
+	arg := s.circ.Inputs[input]
+	if err := circuit.SendArgument(conn, arg); err != nil {
+		return nil, err
+	}
+	if err := conn.SendUint32(len(s.circ.Outputs)); err != nil {
+		return nil, err
+	}
+	for _, o := range s.circ.Outputs {
+		if err := circuit.SendArgument(conn, o); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	data, err := conn.ReceiveData()
+	if err != nil {
+		return nil, err
+	}
+	share := new(big.Int).SetBytes(data)
+	if share.BitLen() > int(arg.Type.Bits) {
+		return nil, fmt.Errorf("%w: %d-bit share for input %s",
+			circuit.ErrInvalidInput, share.BitLen(), arg)
+	}
+	return &submission{
+		conn:    conn,
+		session: id,
+		input:   input,
+		share:   share,
+	}, nil
+}
+
+// add adds the submission to its session. The function returns the
+// session if it has all its inputs.
+func (s *Server) add(sub *submission) *session {
+	sess, ok := s.sessions[sub.session]
+	if !ok {
+		sess = &session{
+			id:      sub.session,
+			created: time.Now(),
+			clients: make([]*p2p.Conn, len(s.circ.Inputs)),
+			shares:  make([]*big.Int, len(s.circ.Inputs)),
+		}
+		s.sessions[sub.session] = sess
+	}
+	if sess.clients[sub.input] != nil {
+		err := fmt.Errorf("%w: input %d of session %s already submitted",
+			circuit.ErrProtocolMismatch, sub.input, sub.session)
+		circuit.Abort(sub.conn, err)
+		sub.conn.Close()
+		return nil
+	}
+	sess.clients[sub.input] = sub.conn
+	sess.shares[sub.input] = sub.share
+	sess.count++
+	if sess.count < len(sess.clients) {
+		return nil
+	}
+	delete(s.sessions, sess.id)
+	return sess
+}
+
+// evict drops the sessions that are older than the session timeout.
+func (s *Server) evict(now time.Time) {
+	for id, sess := range s.sessions {
+		if now.Sub(sess.created) < s.timeout {
+			continue
+		}
+		delete(s.sessions, id)
+		log.Printf("outsource: session %s: timed out with %d/%d inputs\n",
+			id, sess.count, len(sess.clients))
+		sess.abort("session timed out")
+	}
+}
+
+// nextSession waits for the next complete session and announces it
+// to the evaluator server. The function returns nil if the evaluator
+// rejects the session.
+func (s *Server) nextSession(ctx context.Context,
+	submissions <-chan *submission, tick <-chan time.Time) (
+	*session, error) {
+
+	var sess *session
+	for sess == nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case now := <-tick:
+			s.evict(now)
+		case sub := <-submissions:
+			sess = s.add(sub)
+		}
+	}
+
+	// The evaluator answers in its session timeout.
+	actx, cancel := context.WithTimeout(ctx, s.timeout+time.Minute)
+	defer cancel()
+	release := s.peer.WithContext(actx)
+	defer release()
+
+	if err := s.peer.SendString(sess.id); err != nil {
+		return nil, err
+	}
+	if err := s.peer.Flush(); err != nil {
+		return nil, err
+	}
+	ack, err := s.peer.ReceiveByte()
+	if err != nil {
+		return nil, err
+	}
+	switch ack {
+	case sessionAck:
+		return sess, nil
+	case sessionNack:
+		log.Printf("outsource: session %s: rejected by evaluator\n", sess.id)
+		sess.abort("session rejected by evaluator")
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: invalid session ack %d",
+			circuit.ErrProtocolMismatch, ack)
+	}
+}
+
+// announcedSession receives the next session from the garbler server
+// and waits until the session has all its inputs. The function
+// acknowledges the complete session to the garbler. If the session
+// doesn't get all its inputs in the session timeout, the function
+// rejects the session and returns nil.
+func (s *Server) announcedSession(ctx context.Context,
+	submissions <-chan *submission, tick <-chan time.Time) (
+	*session, error) {
+
+	release := s.peer.WithContext(ctx)
+	id, err := s.peer.ReceiveString()
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	var sess *session
+	for sess == nil {
+		if c, ok := s.sessions[id]; ok && c.count == len(c.clients) {
+			delete(s.sessions, id)
+			sess = c
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case now := <-tick:
+			s.evict(now)
+		case <-timer.C:
+			if c, ok := s.sessions[id]; ok {
+				delete(s.sessions, id)
+				c.abort("session timed out")
+			}
+			log.Printf("outsource: session %s: rejected\n", id)
+			return nil, s.ack(ctx, sessionNack)
+		case sub := <-submissions:
+			c := s.add(sub)
+			if c != nil && c.id != id {
+				// Keep the complete session until it is announced.
+				s.sessions[c.id] = c
+			} else if c != nil {
+				sess = c
+			}
+		}
+	}
+	return sess, s.ack(ctx, sessionAck)
+}
+
+// ack sends the session acknowledgement to the garbler server.
+func (s *Server) ack(ctx context.Context, ack byte) error {
+	release := s.peer.WithContext(ctx)
+	defer release()
+
+	if err := s.peer.SendByte(ack); err != nil {
+		return err
+	}
+	return s.peer.Flush()
+}
+
+// run runs the session with the peer server and sends our output
+// shares to the session's clients.
+func (s *Server) run(ctx context.Context, sess *session) error {
+	input := new(big.Int)
+	var offset int
+	for idx, arg := range s.circ.Inputs {
+		input.Or(input, new(big.Int).Lsh(sess.shares[idx], uint(offset)))
+		offset += int(arg.Type.Bits)
+	}
+
+	var result []*big.Int
+	var err error
+	if s.garbler {
+		result, err = circuit.Garbler(ctx, s.peer, s.oti, s.wrapped, input,
+			false)
+	} else {
+		result, err = circuit.Evaluator(ctx, s.peer, s.oti, s.wrapped, input,
+			false)
+	}
+	if err != nil {
+		for _, conn := range sess.clients {
+			conn.Abort(p2p.AbortInternal, err.Error())
+			conn.Close()
+		}
+		return err
+	}
+
+	data := join(s.wrapped.Outputs, result).Bytes()
+	for idx, conn := range sess.clients {
+		release := conn.WithContext(ctx)
+		err := conn.SendData(data)
+		if err == nil {
+			err = conn.Flush()
+		}
+		release()
+		conn.Close()
+		if err != nil {
+			log.Printf("outsource: session %s: client %d: %s\n",
+				sess.id, idx, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+//
+// abort.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	maxAbortSize   = 4096
+	maxAbortReason = maxAbortSize - 4
+
+	// abortTimeout limits the time for writing the abort message.
+	abortTimeout = 5 * time.Second
+)
+
+var (
+	// ErrPeerAbort is returned when the peer aborted the protocol. The
+	// AbortError returned from the connection carries the peer's
+	// abort code and reason.
+	ErrPeerAbort = errors.New("peer aborted protocol")
+
+	// ErrProtocolMismatch is returned when the peers run different
+	// protocols or circuits.
+	ErrProtocolMismatch = errors.New("protocol mismatch")
+)
+
+// AbortCode specifies the reason why a peer aborted the protocol.
+type AbortCode uint32
+
+// Abort codes.
+const (
+	AbortInternal AbortCode = iota
+	AbortProtocolMismatch
+	AbortInvalidInput
+	AbortCorruptGarbledTable
+	AbortInvalidOutputLabel
+	AbortInvalidMAC
+	AbortInvalidTriple
+	AbortInvalidCorrelation
+)
+
+var abortCodes = map[AbortCode]string{
+	AbortInternal:            "internal error",
+	AbortProtocolMismatch:    "protocol mismatch",
+	AbortInvalidInput:        "invalid input",
+	AbortCorruptGarbledTable: "corrupt garbled table",
+	AbortInvalidOutputLabel:  "invalid output label",
+	AbortInvalidMAC:          "invalid MAC",
+	AbortInvalidTriple:       "invalid multiplication triple",
+	AbortInvalidCorrelation:  "invalid MAC correlation",
+}
+
+func (code AbortCode) String() string {
+	name, ok := abortCodes[code]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{AbortCode %d}", code)
+}
+
+// AbortError is returned from the connection's receive functions
+// when the peer has aborted the protocol. The error matches
+// ErrPeerAbort with errors.Is.
+type AbortError struct {
+	Code   AbortCode
+	Reason string
+}
+
+func (e *AbortError) Error() string {
+	if len(e.Reason) == 0 {
+		return fmt.Sprintf("%s: %s", ErrPeerAbort, e.Code)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrPeerAbort, e.Code, e.Reason)
+}
+
+// Is tests if the target error is ErrPeerAbort.
+func (e *AbortError) Is(target error) bool {
+	return target == ErrPeerAbort
+}
+
+// Abort flushes any pending data and sends an abort message to the
+// peer. The peer's pending and future receive operations fail with an
+// AbortError holding the code and the reason. The connection must not
+// be used for sending data after Abort.
+//
+// The abort message is sent even if the connection's context is done
+// or the connection was interrupted. If the transport supports
+// deadlines, it gets a fresh deadline of abortTimeout for writing the
+// message. The deadline is left in place so a peer that does not read
+// can't block Close either. The pending data of an interrupted
+// connection is discarded.
+func (c *Conn) Abort(code AbortCode, reason string) error {
+	if d, ok := c.conn.(deadliner); ok {
+		d.SetDeadline(time.Now().Add(abortTimeout))
+	}
+	if c.interrupted != nil {
+		c.WritePos = frameHeaderSize
+	} else if err := c.Flush(); err != nil {
+		return err
+	}
+	if len(reason) > maxAbortReason {
+		reason = reason[:maxAbortReason]
+	}
+	binary.BigEndian.PutUint32(c.WriteBuf[c.WritePos:], uint32(code))
+	c.WritePos += 4
+	c.WritePos += copy(c.WriteBuf[c.WritePos:], reason)
+
+	return c.sendFrame(frameAbort)
+}
@@ -0,0 +1,87 @@
+//
+// abort_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAbort(t *testing.T) {
+	p0, p1 := newPipes()
+	c0 := NewConn(p0)
+	c1 := NewConn(p1)
+
+	go func() {
+		c0.SendUint32(42)
+		c0.Abort(AbortInvalidInput, "bad input")
+	}()
+
+	// Data sent before the abort is received normally.
+	v, err := c1.ReceiveUint32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 42 {
+		t.Errorf("got %v, expected 42", v)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = c1.ReceiveUint32()
+		if !errors.Is(err, ErrPeerAbort) {
+			t.Fatalf("expected %v, got %v", ErrPeerAbort, err)
+		}
+		var abortErr *AbortError
+		if !errors.As(err, &abortErr) {
+			t.Fatalf("expected AbortError, got %T", err)
+		}
+		if abortErr.Code != AbortInvalidInput {
+			t.Errorf("got code %s, expected %s", abortErr.Code,
+				AbortInvalidInput)
+		}
+		if abortErr.Reason != "bad input" {
+			t.Errorf("got reason %q, expected %q", abortErr.Reason,
+				"bad input")
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	p0, p1 := newPipes()
+	c0 := NewConn(p0)
+	c1 := NewConn(p1)
+
+	// Values span several write buffers and frames.
+	const count = 3 * writeBufSize / 4
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < count; i++ {
+			c0.SendUint32(i)
+		}
+		c0.Flush()
+		close(done)
+	}()
+
+	for i := 0; i < count; i++ {
+		v, err := c1.ReceiveUint32()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i {
+			t.Fatalf("value %d: got %v", i, v)
+		}
+	}
+	<-done
+	frames := c0.Stats.Flushed.Load()
+	if c1.Stats.Recvd.Load() != uint64(count*4)+frames*frameHeaderSize {
+		t.Errorf("received %d bytes in %d frames", c1.Stats.Recvd.Load(),
+			frames)
+	}
+}
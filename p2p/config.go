@@ -0,0 +1,142 @@
+//
+// config.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// PublicKey is a party's ed25519 public key. The key is encoded as a
+// hex string in the network configuration.
+type PublicKey ed25519.PublicKey
+
+// MarshalText implements encoding.TextMarshaler.
+func (key PublicKey) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(key)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (key *PublicKey) UnmarshalText(text []byte) error {
+	data, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid public key: %s", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: %d", len(data))
+	}
+	*key = data
+	return nil
+}
+
+func (key PublicKey) String() string {
+	return hex.EncodeToString(key)
+}
+
+// PeerConfig describes a party of the network.
+type PeerConfig struct {
+	ID        int       `json:"id"`
+	Addr      string    `json:"addr"`
+	PublicKey PublicKey `json:"public_key,omitempty"`
+}
+
+// Config describes the parties of a multi-party computation
+// network. The configuration is stored as JSON:
+//
+//	{
+//	  "peers": [
+//	    {"id": 0, "addr": "10.0.0.1:8080", "public_key": "d75a..."},
+//	    {"id": 1, "addr": "10.0.0.2:8080", "public_key": "3d40..."}
+//	  ],
+//	  "emulation": "latency=20ms,bandwidth=100M"
+//	}
+//
+// The party IDs must be 0...n-1 for an n-party computation. The
+// optional emulation specifies the network conditions to emulate for
+// the peer connections, see ParseEmulation.
+type Config struct {
+	Peers     []PeerConfig `json:"peers"`
+	Emulation *Emulation   `json:"emulation,omitempty"`
+}
+
+// LoadConfig loads the network configuration from the file.
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses the JSON network configuration.
+func ParseConfig(data []byte) (*Config, error) {
+	config := new(Config)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewLoopbackConfig creates a configuration for count parties
+// listening at the loopback address ports port, port+1, ...
+func NewLoopbackConfig(count, port int) *Config {
+	config := new(Config)
+	for i := 0; i < count; i++ {
+		config.Peers = append(config.Peers, PeerConfig{
+			ID:   i,
+			Addr: fmt.Sprintf("127.0.0.1:%d", port+i),
+		})
+	}
+	return config
+}
+
+// Verify verifies that the configuration is valid.
+func (c *Config) Verify() error {
+	if len(c.Peers) < 2 {
+		return fmt.Errorf("network must have at least 2 peers: got %d",
+			len(c.Peers))
+	}
+	seen := make(map[int]bool)
+	addrs := make(map[string]bool)
+	for _, peer := range c.Peers {
+		if peer.ID < 0 || peer.ID >= len(c.Peers) {
+			return fmt.Errorf("peer ID %d out of range [0...%d[",
+				peer.ID, len(c.Peers))
+		}
+		if seen[peer.ID] {
+			return fmt.Errorf("duplicate peer ID %d", peer.ID)
+		}
+		seen[peer.ID] = true
+		if len(peer.Addr) == 0 {
+			return fmt.Errorf("peer %d: no address", peer.ID)
+		}
+		if addrs[peer.Addr] {
+			return fmt.Errorf("peer %d: duplicate address %s",
+				peer.ID, peer.Addr)
+		}
+		addrs[peer.Addr] = true
+	}
+	return nil
+}
+
+// Peer returns the configuration of the peer id.
+func (c *Config) Peer(id int) (*PeerConfig, error) {
+	for idx := range c.Peers {
+		if c.Peers[idx].ID == id {
+			return &c.Peers[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("peer %d not in network configuration", id)
+}
@@ -0,0 +1,133 @@
+//
+// config_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fmt.Sprintf(`{
+  "peers": [
+    {"id": 1, "addr": "127.0.0.1:9001"},
+    {"id": 0, "addr": "127.0.0.1:9000", "public_key": "%x"}
+  ],
+  "emulation": "latency=10ms,bandwidth=100M"
+}`, []byte(pub))
+
+	config, err := ParseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %s", err)
+	}
+	peer, err := config.Peer(0)
+	if err != nil {
+		t.Fatalf("Peer failed: %s", err)
+	}
+	if peer.Addr != "127.0.0.1:9000" {
+		t.Errorf("unexpected address: %s", peer.Addr)
+	}
+	if !ed25519.PublicKey(peer.PublicKey).Equal(pub) {
+		t.Errorf("public key mismatch")
+	}
+	if _, err := config.Peer(2); err == nil {
+		t.Errorf("unknown peer found")
+	}
+	if config.Emulation == nil || config.Emulation.Bandwidth != 100000000 {
+		t.Errorf("unexpected emulation: %v", config.Emulation)
+	}
+
+	out, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+	parsed, err := ParseConfig(out)
+	if err != nil {
+		t.Errorf("marshalled config is invalid: %s", err)
+	} else if *parsed.Emulation != *config.Emulation {
+		t.Errorf("marshalled emulation: got %v, expected %v",
+			parsed.Emulation, config.Emulation)
+	}
+
+	for _, invalid := range []string{
+		`{"peers": [{"id": 0, "addr": "a:1"}]}`,
+		`{"peers": [{"id": 0, "addr": "a:1"}, {"id": 0, "addr": "a:2"}]}`,
+		`{"peers": [{"id": 0, "addr": "a:1"}, {"id": 2, "addr": "a:2"}]}`,
+		`{"peers": [{"id": 0, "addr": "a:1"}, {"id": 1, "addr": "a:1"}]}`,
+		`{"peers": [{"id": 0, "addr": "a:1"}, {"id": 1}]}`,
+		`{"peers": [{"id": 0, "addr": "a:1"},
+                    {"id": 1, "addr": "a:2", "public_key": "0102"}]}`,
+	} {
+		if _, err := ParseConfig([]byte(invalid)); err == nil {
+			t.Errorf("invalid config accepted: %s", invalid)
+		}
+	}
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestConfigNetwork(t *testing.T) {
+	const numPeers = 4
+
+	config := new(Config)
+	for i := 0; i < numPeers; i++ {
+		config.Peers = append(config.Peers, PeerConfig{
+			ID:   i,
+			Addr: fmt.Sprintf("127.0.0.1:%d", freePort(t)),
+		})
+	}
+
+	var networks []*Network
+	for i := 0; i < numPeers; i++ {
+		nw, err := NewConfigNetwork(config, i, nil)
+		if err != nil {
+			t.Fatalf("NewConfigNetwork failed: %s", err)
+		}
+		defer nw.Close()
+		networks = append(networks, nw)
+	}
+
+	errs := make(chan error)
+	for _, nw := range networks {
+		go func(nw *Network) {
+			errs <- nw.Connect(context.Background())
+		}(nw)
+	}
+	for range networks {
+		if err := <-errs; err != nil {
+			t.Fatalf("Connect failed: %s", err)
+		}
+	}
+	for _, nw := range networks {
+		if len(nw.Peers) != numPeers-1 {
+			t.Errorf("network %d: %d peers, expected %d", nw.ID,
+				len(nw.Peers), numPeers-1)
+		}
+		for id := range nw.Peers {
+			if id == nw.ID || id < 0 || id >= numPeers {
+				t.Errorf("network %d: unexpected peer %d", nw.ID, id)
+			}
+		}
+	}
+}
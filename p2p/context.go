@@ -0,0 +1,129 @@
+//
+// context.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// deadliner is implemented by transports that support I/O deadlines
+// such as net.Conn and tls.Conn.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// WithContext binds the connection I/O to the context. When the
+// context is canceled or its deadline expires, all pending and
+// future reads and writes fail with the context's error. The
+// connection transport is interrupted by expiring its I/O deadline if
+// it supports deadlines, or by closing it otherwise.
+//
+// The returned function releases the binding and restores the
+// previous context. It must be called when the bound operation is
+// done. If the binding interrupted the connection, the release clears
+// the transport deadline but the connection can't be used anymore
+// since its frames may be partially read or written: all further
+// reads and writes fail with an error wrapping the context's error.
+// Only Abort can still be used to report the failure to the peer.
+func (c *Conn) WithContext(ctx context.Context) func() {
+	prev := c.ctx
+	c.ctx = ctx
+
+	if ctx.Done() == nil {
+		// Context can never be canceled.
+		return func() {
+			c.ctx = prev
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var interrupted, deadline bool
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			interrupted = true
+			deadline = c.interrupt()
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		c.ctx = prev
+		if !interrupted {
+			return
+		}
+		if deadline {
+			c.conn.(deadliner).SetDeadline(time.Time{})
+		}
+		if c.interrupted == nil {
+			c.interrupted = fmt.Errorf("connection interrupted: %w",
+				ctx.Err())
+		}
+	}
+}
+
+// interrupt interrupts the pending I/O operations of the connection
+// transport. The function returns true if the transport was
+// interrupted by expiring its deadline and false if it was closed.
+func (c *Conn) interrupt() bool {
+	if d, ok := c.conn.(deadliner); ok {
+		if d.SetDeadline(time.Unix(1, 0)) == nil {
+			return true
+		}
+	}
+	if closer, ok := c.conn.(io.Closer); ok {
+		closer.Close()
+	}
+	return false
+}
+
+// ctxErr returns the error of the connection's context or nil if the
+// connection is not bound to a context or the context is still
+// active. If an earlier binding interrupted the connection, ctxErr
+// returns the interruption error.
+func (c *Conn) ctxErr() error {
+	if c.interrupted != nil {
+		return c.interrupted
+	}
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+// ioError maps the I/O error err to the context's error if the I/O
+// failed because the context was done.
+func (c *Conn) ioError(err error) error {
+	if ctxErr := c.ctxErr(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// WithContext binds the I/O of all peer connections to the
+// context. See Conn.WithContext for details. The returned function
+// releases the bindings.
+func (nw *Network) WithContext(ctx context.Context) func() {
+	var releases []func()
+	for _, peer := range nw.Peers {
+		releases = append(releases, peer.conn.WithContext(ctx))
+	}
+	return func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+//
+// context_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnContext(t *testing.T) {
+	// net.Pipe supports deadlines and io.Pipe is interrupted by
+	// closing it.
+	for _, name := range []string{"net.Pipe", "io.Pipe"} {
+		var c0 *Conn
+		if name == "net.Pipe" {
+			p0, _ := net.Pipe()
+			c0 = NewConn(p0)
+		} else {
+			p0, _ := newPipes()
+			c0 = NewConn(p0)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		release := c0.WithContext(ctx)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		_, err := c0.ReceiveUint32()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: ReceiveUint32: expected %v, got %v", name,
+				context.Canceled, err)
+		}
+		release()
+	}
+}
+
+func TestConnContextWrite(t *testing.T) {
+	p0, _ := net.Pipe()
+	c0 := NewConn(p0)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	release := c0.WithContext(ctx)
+	defer release()
+
+	// The peer never reads so the writer blocks. The flushes succeed
+	// until the connection runs out of write buffers.
+	var err error
+	for i := 0; i <= numBuffers && err == nil; i++ {
+		err = c0.SendUint32(i)
+		if err == nil {
+			err = c0.Flush()
+		}
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush: expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestConnContextRelease(t *testing.T) {
+	p0, p1 := net.Pipe()
+	c0 := NewConn(p0)
+	c1 := NewConn(p1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release := c0.WithContext(ctx)
+	release()
+	cancel()
+
+	// Released binding does not affect the connection.
+	go func() {
+		c1.SendUint32(42)
+		c1.Flush()
+	}()
+	v, err := c0.ReceiveUint32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 42 {
+		t.Errorf("got %v, expected 42", v)
+	}
+}
+
+func TestConnContextInterrupted(t *testing.T) {
+	p0, p1 := net.Pipe()
+	c0 := NewConn(p0)
+	c1 := NewConn(p1)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	release := c0.WithContext(ctx)
+	_, err := c0.ReceiveUint32()
+	release()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReceiveUint32: expected %v, got %v",
+			context.DeadlineExceeded, err)
+	}
+
+	// The interrupted connection can't be used after the release.
+	_, err = c0.ReceiveUint32()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReceiveUint32 after release: expected %v, got %v",
+			context.DeadlineExceeded, err)
+	}
+	if err := c0.SendUint32(42); err == nil {
+		err = c0.Flush()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Flush after release: expected %v, got %v",
+				context.DeadlineExceeded, err)
+		}
+	}
+
+	// But it can still abort the protocol.
+	go c0.Abort(AbortInternal, "timeout")
+	_, err = c1.ReceiveUint32()
+	if !errors.Is(err, ErrPeerAbort) {
+		t.Errorf("ReceiveUint32: expected %v, got %v", ErrPeerAbort, err)
+	}
+}
+
+func TestAddPeerContext(t *testing.T) {
+	nw, err := NewNetwork(fmt.Sprintf("127.0.0.1:%d", freePort(t)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Close()
+
+	// Nobody listens at the peer address.
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = nw.AddPeer(ctx, fmt.Sprintf("127.0.0.1:%d", freePort(t)), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AddPeer: expected %v, got %v", context.DeadlineExceeded,
+			err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("AddPeer did not stop at context deadline")
+	}
+}
@@ -0,0 +1,676 @@
+//
+// dealer.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+)
+
+const (
+	dealerMagic = 0x6465616c // deal
+)
+
+// ErrExhausted is returned when the dealt correlations are used up.
+var ErrExhausted = errors.New("dealt correlations exhausted")
+
+// Dealer implements a trusted dealer that creates the correlated
+// randomness of the multi-party protocols. The dealer deals XOR
+// shares of random bits and of AND triples to all parties, optionally
+// authenticated with the parties' MAC keys, and correlated oblivious
+// transfers between all pairs of parties. The parties fetch their
+// correlations with Network.Preprocess before running the protocol,
+// and the protocols use them instead of the public-key oblivious
+// transfers. This makes the preprocessing fast so that the online
+// phases of the protocols can be profiled and tested with many
+// parties.
+//
+// The dealer knows all correlations it creates and it can break the
+// privacy of all parties. The dealer is INSECURE and it must not be
+// used in production.
+type Dealer struct {
+	numParties int
+	listener   net.Listener
+	m          sync.Mutex
+	clients    map[int]*dealerClient
+}
+
+// NewDealer creates a new dealer for numParties parties listening at
+// the address.
+func NewDealer(addr string, numParties int) (*Dealer, error) {
+	if numParties < 2 {
+		return nil, fmt.Errorf("invalid number of parties: %d", numParties)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Dealer{
+		numParties: numParties,
+		listener:   listener,
+		clients:    make(map[int]*dealerClient),
+	}, nil
+}
+
+// Addr returns the dealer's listen address.
+func (d *Dealer) Addr() string {
+	return d.listener.Addr().String()
+}
+
+// Close closes the dealer.
+func (d *Dealer) Close() error {
+	return d.listener.Close()
+}
+
+// Correlations specifies the correlated randomness that the parties
+// request from the dealer. All parties of a session must request the
+// same correlations.
+type Correlations struct {
+	// Bits is the number of random bits.
+	Bits int
+	// Triples is the number of AND triples.
+	Triples int
+	// Authenticated specifies if the bits and triples are
+	// authenticated with the parties' MAC keys.
+	Authenticated bool
+	// COTs is the number of correlated oblivious transfers with each
+	// peer in each direction.
+	COTs int
+}
+
+// Shares holds our XOR shares of dealt bits. With authenticated
+// shares, MACs[j][i] is our MAC of our share of the bit i for the
+// peer j, and Keys[j][i] our key of the peer j's share of the bit i.
+// For the peer j's key K of our share, MACs[j][i] = K XOR
+// Bits[i]*Delta_j.
+type Shares struct {
+	Bits []byte
+	MACs [][]ot.Label
+	Keys [][]ot.Label
+}
+
+func newShares(count, n int, authenticated bool) *Shares {
+	s := &Shares{
+		Bits: make([]byte, count),
+	}
+	if authenticated {
+		s.MACs = make([][]ot.Label, n)
+		s.Keys = make([][]ot.Label, n)
+	}
+	return s
+}
+
+// Dealt holds our correlations from the dealer.
+type Dealt struct {
+	// Delta is our MAC key of the authenticated shares.
+	Delta ot.Label
+	// Bits holds the shares of the random bits.
+	Bits *Shares
+	// A, B, and C hold the shares of the AND triples with C = A AND
+	// B.
+	A *Shares
+	B *Shares
+	C *Shares
+}
+
+// cot holds the correlated oblivious transfers with a peer. In the
+// transfers where we are the sender, we hold the offset and the
+// messages send. In the transfers where we are the receiver, we hold
+// the choices and the messages recv such that recv[i] = send[i] XOR
+// choices[i]*offset.
+type cot struct {
+	offset  ot.Label
+	send    []ot.Label
+	choices []byte
+	recv    []ot.Label
+	// delta is the offset of our first OT-R. The transfers with the
+	// peer must use the same offset.
+	delta *ot.Label
+}
+
+type dealerClient struct {
+	conn *Conn
+	id   int
+	req  Correlations
+}
+
+// Serve serves the parties until the dealer is closed. Each session
+// collects a request from all parties and deals the correlations to
+// them. Each connection is handled in its own goroutine and its
+// request must arrive in handshakeTimeout.
+func (d *Dealer) Serve() error {
+	for {
+		nc, err := d.listener.Accept()
+		if err != nil {
+			d.m.Lock()
+			for id, client := range d.clients {
+				client.conn.Close()
+				delete(d.clients, id)
+			}
+			d.m.Unlock()
+			return err
+		}
+		go d.accept(nc)
+	}
+}
+
+// accept reads the request of the connection and starts the session
+// when all parties are connected.
+func (d *Dealer) accept(nc net.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		handshakeTimeout)
+	defer cancel()
+
+	conn := NewConn(nc)
+	release := conn.WithContext(ctx)
+	client, err := d.hello(conn)
+	release()
+	if err != nil {
+		log.Printf("dealer: %s: %s\n", nc.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if prev, ok := d.clients[client.id]; ok {
+		log.Printf("dealer: party %d reconnected\n", client.id)
+		prev.conn.Close()
+	}
+	d.clients[client.id] = client
+	if len(d.clients) == d.numParties {
+		go func(clients map[int]*dealerClient) {
+			if err := d.session(clients); err != nil {
+				log.Printf("dealer: %s\n", err)
+			}
+		}(d.clients)
+		d.clients = make(map[int]*dealerClient)
+	}
+}
+
+func (d *Dealer) hello(conn *Conn) (*dealerClient, error) {
+	magic, err := conn.ReceiveUint32()
+	if err != nil {
+		return nil, err
+	}
+	if magic != dealerMagic {
+		return nil, fmt.Errorf("%w: invalid magic 0x%x",
+			ErrProtocolMismatch, magic)
+	}
+	var vals [6]int
+	for i := range vals {
+		vals[i], err = conn.ReceiveUint32()
+		if err != nil {
+			return nil, err
+		}
+	}
+	numParties := vals[0]
+	id := vals[1]
+	if numParties != d.numParties || id >= numParties {
+		reason := fmt.Sprintf("party %d of %d, dealer has %d parties",
+			id, numParties, d.numParties)
+		conn.Abort(AbortProtocolMismatch, reason)
+		return nil, fmt.Errorf("%w: %s", ErrProtocolMismatch, reason)
+	}
+	return &dealerClient{
+		conn: conn,
+		id:   id,
+		req: Correlations{
+			Bits:          vals[2],
+			Triples:       vals[3],
+			Authenticated: vals[4] != 0,
+			COTs:          vals[5],
+		},
+	}, nil
+}
+
+func (d *Dealer) session(clients map[int]*dealerClient) error {
+	defer func() {
+		for _, client := range clients {
+			client.conn.Close()
+		}
+	}()
+
+	req := clients[0].req
+	for id, client := range clients {
+		if client.req != req {
+			reason := fmt.Sprintf("party %d requested %+v, party 0 %+v",
+				id, client.req, req)
+			for _, c := range clients {
+				c.conn.Abort(AbortProtocolMismatch, reason)
+			}
+			return fmt.Errorf("%w: %s", ErrProtocolMismatch, reason)
+		}
+	}
+
+	dealt, err := d.deal(req)
+	if err != nil {
+		return err
+	}
+
+	// Create the correlated transfers for each ordered pair of
+	// parties.
+	cots := make([][]*cot, d.numParties)
+	for i := range cots {
+		cots[i] = make([]*cot, d.numParties)
+		for j := range cots[i] {
+			if i != j {
+				cots[i][j] = new(cot)
+			}
+		}
+	}
+	for i := 0; i < d.numParties; i++ {
+		for j := 0; j < d.numParties; j++ {
+			if i != j {
+				err := newCOTs(req.COTs, cots[i][j], cots[j][i])
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, d.numParties)
+	for id, client := range clients {
+		wg.Add(1)
+		go func(id int, conn *Conn) {
+			defer wg.Done()
+			if err := dealTo(conn, id, req, dealt[id], cots[id]); err != nil {
+				errs <- fmt.Errorf("party %d: %w", id, err)
+			}
+		}(id, client.conn)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// deal creates the parties' shares of the random bits and AND
+// triples.
+func (d *Dealer) deal(req Correlations) ([]*Dealt, error) {
+	n := d.numParties
+	dealt := make([]*Dealt, n)
+	for i := range dealt {
+		dealt[i] = &Dealt{
+			Bits: newShares(req.Bits, n, req.Authenticated),
+			A:    newShares(req.Triples, n, req.Authenticated),
+			B:    newShares(req.Triples, n, req.Authenticated),
+			C:    newShares(req.Triples, n, req.Authenticated),
+		}
+		if req.Authenticated {
+			var err error
+			dealt[i].Delta, err = ot.NewLabel(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	shares := func(f func(p *Dealt) *Shares) []*Shares {
+		result := make([]*Shares, n)
+		for i, p := range dealt {
+			result[i] = f(p)
+		}
+		return result
+	}
+	bits := shares(func(p *Dealt) *Shares { return p.Bits })
+	a := shares(func(p *Dealt) *Shares { return p.A })
+	b := shares(func(p *Dealt) *Shares { return p.B })
+	c := shares(func(p *Dealt) *Shares { return p.C })
+
+	for _, s := range [][]*Shares{bits, a, b, c} {
+		for _, party := range s {
+			if _, err := rand.Read(party.Bits); err != nil {
+				return nil, err
+			}
+			for i := range party.Bits {
+				party.Bits[i] &= 1
+			}
+		}
+	}
+	// The last party's share of C makes C = A AND B.
+	for t := 0; t < req.Triples; t++ {
+		var va, vb, vc byte
+		for i := 0; i < n; i++ {
+			va ^= a[i].Bits[t]
+			vb ^= b[i].Bits[t]
+			if i < n-1 {
+				vc ^= c[i].Bits[t]
+			}
+		}
+		c[n-1].Bits[t] = va&vb ^ vc
+	}
+
+	if req.Authenticated {
+		for _, s := range [][]*Shares{bits, a, b, c} {
+			if err := authenticateShares(s, dealt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return dealt, nil
+}
+
+// authenticateShares creates the MACs and keys of the parties'
+// shares.
+func authenticateShares(shares []*Shares, dealt []*Dealt) error {
+	for i, s := range shares {
+		for j := range shares {
+			if i == j {
+				continue
+			}
+			macs := make([]ot.Label, len(s.Bits))
+			keys := make([]ot.Label, len(s.Bits))
+			for k, bit := range s.Bits {
+				key, err := ot.NewLabel(rand.Reader)
+				if err != nil {
+					return err
+				}
+				keys[k] = key
+				if bit != 0 {
+					key.Xor(dealt[j].Delta)
+				}
+				macs[k] = key
+			}
+			s.MACs[j] = macs
+			shares[j].Keys[i] = keys
+		}
+	}
+	return nil
+}
+
+// newCOTs creates count correlated oblivious transfers from the
+// sender to the receiver.
+func newCOTs(count int, sender, receiver *cot) error {
+	var err error
+	sender.offset, err = ot.NewLabel(rand.Reader)
+	if err != nil {
+		return err
+	}
+	sender.send = make([]ot.Label, count)
+	receiver.choices = make([]byte, count)
+	receiver.recv = make([]ot.Label, count)
+	if _, err := rand.Read(receiver.choices); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		sender.send[i], err = ot.NewLabel(rand.Reader)
+		if err != nil {
+			return err
+		}
+		receiver.choices[i] &= 1
+		receiver.recv[i] = sender.send[i]
+		if receiver.choices[i] != 0 {
+			receiver.recv[i].Xor(sender.offset)
+		}
+	}
+	return nil
+}
+
+// dealTo sends the party's shares and its correlated transfers with
+// all its peers.
+func dealTo(conn *Conn, id int, req Correlations, dealt *Dealt,
+	cots []*cot) error {
+
+	var data ot.LabelData
+	if req.Authenticated {
+		if err := conn.SendLabel(dealt.Delta, &data); err != nil {
+			return err
+		}
+	}
+	for _, s := range []*Shares{dealt.Bits, dealt.A, dealt.B, dealt.C} {
+		if err := sendShares(conn, id, s); err != nil {
+			return err
+		}
+	}
+	for peer, c := range cots {
+		if peer == id {
+			continue
+		}
+		if err := conn.SendUint32(peer); err != nil {
+			return err
+		}
+		if err := conn.SendLabel(c.offset, &data); err != nil {
+			return err
+		}
+		for _, l := range c.send {
+			if err := conn.SendLabel(l, &data); err != nil {
+				return err
+			}
+		}
+		for i, choice := range c.choices {
+			if err := conn.SendByte(choice); err != nil {
+				return err
+			}
+			if err := conn.SendLabel(c.recv[i], &data); err != nil {
+				return err
+			}
+		}
+	}
+	return conn.Flush()
+}
+
+// sendShares sends the party's shares with their MACs and keys.
+func sendShares(conn *Conn, id int, s *Shares) error {
+	var data ot.LabelData
+	for i, bit := range s.Bits {
+		if err := conn.SendByte(bit); err != nil {
+			return err
+		}
+		for peer := range s.MACs {
+			if peer == id {
+				continue
+			}
+			if err := conn.SendLabel(s.MACs[peer][i], &data); err != nil {
+				return err
+			}
+			if err := conn.SendLabel(s.Keys[peer][i], &data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// receiveShares receives our count shares with their MACs and keys.
+func (nw *Network) receiveShares(conn *Conn, count int,
+	authenticated bool) (*Shares, error) {
+
+	n := len(nw.Peers) + 1
+	s := newShares(count, n, authenticated)
+	if authenticated {
+		for peer := range nw.Peers {
+			s.MACs[peer] = make([]ot.Label, count)
+			s.Keys[peer] = make([]ot.Label, count)
+		}
+	}
+	var data ot.LabelData
+	for i := range s.Bits {
+		bit, err := conn.ReceiveByte()
+		if err != nil {
+			return nil, err
+		}
+		if bit > 1 {
+			return nil, fmt.Errorf("%w: invalid share %d",
+				ErrProtocolMismatch, bit)
+		}
+		s.Bits[i] = bit
+		if !authenticated {
+			continue
+		}
+		for peer := 0; peer < n; peer++ {
+			if peer == nw.ID {
+				continue
+			}
+			err := conn.ReceiveLabel(&s.MACs[peer][i], &data)
+			if err != nil {
+				return nil, err
+			}
+			err = conn.ReceiveLabel(&s.Keys[peer][i], &data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Preprocess fetches the correlations from the dealer at the
+// address. After Preprocess, Dealt returns our shares of the random
+// bits and AND triples, and Peer.OTR uses the correlated transfers.
+// All parties of the network must request the same correlations from
+// the same dealer.
+//
+// The dealer knows the correlations of all parties so the protocols
+// run with the dealt correlations are INSECURE. The preprocessing is
+// meant only for testing and benchmarking.
+func (nw *Network) Preprocess(ctx context.Context, addr string,
+	req Correlations) error {
+
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	conn := NewConn(nc)
+	defer conn.Close()
+
+	release := conn.WithContext(ctx)
+	defer release()
+
+	var authenticated int
+	if req.Authenticated {
+		authenticated = 1
+	}
+	for _, v := range []int{dealerMagic, len(nw.Peers) + 1, nw.ID,
+		req.Bits, req.Triples, authenticated, req.COTs} {
+		if err := conn.SendUint32(v); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	var data ot.LabelData
+	dealt := new(Dealt)
+	if req.Authenticated {
+		if err := conn.ReceiveLabel(&dealt.Delta, &data); err != nil {
+			return err
+		}
+	}
+	dealt.Bits, err = nw.receiveShares(conn, req.Bits, req.Authenticated)
+	if err != nil {
+		return err
+	}
+	for _, s := range []**Shares{&dealt.A, &dealt.B, &dealt.C} {
+		*s, err = nw.receiveShares(conn, req.Triples, req.Authenticated)
+		if err != nil {
+			return err
+		}
+	}
+
+	cots := make(map[int]*cot)
+	for range nw.Peers {
+		id, err := conn.ReceiveUint32()
+		if err != nil {
+			return err
+		}
+		if _, ok := nw.Peers[id]; !ok || cots[id] != nil {
+			return fmt.Errorf("%w: unexpected transfers for peer %d",
+				ErrProtocolMismatch, id)
+		}
+		c := &cot{
+			send:    make([]ot.Label, req.COTs),
+			choices: make([]byte, req.COTs),
+			recv:    make([]ot.Label, req.COTs),
+		}
+		if err := conn.ReceiveLabel(&c.offset, &data); err != nil {
+			return err
+		}
+		for i := range c.send {
+			if err := conn.ReceiveLabel(&c.send[i], &data); err != nil {
+				return err
+			}
+		}
+		for i := range c.choices {
+			c.choices[i], err = conn.ReceiveByte()
+			if err != nil {
+				return err
+			}
+			if err := conn.ReceiveLabel(&c.recv[i], &data); err != nil {
+				return err
+			}
+		}
+		cots[id] = c
+	}
+
+	nw.m.Lock()
+	nw.dealt = dealt
+	for id, c := range cots {
+		nw.Peers[id].cot = c
+	}
+	nw.m.Unlock()
+
+	return nil
+}
+
+// Dealt returns our correlations from the dealer or nil if the
+// network is not preprocessed with a dealer.
+func (nw *Network) Dealt() *Dealt {
+	nw.m.Lock()
+	defer nw.m.Unlock()
+	return nw.dealt
+}
+
+// Take removes count bits from the shares and returns them.
+func (s *Shares) Take(count int) (*Shares, error) {
+	if s == nil || len(s.Bits) < count {
+		return nil, ErrExhausted
+	}
+	result := &Shares{
+		Bits: s.Bits[:count],
+	}
+	s.Bits = s.Bits[count:]
+	if s.MACs != nil {
+		result.MACs = make([][]ot.Label, len(s.MACs))
+		result.Keys = make([][]ot.Label, len(s.Keys))
+		for peer := range s.MACs {
+			if s.MACs[peer] == nil {
+				continue
+			}
+			result.MACs[peer] = s.MACs[peer][:count]
+			result.Keys[peer] = s.Keys[peer][:count]
+			s.MACs[peer] = s.MACs[peer][count:]
+			s.Keys[peer] = s.Keys[peer][count:]
+		}
+	}
+	return result, nil
+}
+
+// Triples removes count AND triples from the dealt correlations and
+// returns their shares.
+func (d *Dealt) Triples(count int) (a, b, c *Shares, err error) {
+	if len(d.A.Bits) < count {
+		return nil, nil, nil, ErrExhausted
+	}
+	a, _ = d.A.Take(count)
+	b, _ = d.B.Take(count)
+	c, _ = d.C.Take(count)
+	return a, b, c, nil
+}
@@ -0,0 +1,215 @@
+//
+// dealer_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+)
+
+func newDealerNetworks(t *testing.T) (*Network, *Network) {
+	config := new(Config)
+	for i := 0; i < 2; i++ {
+		config.Peers = append(config.Peers, PeerConfig{
+			ID:   i,
+			Addr: fmt.Sprintf("127.0.0.1:%d", freePort(t)),
+		})
+	}
+	var networks []*Network
+	for i := 0; i < 2; i++ {
+		nw, err := NewConfigNetwork(config, i, nil)
+		if err != nil {
+			t.Fatalf("NewConfigNetwork failed: %s", err)
+		}
+		t.Cleanup(func() {
+			nw.Close()
+		})
+		networks = append(networks, nw)
+	}
+	errs := make(chan error)
+	for _, nw := range networks {
+		go func(nw *Network) {
+			errs <- nw.Connect(context.Background())
+		}(nw)
+	}
+	for range networks {
+		if err := <-errs; err != nil {
+			t.Fatalf("Connect failed: %s", err)
+		}
+	}
+	return networks[0], networks[1]
+}
+
+func randomInt(t *testing.T, bits int) *big.Int {
+	v, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1),
+		uint(bits)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDealer(t *testing.T) {
+	const count = 16
+
+	dealer, err := NewDealer("127.0.0.1:0", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dealer.Close()
+	go dealer.Serve()
+
+	// A silent client must not block the parties.
+	silent, err := net.Dial("tcp", dealer.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	nw0, nw1 := newDealerNetworks(t)
+
+	// The OT R runs three times count transfers in each direction.
+	req := Correlations{
+		Bits:          count,
+		Triples:       count,
+		Authenticated: true,
+		COTs:          3 * count,
+	}
+	errs := make(chan error)
+	for _, nw := range []*Network{nw0, nw1} {
+		go func(nw *Network) {
+			errs <- nw.Preprocess(context.Background(), dealer.Addr(), req)
+		}(nw)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Preprocess failed: %s", err)
+		}
+	}
+
+	dealt := []*Dealt{nw0.Dealt(), nw1.Dealt()}
+	for i := 0; i < count; i++ {
+		a := dealt[0].A.Bits[i] ^ dealt[1].A.Bits[i]
+		b := dealt[0].B.Bits[i] ^ dealt[1].B.Bits[i]
+		c := dealt[0].C.Bits[i] ^ dealt[1].C.Bits[i]
+		if c != a&b {
+			t.Errorf("triple %d: %d AND %d != %d", i, a, b, c)
+		}
+	}
+	shares := func(d *Dealt) []*Shares {
+		return []*Shares{d.Bits, d.A, d.B, d.C}
+	}
+	for we, d := range dealt {
+		peer := 1 - we
+		for idx, s := range shares(d) {
+			theirs := shares(dealt[peer])[idx]
+			for i, bit := range s.Bits {
+				expected := theirs.Keys[we][i]
+				if bit != 0 {
+					expected.Xor(dealt[peer].Delta)
+				}
+				if !s.MACs[peer][i].Equal(expected) {
+					t.Errorf("party %d: invalid MAC of share %d", we, i)
+				}
+			}
+		}
+	}
+
+	choices := randomInt(t, count)
+	r, err := ot.NewLabel(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels [2][]ot.Label
+	for j := 0; j < count; j++ {
+		l, err := ot.NewLabel(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels[0] = append(labels[0], l)
+		l.Xor(r)
+		labels[1] = append(labels[1], l)
+	}
+
+	// Party 1 chooses and party 0 responds with the same values.
+	go func() {
+		peer := nw0.Peers[1]
+		_, _, _, err = peer.OTR(choices, choices, choices,
+			labels[0], labels[1], labels[0], labels[1],
+			labels[0], labels[1])
+		errs <- err
+	}()
+	peer := nw1.Peers[0]
+	ra, rb, rc, err := peer.OTR(choices, choices, choices,
+		labels[0], labels[1], labels[0], labels[1], labels[0], labels[1])
+	if err != nil {
+		t.Fatalf("OTR failed: %s", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("peer failed: %s", err)
+	}
+
+	for i := 0; i < count; i++ {
+		label := labels[0][i]
+		if choices.Bit(i) != 0 {
+			label = labels[1][i]
+		}
+		for _, r := range [][]ot.Label{ra, rb, rc} {
+			if !r[i].Equal(label) {
+				t.Errorf("OTR %d: got %v, expected %v", i, r[i], label)
+			}
+		}
+	}
+
+	// The dealt transfers are used up.
+	_, err = peer.otrQuery(1, choices)
+	if !errors.Is(err, ErrExhausted) {
+		t.Errorf("OTR: got %v, expected %v", err, ErrExhausted)
+	}
+	if _, _, _, err := dealt[0].Triples(count); err != nil {
+		t.Fatalf("Triples failed: %s", err)
+	}
+	_, _, _, err = dealt[0].Triples(1)
+	if !errors.Is(err, ErrExhausted) {
+		t.Errorf("Triples: got %v, expected %v", err, ErrExhausted)
+	}
+}
+
+func TestDealerMismatch(t *testing.T) {
+	dealer, err := NewDealer("127.0.0.1:0", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dealer.Close()
+	go dealer.Serve()
+
+	nw0, nw1 := newDealerNetworks(t)
+
+	errs := make(chan error)
+	for i, nw := range []*Network{nw0, nw1} {
+		go func(nw *Network, count int) {
+			errs <- nw.Preprocess(context.Background(), dealer.Addr(),
+				Correlations{
+					Triples: count,
+				})
+		}(nw, 10+i)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrPeerAbort) {
+			t.Errorf("Preprocess: got %v, expected %v", err, ErrPeerAbort)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+//
+// emulation.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// emulationQueueSize specifies how many packets can be in flight in
+// an emulated connection before writes block.
+const emulationQueueSize = 4096
+
+// Emulation specifies network conditions for emulated
+// connections. The emulation applies to the outbound traffic of a
+// connection so both peers must enable it to emulate both directions
+// of the network.
+type Emulation struct {
+	// Latency specifies the one-way delay of each packet.
+	Latency time.Duration
+	// Jitter specifies the maximum random delay added to the
+	// latency of each packet. The packets are always delivered in
+	// order.
+	Jitter time.Duration
+	// Bandwidth specifies the link capacity in bits per second. The
+	// value 0 means unlimited bandwidth.
+	Bandwidth int64
+	// PacketSize specifies the maximum packet payload in bytes. The
+	// writes are split into packets that are delayed
+	// individually. The value 0 sends each write as one packet.
+	PacketSize int
+	// Overhead specifies the per-packet header bytes that count
+	// against the bandwidth but are not delivered.
+	Overhead int
+}
+
+// ParseEmulation parses the network emulation specification. The
+// specification is a comma-separated list of key=value pairs:
+//
+//	latency=50ms,jitter=5ms,bandwidth=100M,packet=1460,overhead=40
+//
+// The latency and jitter are durations. The bandwidth is in bits per
+// second with an optional k, M, or G suffix. The packet and overhead
+// are in bytes.
+func ParseEmulation(spec string) (*Emulation, error) {
+	em := new(Emulation)
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid emulation parameter '%s'", field)
+		}
+		var err error
+		switch key {
+		case "latency":
+			em.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			em.Jitter, err = time.ParseDuration(value)
+		case "bandwidth":
+			em.Bandwidth, err = parseBandwidth(value)
+		case "packet":
+			em.PacketSize, err = strconv.Atoi(value)
+		case "overhead":
+			em.Overhead, err = strconv.Atoi(value)
+		default:
+			return nil, fmt.Errorf("unknown emulation parameter '%s'", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid emulation %s '%s': %v",
+				key, value, err)
+		}
+	}
+	if em.Latency < 0 || em.Jitter < 0 || em.Bandwidth < 0 ||
+		em.PacketSize < 0 || em.Overhead < 0 {
+		return nil, fmt.Errorf("negative emulation parameter: %s", spec)
+	}
+	return em, nil
+}
+
+func parseBandwidth(value string) (int64, error) {
+	var mul float64 = 1
+	if len(value) > 0 {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			mul = 1e3
+		case 'M':
+			mul = 1e6
+		case 'G':
+			mul = 1e9
+		}
+		if mul != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * mul), nil
+}
+
+func (em *Emulation) String() string {
+	var fields []string
+	if em.Latency > 0 {
+		fields = append(fields, fmt.Sprintf("latency=%s", em.Latency))
+	}
+	if em.Jitter > 0 {
+		fields = append(fields, fmt.Sprintf("jitter=%s", em.Jitter))
+	}
+	if em.Bandwidth > 0 {
+		fields = append(fields, fmt.Sprintf("bandwidth=%d", em.Bandwidth))
+	}
+	if em.PacketSize > 0 {
+		fields = append(fields, fmt.Sprintf("packet=%d", em.PacketSize))
+	}
+	if em.Overhead > 0 {
+		fields = append(fields, fmt.Sprintf("overhead=%d", em.Overhead))
+	}
+	return strings.Join(fields, ",")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (em Emulation) MarshalText() ([]byte, error) {
+	return []byte(em.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (em *Emulation) UnmarshalText(text []byte) error {
+	parsed, err := ParseEmulation(string(text))
+	if err != nil {
+		return err
+	}
+	*em = *parsed
+	return nil
+}
+
+// Emulate wraps the connection so that its writes are delayed
+// according to the emulated network conditions. The writes are
+// queued and return before the data is delivered to the underlying
+// connection, like writes to a socket buffer. The reads are passed
+// through as-is.
+func Emulate(rw io.ReadWriter, em *Emulation) io.ReadWriteCloser {
+	return newEmulator(rw, em)
+}
+
+// EmulateNet wraps the network connection like Emulate. The returned
+// connection can be secured with SecureClient and SecureServer.
+func EmulateNet(nc net.Conn, em *Emulation) net.Conn {
+	return &emulatedNetConn{
+		Conn: nc,
+		e:    newEmulator(nc, em),
+	}
+}
+
+type emulatedNetConn struct {
+	net.Conn
+	e *emulator
+}
+
+func (c *emulatedNetConn) Write(p []byte) (int, error) {
+	return c.e.Write(p)
+}
+
+func (c *emulatedNetConn) Close() error {
+	return c.e.Close()
+}
+
+type packet struct {
+	data    []byte
+	deliver time.Time
+}
+
+type emulator struct {
+	rw       io.ReadWriter
+	em       Emulation
+	rand     *rand.Rand
+	linkFree time.Time
+	last     time.Time
+	queue    chan packet
+	closing  chan struct{}
+	finished chan struct{}
+	closeM   sync.Mutex
+	closed   bool
+	errM     sync.Mutex
+	err      error
+}
+
+func newEmulator(rw io.ReadWriter, em *Emulation) *emulator {
+	e := &emulator{
+		rw:       rw,
+		em:       *em,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		queue:    make(chan packet, emulationQueueSize),
+		closing:  make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go e.sender()
+	return e
+}
+
+func (e *emulator) Read(p []byte) (int, error) {
+	return e.rw.Read(p)
+}
+
+func (e *emulator) Write(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if err := e.error(); err != nil {
+			return n, err
+		}
+		size := len(p) - n
+		if e.em.PacketSize > 0 && size > e.em.PacketSize {
+			size = e.em.PacketSize
+		}
+		pkt := packet{
+			data:    make([]byte, size),
+			deliver: e.schedule(size),
+		}
+		copy(pkt.data, p[n:])
+
+		select {
+		case e.queue <- pkt:
+		case <-e.closing:
+			return n, net.ErrClosed
+		case <-e.finished:
+			return n, e.error()
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// schedule computes the delivery time of a packet of size bytes.
+func (e *emulator) schedule(size int) time.Time {
+	now := time.Now()
+	if e.linkFree.Before(now) {
+		e.linkFree = now
+	}
+	if e.em.Bandwidth > 0 {
+		bits := int64(size+e.em.Overhead) * 8
+		e.linkFree = e.linkFree.Add(
+			time.Duration(bits * int64(time.Second) / e.em.Bandwidth))
+	}
+	deliver := e.linkFree.Add(e.em.Latency)
+	if e.em.Jitter > 0 {
+		deliver = deliver.Add(time.Duration(e.rand.Int63n(
+			int64(e.em.Jitter))))
+	}
+	if deliver.Before(e.last) {
+		deliver = e.last
+	}
+	e.last = deliver
+	return deliver
+}
+
+func (e *emulator) sender() {
+	defer close(e.finished)
+	for {
+		select {
+		case pkt := <-e.queue:
+			if !e.deliver(pkt) {
+				return
+			}
+		case <-e.closing:
+			// Deliver the queued packets.
+			for {
+				select {
+				case pkt := <-e.queue:
+					if !e.deliver(pkt) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *emulator) deliver(pkt packet) bool {
+	if d := time.Until(pkt.deliver); d > 0 {
+		time.Sleep(d)
+	}
+	_, err := e.rw.Write(pkt.data)
+	if err != nil {
+		e.errM.Lock()
+		e.err = err
+		e.errM.Unlock()
+		return false
+	}
+	return true
+}
+
+func (e *emulator) error() error {
+	e.errM.Lock()
+	defer e.errM.Unlock()
+	return e.err
+}
+
+// SetDeadline implements the deadliner interface so Conn.WithContext
+// can interrupt the underlying connection.
+func (e *emulator) SetDeadline(t time.Time) error {
+	d, ok := e.rw.(deadliner)
+	if !ok {
+		return fmt.Errorf("deadlines not supported")
+	}
+	return d.SetDeadline(t)
+}
+
+// Close delivers the queued packets and closes the underlying
+// connection.
+func (e *emulator) Close() error {
+	e.closeM.Lock()
+	if e.closed {
+		e.closeM.Unlock()
+		return net.ErrClosed
+	}
+	e.closed = true
+	close(e.closing)
+	e.closeM.Unlock()
+
+	<-e.finished
+	err := e.error()
+	if closer, ok := e.rw.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+//
+// emulation_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEmulation(t *testing.T) {
+	em, err := ParseEmulation(
+		"latency=50ms,jitter=5ms,bandwidth=1.5M,packet=1460,overhead=40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Emulation{
+		Latency:    50 * time.Millisecond,
+		Jitter:     5 * time.Millisecond,
+		Bandwidth:  1500000,
+		PacketSize: 1460,
+		Overhead:   40,
+	}
+	if *em != expected {
+		t.Errorf("got %+v, expected %+v", *em, expected)
+	}
+	parsed, err := ParseEmulation(em.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *em {
+		t.Errorf("String: got %+v, expected %+v", *parsed, *em)
+	}
+
+	for _, spec := range []string{
+		"latency",
+		"latency=50",
+		"delay=50ms",
+		"bandwidth=fast",
+		"packet=-1",
+	} {
+		_, err := ParseEmulation(spec)
+		if err == nil {
+			t.Errorf("ParseEmulation(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestEmulationLatency(t *testing.T) {
+	const latency = 20 * time.Millisecond
+
+	em := &Emulation{
+		Latency: latency,
+		Jitter:  5 * time.Millisecond,
+	}
+	p0, p1 := newPipes()
+	c0 := NewConn(Emulate(p0, em))
+	c1 := NewConn(Emulate(p1, em))
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			v, err := c1.ReceiveUint32()
+			if err != nil {
+				return
+			}
+			c1.SendUint32(v + 1)
+			c1.Flush()
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := c0.SendUint32(i); err != nil {
+			t.Fatal(err)
+		}
+		if err := c0.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		v, err := c0.ReceiveUint32()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i+1 {
+			t.Fatalf("got %v, expected %v", v, i+1)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < 6*latency {
+		t.Errorf("3 round-trips took %s, expected at least %s", elapsed,
+			6*latency)
+	}
+}
+
+func TestEmulationBandwidth(t *testing.T) {
+	// 8 Mbit/s moves 64kB in 64ms.
+	em := &Emulation{
+		Bandwidth:  8000000,
+		PacketSize: 1000,
+		Jitter:     time.Millisecond,
+	}
+	const count = 16 * 1024
+
+	p0, p1 := newPipes()
+	c0 := NewConn(Emulate(p0, em))
+	c1 := NewConn(p1)
+
+	start := time.Now()
+	go func() {
+		for i := 0; i < count; i++ {
+			c0.SendUint32(i)
+		}
+		c0.Close()
+	}()
+	for i := 0; i < count; i++ {
+		v, err := c1.ReceiveUint32()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i {
+			t.Fatalf("value %d: got %v", i, v)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < 60*time.Millisecond {
+		t.Errorf("64kB took %s, expected at least 64ms", elapsed)
+	}
+}
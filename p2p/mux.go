@@ -0,0 +1,481 @@
+//
+// mux.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Session multiplexing. The Mux runs independent logical streams over
+// one connection. Each stream is identified by its session ID and it
+// is wrapped in its own Conn so the streams have the same
+// Send*/Receive*/Flush API and I/O statistics as plain connections.
+//
+// The streams are carried in frames:
+//
+//	type:byte session:uint32 length:uint32 payload:[length]byte
+//
+// Each stream has a receive window of muxWindow bytes. The sender
+// may have at most muxWindow unacknowledged bytes in flight and the
+// receiver grants more credit with window frames as the application
+// consumes data. This way one slow stream never blocks the others.
+//
+// The opened streams wait in an accept queue of muxAcceptQueue
+// streams. If the application does not accept the streams fast
+// enough, the new streams are rejected with reset frames and their
+// I/O fails at the peer. The reset frames are written by their own
+// goroutine so the frame reader never blocks on writes.
+
+const (
+	frameOpen byte = iota
+	frameData
+	frameWindow
+	frameClose
+	frameReset
+)
+
+const (
+	muxHeaderSize = 9
+	muxWindow     = 1024 * 1024
+	muxMaxFrame   = 64 * 1024
+
+	muxAcceptQueue = 64
+)
+
+// Mux multiplexes logical streams over a connection.
+type Mux struct {
+	conn   io.ReadWriter
+	wm     sync.Mutex
+	wbuf   []byte
+	m      sync.Mutex
+	err    error
+	done   chan struct{}
+	open   map[uint32]*stream
+	queue  chan *Stream
+	resets chan uint32
+	Stats  IOStats
+}
+
+// Stream implements a logical stream of the Mux. The embedded Conn
+// implements the protocol API for the stream and its Stats counts the
+// stream's I/O.
+type Stream struct {
+	*Conn
+	ID uint32
+}
+
+// NewMux creates a new multiplexer for the connection. The Mux takes
+// ownership of the connection; it must not be used after this call.
+func NewMux(conn io.ReadWriter) *Mux {
+	mux := &Mux{
+		conn:   conn,
+		wbuf:   make([]byte, muxHeaderSize+muxMaxFrame),
+		done:   make(chan struct{}),
+		open:   make(map[uint32]*stream),
+		queue:  make(chan *Stream, muxAcceptQueue),
+		resets: make(chan uint32, muxAcceptQueue),
+		Stats:  NewIOStats(),
+	}
+	go mux.reader()
+	go mux.resetter()
+	return mux
+}
+
+// Open opens a new stream with the session ID. The peer receives the
+// stream from Accept.
+func (mux *Mux) Open(id uint32) (*Stream, error) {
+	mux.m.Lock()
+	if mux.err != nil {
+		mux.m.Unlock()
+		return nil, mux.err
+	}
+	_, ok := mux.open[id]
+	if ok {
+		mux.m.Unlock()
+		return nil, fmt.Errorf("session %d already open", id)
+	}
+	s := mux.newStream(id)
+	mux.m.Unlock()
+
+	if err := mux.writeFrame(frameOpen, id, nil); err != nil {
+		return nil, err
+	}
+	return &Stream{
+		Conn: NewConn(s),
+		ID:   id,
+	}, nil
+}
+
+// Accept waits for the next stream opened by the peer.
+func (mux *Mux) Accept() (*Stream, error) {
+	s, ok := <-mux.queue
+	if !ok {
+		mux.m.Lock()
+		defer mux.m.Unlock()
+		return nil, mux.err
+	}
+	return s, nil
+}
+
+// Close closes the multiplexer and its underlying connection. All
+// pending stream operations fail.
+func (mux *Mux) Close() error {
+	mux.fail(fmt.Errorf("mux closed"))
+	closer, ok := mux.conn.(io.Closer)
+	if ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// newStream creates a new stream. The mux lock must be held.
+func (mux *Mux) newStream(id uint32) *stream {
+	s := &stream{
+		mux:    mux,
+		id:     id,
+		window: muxWindow,
+	}
+	s.c = sync.NewCond(&s.m)
+	mux.open[id] = s
+	return s
+}
+
+// release removes the stream from the mux if it is closed by both
+// parties.
+func (mux *Mux) release(s *stream) {
+	s.m.Lock()
+	done := s.closed && s.eof
+	s.m.Unlock()
+	if !done {
+		return
+	}
+
+	mux.m.Lock()
+	if mux.open[s.id] == s {
+		delete(mux.open, s.id)
+	}
+	mux.m.Unlock()
+}
+
+func (mux *Mux) writeFrame(t byte, id uint32, data []byte) error {
+	mux.wm.Lock()
+	defer mux.wm.Unlock()
+
+	mux.m.Lock()
+	err := mux.err
+	mux.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	mux.wbuf[0] = t
+	binary.BigEndian.PutUint32(mux.wbuf[1:], id)
+	binary.BigEndian.PutUint32(mux.wbuf[5:], uint32(len(data)))
+	n := copy(mux.wbuf[muxHeaderSize:], data)
+
+	_, err = mux.conn.Write(mux.wbuf[:muxHeaderSize+n])
+	if err != nil {
+		mux.fail(err)
+		return err
+	}
+	mux.Stats.Sent.Add(uint64(muxHeaderSize + n))
+	mux.Stats.Flushed.Add(1)
+	return nil
+}
+
+func (mux *Mux) fail(err error) {
+	mux.m.Lock()
+	if mux.err != nil {
+		mux.m.Unlock()
+		return
+	}
+	mux.err = err
+	close(mux.done)
+	streams := make([]*stream, 0, len(mux.open))
+	for _, s := range mux.open {
+		streams = append(streams, s)
+	}
+	mux.m.Unlock()
+
+	for _, s := range streams {
+		s.m.Lock()
+		s.c.Broadcast()
+		s.m.Unlock()
+	}
+}
+
+func (mux *Mux) reader() {
+	err := mux.readFrames()
+	mux.fail(err)
+	close(mux.queue)
+}
+
+func (mux *Mux) readFrames() error {
+	var hdr [muxHeaderSize]byte
+
+	for {
+		_, err := io.ReadFull(mux.conn, hdr[:])
+		if err != nil {
+			return err
+		}
+		t := hdr[0]
+		id := binary.BigEndian.Uint32(hdr[1:])
+		length := binary.BigEndian.Uint32(hdr[5:])
+		if length > muxMaxFrame {
+			return fmt.Errorf("%w: session %d: frame too large: %d",
+				ErrProtocolMismatch, id, length)
+		}
+		var data []byte
+		if length > 0 {
+			data = make([]byte, length)
+			_, err = io.ReadFull(mux.conn, data)
+			if err != nil {
+				return err
+			}
+		}
+		mux.Stats.Recvd.Add(uint64(muxHeaderSize + len(data)))
+
+		if t == frameOpen {
+			mux.m.Lock()
+			_, ok := mux.open[id]
+			if ok {
+				mux.m.Unlock()
+				return fmt.Errorf("session %d already open", id)
+			}
+			s := mux.newStream(id)
+			mux.m.Unlock()
+
+			if !mux.enqueue(s) {
+				mux.m.Lock()
+				delete(mux.open, id)
+				mux.m.Unlock()
+				if !mux.reject(id) {
+					return fmt.Errorf("%w: session %d: too many rejected "+
+						"sessions", ErrProtocolMismatch, id)
+				}
+			}
+			continue
+		}
+
+		mux.m.Lock()
+		s, ok := mux.open[id]
+		mux.m.Unlock()
+		if !ok {
+			// Frames of released streams are ignored.
+			continue
+		}
+
+		switch t {
+		case frameData:
+			s.m.Lock()
+			if len(s.buf)+len(data) > muxWindow {
+				s.m.Unlock()
+				return fmt.Errorf("%w: session %d: receive window exceeded",
+					ErrProtocolMismatch, id)
+			}
+			s.buf = append(s.buf, data...)
+			s.c.Broadcast()
+			s.m.Unlock()
+
+		case frameWindow:
+			if len(data) != 4 {
+				return fmt.Errorf("%w: session %d: invalid window update",
+					ErrProtocolMismatch, id)
+			}
+			s.m.Lock()
+			s.window += int(binary.BigEndian.Uint32(data))
+			s.c.Broadcast()
+			s.m.Unlock()
+
+		case frameClose:
+			s.m.Lock()
+			s.eof = true
+			s.c.Broadcast()
+			s.m.Unlock()
+			mux.release(s)
+
+		case frameReset:
+			s.m.Lock()
+			s.eof = true
+			s.reset = true
+			s.c.Broadcast()
+			s.m.Unlock()
+			mux.release(s)
+
+		default:
+			return fmt.Errorf("%w: session %d: invalid frame type %d",
+				ErrProtocolMismatch, id, t)
+		}
+	}
+}
+
+// enqueue delivers the stream opened by the peer to Accept. The
+// function does not block the frame reader: it returns false if the
+// accept queue is full.
+func (mux *Mux) enqueue(s *stream) bool {
+	select {
+	case mux.queue <- &Stream{
+		Conn: NewConn(s),
+		ID:   s.id,
+	}:
+		return true
+	default:
+		return false
+	}
+}
+
+// reject queues a reset frame for the stream opened by the peer. Like
+// enqueue, the function does not block the frame reader: it returns
+// false if the reset queue is full, that is, if the peer keeps opening
+// streams without reading their resets.
+func (mux *Mux) reject(id uint32) bool {
+	select {
+	case mux.resets <- id:
+		return true
+	default:
+		return false
+	}
+}
+
+// resetter writes the queued reset frames until the mux fails.
+func (mux *Mux) resetter() {
+	for {
+		select {
+		case id := <-mux.resets:
+			if err := mux.writeFrame(frameReset, id, nil); err != nil {
+				return
+			}
+		case <-mux.done:
+			return
+		}
+	}
+}
+
+// stream implements the io.ReadWriteCloser transport of a Stream.
+type stream struct {
+	mux      *Mux
+	id       uint32
+	m        sync.Mutex
+	c        *sync.Cond
+	buf      []byte
+	consumed int
+	window   int
+	closed   bool
+	eof      bool
+	reset    bool
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	s.m.Lock()
+	for len(s.buf) == 0 && !s.eof && !s.closed && s.mux.failed() == nil {
+		s.c.Wait()
+	}
+	if len(s.buf) == 0 {
+		eof := s.eof
+		closed := s.closed
+		reset := s.reset
+		s.m.Unlock()
+		if reset {
+			return 0, fmt.Errorf("session %d rejected", s.id)
+		}
+		if eof {
+			return 0, io.EOF
+		}
+		if closed {
+			return 0, fmt.Errorf("session %d closed", s.id)
+		}
+		return 0, s.mux.failed()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+	s.consumed += n
+
+	var credit int
+	if s.consumed >= muxWindow/2 {
+		credit = s.consumed
+		s.consumed = 0
+	}
+	s.m.Unlock()
+
+	if credit > 0 {
+		var data [4]byte
+		binary.BigEndian.PutUint32(data[:], uint32(credit))
+		if err := s.mux.writeFrame(frameWindow, s.id, data[:]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		s.m.Lock()
+		for s.window == 0 && !s.closed && !s.reset &&
+			s.mux.failed() == nil {
+			s.c.Wait()
+		}
+		if s.reset {
+			s.m.Unlock()
+			return written, fmt.Errorf("session %d rejected", s.id)
+		}
+		if s.closed {
+			s.m.Unlock()
+			return written, fmt.Errorf("session %d closed", s.id)
+		}
+		if err := s.mux.failed(); err != nil {
+			s.m.Unlock()
+			return written, err
+		}
+		n := len(p)
+		if n > s.window {
+			n = s.window
+		}
+		if n > muxMaxFrame {
+			n = muxMaxFrame
+		}
+		s.window -= n
+		s.m.Unlock()
+
+		if err := s.mux.writeFrame(frameData, s.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (s *stream) Close() error {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.c.Broadcast()
+	s.m.Unlock()
+
+	err := s.mux.writeFrame(frameClose, s.id, nil)
+	s.mux.release(s)
+	return err
+}
+
+func (mux *Mux) failed() error {
+	mux.m.Lock()
+	defer mux.m.Unlock()
+	return mux.err
+}
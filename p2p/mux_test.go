@@ -0,0 +1,310 @@
+//
+// mux_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func muxPayload(id uint32, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(int(id)*7 + i)
+	}
+	return data
+}
+
+// muxChunk is the chunk size for sending payloads larger than the
+// Conn write buffer.
+const muxChunk = 32 * 1024
+
+func sendChunks(c *Conn, data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > muxChunk {
+			n = muxChunk
+		}
+		if err := c.SendData(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return c.Flush()
+}
+
+func receiveChunks(c *Conn, size int) ([]byte, error) {
+	var result []byte
+	for len(result) < size {
+		data, err := c.ReceiveData()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data...)
+	}
+	return result, nil
+}
+
+func TestMux(t *testing.T) {
+	p0, p1 := newPipes()
+	m0 := NewMux(p0)
+	m1 := NewMux(p1)
+	defer m0.Close()
+	defer m1.Close()
+
+	const numStreams = 4
+	const size = 3 * muxWindow
+
+	errc := make(chan error, 2*numStreams)
+
+	// Echo server: receive data and send it back.
+	go func() {
+		for i := 0; i < numStreams; i++ {
+			s, err := m1.Accept()
+			if err != nil {
+				errc <- err
+				return
+			}
+			go func(s *Stream) {
+				data, err := receiveChunks(s.Conn, size)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if err := sendChunks(s.Conn, data); err != nil {
+					errc <- err
+					return
+				}
+				errc <- s.Close()
+			}(s)
+		}
+	}()
+
+	for i := 0; i < numStreams; i++ {
+		go func(id uint32) {
+			s, err := m0.Open(id)
+			if err != nil {
+				errc <- err
+				return
+			}
+			data := muxPayload(id, size)
+			if err := sendChunks(s.Conn, data); err != nil {
+				errc <- err
+				return
+			}
+			echo, err := receiveChunks(s.Conn, size)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if !bytes.Equal(data, echo) {
+				t.Errorf("session %d: echo mismatch", id)
+			}
+			// Each chunk has its length and is flushed in its own
+			// frame.
+			expected := uint64(size + size/muxChunk*(4+frameHeaderSize))
+			if s.Stats.Sent.Load() != expected {
+				t.Errorf("session %d: sent %d, expected %d", id,
+					s.Stats.Sent.Load(), expected)
+			}
+			if s.Stats.Recvd.Load() != expected {
+				t.Errorf("session %d: received %d, expected %d", id,
+					s.Stats.Recvd.Load(), expected)
+			}
+			errc <- s.Close()
+		}(uint32(i + 1))
+	}
+
+	for i := 0; i < 2*numStreams; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m0.Stats.Sent.Load() < numStreams*size {
+		t.Errorf("mux sent %d bytes, expected at least %d",
+			m0.Stats.Sent.Load(), numStreams*size)
+	}
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	p0, p1 := newPipes()
+	m0 := NewMux(p0)
+	m1 := NewMux(p1)
+	defer m0.Close()
+	defer m1.Close()
+
+	// The first stream fills its window and the peer does not read
+	// it. The second stream must still make progress.
+	blocked, err := m0.Open(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- sendChunks(blocked.Conn, muxPayload(1, 2*muxWindow))
+	}()
+
+	s, err := m0.Open(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerBlocked, err := m1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := m1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peerBlocked.ID != 1 || peer.ID != 2 {
+		t.Fatalf("unexpected session IDs %d, %d", peerBlocked.ID, peer.ID)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := s.SendUint32(i); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		v, err := peer.ReceiveUint32()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i {
+			t.Fatalf("got %d, expected %d", v, i)
+		}
+	}
+
+	// Drain the blocked stream.
+	data, err := receiveChunks(peerBlocked.Conn, 2*muxWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, muxPayload(1, 2*muxWindow)) {
+		t.Errorf("blocked stream data mismatch")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMuxAcceptQueue(t *testing.T) {
+	p0, p1 := newPipes()
+	m0 := NewMux(p0)
+	m1 := NewMux(p1)
+	defer m0.Close()
+	defer m1.Close()
+
+	// The peer does not accept the streams. The streams beyond the
+	// accept queue are rejected and the frame reader keeps running.
+	var streams []*Stream
+	for i := 0; i <= muxAcceptQueue; i++ {
+		s, err := m0.Open(uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, s)
+	}
+	rejected := streams[muxAcceptQueue]
+	if _, err := rejected.ReceiveByte(); err == nil {
+		t.Errorf("stream beyond accept queue was not rejected")
+	}
+
+	// The queued streams still work.
+	if err := streams[0].SendByte(42); err != nil {
+		t.Fatal(err)
+	}
+	if err := streams[0].Flush(); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := m1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := peer.ReceiveByte()
+	if err != nil || v != 42 {
+		t.Fatalf("ReceiveByte: %v, %v", v, err)
+	}
+}
+
+func TestMuxRejectBlocked(t *testing.T) {
+	p0, p1 := newPipes()
+	m1 := NewMux(p1)
+	defer m1.Close()
+	defer p0.Close()
+
+	// The peer opens too many streams and never reads the reset
+	// frames. The frame reader must not block on writing them.
+	frame := func(t byte, id uint32, data []byte) []byte {
+		hdr := make([]byte, muxHeaderSize)
+		hdr[0] = t
+		binary.BigEndian.PutUint32(hdr[1:], id)
+		binary.BigEndian.PutUint32(hdr[5:], uint32(len(data)))
+		return append(hdr, data...)
+	}
+	var frames []byte
+	for i := 0; i < muxAcceptQueue+2; i++ {
+		frames = append(frames, frame(frameOpen, uint32(i), nil)...)
+	}
+	frames = append(frames, frame(frameClose, 0, nil)...)
+	go p0.Write(frames)
+
+	s, err := m1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReceiveByte(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestMuxClose(t *testing.T) {
+	p0, p1 := newPipes()
+	m0 := NewMux(p0)
+	m1 := NewMux(p1)
+
+	s, err := m0.Open(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m0.Open(42); err == nil {
+		t.Errorf("duplicate session opened")
+	}
+	peer, err := m1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendByte(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := peer.ReceiveByte()
+	if err != nil || v != 1 {
+		t.Fatalf("ReceiveByte: %v, %v", v, err)
+	}
+	if _, err := peer.ReceiveByte(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if err := peer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m0.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m1.Accept(); err == nil {
+		t.Errorf("Accept succeeded after close")
+	}
+	m1.Close()
+}
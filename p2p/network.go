@@ -7,6 +7,8 @@
 package p2p
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"math/big"
@@ -18,17 +20,67 @@ import (
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
 )
 
+const (
+	barrierMagic = 0x62617272 // barr
+
+	// handshakeTimeout limits the secure channel handshake and the
+	// peer ID exchange of the inbound connections.
+	handshakeTimeout = 30 * time.Second
+)
+
 // Network implements peer-to-peer network.
 type Network struct {
 	ID       int
 	m        sync.Mutex
+	c        *sync.Cond
 	Peers    map[int]*Peer
 	addr     string
 	listener net.Listener
+	config   *Config
+	identity *Identity
+	dealt    *Dealt
 }
 
 // NewNetwork creats a new peer-to-peer network.
 func NewNetwork(addr string, id int) (*Network, error) {
+	return newNetwork(addr, id, nil, nil)
+}
+
+// NewConfigNetwork creates a new peer-to-peer network for the party
+// id of the network configuration. The network listens at the
+// party's address and accepts connections only from the parties of
+// the configuration. If identity is not nil, the peer connections
+// are secured with the party keys: the identity must match the
+// party's public key in the configuration and the peers are
+// authenticated with their configured public keys. Use Connect to
+// connect the network to its peers.
+func NewConfigNetwork(config *Config, id int, identity *Identity) (
+	*Network, error) {
+
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+	peer, err := config.Peer(id)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		for _, p := range config.Peers {
+			if len(p.PublicKey) == 0 {
+				return nil, fmt.Errorf("peer %d: no public key", p.ID)
+			}
+		}
+		if !bytes.Equal(peer.PublicKey, identity.PublicKey) {
+			return nil, fmt.Errorf("peer %d: identity does not match "+
+				"public key %s", id, peer.PublicKey)
+		}
+	}
+	return newNetwork(peer.Addr, id, config, identity)
+}
+
+func newNetwork(addr string, id int, config *Config, identity *Identity) (
+	*Network, error) {
+
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -38,18 +90,109 @@ func NewNetwork(addr string, id int) (*Network, error) {
 		Peers:    make(map[int]*Peer),
 		addr:     addr,
 		listener: listener,
+		config:   config,
+		identity: identity,
 	}
+	nw.c = sync.NewCond(&nw.m)
 	go nw.acceptLoop()
 	return nw, nil
 }
 
-// Close closes the network.
+// Connect connects the network to all peers of its configuration and
+// runs the startup barrier. The network dials the peers with smaller
+// IDs and accepts connections from the peers with larger IDs so each
+// pair of peers has exactly one connection. The function returns
+// when the full mesh is connected and all peers have reached the
+// barrier. The function fails if the context is done before all
+// peers are connected.
+func (nw *Network) Connect(ctx context.Context) error {
+	if nw.config == nil {
+		return fmt.Errorf("network has no configuration")
+	}
+	for _, peer := range nw.config.Peers {
+		if peer.ID >= nw.ID {
+			continue
+		}
+		if err := nw.AddPeer(ctx, peer.Addr, peer.ID); err != nil {
+			return err
+		}
+	}
+
+	// Wait for the peers with larger IDs.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			nw.m.Lock()
+			nw.c.Broadcast()
+			nw.m.Unlock()
+		case <-stop:
+		}
+	}()
+	nw.m.Lock()
+	for len(nw.Peers) < len(nw.config.Peers)-1 && ctx.Err() == nil {
+		nw.c.Wait()
+	}
+	nw.m.Unlock()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("waiting for peers: %w", err)
+	}
+
+	log.Printf("NW %d: all %d peers connected\n", nw.ID, len(nw.Peers))
+
+	return nw.Barrier(ctx)
+}
+
+// Barrier synchronizes the network peers. Each peer sends a barrier
+// message to all its peers after it has connected to them. When the
+// function returns, all peers are connected to each other.
+func (nw *Network) Barrier(ctx context.Context) error {
+	release := nw.WithContext(ctx)
+	defer release()
+
+	for _, peer := range nw.Peers {
+		if err := peer.conn.SendUint32(barrierMagic); err != nil {
+			return err
+		}
+		if err := peer.conn.Flush(); err != nil {
+			return err
+		}
+	}
+	for id, peer := range nw.Peers {
+		msg, err := peer.conn.ReceiveUint32()
+		if err != nil {
+			return err
+		}
+		if msg != barrierMagic {
+			return fmt.Errorf("%w: peer %d: unexpected barrier message 0x%x",
+				ErrProtocolMismatch, id, msg)
+		}
+	}
+	return nil
+}
+
+// Close closes the network listener and the peer connections. The
+// pending data is flushed to the peers before the connections are
+// closed.
 func (nw *Network) Close() error {
-	return nw.listener.Close()
+	err := nw.listener.Close()
+
+	nw.m.Lock()
+	defer nw.m.Unlock()
+	for _, peer := range nw.Peers {
+		if cerr := peer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
-// AddPeer adds a peer to the network.
-func (nw *Network) AddPeer(addr string, id int) error {
+// AddPeer adds a peer to the network. The function retries the
+// connection until it succeeds or the context is done.
+func (nw *Network) AddPeer(ctx context.Context, addr string, id int) error {
+	var dialer net.Dialer
+
 	// Try to connect to peer.
 	for {
 		// Check if we have already accepted peer `id`.
@@ -61,22 +204,48 @@ func (nw *Network) AddPeer(addr string, id int) error {
 		}
 
 		log.Printf("NW %d: Connecting to peer %d...\n", nw.ID, id)
-		nc, err := net.Dial("tcp", addr)
+		nc, err := dialer.DialContext(ctx, "tcp", addr)
 		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("connect to peer %d: %w", id, ctx.Err())
+			}
 			delay := 5 * time.Second
 			log.Printf("NW %d: Connect to %s failed, retrying in %s\n",
 				nw.ID, addr, delay)
-			<-time.After(delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return fmt.Errorf("connect to peer %d: %w", id, ctx.Err())
+			}
 			continue
 		}
 		log.Printf("NW %d: Connected to %s\n", nw.ID, addr)
-		conn := NewConn(nc)
-
-		if err := conn.SendUint32(nw.ID); err != nil {
-			conn.Close()
-			return err
-		}
-		if err := conn.Flush(); err != nil {
+		nc = nw.emulate(nc)
+		var conn *Conn
+		if nw.identity != nil {
+			peer, err := nw.config.Peer(id)
+			if err != nil {
+				nc.Close()
+				return err
+			}
+			tc, _, err := SecureClient(ctx, nc, nw.identity,
+				[]PublicKey{peer.PublicKey})
+			if err != nil {
+				nc.Close()
+				return err
+			}
+			conn = NewConn(tc)
+		} else {
+			conn = NewConn(nc)
+		}
+
+		release := conn.WithContext(ctx)
+		err = conn.SendUint32(nw.ID)
+		if err == nil {
+			err = conn.Flush()
+		}
+		release()
+		if err != nil {
 			conn.Close()
 			return err
 		}
@@ -95,7 +264,7 @@ func (nw *Network) Ping() {
 
 // Stats returns the I/O stats from the network.
 func (nw *Network) Stats() IOStats {
-	var result IOStats
+	result := NewIOStats()
 	for _, peer := range nw.Peers {
 		result = result.Add(peer.conn.Stats)
 	}
@@ -109,21 +278,90 @@ func (nw *Network) acceptLoop() {
 			log.Printf("NW %d: accept failed: %s\n", nw.ID, err)
 			return
 		}
-		conn := NewConn(nc)
+		go nw.accept(nc)
+	}
+}
 
-		// Read peer ID.
-		id, err := conn.ReceiveUint32()
-		if err != nil {
-			log.Printf("NW %d: I/O error: %s\n", nw.ID, err)
-			conn.Close()
-			continue
+// accept runs the secure channel handshake and reads the peer ID of
+// the inbound connection. Each connection is handled in its own
+// goroutine and the handshake must complete in handshakeTimeout so a
+// silent client can't block the other peers.
+func (nw *Network) accept(nc net.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		handshakeTimeout)
+	defer cancel()
+
+	nc = nw.emulate(nc)
+	var conn *Conn
+	var key PublicKey
+	if nw.identity != nil {
+		tc, k, err := SecureServer(ctx, nc, nw.identity, nw.trustedKeys())
+		if err != nil {
+			log.Printf("NW %d: handshake failed: %s\n", nw.ID, err)
+			nc.Close()
+			return
 		}
+		conn = NewConn(tc)
+		key = k
+	} else {
+		conn = NewConn(nc)
+	}
 
-		err = nw.newPeer(false, conn, id)
-		if err != nil {
-			log.Printf("inbound connection error: %s\n", err)
+	// Read peer ID.
+	release := conn.WithContext(ctx)
+	id, err := conn.ReceiveUint32()
+	release()
+	if err != nil {
+		log.Printf("NW %d: I/O error: %s\n", nw.ID, err)
+		conn.Close()
+		return
+	}
+
+	if !nw.acceptPeer(id, key) {
+		log.Printf("NW %d: unexpected peer %d\n", nw.ID, id)
+		conn.Close()
+		return
+	}
+
+	err = nw.newPeer(false, conn, id)
+	if err != nil {
+		log.Printf("inbound connection error: %s\n", err)
+	}
+}
+
+// emulate wraps the peer connection with the network emulation of
+// the configuration.
+func (nw *Network) emulate(nc net.Conn) net.Conn {
+	if nw.config == nil || nw.config.Emulation == nil {
+		return nc
+	}
+	return EmulateNet(nc, nw.config.Emulation)
+}
+
+// trustedKeys returns the public keys of the peers that connect to
+// us.
+func (nw *Network) trustedKeys() []PublicKey {
+	var result []PublicKey
+	for _, peer := range nw.config.Peers {
+		if peer.ID > nw.ID {
+			result = append(result, peer.PublicKey)
 		}
 	}
+	return result
+}
+
+// acceptPeer tests if the network accepts an inbound connection from
+// the peer id. For secure networks, key is the authenticated public
+// key of the peer.
+func (nw *Network) acceptPeer(id int, key PublicKey) bool {
+	if nw.config == nil {
+		return true
+	}
+	peer, err := nw.config.Peer(id)
+	if err != nil || id <= nw.ID {
+		return false
+	}
+	return nw.identity == nil || bytes.Equal(peer.PublicKey, key)
 }
 
 func (nw *Network) newPeer(client bool, conn *Conn, id int) error {
@@ -140,6 +378,7 @@ func (nw *Network) newPeer(client bool, conn *Conn, id int) error {
 		client: client,
 	}
 	nw.Peers[id] = peer
+	nw.c.Broadcast()
 	nw.m.Unlock()
 
 	return peer.init()
@@ -152,6 +391,19 @@ type Peer struct {
 	client     bool
 	otSender   *ot.COSender
 	otReceiver *ot.COReceiver
+	cot        *cot
+}
+
+// Conn returns the peer connection. The protocols that run their own
+// message exchanges with the peer use the connection directly.
+func (peer *Peer) Conn() *Conn {
+	return peer.conn
+}
+
+// OT returns a new CO oblivious transfer for running the protocols
+// with the peer.
+func (peer *Peer) OT() ot.OT {
+	return ot.NewCO()
 }
 
 // Close closes the peer connection.
@@ -260,7 +512,8 @@ func (peer *Peer) otLambdaRespond(count int, x1, x2 *big.Int) error {
 		return err
 	}
 	if pc != count {
-		return fmt.Errorf("protocol error: peer count %d, our %d", pc, count)
+		return fmt.Errorf("%w: peer count %d, our %d", ErrProtocolMismatch,
+			pc, count)
 	}
 	for i := 0; i < count; i++ {
 		bit, err := peer.conn.ReceiveUint32()
@@ -377,6 +630,9 @@ func (peer *Peer) otrQueries(count int, chA, chB, chC *big.Int) (
 }
 
 func (peer *Peer) otrQuery(count int, choices *big.Int) ([]ot.Label, error) {
+	if peer.cot != nil {
+		return peer.cotQuery(count, choices)
+	}
 
 	// Number of OTs following
 	if err := peer.conn.SendUint32(count); err != nil {
@@ -413,13 +669,17 @@ func (peer *Peer) otrResponses(x1Ag, x2Ag, x1Bg, x2Bg,
 }
 
 func (peer *Peer) otrRespond(x1, x2 []ot.Label) error {
+	if peer.cot != nil {
+		return peer.cotRespond(x1, x2)
+	}
 
 	pc, err := peer.conn.ReceiveUint32()
 	if err != nil {
 		return err
 	}
 	if pc != len(x1) {
-		return fmt.Errorf("protocol error: peer count %d, our %d", pc, len(x1))
+		return fmt.Errorf("%w: peer count %d, our %d", ErrProtocolMismatch,
+			pc, len(x1))
 	}
 
 	for i := 0; i < len(x1); i++ {
@@ -476,6 +736,117 @@ func (peer *Peer) otrRespond(x1, x2 []ot.Label) error {
 	return nil
 }
 
+// cotQuery receives count labels with the dealt correlated
+// transfers, choosing with the bits of choices. We send the
+// differences e of our choices and the dealt choices s. The peer
+// sends the difference D of its offset R and the dealt offset, and
+// the corrections C = x1 XOR send XOR e*R of its messages. With the
+// dealt recv = send XOR s*offset, our label is
+//
+//	recv XOR s*D XOR C = x1 XOR choice*R
+func (peer *Peer) cotQuery(count int, choices *big.Int) (
+	[]ot.Label, error) {
+
+	c := peer.cot
+	if len(c.choices) < count {
+		return nil, ErrExhausted
+	}
+	s := c.choices[:count]
+	recv := c.recv[:count]
+	c.choices = c.choices[count:]
+	c.recv = c.recv[count:]
+
+	for i := 0; i < count; i++ {
+		if err := peer.conn.SendByte(byte(choices.Bit(i)) ^ s[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := peer.conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	var data ot.LabelData
+	var d ot.Label
+	if err := peer.conn.ReceiveLabel(&d, &data); err != nil {
+		return nil, err
+	}
+	result := make([]ot.Label, count)
+	for i := 0; i < count; i++ {
+		var corr ot.Label
+		if err := peer.conn.ReceiveLabel(&corr, &data); err != nil {
+			return nil, err
+		}
+		result[i] = recv[i]
+		if s[i] != 0 {
+			result[i].Xor(d)
+		}
+		result[i].Xor(corr)
+	}
+	return result, nil
+}
+
+// cotRespond sends the messages x1 and x2 with the dealt correlated
+// transfers; see cotQuery. The messages must have the same offset R =
+// x1 XOR x2 in all transfers with the peer so that the peer learns
+// nothing from the offset differences.
+func (peer *Peer) cotRespond(x1, x2 []ot.Label) error {
+	c := peer.cot
+	count := len(x1)
+	if len(c.send) < count {
+		return ErrExhausted
+	}
+	send := c.send[:count]
+	c.send = c.send[count:]
+
+	var r ot.Label
+	for i := 0; i < count; i++ {
+		offset := x1[i]
+		offset.Xor(x2[i])
+		if i == 0 {
+			r = offset
+		} else if !offset.Equal(r) {
+			return fmt.Errorf("dealt transfer %d: offset differs", i)
+		}
+	}
+	if count > 0 {
+		if c.delta == nil {
+			c.delta = &r
+		} else if !c.delta.Equal(r) {
+			return fmt.Errorf("dealt transfers: offset differs")
+		}
+	}
+
+	e := make([]byte, count)
+	for i := range e {
+		b, err := peer.conn.ReceiveByte()
+		if err != nil {
+			return err
+		}
+		if b > 1 {
+			return fmt.Errorf("%w: invalid choice %d", ErrProtocolMismatch, b)
+		}
+		e[i] = b
+	}
+
+	var data ot.LabelData
+	d := r
+	d.Xor(c.offset)
+	if err := peer.conn.SendLabel(d, &data); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		corr := x1[i]
+		corr.Xor(send[i])
+		if e[i] != 0 {
+			corr.Xor(r)
+		}
+		if err := peer.conn.SendLabel(corr, &data); err != nil {
+			return err
+		}
+	}
+	return peer.conn.Flush()
+}
+
 // ExchangeGates exchanges gate values with peers.
 func (peer *Peer) ExchangeGates(ag, bg, cg, dg [][]ot.Label, lo *big.Int) (
 	ra, rb, rc, rd [][]ot.Label, ro *big.Int, err error) {
@@ -7,7 +7,11 @@
 package p2p
 
 import (
+	"context"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"sync"
 	"sync/atomic"
 
 	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
@@ -21,6 +25,13 @@ const (
 	numBuffers   = 3
 	writeBufSize = 64 * 1024
 	readBufSize  = 1024 * 1024
+
+	// Each flushed write buffer is sent as a frame that starts with a
+	// 32-bit header. The high bit of the header marks abort frames
+	// and the low bits hold the payload length.
+	frameHeaderSize = 4
+	frameAbort      = 0x80000000
+	frameLenMask    = 0x7fffffff
 )
 
 // Conn implements a protocol connection.
@@ -33,9 +44,14 @@ type Conn struct {
 	ReadEnd   int
 	Stats     IOStats
 
-	fromWriter chan []byte
-	toWriter   chan []byte
-	writerErr  error
+	fromWriter  chan []byte
+	toWriter    chan []byte
+	writerM     sync.Mutex
+	writerErr   error
+	ctx         context.Context
+	interrupted error
+	frameLeft   int
+	abortErr    error
 }
 
 // IOStats implements I/O statistics.
@@ -90,6 +106,7 @@ func NewConn(conn io.ReadWriter) *Conn {
 	go c.writer()
 
 	c.WriteBuf = <-c.fromWriter
+	c.WritePos = frameHeaderSize
 
 	return c
 }
@@ -102,13 +119,21 @@ func (c *Conn) writer() {
 	for buf := range c.toWriter {
 		_, err := c.conn.Write(buf)
 		if err != nil {
+			c.writerM.Lock()
 			c.writerErr = err
+			c.writerM.Unlock()
 		}
 		c.fromWriter <- buf[0:cap(buf)]
 	}
 	close(c.fromWriter)
 }
 
+func (c *Conn) writerError() error {
+	c.writerM.Lock()
+	defer c.writerM.Unlock()
+	return c.writerErr
+}
+
 // NeedSpace ensures the write buffer has space for count bytes. The
 // function flushes the output if needed.
 func (c *Conn) NeedSpace(count int) error {
@@ -120,19 +145,32 @@ func (c *Conn) NeedSpace(count int) error {
 
 // Flush flushed any pending data in the connection.
 func (c *Conn) Flush() error {
-	if c.WritePos > 0 {
-		c.Stats.Sent.Add(uint64(c.WritePos))
-		c.toWriter <- c.WriteBuf[0:c.WritePos]
+	if c.WritePos > frameHeaderSize {
+		return c.sendFrame(0)
+	}
+	return nil
+}
 
-		next := <-c.fromWriter
-		if c.writerErr != nil {
-			return c.writerErr
-		}
+// sendFrame sends the write buffer as a frame with the header flags.
+func (c *Conn) sendFrame(flags uint32) error {
+	if err := c.ctxErr(); err != nil && flags&frameAbort == 0 {
+		return err
+	}
+	length := uint32(c.WritePos - frameHeaderSize)
+	binary.BigEndian.PutUint32(c.WriteBuf, flags|length)
+
+	c.Stats.Sent.Add(uint64(c.WritePos))
+	c.toWriter <- c.WriteBuf[0:c.WritePos]
 
-		c.WriteBuf = next
-		c.WritePos = 0
-		c.Stats.Flushed.Add(1)
+	next := <-c.fromWriter
+	if err := c.writerError(); err != nil {
+		return c.ioError(err)
 	}
+
+	c.WriteBuf = next
+	c.WritePos = frameHeaderSize
+	c.Stats.Flushed.Add(1)
+
 	return nil
 }
 
@@ -148,27 +186,81 @@ func (c *Conn) Fill(n int) error {
 		c.ReadEnd = 0
 	}
 	for c.ReadStart+n > c.ReadEnd {
-		got, err := c.conn.Read(c.ReadBuf[c.ReadEnd:])
-		if err != nil {
+		if err := c.ctxErr(); err != nil {
 			return err
 		}
+		if c.frameLeft == 0 {
+			if err := c.readFrameHeader(); err != nil {
+				return err
+			}
+			continue
+		}
+		limit := c.ReadEnd + c.frameLeft
+		if limit > len(c.ReadBuf) {
+			limit = len(c.ReadBuf)
+		}
+		got, err := c.conn.Read(c.ReadBuf[c.ReadEnd:limit])
+		if err != nil {
+			return c.ioError(err)
+		}
 		c.Stats.Recvd.Add(uint64(got))
 		c.ReadEnd += got
+		c.frameLeft -= got
 	}
 	return nil
 }
 
-// Close flushes any pending data and closes the connection.
+// readFrameHeader reads the next frame header from the connection. If
+// the frame is an abort frame, the function reads the abort message
+// and returns it as an AbortError.
+func (c *Conn) readFrameHeader() error {
+	if c.abortErr != nil {
+		return c.abortErr
+	}
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.conn, hdr[:]); err != nil {
+		return c.ioError(err)
+	}
+	c.Stats.Recvd.Add(frameHeaderSize)
+
+	v := binary.BigEndian.Uint32(hdr[:])
+	length := int(v & frameLenMask)
+	if v&frameAbort == 0 {
+		c.frameLeft = length
+		return nil
+	}
+	if length < 4 || length > maxAbortSize {
+		return fmt.Errorf("%w: invalid abort frame length %d",
+			ErrProtocolMismatch, length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, msg); err != nil {
+		return c.ioError(err)
+	}
+	c.Stats.Recvd.Add(uint64(length))
+
+	c.abortErr = &AbortError{
+		Code:   AbortCode(binary.BigEndian.Uint32(msg)),
+		Reason: string(msg[4:]),
+	}
+	return c.abortErr
+}
+
+// Close flushes any pending data and closes the connection. The
+// pending data of an interrupted connection is discarded.
 func (c *Conn) Close() error {
+	if c.interrupted != nil {
+		c.WritePos = frameHeaderSize
+	}
 	if err := c.Flush(); err != nil {
 		return err
 	}
 	// Wait that flush completes.
 	close(c.toWriter)
-	for range <-c.fromWriter {
+	for range c.fromWriter {
 	}
-	if c.writerErr != nil {
-		return c.writerErr
+	if err := c.writerError(); err != nil {
+		return err
 	}
 	closer, ok := c.conn.(io.Closer)
 	if ok {
@@ -0,0 +1,181 @@
+//
+// secure.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Secure channels. The parties authenticate each other with their
+// static ed25519 keys and run a TLS 1.3 handshake over the transport
+// connection. The peer certificates are self-signed and they are
+// trusted only if their public key is in the peer list (see
+// Config). The resulting tls.Conn is used as the Conn transport so
+// the Conn API is unchanged.
+
+// Identity holds a party's static key pair.
+type Identity struct {
+	PublicKey  PublicKey
+	privateKey ed25519.PrivateKey
+	cert       tls.Certificate
+}
+
+// NewIdentity creates a new random identity.
+func NewIdentity() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewIdentityFromKey(key)
+}
+
+// NewIdentityFromKey creates an identity for the private key.
+func NewIdentityFromKey(key ed25519.PrivateKey) (*Identity, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(100 * 365 * 24 * time.Hour),
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub,
+		key)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		PublicKey:  PublicKey(pub),
+		privateKey: key,
+		cert: tls.Certificate{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		},
+	}, nil
+}
+
+// LoadIdentity loads the identity from the file. The file contains
+// the hex-encoded ed25519 private key seed.
+func LoadIdentity(file string) (*Identity, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid key: %s", file, err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: invalid key size: %d", file, len(seed))
+	}
+	return NewIdentityFromKey(ed25519.NewKeyFromSeed(seed))
+}
+
+// Save saves the identity's private key seed to the file. The
+// function fails if the file exists.
+func (id *Identity) Save(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%x\n", id.privateKey.Seed())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (id *Identity) tlsConfig(trusted []PublicKey,
+	peer *PublicKey) *tls.Config {
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{id.cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		// The peer certificates are self-signed and they are
+		// verified against the trusted keys in
+		// VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte,
+			_ [][]*x509.Certificate) error {
+
+			key, err := verifyPeerKey(rawCerts, trusted)
+			if err != nil {
+				return err
+			}
+			*peer = key
+			return nil
+		},
+	}
+}
+
+func verifyPeerKey(rawCerts [][]byte, trusted []PublicKey) (
+	PublicKey, error) {
+
+	if len(rawCerts) != 1 {
+		return nil, fmt.Errorf("expected one peer certificate, got %d",
+			len(rawCerts))
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported peer key type %T", cert.PublicKey)
+	}
+	for _, t := range trusted {
+		if bytes.Equal(t, key) {
+			return PublicKey(key), nil
+		}
+	}
+	return nil, fmt.Errorf("untrusted peer key %x", []byte(key))
+}
+
+// SecureClient runs the client side of the secure channel handshake
+// over the connection. The peer's key must be one of the trusted
+// keys. The function returns the secure connection and the peer's
+// public key. The handshake fails if the context is done before it
+// completes.
+func SecureClient(ctx context.Context, conn net.Conn, id *Identity,
+	trusted []PublicKey) (*tls.Conn, PublicKey, error) {
+
+	var peer PublicKey
+	tc := tls.Client(conn, id.tlsConfig(trusted, &peer))
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	return tc, peer, nil
+}
+
+// SecureServer runs the server side of the secure channel handshake
+// over the connection. The peer's key must be one of the trusted
+// keys. The function returns the secure connection and the peer's
+// public key. The handshake fails if the context is done before it
+// completes.
+func SecureServer(ctx context.Context, conn net.Conn, id *Identity,
+	trusted []PublicKey) (*tls.Conn, PublicKey, error) {
+
+	var peer PublicKey
+	tc := tls.Server(conn, id.tlsConfig(trusted, &peer))
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	return tc, peer, nil
+}
@@ -0,0 +1,271 @@
+//
+// secure_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+type handshakeResult struct {
+	conn *Conn
+	key  PublicKey
+	err  error
+}
+
+// tcpPipe creates a connected pair of loopback TCP connections. Unlike
+// net.Pipe, the TCP connections are buffered so that both sides can
+// send their TLS alerts when a handshake fails.
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	cc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, <-accepted
+}
+
+func secureHandshake(cc, sc net.Conn, client, server *Identity,
+	clientTrusted, serverTrusted []PublicKey) (
+	c, s handshakeResult) {
+
+	done := make(chan handshakeResult)
+
+	go func() {
+		tc, key, err := SecureServer(context.Background(), sc, server,
+			serverTrusted)
+		if err != nil {
+			sc.Close()
+			done <- handshakeResult{err: err}
+			return
+		}
+		done <- handshakeResult{
+			conn: NewConn(tc),
+			key:  key,
+		}
+	}()
+
+	tc, key, err := SecureClient(context.Background(), cc, client,
+		clientTrusted)
+	if err != nil {
+		cc.Close()
+		c.err = err
+		s = <-done
+		return
+	}
+
+	// The TLS 1.3 client completes its handshake before the server
+	// has verified the client certificate. Read the server's
+	// response so that a rejecting server can send its alert over
+	// the synchronous pipe.
+	response := make(chan error)
+	go func() {
+		var buf [1]byte
+		_, err := tc.Read(buf[:])
+		response <- err
+	}()
+	s = <-done
+	if s.err != nil {
+		c.err = <-response
+		return
+	}
+	if err := s.conn.SendByte(0); err != nil {
+		s.err = err
+		return
+	}
+	if err := s.conn.Flush(); err != nil {
+		s.err = err
+		return
+	}
+	if err := <-response; err != nil {
+		c.err = err
+		return
+	}
+	c.conn = NewConn(tc)
+	c.key = key
+	return
+}
+
+func newIdentities(t *testing.T, count int) []*Identity {
+	var result []*Identity
+	for i := 0; i < count; i++ {
+		id, err := NewIdentity()
+		if err != nil {
+			t.Fatalf("NewIdentity failed: %s", err)
+		}
+		result = append(result, id)
+	}
+	return result
+}
+
+func TestSecure(t *testing.T) {
+	ids := newIdentities(t, 2)
+
+	cc, sc := net.Pipe()
+	c, s := secureHandshake(cc, sc, ids[0], ids[1],
+		[]PublicKey{ids[1].PublicKey}, []PublicKey{ids[0].PublicKey})
+	if c.err != nil || s.err != nil {
+		t.Fatalf("handshake failed: client=%v, server=%v", c.err, s.err)
+	}
+	if !bytes.Equal(c.key, ids[1].PublicKey) {
+		t.Errorf("client: unexpected peer key %s", c.key)
+	}
+	if !bytes.Equal(s.key, ids[0].PublicKey) {
+		t.Errorf("server: unexpected peer key %s", s.key)
+	}
+
+	done := make(chan error)
+	go func() {
+		if err := c.conn.SendString("Hello, world!"); err != nil {
+			done <- err
+			return
+		}
+		done <- c.conn.Flush()
+	}()
+	msg, err := s.conn.ReceiveString()
+	if err != nil {
+		t.Fatalf("ReceiveString failed: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("SendString failed: %s", err)
+	}
+	if msg != "Hello, world!" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+}
+
+func TestSecureUntrusted(t *testing.T) {
+	ids := newIdentities(t, 3)
+
+	// Server does not trust the client.
+	cc, sc := tcpPipe(t)
+	c, s := secureHandshake(cc, sc, ids[0], ids[1],
+		[]PublicKey{ids[1].PublicKey}, []PublicKey{ids[2].PublicKey})
+	if s.err == nil || c.err == nil {
+		t.Errorf("untrusted client accepted: client=%v, server=%v",
+			c.err, s.err)
+	}
+
+	// Client does not trust the server.
+	cc, sc = tcpPipe(t)
+	c, s = secureHandshake(cc, sc, ids[0], ids[1],
+		[]PublicKey{ids[2].PublicKey}, []PublicKey{ids[0].PublicKey})
+	if c.err == nil {
+		t.Errorf("client accepted untrusted server")
+	}
+}
+
+func TestIdentitySave(t *testing.T) {
+	ids := newIdentities(t, 1)
+	file := filepath.Join(t.TempDir(), "party.key")
+
+	if err := ids[0].Save(file); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+	if err := ids[0].Save(file); err == nil {
+		t.Errorf("Save overwrote existing key")
+	}
+	id, err := LoadIdentity(file)
+	if err != nil {
+		t.Fatalf("LoadIdentity failed: %s", err)
+	}
+	if !bytes.Equal(id.PublicKey, ids[0].PublicKey) {
+		t.Errorf("loaded identity mismatch")
+	}
+}
+
+func TestSecureConfigNetwork(t *testing.T) {
+	const numPeers = 3
+
+	ids := newIdentities(t, numPeers)
+	config := new(Config)
+	for i := 0; i < numPeers; i++ {
+		config.Peers = append(config.Peers, PeerConfig{
+			ID:        i,
+			Addr:      fmt.Sprintf("127.0.0.1:%d", freePort(t)),
+			PublicKey: ids[i].PublicKey,
+		})
+	}
+
+	// Identity must match the configuration.
+	_, err := NewConfigNetwork(config, 0, ids[1])
+	if err == nil {
+		t.Fatalf("NewConfigNetwork accepted wrong identity")
+	}
+
+	var networks []*Network
+	for i := 0; i < numPeers; i++ {
+		nw, err := NewConfigNetwork(config, i, ids[i])
+		if err != nil {
+			t.Fatalf("NewConfigNetwork failed: %s", err)
+		}
+		defer nw.Close()
+		networks = append(networks, nw)
+	}
+
+	// An impostor with an unknown key can't connect as party 2.
+	impostor := newIdentities(t, 1)[0]
+	nc, err := net.Dial("tcp", config.Peers[0].Addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	tc, _, err := SecureClient(context.Background(), nc, impostor,
+		[]PublicKey{ids[0].PublicKey})
+	if err == nil {
+		// TLS 1.3 client finishes before the server verifies the
+		// client certificate; the server must reject the connection.
+		var buf [1]byte
+		if _, err := tc.Read(buf[:]); err == nil {
+			t.Errorf("impostor accepted")
+		}
+	}
+	nc.Close()
+
+	// A silent client must not block the other peers' connections.
+	silent, err := net.Dial("tcp", config.Peers[0].Addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer silent.Close()
+
+	errs := make(chan error)
+	for _, nw := range networks {
+		go func(nw *Network) {
+			errs <- nw.Connect(context.Background())
+		}(nw)
+	}
+	for range networks {
+		if err := <-errs; err != nil {
+			t.Fatalf("Connect failed: %s", err)
+		}
+	}
+	for _, nw := range networks {
+		if len(nw.Peers) != numPeers-1 {
+			t.Errorf("network %d: %d peers, expected %d", nw.ID,
+				len(nw.Peers), numPeers-1)
+		}
+	}
+}
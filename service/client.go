@@ -0,0 +1,106 @@
+//
+// client.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+// Run runs the named program of the server with the input values as
+// the evaluator. The server sends the program's circuit and the
+// function verifies that the circuit has the fingerprint, see
+// circuit.Circuit.Fingerprint and Program.Fingerprints. The function
+// returns the circuit outputs and the results. The protocol run is
+// aborted when the context is done.
+func Run(ctx context.Context, conn *p2p.Conn, oti ot.OT, program string,
+	fingerprint []byte, inputs []string) (circuit.IO, []*big.Int, error) {
+
+	if len(fingerprint) != sha256.Size {
+		return nil, nil, fmt.Errorf("invalid circuit fingerprint: %x",
+			fingerprint)
+	}
+	inputSizes, err := circuit.InputSizes(inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	circ, input, err := request(ctx, conn, program, fingerprint, inputs,
+		inputSizes)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := circuit.Evaluator(ctx, conn, oti, circ, input, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return circ.Outputs, result, nil
+}
+
+// request requests the program from the server and returns its
+// circuit and our input value.
+func request(ctx context.Context, conn *p2p.Conn, program string,
+	fingerprint []byte, inputs []string, inputSizes []int) (
+	*circuit.Circuit, *big.Int, error) {
+
+	release := conn.WithContext(ctx)
+	defer release()
+
+	if err := conn.SendUint32(magic); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SendString(program); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SendInputSizes(inputSizes); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, nil, err
+	}
+	data, err := receiveChunked(conn)
+	if err != nil {
+		return nil, nil, circuit.Abort(conn, err)
+	}
+	circ, err := circuit.ParseQCLC(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, circuit.Abort(conn,
+			fmt.Errorf("%w: invalid circuit: %s",
+				circuit.ErrProtocolMismatch, err))
+	}
+	fp, err := circ.Fingerprint()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(fp[:], fingerprint) {
+		return nil, nil, circuit.Abort(conn,
+			fmt.Errorf("%w: circuit fingerprint %x, expected %x",
+				circuit.ErrProtocolMismatch, fp, fingerprint))
+	}
+	if len(circ.Inputs) != 2 {
+		return nil, nil, circuit.Abort(conn,
+			fmt.Errorf("%w: invalid circuit for 2-party MPC: %d parties",
+				circuit.ErrProtocolMismatch, len(circ.Inputs)))
+	}
+	circ.AssignLevels()
+
+	input, err := circ.Inputs[1].Parse(inputs)
+	if err != nil {
+		return nil, nil, circuit.Abort(conn,
+			fmt.Errorf("%w: %s", circuit.ErrInvalidInput, err))
+	}
+	return circ, input, nil
+}
@@ -0,0 +1,325 @@
+//
+// registry.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package service implements a long-running two-party computation
+// service. The server runs the garbler role for the programs of its
+// registry and the clients run the evaluator role. A client names
+// the program it wants to run and the server sends it the compiled
+// circuit so the clients don't need the program sources.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+)
+
+var (
+	// ErrUnknownProgram is returned when a client requests a program
+	// that is not in the registry.
+	ErrUnknownProgram = errors.New("unknown program")
+
+	// ErrUnsupportedSizes is returned when a client requests a
+	// program with evaluator input sizes that the program is not
+	// configured for.
+	ErrUnsupportedSizes = errors.New("unsupported input sizes")
+)
+
+// InputFunc returns the garbler's input values for a session.
+type InputFunc func() ([]string, error)
+
+// ProgramConfig describes a program of the registry. The garbler's
+// inputs are either given in Inputs or read from InputFile for each
+// session. The input file holds comma or whitespace separated
+// values. The EvaluatorSizes list the evaluator input sizes for
+// which the program is precompiled, see circuit.InputSizes.
+type ProgramConfig struct {
+	Name           string   `json:"name"`
+	File           string   `json:"file"`
+	Inputs         []string `json:"inputs,omitempty"`
+	InputFile      string   `json:"input_file,omitempty"`
+	EvaluatorSizes [][]int  `json:"evaluator_sizes,omitempty"`
+}
+
+// RegistryConfig describes the programs of a registry. The
+// configuration is stored as JSON:
+//
+//	{
+//	  "programs": [
+//	    {"name": "millionaire", "file": "millionaire.qcl",
+//	     "inputs": ["800000"]},
+//	    {"name": "auction", "file": "auction.qcl",
+//	     "input_file": "bids.txt", "evaluator_sizes": [[32]]}
+//	  ]
+//	}
+//
+// The relative file names are relative to the configuration file's
+// directory.
+type RegistryConfig struct {
+	Programs []ProgramConfig `json:"programs"`
+}
+
+// Registry holds the programs that the server runs.
+type Registry struct {
+	params   *utils.Params
+	m        sync.Mutex
+	programs map[string]*Program
+}
+
+// Program is a registered QCL program. The program caches its
+// compiled circuits by the garbler input sizes, and by the evaluator
+// input sizes if the evaluator's arguments don't have concrete types.
+// The program runs only with the evaluator input sizes that it is
+// registered for. The cache holds at most maxGarblerSizes circuits
+// for each registered evaluator input sizes and drops the oldest
+// circuits first.
+type Program struct {
+	Name   string
+	File   string
+	Inputs InputFunc
+
+	params         *utils.Params
+	evaluatorSizes [][]int
+	m              sync.Mutex
+	circuits       map[string]*circuit.Circuit
+	keys           []string
+}
+
+// maxGarblerSizes limits the number of garbler input sizes that a
+// program caches circuits for.
+const maxGarblerSizes = 4
+
+// NewRegistry creates an empty registry. The programs are compiled
+// with the compiler parameters.
+func NewRegistry(params *utils.Params) *Registry {
+	return &Registry{
+		params:   params,
+		programs: make(map[string]*Program),
+	}
+}
+
+// LoadRegistry loads the registry configuration from the file and
+// precompiles its programs.
+func LoadRegistry(file string, params *utils.Params) (*Registry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := new(RegistryConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	dir := filepath.Dir(file)
+	resolve := func(name string) string {
+		if len(name) == 0 || filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	registry := NewRegistry(params)
+	for _, pc := range config.Programs {
+		var inputs InputFunc
+		switch {
+		case len(pc.InputFile) > 0 && len(pc.Inputs) > 0:
+			return nil, fmt.Errorf("program %s: both inputs and input_file",
+				pc.Name)
+		case len(pc.InputFile) > 0:
+			inputs = FileInputs(resolve(pc.InputFile))
+		default:
+			inputs = StaticInputs(pc.Inputs)
+		}
+		err := registry.Add(pc.Name, resolve(pc.File), inputs,
+			pc.EvaluatorSizes...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// StaticInputs returns an input function that returns the values.
+func StaticInputs(values []string) InputFunc {
+	return func() ([]string, error) {
+		return values, nil
+	}
+}
+
+// FileInputs returns an input function that reads the comma or
+// whitespace separated values from the file.
+func FileInputs(file string) InputFunc {
+	return func() ([]string, error) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return strings.FieldsFunc(string(data), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' ||
+				r == '\r'
+		}), nil
+	}
+}
+
+// Add adds the program to the registry and precompiles it with the
+// current garbler inputs for each evaluator input sizes. The sizes
+// must be given if the evaluator's arguments don't have concrete
+// types, otherwise the program is compiled without evaluator input
+// sizes.
+func (r *Registry) Add(name, file string, inputs InputFunc,
+	evaluatorSizes ...[]int) error {
+
+	if len(name) == 0 {
+		return fmt.Errorf("program %s: empty name", file)
+	}
+	if !strings.HasSuffix(file, ".qcl") {
+		return fmt.Errorf("program %s: unknown file type '%s'", name, file)
+	}
+	prog := &Program{
+		Name:           name,
+		File:           file,
+		Inputs:         inputs,
+		params:         r.params,
+		evaluatorSizes: evaluatorSizes,
+		circuits:       make(map[string]*circuit.Circuit),
+	}
+	values, err := inputs()
+	if err != nil {
+		return fmt.Errorf("program %s: %s", name, err)
+	}
+	sizes, err := circuit.InputSizes(values)
+	if err != nil {
+		return fmt.Errorf("program %s: %s", name, err)
+	}
+	if len(evaluatorSizes) == 0 {
+		evaluatorSizes = [][]int{nil}
+	}
+	for _, es := range evaluatorSizes {
+		if _, err := prog.Circuit([][]int{sizes, es}); err != nil {
+			return fmt.Errorf("program %s: %s", name, err)
+		}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.programs[name]; ok {
+		return fmt.Errorf("program %s already registered", name)
+	}
+	r.programs[name] = prog
+	return nil
+}
+
+// Program returns the named program.
+func (r *Registry) Program(name string) (*Program, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	prog, ok := r.programs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProgram, name)
+	}
+	return prog, nil
+}
+
+// Names returns the sorted names of the registered programs.
+func (r *Registry) Names() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var names []string
+	for name := range r.programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Circuit returns the program's circuit for the garbler and
+// evaluator input sizes. The circuit is compiled on the first use
+// and cached for the later sessions.
+func (p *Program) Circuit(inputSizes [][]int) (*circuit.Circuit, error) {
+	if len(p.evaluatorSizes) == 0 {
+		inputSizes = [][]int{inputSizes[0], nil}
+	} else if !p.supports(inputSizes[1]) {
+		return nil, fmt.Errorf("%w: evaluator input sizes %v",
+			ErrUnsupportedSizes, inputSizes[1])
+	}
+	key := fmt.Sprintf("%v", inputSizes)
+
+	p.m.Lock()
+	circ, ok := p.circuits[key]
+	p.m.Unlock()
+	if ok {
+		return circ, nil
+	}
+
+	// Compile without the lock so the sessions of the cached
+	// circuits are not blocked. The concurrent sessions may compile
+	// the same circuit and the first one is cached.
+	circ, _, err := compiler.New(p.params).CompileFile(p.File, inputSizes)
+	if err != nil {
+		return nil, err
+	}
+	if len(circ.Inputs) != 2 {
+		return nil, fmt.Errorf("invalid circuit for 2-party MPC: %d parties",
+			len(circ.Inputs))
+	}
+	circ.AssignLevels()
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if cached, ok := p.circuits[key]; ok {
+		return cached, nil
+	}
+	p.circuits[key] = circ
+	p.keys = append(p.keys, key)
+	limit := maxGarblerSizes * len(p.evaluatorSizes)
+	if limit == 0 {
+		limit = maxGarblerSizes
+	}
+	for len(p.keys) > limit {
+		delete(p.circuits, p.keys[0])
+		p.keys = p.keys[1:]
+	}
+	return circ, nil
+}
+
+// supports tests if the program is registered for the evaluator
+// input sizes.
+func (p *Program) supports(sizes []int) bool {
+	for _, es := range p.evaluatorSizes {
+		if fmt.Sprintf("%v", es) == fmt.Sprintf("%v", sizes) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprints returns the fingerprints of the program's cached
+// circuits by their input sizes. The clients verify the circuit that
+// they run against its fingerprint, see Run.
+func (p *Program) Fingerprints() (map[string][32]byte, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	result := make(map[string][32]byte)
+	for key, circ := range p.circuits {
+		fp, err := circ.Fingerprint()
+		if err != nil {
+			return nil, err
+		}
+		result[key] = fp
+	}
+	return result, nil
+}
@@ -0,0 +1,321 @@
+//
+// server.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+const (
+	magic     = 0x73657276 // serv
+	chunkSize = 32 * 1024
+
+	// sessionTimeout limits the duration of a session if the server
+	// has no NewContext function.
+	sessionTimeout = 10 * time.Minute
+
+	// shutdownGrace is the time the running sessions have to complete
+	// after Serve's context is done.
+	shutdownGrace = 30 * time.Second
+)
+
+// Server runs the registry programs for the clients. Each client
+// connection is a session that runs one program. The sessions run
+// concurrently.
+type Server struct {
+	Registry *Registry
+
+	// Log receives the server's log messages. The default is the
+	// standard logger's output.
+	Log io.Writer
+
+	// LogDir specifies the directory for the per-session log
+	// files. If empty, the session logs go to Log.
+	LogDir string
+
+	// NewConn creates the protocol connection for the client's
+	// network connection. The default is p2p.NewConn.
+	NewConn func(nc net.Conn) *p2p.Conn
+
+	// NewContext creates the context for a session. The default is a
+	// context with the timeout sessionTimeout. The sessions are
+	// canceled if they don't complete within shutdownGrace after
+	// Serve's context is done.
+	NewContext func() (context.Context, context.CancelFunc)
+
+	timeout time.Duration
+	grace   time.Duration
+	m       sync.Mutex
+	next    int
+}
+
+// NewServer creates a new server for the registry.
+func NewServer(registry *Registry) *Server {
+	return &Server{
+		Registry: registry,
+		timeout:  sessionTimeout,
+		grace:    shutdownGrace,
+	}
+}
+
+// Serve accepts clients from the listener until the context is
+// done. Serve closes the listener and waits for the running sessions
+// to complete before it returns the context's error. The sessions
+// still running after the shutdown grace period are canceled.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	logger := s.logger("")
+
+	shutdown, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		ln.Close()
+
+		timer := time.NewTimer(s.grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			logger.Printf("shutdown grace period expired, " +
+				"canceling sessions\n")
+			cancel()
+		case <-done:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Printf("shutting down, waiting for sessions\n")
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.session(shutdown, nc)
+		}()
+	}
+}
+
+func (s *Server) logger(prefix string) *log.Logger {
+	if s.Log != nil {
+		return log.New(s.Log, prefix, log.LstdFlags)
+	}
+	return log.New(log.Writer(), prefix, log.Flags())
+}
+
+// session runs the client's session. The session is canceled when
+// the shutdown context is done.
+func (s *Server) session(shutdown context.Context, nc net.Conn) {
+	s.m.Lock()
+	s.next++
+	id := s.next
+	s.m.Unlock()
+
+	logger := s.logger(fmt.Sprintf("session %d: ", id))
+	if len(s.LogDir) > 0 {
+		name := filepath.Join(s.LogDir, fmt.Sprintf("session-%d.log", id))
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+			0644)
+		if err != nil {
+			logger.Printf("failed to create session log: %s\n", err)
+		} else {
+			defer f.Close()
+			s.logger("").Printf("session %d: %s: log %s\n",
+				id, nc.RemoteAddr(), name)
+			logger = log.New(f, "", log.LstdFlags)
+		}
+	}
+	logger.Printf("new connection from %s\n", nc.RemoteAddr())
+
+	var conn *p2p.Conn
+	if s.NewConn != nil {
+		conn = s.NewConn(nc)
+	} else {
+		conn = p2p.NewConn(nc)
+	}
+	defer conn.Close()
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.NewContext != nil {
+		ctx, cancel = s.NewContext()
+	} else {
+		ctx, cancel = context.WithTimeout(context.Background(), s.timeout)
+	}
+	defer cancel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-shutdown.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+
+	outputs, result, err := s.run(ctx, conn, logger)
+	if err != nil {
+		logger.Printf("failed: %s\n", err)
+		return
+	}
+	for idx, r := range result {
+		if r == nil {
+			logger.Printf("result[%d]: -\n", idx)
+		} else {
+			logger.Printf("result[%d]: %s: %v\n", idx, outputs[idx], r)
+		}
+	}
+	logger.Printf("done\n")
+}
+
+// run receives the client's program request and runs the program as
+// the garbler.
+func (s *Server) run(ctx context.Context, conn *p2p.Conn,
+	logger *log.Logger) (circuit.IO, []*big.Int, error) {
+
+	release := conn.WithContext(ctx)
+	m, err := conn.ReceiveUint32()
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	if m != magic {
+		release()
+		return nil, nil, circuit.Abort(conn, fmt.Errorf(
+			"%w: invalid magic 0x%x", circuit.ErrProtocolMismatch, m))
+	}
+	name, err := conn.ReceiveString()
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	peerInputSizes, err := conn.ReceiveInputSizes()
+	release()
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Printf("program %s, evaluator input sizes %v\n",
+		name, peerInputSizes)
+
+	prog, err := s.Registry.Program(name)
+	if err != nil {
+		conn.Abort(p2p.AbortInvalidInput, err.Error())
+		return nil, nil, err
+	}
+	values, err := prog.Inputs()
+	if err != nil {
+		conn.Abort(p2p.AbortInternal, "garbler inputs not available")
+		return nil, nil, err
+	}
+	inputSizes, err := circuit.InputSizes(values)
+	if err != nil {
+		conn.Abort(p2p.AbortInternal, "invalid garbler inputs")
+		return nil, nil, err
+	}
+	circ, err := prog.Circuit([][]int{inputSizes, peerInputSizes})
+	if errors.Is(err, ErrUnsupportedSizes) {
+		conn.Abort(p2p.AbortInvalidInput, err.Error())
+		return nil, nil, err
+	} else if err != nil {
+		conn.Abort(p2p.AbortInvalidInput, "failed to compile program")
+		return nil, nil, err
+	}
+	input, err := circ.Inputs[0].Parse(values)
+	if err != nil {
+		conn.Abort(p2p.AbortInternal, "invalid garbler inputs")
+		return nil, nil, err
+	}
+
+	// Send the circuit to the client.
+	var buf bytes.Buffer
+	if err := circ.Marshal(&buf); err != nil {
+		return nil, nil, err
+	}
+	release = conn.WithContext(ctx)
+	err = sendChunked(conn, buf.Bytes())
+	release()
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Printf("circuit: %v\n", circ)
+
+	result, err := circuit.Garbler(ctx, conn, ot.NewCO(), circ, input, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return circ.Outputs, result, nil
+}
+
+// sendChunked sends the data in chunks that fit in the connection's
+// write buffer.
+func sendChunked(conn *p2p.Conn, data []byte) error {
+	if err := conn.SendUint32(len(data)); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if err := conn.SendData(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return conn.Flush()
+}
+
+// receiveChunked receives the data that was sent with sendChunked.
+func receiveChunked(conn *p2p.Conn) ([]byte, error) {
+	size, err := conn.ReceiveUint32()
+	if err != nil {
+		return nil, err
+	}
+	var result []byte
+	for len(result) < size {
+		data, err := conn.ReceiveData()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > chunkSize || len(result)+len(data) > size {
+			return nil, fmt.Errorf("%w: invalid chunk size %d",
+				circuit.ErrProtocolMismatch, len(data))
+		}
+		result = append(result, data...)
+	}
+	return result, nil
+}
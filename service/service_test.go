@@ -0,0 +1,334 @@
+//
+// service_test.go
+//
+// Copyright (c) 2023 Markku Rossi
+//
+// All rights reserved.
+//
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/circuit"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/compiler/utils"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/ot"
+	"source.quilibrium.com/quilibrium/monorepo/bedlam/p2p"
+)
+
+var programs = map[string]string{
+	"add.qcl": `
+package main
+
+func main(a, b int32) int32 {
+    return a + b
+}
+`,
+	"count.qcl": `
+package main
+
+func main(a int32, b []byte) int {
+    return a + len(b)
+}
+`,
+}
+
+var registryConfig = `{
+  "programs": [
+    {"name": "add", "file": "add.qcl", "inputs": ["100"]},
+    {"name": "count", "file": "count.qcl", "input_file": "count.txt",
+     "evaluator_sizes": [[16]]}
+  ]
+}`
+
+func newRegistry(t *testing.T) *Registry {
+	dir := t.TempDir()
+	for name, data := range programs {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(dir, "count.txt"), []byte("5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "registry.json")
+	err = os.WriteFile(file, []byte(registryConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := LoadRegistry(file, utils.NewParams())
+	if err != nil {
+		t.Fatalf("LoadRegistry failed: %s", err)
+	}
+	return registry
+}
+
+func startServer(t *testing.T, server *Server) (string, func() error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(ctx, ln)
+	}()
+	return ln.Addr().String(), func() error {
+		cancel()
+		return <-errs
+	}
+}
+
+// fingerprint returns the fingerprint of the program's circuit for
+// the garbler input and the evaluator inputs.
+func fingerprint(t *testing.T, registry *Registry, program, garbler string,
+	inputs ...string) []byte {
+
+	prog, err := registry.Program(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := circuit.InputSizes([]string{garbler})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerSizes, err := circuit.InputSizes(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	circ, err := prog.Circuit([][]int{sizes, peerSizes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := circ.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp[:]
+}
+
+func run(addr, program string, fp []byte, inputs ...string) (
+	[]*big.Int, error) {
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := p2p.NewConn(nc)
+	defer conn.Close()
+
+	_, result, err := Run(context.Background(), conn, ot.NewCO(), program,
+		fp, inputs)
+	return result, err
+}
+
+func TestRegistry(t *testing.T) {
+	registry := newRegistry(t)
+	names := registry.Names()
+	if len(names) != 2 || names[0] != "add" || names[1] != "count" {
+		t.Errorf("unexpected programs: %v", names)
+	}
+	_, err := registry.Program("missing")
+	if !errors.Is(err, ErrUnknownProgram) {
+		t.Errorf("expected %s, got %v", ErrUnknownProgram, err)
+	}
+	err = registry.Add("add", "add.qcl", StaticInputs([]string{"1"}))
+	if err == nil {
+		t.Errorf("duplicate program registered")
+	}
+
+	prog, err := registry.Program("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = prog.Circuit([][]int{{32}, {24}})
+	if !errors.Is(err, ErrUnsupportedSizes) {
+		t.Errorf("expected %s, got %v", ErrUnsupportedSizes, err)
+	}
+	for i := 0; i < 2*maxGarblerSizes; i++ {
+		if _, err := prog.Circuit([][]int{{32 + i}, {16}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fps, err := prog.Fingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fps) != maxGarblerSizes {
+		t.Errorf("got %d cached circuits, expected %d",
+			len(fps), maxGarblerSizes)
+	}
+}
+
+func TestServer(t *testing.T) {
+	registry := newRegistry(t)
+
+	var calls int
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "mult.qcl"), []byte(`
+package main
+
+func main(a, b int32) int32 {
+    return a * b
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = registry.Add("mult", filepath.Join(dir, "mult.qcl"),
+		func() ([]string, error) {
+			calls++
+			return []string{fmt.Sprintf("%d", calls)}, nil
+		})
+	if err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+
+	server := NewServer(registry)
+	server.LogDir = t.TempDir()
+	server.Log = io.Discard
+	addr, stop := startServer(t, server)
+
+	tests := []struct {
+		program string
+		garbler string
+		inputs  []string
+		result  int64
+	}{
+		{"add", "100", []string{"42"}, 142},
+		{"add", "100", []string{"-1"}, 99},
+		{"count", "5", []string{"0x0102"}, 7},
+		{"mult", "1", []string{"7"}, 14},
+	}
+	type result struct {
+		idx    int
+		result []*big.Int
+		err    error
+	}
+	results := make(chan result)
+	for idx, test := range tests {
+		fp := fingerprint(t, registry, test.program, test.garbler,
+			test.inputs...)
+		go func(idx int, program string, inputs []string) {
+			r, err := run(addr, program, fp, inputs...)
+			results <- result{
+				idx:    idx,
+				result: r,
+				err:    err,
+			}
+		}(idx, test.program, test.inputs)
+	}
+	for range tests {
+		r := <-results
+		test := tests[r.idx]
+		if r.err != nil {
+			t.Errorf("%s%v failed: %s", test.program, test.inputs, r.err)
+			continue
+		}
+		if len(r.result) != 1 {
+			t.Errorf("%s%v: unexpected result: %v", test.program,
+				test.inputs, r.result)
+			continue
+		}
+		got := r.result[0].Int64()
+		if test.program == "add" {
+			got = int64(int32(got))
+		}
+		if got != test.result {
+			t.Errorf("%s%v: got %v, expected %v", test.program, test.inputs,
+				got, test.result)
+		}
+	}
+
+	_, err = run(addr, "missing", make([]byte, 32), "1")
+	if !errors.Is(err, circuit.ErrPeerAbort) {
+		t.Errorf("expected %s, got %v", circuit.ErrPeerAbort, err)
+	}
+	_, err = run(addr, "count", fingerprint(t, registry, "count", "5", "0x0102"),
+		"0x010203")
+	if !errors.Is(err, circuit.ErrPeerAbort) {
+		t.Errorf("unsupported sizes: expected %s, got %v",
+			circuit.ErrPeerAbort, err)
+	}
+	_, err = run(addr, "add", fingerprint(t, registry, "mult", "1", "1"),
+		"1")
+	if !errors.Is(err, circuit.ErrProtocolMismatch) {
+		t.Errorf("wrong fingerprint: expected %s, got %v",
+			circuit.ErrProtocolMismatch, err)
+	}
+
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve failed: %v", err)
+	}
+	logs, err := filepath.Glob(filepath.Join(server.LogDir, "session-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != len(tests)+3 {
+		t.Errorf("got %d session logs, expected %d", len(logs), len(tests)+3)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	registry := newRegistry(t)
+
+	// The stalled session is canceled at its timeout.
+	server := NewServer(registry)
+	server.Log = io.Discard
+	server.timeout = 100 * time.Millisecond
+	addr, stop := startServer(t, server)
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	var buf [1]byte
+	_, err = nc.Read(buf[:])
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("stalled session not canceled at its timeout")
+	}
+	nc.Close()
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve failed: %v", err)
+	}
+
+	// The stalled session is canceled after the shutdown grace
+	// period.
+	server = NewServer(registry)
+	server.Log = io.Discard
+	server.grace = 100 * time.Millisecond
+	addr, stop = startServer(t, server)
+
+	nc, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- stop()
+	}()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Serve did not cancel the stalled session")
+	}
+}
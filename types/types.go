@@ -53,6 +53,7 @@ const (
 	TStruct
 	TArray
 	TPtr
+	TFunc
 )
 
 // Types define QCL types and their names.
@@ -66,6 +67,7 @@ var Types = map[string]Type{
 	"struct":      TStruct,
 	"array":       TArray,
 	"ptr":         TPtr,
+	"func":        TFunc,
 }
 
 var shortTypes = map[Type]string{
@@ -78,6 +80,7 @@ var shortTypes = map[Type]string{
 	TStruct:    "struct",
 	TArray:     "arr",
 	TPtr:       "*",
+	TFunc:      "func",
 }
 
 // Info specifies information about a type.
@@ -147,6 +150,13 @@ var Uint64 = Info{
 	MinBits:    64,
 }
 
+// Func defines type info for function values. The function values
+// exist only at compile time and they don't have any wires.
+var Func = Info{
+	Type:       TFunc,
+	IsConcrete: true,
+}
+
 // StructField defines a structure field name and type.
 type StructField struct {
 	Name string
@@ -166,6 +176,9 @@ func (i Info) String() string {
 	case TPtr:
 		return fmt.Sprintf("*%s", i.ElementType)
 
+	case TFunc:
+		return i.Type.String()
+
 	default:
 		if !i.Concrete() {
 			return i.Type.String()
@@ -176,7 +189,7 @@ func (i Info) String() string {
 
 // ShortString returns a short string name for the type info.
 func (i Info) ShortString() string {
-	if !i.Concrete() {
+	if !i.Concrete() || i.Type == TFunc {
 		return i.Type.ShortString()
 	}
 	if i.Type == TPtr {
@@ -353,6 +366,9 @@ func (i Info) Equal(o Info) bool {
 	case TPtr:
 		return i.ElementType.Equal(*o.ElementType)
 
+	case TFunc:
+		return true
+
 	default:
 		panic(fmt.Sprintf("Info.Equal called for %v (%T)", i.Type, i.Type))
 	}